@@ -515,7 +515,10 @@ func migrateHelp() {
 
 func agentCmd() {
 	message := ""
-	sessionKey := "cli:default"
+	sessionKey := ""
+	recordPath := ""
+	replayPath := ""
+	agentName := ""
 
 	args := os.Args[2:]
 	for i := 0; i < len(args); i++ {
@@ -533,6 +536,33 @@ func agentCmd() {
 				sessionKey = args[i+1]
 				i++
 			}
+		case "--record":
+			if i+1 < len(args) {
+				recordPath = args[i+1]
+				i++
+			}
+		case "--replay":
+			if i+1 < len(args) {
+				replayPath = args[i+1]
+				i++
+			}
+		case "-a", "--agent":
+			if i+1 < len(args) {
+				agentName = args[i+1]
+				i++
+			}
+		}
+	}
+	if recordPath != "" && replayPath != "" {
+		fmt.Println("Error: --record and --replay cannot be combined")
+		os.Exit(1)
+	}
+	if sessionKey == "" {
+		sessionKey = "cli:default"
+		// Recorded and replayed runs start from an empty conversation, so a
+		// fixture never depends on, or disturbs, the default session.
+		if recordPath != "" || replayPath != "" {
+			sessionKey = fmt.Sprintf("cli:fixture:%d", time.Now().UnixNano())
 		}
 	}
 
@@ -542,14 +572,36 @@ func agentCmd() {
 		os.Exit(1)
 	}
 
-	provider, err := providers.CreateProvider(cfg)
-	if err != nil {
-		fmt.Printf("Error creating provider: %v\n", err)
-		os.Exit(1)
+	// --replay serves recorded LLM responses instead of calling a provider,
+	// so agent runs with tools can be repeated offline.
+	var provider providers.LLMProvider
+	var replay *providers.ReplayProvider
+	if replayPath != "" {
+		fixture, err := providers.LoadFixture(replayPath)
+		if err != nil {
+			fmt.Printf("Error loading replay fixture: %v\n", err)
+			os.Exit(1)
+		}
+		replay = providers.NewReplayProvider(fixture)
+		provider = replay
+	} else {
+		provider, err = providers.CreateProvider(cfg)
+		if err != nil {
+			fmt.Printf("Error creating provider: %v\n", err)
+			os.Exit(1)
+		}
+		if recordPath != "" {
+			provider = providers.NewRecordingProvider(provider, recordPath)
+			fmt.Printf("Recording LLM calls to %s\n", recordPath)
+		}
 	}
 
 	msgBus := bus.NewMessageBus()
-	agentLoop := agent.NewAgentLoop(cfg, msgBus, provider)
+	agentLoop, err := agent.NewAgentLoopForProfile(cfg, msgBus, provider, agentName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 
 	var channelStore *storage.Storage
 	if cfg.Storage.Path != "" {
@@ -580,6 +632,12 @@ func agentCmd() {
 			os.Exit(1)
 		}
 		fmt.Printf("\n%s %s\n", logo, response)
+		if replay != nil {
+			if n := replay.Remaining(); n > 0 {
+				fmt.Printf("Error: replay ended with %d unused recorded interactions\n", n)
+				os.Exit(1)
+			}
+		}
 	} else {
 		fmt.Printf("%s Interactive mode (Ctrl+C to exit)\n\n", logo)
 		interactiveMode(agentLoop, sessionKey)
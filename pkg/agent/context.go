@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/sipeed/kakoclaw/pkg/config"
 	"github.com/sipeed/kakoclaw/pkg/logger"
 	"github.com/sipeed/kakoclaw/pkg/providers"
 	"github.com/sipeed/kakoclaw/pkg/skills"
@@ -21,6 +22,7 @@ type ContextBuilder struct {
 	skillsLoader *skills.SkillsLoader
 	memory       *MemoryStore
 	tools        *tools.ToolRegistry // Direct reference to tool registry
+	profile      config.AgentProfile // Named agent persona; zero for the default agent
 }
 
 func getGlobalConfigDir() string {
@@ -85,13 +87,21 @@ func (cb *ContextBuilder) getUserWorkspacePath() string {
 	return filepath.Join(home, ".kakoclaw", "users", cb.userUUID, "workspace")
 }
 
+// WithProfile applies a named agent profile: its system prompt, its skills
+// allow-list and the bootstrap files in its workspace. Call it after WithUser,
+// which rebuilds the skills loader.
+func (cb *ContextBuilder) WithProfile(profile config.AgentProfile) *ContextBuilder {
+	cb.profile = profile
+	cb.skillsLoader.SetAllowed(profile.Skills)
+	return cb
+}
+
 // SetToolsRegistry sets the tools registry for dynamic tool summary generation.
 func (cb *ContextBuilder) SetToolsRegistry(registry *tools.ToolRegistry) {
 	cb.tools = registry
 }
 
 func (cb *ContextBuilder) getIdentity() string {
-	now := time.Now().Format("2006-01-02 15:04 (Monday)")
 	workspacePath, _ := filepath.Abs(cb.getUserWorkspacePath())
 	runtime := fmt.Sprintf("%s %s, Go %s", runtime.GOOS, runtime.GOARCH, runtime.Version())
 
@@ -102,9 +112,6 @@ func (cb *ContextBuilder) getIdentity() string {
 
 You are KakoClaw, a helpful AI assistant.
 
-## Current Time
-%s
-
 ## Runtime
 %s
 
@@ -137,7 +144,22 @@ Your workspace is at: %s
 - Skills that require network access
 
 If a command needs network access, you should execute it normally.`,
-		now, runtime, workspacePath, workspacePath, workspacePath, workspacePath, toolsSection, workspacePath)
+		runtime, workspacePath, workspacePath, workspacePath, workspacePath, toolsSection, workspacePath)
+}
+
+// profileSection introduces the named agent and its system prompt.
+func (cb *ContextBuilder) profileSection() string {
+	if cb.profile.Name == "" {
+		return ""
+	}
+	section := "# Agent: " + cb.profile.Name
+	if cb.profile.Description != "" {
+		section += "\n\n" + cb.profile.Description
+	}
+	if cb.profile.SystemPrompt != "" {
+		section += "\n\n" + cb.profile.SystemPrompt
+	}
+	return section
 }
 
 func (cb *ContextBuilder) buildToolsSection() string {
@@ -162,12 +184,20 @@ func (cb *ContextBuilder) buildToolsSection() string {
 	return sb.String()
 }
 
+// BuildSystemPrompt returns the part of the system prompt that stays the same
+// from turn to turn, so providers can cache it. Per-turn details (time,
+// session, summary) are added by BuildMessages.
 func (cb *ContextBuilder) BuildSystemPrompt() string {
 	parts := []string{}
 
 	// Core identity section
 	parts = append(parts, cb.getIdentity())
 
+	// Agent profile persona
+	if section := cb.profileSection(); section != "" {
+		parts = append(parts, section)
+	}
+
 	// Bootstrap files
 	bootstrapContent := cb.LoadBootstrapFiles()
 	if bootstrapContent != "" {
@@ -202,11 +232,19 @@ func (cb *ContextBuilder) LoadBootstrapFiles() string {
 		"IDENTITY.md",
 	}
 
+	// A profile's own files take precedence over the user's.
+	dirs := []string{cb.getUserWorkspacePath()}
+	if profileDir := cb.profile.WorkspacePath(); profileDir != "" && profileDir != dirs[0] {
+		dirs = append([]string{profileDir}, dirs...)
+	}
+
 	var result string
 	for _, filename := range bootstrapFiles {
-		filePath := filepath.Join(cb.getUserWorkspacePath(), filename)
-		if data, err := os.ReadFile(filePath); err == nil {
-			result += fmt.Sprintf("## %s\n\n%s\n\n", filename, string(data))
+		for _, dir := range dirs {
+			if data, err := os.ReadFile(filepath.Join(dir, filename)); err == nil {
+				result += fmt.Sprintf("## %s\n\n%s\n\n", filename, string(data))
+				break
+			}
 		}
 	}
 
@@ -218,9 +256,13 @@ func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary str
 
 	systemPrompt := cb.BuildSystemPrompt()
 
+	// Per-turn details go after the stable prompt so they do not invalidate
+	// the provider's cached prefix.
+	turnContext := "## Current Time\n" + time.Now().Format("2006-01-02 15:04 (Monday)")
+
 	// Add Current Session info if provided
 	if channel != "" && chatID != "" {
-		systemPrompt += fmt.Sprintf("\n\n## Current Session\nChannel: %s\nChat ID: %s", channel, chatID)
+		turnContext += fmt.Sprintf("\n\n## Current Session\nChannel: %s\nChat ID: %s", channel, chatID)
 	}
 
 	// Log system prompt summary for debugging (debug mode only)
@@ -242,7 +284,7 @@ func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary str
 		})
 
 	if summary != "" {
-		systemPrompt += "\n\n## Summary of Previous Conversation\n\n" + summary
+		turnContext += "\n\n## Summary of Previous Conversation\n\n" + summary
 	}
 
 	//This fix prevents the session memory from LLM failure due to elimination of toolu_IDs required from LLM
@@ -256,17 +298,33 @@ func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary str
 	//Diegox-17
 	// --- FIN DEL FIX ---
 
+	// The separator opens the second part, so providers that join parts with
+	// "\n" send the same text as Content.
+	turnPart := "\n---\n\n" + turnContext
 	messages = append(messages, providers.Message{
 		Role:    "system",
-		Content: systemPrompt,
+		Content: systemPrompt + "\n" + turnPart,
+		Parts:   []providers.ContentPart{providers.TextPart(systemPrompt), providers.TextPart(turnPart)},
 	})
 
 	messages = append(messages, history...)
 
-	messages = append(messages, providers.Message{
+	userMsg := providers.Message{
 		Role:    "user",
 		Content: currentMessage,
-	})
+	}
+	// Attach images as content parts; other media (voice, documents) is
+	// already described in the message text by the channel.
+	for _, ref := range media {
+		if !providers.IsImageRef(ref) {
+			continue
+		}
+		if len(userMsg.Parts) == 0 {
+			userMsg.Parts = append(userMsg.Parts, providers.TextPart(currentMessage))
+		}
+		userMsg.Parts = append(userMsg.Parts, providers.ImagePart(ref))
+	}
+	messages = append(messages, userMsg)
 
 	return messages
 }
@@ -0,0 +1,23 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSystemMessagePartsMatchContent(t *testing.T) {
+	cb := NewContextBuilder(t.TempDir())
+	msgs := cb.BuildMessages(nil, "earlier", "hi", nil, "cli", "direct")
+
+	system := msgs[0]
+	if len(system.Parts) != 2 {
+		t.Fatalf("expected the prompt and the turn context as parts, got %d", len(system.Parts))
+	}
+	joined := system.Parts[0].Text + "\n" + system.Parts[1].Text
+	if joined != system.Content {
+		t.Fatalf("joined parts differ from Content:\n%q\n%q", joined, system.Content)
+	}
+	if !strings.Contains(system.Content, "\n\n---\n\n") {
+		t.Fatal("expected the prompt and turn context to be separated")
+	}
+}
@@ -0,0 +1,193 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/kakoclaw/pkg/bus"
+	"github.com/sipeed/kakoclaw/pkg/observability"
+)
+
+// defaultMaxConcurrentRuns is used when the config does not set a cap.
+const defaultMaxConcurrentRuns = 4
+
+// queuedMessage is an inbound message waiting for a worker.
+type queuedMessage struct {
+	msg      bus.InboundMessage
+	enqueued time.Time
+}
+
+// sessionDispatcher schedules inbound messages onto a bounded worker pool.
+// Messages that share a session key are processed strictly in arrival order,
+// one at a time. Different sessions run in parallel, and ready sessions are
+// picked round-robin across users so one busy user cannot starve the others.
+type sessionDispatcher struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending map[string][]queuedMessage // session key -> FIFO of waiting messages
+	running map[string]bool            // session keys currently held by a worker
+	ready   map[string][]string        // user key -> session keys ready to run
+	users   []string                   // round-robin order of users with ready sessions
+	next    int                        // index into users for the next pick
+	depth   int                        // total queued messages (not yet picked)
+	active  int                        // messages currently being processed
+	closed  bool
+}
+
+func newSessionDispatcher() *sessionDispatcher {
+	d := &sessionDispatcher{
+		pending: make(map[string][]queuedMessage),
+		running: make(map[string]bool),
+		ready:   make(map[string][]string),
+	}
+	d.cond = sync.NewCond(&d.mu)
+	return d
+}
+
+// dispatchKey returns the ordering key for a message. System messages are
+// routed back to their origin session, so they share that session's key.
+func dispatchKey(msg bus.InboundMessage) string {
+	if msg.Channel == "system" {
+		if idx := strings.Index(msg.ChatID, ":"); idx > 0 {
+			return msg.ChatID
+		}
+		return "cli:" + msg.ChatID
+	}
+	if msg.SessionKey != "" {
+		return msg.SessionKey
+	}
+	return fmt.Sprintf("%s:%s", msg.Channel, msg.ChatID)
+}
+
+// fairnessKey identifies the user a message is accounted against.
+func fairnessKey(msg bus.InboundMessage) string {
+	if msg.UserID > 0 {
+		return fmt.Sprintf("user:%d", msg.UserID)
+	}
+	return fmt.Sprintf("%s:%s", msg.Channel, msg.SenderID)
+}
+
+// Enqueue adds a message to its session queue.
+func (d *sessionDispatcher) Enqueue(msg bus.InboundMessage) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+
+	key := dispatchKey(msg)
+	d.pending[key] = append(d.pending[key], queuedMessage{msg: msg, enqueued: time.Now()})
+	d.depth++
+
+	// A session becomes ready when its first message arrives and no worker holds it.
+	if !d.running[key] && len(d.pending[key]) == 1 {
+		d.markReady(fairnessKey(msg), key)
+	}
+
+	observability.Global().RecordQueueState(d.depth, d.active)
+	d.cond.Signal()
+}
+
+// markReady appends a session to its user's ready list. Caller holds d.mu.
+func (d *sessionDispatcher) markReady(user, key string) {
+	if len(d.ready[user]) == 0 {
+		d.users = append(d.users, user)
+	}
+	d.ready[user] = append(d.ready[user], key)
+}
+
+// take blocks until a session is ready and claims its oldest message.
+// Returns false once the dispatcher is closed.
+func (d *sessionDispatcher) take() (string, queuedMessage, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for len(d.users) == 0 && !d.closed {
+		d.cond.Wait()
+	}
+	if d.closed {
+		return "", queuedMessage{}, false
+	}
+
+	if d.next >= len(d.users) {
+		d.next = 0
+	}
+	user := d.users[d.next]
+	key := d.ready[user][0]
+	d.ready[user] = d.ready[user][1:]
+	if len(d.ready[user]) == 0 {
+		delete(d.ready, user)
+		d.users = append(d.users[:d.next], d.users[d.next+1:]...)
+	} else {
+		d.next++
+	}
+
+	qm := d.pending[key][0]
+	d.pending[key] = d.pending[key][1:]
+	d.running[key] = true
+	d.depth--
+	d.active++
+
+	observability.Global().RecordQueueState(d.depth, d.active)
+	return key, qm, true
+}
+
+// done releases a session after its message was processed and requeues the
+// session if more messages arrived meanwhile.
+func (d *sessionDispatcher) done(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.running, key)
+	d.active--
+	if len(d.pending[key]) > 0 {
+		d.markReady(fairnessKey(d.pending[key][0].msg), key)
+		d.cond.Signal()
+	} else {
+		delete(d.pending, key)
+	}
+
+	observability.Global().RecordQueueState(d.depth, d.active)
+}
+
+// Close wakes all workers and stops accepting messages.
+func (d *sessionDispatcher) Close() {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+	d.cond.Broadcast()
+}
+
+// Stats returns the current queue depth and number of active runs.
+func (d *sessionDispatcher) Stats() (depth, active int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.depth, d.active
+}
+
+// startWorkers launches n workers that process messages with handle until
+// ctx is canceled or the dispatcher is closed.
+func (d *sessionDispatcher) startWorkers(ctx context.Context, n int, handle func(context.Context, bus.InboundMessage)) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				key, qm, ok := d.take()
+				if !ok {
+					return
+				}
+				observability.Global().RecordQueueWait(time.Since(qm.enqueued))
+				if ctx.Err() == nil {
+					handle(ctx, qm.msg)
+				}
+				d.done(key)
+			}
+		}()
+	}
+	return &wg
+}
@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sipeed/kakoclaw/pkg/bus"
+)
+
+// runDispatcher starts n workers with handle and returns a function that
+// waits until total messages were handled and then stops the workers.
+func runDispatcher(t *testing.T, n, total int, handle func(bus.InboundMessage)) (*sessionDispatcher, func()) {
+	t.Helper()
+	d := newSessionDispatcher()
+	var handled sync.WaitGroup
+	handled.Add(total)
+	workers := d.startWorkers(context.Background(), n, func(_ context.Context, msg bus.InboundMessage) {
+		defer handled.Done()
+		handle(msg)
+	})
+	return d, func() {
+		t.Helper()
+		finished := make(chan struct{})
+		go func() {
+			handled.Wait()
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for messages to be handled")
+		}
+		d.Close()
+		workers.Wait()
+	}
+}
+
+func TestSessionDispatcherKeepsSessionOrder(t *testing.T) {
+	const perSession = 20
+	var mu sync.Mutex
+	seen := make(map[string][]int)
+	busy := make(map[string]bool)
+
+	d, wait := runDispatcher(t, 4, 2*perSession, func(msg bus.InboundMessage) {
+		mu.Lock()
+		if busy[msg.ChatID] {
+			t.Errorf("session %s handled two messages at once", msg.ChatID)
+		}
+		busy[msg.ChatID] = true
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+		n, _ := strconv.Atoi(msg.Content)
+
+		mu.Lock()
+		busy[msg.ChatID] = false
+		seen[msg.ChatID] = append(seen[msg.ChatID], n)
+		mu.Unlock()
+	})
+	for i := 0; i < perSession; i++ {
+		for _, chat := range []string{"a", "b"} {
+			d.Enqueue(bus.InboundMessage{Channel: "test", ChatID: chat, SenderID: chat, Content: strconv.Itoa(i)})
+		}
+	}
+	wait()
+
+	for _, chat := range []string{"a", "b"} {
+		if len(seen[chat]) != perSession {
+			t.Fatalf("session %s: handled %d messages, want %d", chat, len(seen[chat]), perSession)
+		}
+		for i, n := range seen[chat] {
+			if n != i {
+				t.Fatalf("session %s handled messages out of order: %v", chat, seen[chat])
+			}
+		}
+	}
+}
+
+func TestSessionDispatcherRunsSessionsInParallel(t *testing.T) {
+	started := make(chan string, 2)
+	release := make(chan struct{})
+
+	d, wait := runDispatcher(t, 2, 2, func(msg bus.InboundMessage) {
+		started <- msg.ChatID
+		<-release
+	})
+	d.Enqueue(bus.InboundMessage{Channel: "test", ChatID: "a", SenderID: "u1"})
+	d.Enqueue(bus.InboundMessage{Channel: "test", ChatID: "b", SenderID: "u1"})
+
+	// Both handlers must be running before either is released.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			close(release)
+			t.Fatal("expected different sessions to run at the same time")
+		}
+	}
+	if _, active := d.Stats(); active != 2 {
+		t.Errorf("expected 2 active runs, got %d", active)
+	}
+	close(release)
+	wait()
+}
+
+func TestSessionDispatcherRespectsConcurrencyCap(t *testing.T) {
+	const workers, sessions = 3, 12
+	var active, peak int32
+
+	d, wait := runDispatcher(t, workers, sessions, func(bus.InboundMessage) {
+		n := atomic.AddInt32(&active, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&active, -1)
+	})
+	for i := 0; i < sessions; i++ {
+		d.Enqueue(bus.InboundMessage{Channel: "test", ChatID: fmt.Sprintf("chat%d", i), SenderID: fmt.Sprintf("u%d", i)})
+	}
+	wait()
+
+	if peak > workers {
+		t.Fatalf("%d runs overlapped, want at most %d", peak, workers)
+	}
+	if peak < 2 {
+		t.Fatalf("expected runs to overlap up to the cap, peak was %d", peak)
+	}
+	if depth, active := d.Stats(); depth != 0 || active != 0 {
+		t.Fatalf("expected an empty dispatcher, got depth %d, active %d", depth, active)
+	}
+}
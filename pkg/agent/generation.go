@@ -0,0 +1,198 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sipeed/kakoclaw/pkg/bus"
+	"github.com/sipeed/kakoclaw/pkg/config"
+)
+
+type generationKey struct{}
+
+// WithGeneration attaches generation overrides to ctx. They apply to every
+// LLM call of runs started with ctx, on top of the configured and session
+// parameters (used e.g. by workflow prompt steps).
+func WithGeneration(ctx context.Context, params config.GenerationParams) context.Context {
+	return context.WithValue(ctx, generationKey{}, params)
+}
+
+func generationFromContext(ctx context.Context) (config.GenerationParams, bool) {
+	params, ok := ctx.Value(generationKey{}).(config.GenerationParams)
+	return params, ok
+}
+
+// resolveGeneration layers the configured defaults, the model and channel
+// overrides, the session overrides and any overrides carried by ctx.
+func (al *AgentLoop) resolveGeneration(ctx context.Context, rs *runState, model, channel, sessionKey string) config.GenerationParams {
+	var extra []config.GenerationParams
+	if rs != nil && sessionKey != "" {
+		if p := rs.sessions.GetGenerationForUser(rs.userID, sessionKey); p != nil {
+			extra = append(extra, *p)
+		}
+	}
+	if p, ok := generationFromContext(ctx); ok {
+		extra = append(extra, p)
+	}
+	return al.agents.Resolve(model, channel, extra...)
+}
+
+// chatOptions returns the provider options for an agent LLM call.
+func (al *AgentLoop) chatOptions(ctx context.Context, rs *runState, opts processOptions, model string) map[string]interface{} {
+	llmOpts := al.resolveGeneration(ctx, rs, model, opts.Channel, opts.SessionKey).Options()
+	switch al.vision {
+	case "on":
+		llmOpts["vision"] = true
+	case "off":
+		llmOpts["vision"] = false
+	}
+	if !al.promptCache {
+		llmOpts["prompt_cache"] = false
+	}
+	if opts.ResponseSchema != nil {
+		llmOpts["response_schema"] = opts.ResponseSchema
+	}
+	return llmOpts
+}
+
+// SessionGeneration returns the generation overrides of a session started
+// through ProcessDirect* (the web chat), or nil when none are set.
+func (al *AgentLoop) SessionGeneration(userID int64, sessionKey string) *config.GenerationParams {
+	rs := al.resolveRunState(bus.InboundMessage{UserID: userID})
+	return rs.sessions.GetGenerationForUser(rs.userID, sessionKey)
+}
+
+// SetSessionGeneration replaces the generation overrides of a session started
+// through ProcessDirect*. A nil params clears them.
+func (al *AgentLoop) SetSessionGeneration(userID int64, sessionKey string, params *config.GenerationParams) error {
+	if params != nil {
+		if err := params.Validate(); err != nil {
+			return err
+		}
+	}
+	rs := al.resolveRunState(bus.InboundMessage{UserID: userID})
+	return rs.sessions.SetGenerationForUser(rs.userID, sessionKey, params)
+}
+
+// EffectiveGeneration returns the parameters a ProcessDirect* session would
+// use with the default model.
+func (al *AgentLoop) EffectiveGeneration(userID int64, sessionKey string) config.GenerationParams {
+	rs := al.resolveRunState(bus.InboundMessage{UserID: userID})
+	return al.resolveGeneration(context.Background(), rs, al.model, "cli", sessionKey)
+}
+
+const generationUsage = "Usage: /gen [show | reset | key=value ...]\n" +
+	"Keys: max_tokens, temperature, top_p, stop (comma-separated), reasoning_effort, thinking_budget, seed. " +
+	"Use key=default to clear one override."
+
+// handleGenerationCommand handles the /gen chat command, which shows or
+// changes the session's generation parameters. It reports whether msg was
+// the command.
+func (al *AgentLoop) handleGenerationCommand(rs *runState, msg bus.InboundMessage) (string, bool) {
+	fields := strings.Fields(strings.TrimSpace(msg.Content))
+	if len(fields) == 0 || fields[0] != "/gen" {
+		return "", false
+	}
+
+	current := rs.sessions.GetGenerationForUser(rs.userID, msg.SessionKey)
+	args := fields[1:]
+	switch {
+	case len(args) == 0 || (len(args) == 1 && args[0] == "show"):
+		overrides := config.GenerationParams{}
+		if current != nil {
+			overrides = *current
+		}
+		effective := al.resolveGeneration(context.Background(), rs, al.model, msg.Channel, msg.SessionKey)
+		return fmt.Sprintf("Session overrides: %s\nEffective: %s\n\n%s", overrides, effective, generationUsage), true
+
+	case len(args) == 1 && args[0] == "reset":
+		if err := rs.sessions.SetGenerationForUser(rs.userID, msg.SessionKey, nil); err != nil {
+			return fmt.Sprintf("Failed to reset generation parameters: %v", err), true
+		}
+		return "Generation parameters reset to the configured defaults.", true
+	}
+
+	params := config.GenerationParams{}
+	if current != nil {
+		params = *current
+	}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return generationUsage, true
+		}
+		if err := setGenerationParam(&params, key, value); err != nil {
+			return fmt.Sprintf("Invalid %s: %v\n\n%s", key, err, generationUsage), true
+		}
+	}
+	if err := params.Validate(); err != nil {
+		return fmt.Sprintf("Invalid generation parameters: %v", err), true
+	}
+	if err := rs.sessions.SetGenerationForUser(rs.userID, msg.SessionKey, &params); err != nil {
+		return fmt.Sprintf("Failed to save generation parameters: %v", err), true
+	}
+	return fmt.Sprintf("Session overrides: %s", params), true
+}
+
+// setGenerationParam sets one field from a /gen key=value argument; the
+// value "default" clears the field.
+func setGenerationParam(p *config.GenerationParams, key, value string) error {
+	clear := value == "default"
+	switch key {
+	case "max_tokens", "thinking_budget":
+		target := &p.MaxTokens
+		if key == "thinking_budget" {
+			target = &p.ThinkingBudget
+		}
+		if clear {
+			*target = 0
+			return nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("want a positive integer")
+		}
+		*target = n
+	case "temperature", "top_p":
+		target := &p.Temperature
+		if key == "top_p" {
+			target = &p.TopP
+		}
+		if clear {
+			*target = nil
+			return nil
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("want a number")
+		}
+		*target = &f
+	case "stop":
+		if clear {
+			p.Stop = nil
+			return nil
+		}
+		p.Stop = strings.Split(value, ",")
+	case "reasoning_effort":
+		if clear {
+			p.ReasoningEffort = ""
+			return nil
+		}
+		p.ReasoningEffort = value
+	case "seed":
+		if clear {
+			p.Seed = nil
+			return nil
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("want an integer")
+		}
+		p.Seed = &n
+	default:
+		return fmt.Errorf("unknown parameter")
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sipeed/kakoclaw/pkg/bus"
+	"github.com/sipeed/kakoclaw/pkg/logger"
+	"github.com/sipeed/kakoclaw/pkg/providers"
+	"github.com/sipeed/kakoclaw/pkg/tools"
+	"github.com/sipeed/kakoclaw/pkg/utils"
+)
+
+// registerHandoffTools gives every agent a handoff tool listing the other
+// agents. Only loops with named agents get one.
+func (al *AgentLoop) registerHandoffTools() {
+	if len(al.profiles) == 0 {
+		return
+	}
+	loops := []*AgentLoop{al}
+	for _, p := range al.profiles {
+		loops = append(loops, p)
+	}
+	infos := al.Agents()
+	for _, loop := range loops {
+		targets := make([]tools.HandoffAgent, 0, len(infos)-1)
+		for _, info := range infos {
+			if info.Name != loop.Name() {
+				targets = append(targets, tools.HandoffAgent{Name: info.Name, Description: info.Description})
+			}
+		}
+		loop.tools.Register(tools.NewHandoffTool(targets, loop.runHandoff))
+	}
+}
+
+// runHandoff runs req on the target agent for the session in ctx. The target
+// keeps its own history for the session under a separate key, so its tool
+// calls do not interleave with the caller's. A delegated answer is recorded
+// in the caller's session under the target's name; a transferred one is not,
+// since the caller passes it on to the user as its own reply.
+func (al *AgentLoop) runHandoff(ctx context.Context, req tools.HandoffRequest) (string, error) {
+	target := al.Agent(req.Agent)
+	if target == nil {
+		return "", fmt.Errorf("unknown agent %q", req.Agent)
+	}
+	if target == al {
+		return "", fmt.Errorf("%s cannot hand off to itself", al.Name())
+	}
+	ec, _ := tools.ExecutionContextFrom(ctx)
+	if ec.SessionKey == "" {
+		return "", fmt.Errorf("handoff needs a chat session")
+	}
+
+	logger.InfoCF("agent", "Handing off to "+target.Name(), map[string]interface{}{
+		"from":        al.Name(),
+		"session_key": ec.SessionKey,
+		"transfer":    req.Transfer,
+		"task":        utils.Truncate(req.Task, 80),
+	})
+
+	rs := target.resolveRunState(bus.InboundMessage{UserID: ec.UserID, Channel: ec.Channel})
+	opts := processOptions{
+		SessionKey:      ec.SessionKey + "#handoff:" + target.Name(),
+		Channel:         ec.Channel,
+		ChatID:          ec.ChatID,
+		SenderID:        ec.SenderID,
+		UserMessage:     handoffPrompt(al.Name(), req),
+		DefaultResponse: "I've completed processing but have no response to give.",
+	}
+
+	history := rs.sessions.GetHistoryForUser(rs.userID, opts.SessionKey)
+	summary := rs.sessions.GetSummaryForUser(rs.userID, ec.SessionKey)
+	messages := rs.contextBuilder.BuildMessages(history, summary, opts.UserMessage, nil, opts.Channel, opts.ChatID)
+	rs.sessions.AddMessageForUser(rs.userID, opts.SessionKey, "user", opts.UserMessage)
+
+	runCtx := tools.WithHandoff(tools.WithExecutionContext(rs.withCaller(ctx, opts), rs.executionContext(opts)), al.Name())
+	answer, reasoning, _, err := target.runLLMIteration(runCtx, rs, messages, opts)
+	if err != nil {
+		return "", err
+	}
+	if answer == "" {
+		answer = opts.DefaultResponse
+	}
+
+	rs.sessions.AddFullMessageForUser(rs.userID, opts.SessionKey, target.historyMessage(providers.Message{
+		Role:      "assistant",
+		Content:   answer,
+		Reasoning: reasoning,
+	}))
+	rs.sessions.SaveForUser(rs.userID, rs.sessions.GetOrCreateForUser(rs.userID, opts.SessionKey))
+
+	if al.storage != nil && !req.Transfer {
+		if err := al.storage.SaveAgentMessageForUser(rs.userID, ec.SessionKey, "assistant", answer, target.Name()); err != nil {
+			logger.ErrorCF("agent", "Failed to save handoff answer to storage", map[string]interface{}{"error": err.Error()})
+		}
+	}
+
+	if req.Transfer {
+		al.transfer(rs.userID, ec.SessionKey, target.Name())
+	}
+	return answer, nil
+}
+
+// transfer routes the session's next messages to the named agent.
+func (al *AgentLoop) transfer(userID int64, sessionKey, name string) {
+	root := al
+	if al.root != nil {
+		root = al.root
+	}
+	root.routesMu.Lock()
+	root.transfers[sessionKey] = name
+	root.routesMu.Unlock()
+
+	if root.storage != nil {
+		if err := root.storage.SetSessionAgentForUser(userID, sessionKey, name); err != nil {
+			logger.WarnCF("agent", "Failed to save session agent", map[string]interface{}{
+				"session_key": sessionKey,
+				"error":       err.Error(),
+			})
+		}
+	}
+}
+
+// handoffPrompt is the message the target agent receives.
+func handoffPrompt(from string, req tools.HandoffRequest) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[Handoff from %s]", from)
+	if req.Transfer {
+		sb.WriteString(" You are taking over this conversation.")
+	}
+	if c := strings.TrimSpace(req.Context); c != "" {
+		sb.WriteString("\n\n## Context\n\n" + c)
+	}
+	sb.WriteString("\n\n## Request\n\n" + req.Task)
+	return sb.String()
+}
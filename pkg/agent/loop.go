@@ -9,19 +9,24 @@ package agent
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/sipeed/kakoclaw/pkg/approval"
+	"github.com/sipeed/kakoclaw/pkg/budget"
 	"github.com/sipeed/kakoclaw/pkg/bus"
 	"github.com/sipeed/kakoclaw/pkg/config"
 	"github.com/sipeed/kakoclaw/pkg/logger"
 	"github.com/sipeed/kakoclaw/pkg/mcp"
 	"github.com/sipeed/kakoclaw/pkg/observability"
+	"github.com/sipeed/kakoclaw/pkg/policy"
 	"github.com/sipeed/kakoclaw/pkg/providers"
 	"github.com/sipeed/kakoclaw/pkg/ratelimit"
 	"github.com/sipeed/kakoclaw/pkg/session"
@@ -33,19 +38,42 @@ import (
 type AgentLoop struct {
 	bus              *bus.MessageBus
 	provider         providers.LLMProvider
-	workspace        string
 	defaultWorkspace string // Base workspace when no user is set
-	userUUID         string // User UUID for multiuser support
-	userID           int64  // User ID for multiuser support
+	userUUID         string // Default user UUID for messages without a user
+	userID           int64  // Default user ID for messages without a user
 	model            string
-	contextWindow    int // Maximum context window size in tokens
+	contextWindows   *providers.ContextWindows // Context window sizes per model
 	maxIterations    int
-	sessions         *session.SessionManager
-	contextBuilder   *ContextBuilder
+	maxConcurrent    int                 // Maximum number of messages processed in parallel by Run
+	vision           string              // Image input override: "auto", "on" or "off"
+	persistReasoning bool                // Keep reasoning text in session history
+	promptCache      bool                // Ask providers to cache the prompt prefix
+	steering         *steering           // Running turns that new messages can steer
+	jsonRetries      int                 // Repair attempts for answers that fail the response schema
+	agents           config.AgentsConfig // Generation defaults and overrides
+	defaultScope     *userScope
+	scopes           map[string]*userScope // Per-user scopes keyed by user UUID
+	scopesMu         sync.Mutex
 	tools            *tools.ToolRegistry
+	approvals        *approval.Manager
+	policy           *policy.Manager
+	budget           *budget.Manager
+	subagents        *tools.SubagentManager
+	processes        *tools.ProcessManager
+	shells           *tools.ShellSessionManager
 	running          atomic.Bool
 	summarizing      sync.Map // Tracks which sessions are currently being summarized
 	storage          *storage.Storage
+	mcp              *mcp.Manager
+
+	// Named agents. A profile loop shares its root's storage, MCP tools,
+	// approvals, tool policy, budget, steering, subagents, processes and
+	// session history; the root routes inbound messages to its profiles.
+	profile   config.AgentProfile
+	root      *AgentLoop
+	profiles  map[string]*AgentLoop
+	transfers map[string]string // Agent name by session key, after a handoff transferred the chat
+	routesMu  sync.Mutex
 }
 
 // ToolRegistry returns the agent loop's tool registry so external
@@ -59,36 +87,88 @@ type processOptions struct {
 	SessionKey      string         // Session identifier for history/context
 	Channel         string         // Target channel for tool execution
 	ChatID          string         // Target chat ID for tool execution
+	SenderID        string         // Sender allowed to answer approval prompts (empty = anyone in the chat)
 	UserMessage     string         // User message content (may include prefix)
+	Media           []string       // Attached media: local paths, URLs or data URLs
 	DefaultResponse string         // Response when LLM returns empty
 	EnableSummary   bool           // Whether to trigger summarization
 	SendResponse    bool           // Whether to send response via bus
 	ModelOverride   string         // If set, use this model instead of the default for LLM calls
 	ExcludeTools    []string       // Tool names to exclude from this request (e.g., "web_search")
 	OnToken         StreamCallback // Optional callback for text tokens
+	OnReasoning     StreamCallback // Optional callback for reasoning (thinking) tokens
 	OnTool          ToolCallback   // Optional callback for tool call updates
+	Steer           *steerInbox    // Messages steering this turn, if it accepts any
+
+	// ResponseSchema is the JSON Schema the final answer must follow, if any.
+	ResponseSchema map[string]interface{}
 }
 
 // ToolEvent represents a tool call update during agent execution.
+// Calls from one LLM response may run in parallel, so events for different
+// calls can interleave; use ID to pair a call's start and finish events.
 type ToolEvent struct {
-	Name   string                 `json:"name"`
-	Args   map[string]interface{} `json:"arguments"`
-	Result string                 `json:"result,omitempty"`
-	Status string                 `json:"status"` // "started", "finished", "error"
+	ID         string                 `json:"id"`
+	Name       string                 `json:"name"`
+	Args       map[string]interface{} `json:"arguments"`
+	Result     string                 `json:"result,omitempty"`
+	Status     string                 `json:"status"` // "awaiting_approval", "denied", "started", "finished", "error"
+	StartedAt  time.Time              `json:"started_at"`
+	FinishedAt time.Time              `json:"finished_at,omitempty"`
+	DurationMs int64                  `json:"duration_ms,omitempty"`
 }
 
-// ToolCallback is called when a tool is about to be executed or starts/finishes.
+// ToolCallback is called when a tool starts or finishes. Calls are never made
+// concurrently, even when the tools themselves run in parallel.
 type ToolCallback func(ev ToolEvent) error
 
+// NewAgentLoop creates the default agent from agents.defaults, along with a
+// loop for each named agent in agents.list. Run routes inbound messages to
+// them according to agents.routes.
 func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers.LLMProvider) *AgentLoop {
+	al := newAgentLoop(cfg, msgBus, provider, config.AgentProfile{}, nil)
+	al.profiles = make(map[string]*AgentLoop, len(cfg.Agents.List))
+	al.transfers = make(map[string]string)
+	for _, profile := range cfg.Agents.List {
+		al.profiles[profile.Name] = newAgentLoop(cfg, msgBus, provider, profile, al)
+		logger.InfoCF("agent", "Created named agent", map[string]interface{}{
+			"agent": profile.Name,
+			"model": al.profiles[profile.Name].model,
+		})
+	}
+	al.registerHandoffTools()
+	return al
+}
+
+// NewAgentLoopForProfile creates a standalone loop for the named agent in
+// agents.list, e.g. to run it from the command line.
+func NewAgentLoopForProfile(cfg *config.Config, msgBus *bus.MessageBus, provider providers.LLMProvider, name string) (*AgentLoop, error) {
+	if name == "" || name == config.DefaultAgentName {
+		return newAgentLoop(cfg, msgBus, provider, config.AgentProfile{}, nil), nil
+	}
+	profile, ok := cfg.Agents.Profile(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown agent %q", name)
+	}
+	return newAgentLoop(cfg, msgBus, provider, profile, nil), nil
+}
+
+// newAgentLoop builds a loop for profile, which is the zero profile for the
+// default agent. With root set, the loop shares root's resources.
+func newAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers.LLMProvider, profile config.AgentProfile, root *AgentLoop) *AgentLoop {
 	workspace := cfg.WorkspacePath()
+	if dir := profile.WorkspacePath(); dir != "" {
+		workspace = dir
+	}
 	os.MkdirAll(workspace, 0755)
 
 	restrict := cfg.Agents.Defaults.RestrictToWorkspace
 
 	// Initialize Storage
 	var store *storage.Storage
-	if cfg.Storage.Path != "" {
+	if root != nil {
+		store = root.storage
+	} else if cfg.Storage.Path != "" {
 		var err error
 		store, err = storage.New(cfg.Storage)
 		if err != nil {
@@ -97,10 +177,32 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 	}
 
 	toolsRegistry := tools.NewToolRegistry()
+	toolsRegistry.SetAllowed(profile.Tools)
+	toolsRegistry.SetLimits(cfg.Tools.Limits)
+	if root != nil {
+		toolsRegistry.SetCache(root.tools.Cache())
+	} else {
+		toolsRegistry.SetCache(tools.NewResultCache(cfg.Tools.Cache))
+	}
 	toolsRegistry.Register(tools.NewReadFileTool(workspace, restrict))
 	toolsRegistry.Register(tools.NewWriteFileTool(workspace, restrict))
 	toolsRegistry.Register(tools.NewListDirTool(workspace, restrict))
-	toolsRegistry.Register(tools.NewExecTool(workspace, restrict))
+	execTool := tools.NewExecTool(workspace, restrict)
+	execTool.SetSandbox(cfg.Tools.Exec.Sandbox)
+	toolsRegistry.Register(execTool)
+
+	// Background processes are shared with named agents so that one list
+	// covers them all and shutdown stops every one.
+	var processes *tools.ProcessManager
+	if root != nil {
+		processes = root.processes
+	} else {
+		processes = tools.NewProcessManager(execTool, cfg.Tools.Process)
+	}
+	toolsRegistry.Register(tools.NewProcessTool(processes))
+	// Shells run in this agent's workspace, so each agent keeps its own.
+	shells := tools.NewShellSessionManager(execTool, cfg.Tools.Shell)
+	toolsRegistry.Register(tools.NewShellTool(shells))
 
 	braveAPIKey := cfg.Tools.Web.Search.APIKey
 	toolsRegistry.Register(tools.NewWebSearchTool(braveAPIKey, cfg.Tools.Web.Search.MaxResults))
@@ -126,9 +228,27 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 	})
 	toolsRegistry.Register(messageTool)
 
-	// Register spawn tool
-	subagentManager := tools.NewSubagentManager(provider, workspace, msgBus)
+	// Subagents are shared with named agents like background processes; a
+	// named agent's subagents still only get that agent's tools.
+	var subagentManager *tools.SubagentManager
+	if root != nil {
+		subagentManager = root.subagents
+	} else {
+		subagentManager = tools.NewSubagentManager(provider, workspace, msgBus, toolsRegistry, cfg.Agents.Subagents)
+		if store != nil {
+			subagentManager.SetStore(store)
+		}
+		agentsCfg := cfg.Agents
+		subagentManager.SetChatOptions(func(model, channel string) map[string]interface{} {
+			return agentsCfg.Resolve(model, channel).Options()
+		})
+	}
 	spawnTool := tools.NewSpawnTool(subagentManager)
+	agentName := profile.Name
+	if agentName == "" {
+		agentName = config.DefaultAgentName
+	}
+	spawnTool.SetAgent(agentName, toolsRegistry)
 	toolsRegistry.Register(spawnTool)
 
 	// Register edit file tool
@@ -147,103 +267,147 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 		toolsRegistry.Register(tools.NewKnowledgeTool(store))
 	}
 
-	// Register MCP tools from configured servers
-	if len(cfg.Tools.MCP.Servers) > 0 {
-		mcpMgr := mcp.NewManager(cfg.Tools.MCP)
+	// Register MCP tools from configured servers; named agents reuse the
+	// servers started for the default agent.
+	var mcpMgr *mcp.Manager
+	if root != nil {
+		mcpMgr = root.mcp
+	} else if len(cfg.Tools.MCP.Servers) > 0 {
+		mcpMgr = mcp.NewManager(cfg.Tools.MCP)
 		mcpMgr.Start(context.Background())
+	}
+	if mcpMgr != nil {
 		for _, mcpTool := range mcpMgr.GetTools() {
 			toolsRegistry.Register(mcpTool)
-			logger.InfoCF("agent", "Registered MCP tool", map[string]interface{}{"name": mcpTool.Name()})
+			if root == nil {
+				logger.InfoCF("agent", "Registered MCP tool", map[string]interface{}{"name": mcpTool.Name()})
+			}
 		}
 	}
 
-	sessionsManager := session.NewSessionManager(filepath.Join(workspace, "sessions"))
-
-	// Create context builder and set tools registry
-	contextBuilder := NewContextBuilder(workspace)
-	contextBuilder.SetToolsRegistry(toolsRegistry)
-
-	return &AgentLoop{
-		bus:              msgBus,
-		provider:         provider,
-		workspace:        workspace,
-		defaultWorkspace: workspace,
-		userUUID:         "", // Will be set via SetUserForAgent if needed
-		userID:           0,  // Default for backward compatibility
-		model:            cfg.Agents.Defaults.Model,
-		contextWindow:    cfg.Agents.Defaults.MaxTokens, // Restore context window for summarization
-		maxIterations:    cfg.Agents.Defaults.MaxToolIterations,
-		sessions:         sessionsManager,
-		contextBuilder:   contextBuilder,
-		tools:            toolsRegistry,
-		summarizing:      sync.Map{},
-		storage:          store,
+	// Named agents keep the default agent's session history, so a chat can
+	// move between agents without losing its context.
+	var sessions *session.SessionManager
+	if root != nil {
+		sessions = root.defaultScope.sessions
+	} else {
+		sessions = session.NewSessionManager(filepath.Join(workspace, "sessions"))
 	}
-}
 
-// SetUserForAgent configures the agent loop for a specific user (multiuser support).
-func (al *AgentLoop) SetUserForAgent(userUUID string, userID int64) {
-	al.userUUID = userUUID
-	al.userID = userID
+	var approvals *approval.Manager
+	var toolPolicy *policy.Manager
+	var budgets *budget.Manager
+	var steer *steering
+	if root != nil {
+		approvals, toolPolicy, budgets, steer = root.approvals, root.policy, root.budget, root.steering
+	} else {
+		var approvalRecorder approval.Recorder
+		if store != nil {
+			approvalRecorder = store
+		}
+		approvals = approval.NewManager(cfg.Tools.Approval, msgBus, approvalRecorder)
 
-	if userUUID == "" {
-		al.workspace = al.defaultWorkspace
-		al.sessions.SetStorage(filepath.Join(al.workspace, "sessions"))
-		al.updateToolsWorkspace(al.workspace)
-		al.contextBuilder.WithUser(userUUID, userID)
-		return
-	}
+		var policyStore policy.Store
+		if store != nil {
+			policyStore = store
+		}
+		toolPolicy = policy.NewManager(cfg.Tools.Policy, policyStore)
 
-	if userUUID != "" {
-		workspace, err := config.EnsureUserWorkspace(userUUID)
-		if err != nil {
-			logger.WarnCF("agent", "Failed to ensure user workspace", map[string]interface{}{"error": err.Error()})
-		} else {
-			al.workspace = workspace
-			al.sessions.SetStorage(filepath.Join(workspace, "sessions"))
-			al.updateToolsWorkspace(workspace)
+		var budgetStore budget.Store
+		if store != nil {
+			budgetStore = store
 		}
+		budgets = budget.NewManager(cfg.Budget, cfg.Agents.Defaults.Provider, budgetStore)
+		steer = newSteering(cfg.Agents.Defaults.SteerMode)
 	}
 
-	al.contextBuilder.WithUser(userUUID, userID)
-}
-
-// updateToolsWorkspace updates workspace paths for tools that depend on a workspace directory.
-func (al *AgentLoop) updateToolsWorkspace(workspace string) {
-	if al.tools == nil {
-		return
+	maxConcurrent := cfg.Agents.Defaults.MaxConcurrentRuns
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultMaxConcurrentRuns
 	}
-	al.tools.ForEach(func(t tools.Tool) {
-		if wt, ok := t.(tools.WorkspaceTool); ok {
-			wt.SetWorkspace(workspace)
-		}
-	})
-}
 
-// applyMessageUserContext configures user context for the current inbound message.
-func (al *AgentLoop) applyMessageUserContext(msg bus.InboundMessage) {
-	if msg.UserID == 0 {
-		al.SetUserForAgent("", 0)
-		return
+	model := cfg.Agents.Defaults.Model
+	if profile.Model != "" {
+		model = profile.Model
 	}
-	if al.storage == nil {
-		al.SetUserForAgent("", msg.UserID)
-		return
+	maxIterations := cfg.Agents.Defaults.MaxToolIterations
+	if profile.MaxToolIterations > 0 {
+		maxIterations = profile.MaxToolIterations
 	}
 
-	user, err := al.storage.GetUserByID(msg.UserID)
-	if err != nil {
-		logger.WarnCF("agent", "Failed to resolve user UUID", map[string]interface{}{"error": err.Error()})
-		al.SetUserForAgent("", msg.UserID)
-		return
+	al := &AgentLoop{
+		bus:              msgBus,
+		provider:         provider,
+		defaultWorkspace: workspace,
+		userUUID:         "", // Will be set via SetUserForAgent if needed
+		userID:           0,  // Default for backward compatibility
+		model:            model,
+		contextWindows:   providers.NewContextWindows(cfg),
+		maxIterations:    maxIterations,
+		maxConcurrent:    maxConcurrent,
+		vision:           cfg.Agents.Defaults.Vision,
+		persistReasoning: cfg.Agents.Defaults.PersistReasoning,
+		promptCache:      cfg.Agents.Defaults.PromptCache != "off",
+		steering:         steer,
+		jsonRetries:      cfg.Agents.Defaults.StructuredRetries,
+		agents:           cfg.Agents,
+		defaultScope: &userScope{
+			workspace: workspace,
+			sessions:  sessions,
+		},
+		scopes:      make(map[string]*userScope),
+		tools:       toolsRegistry,
+		approvals:   approvals,
+		policy:      toolPolicy,
+		budget:      budgets,
+		subagents:   subagentManager,
+		processes:   processes,
+		shells:      shells,
+		summarizing: sync.Map{},
+		storage:     store,
+		mcp:         mcpMgr,
+		profile:     profile,
+		root:        root,
+	}
+	al.defaultScope.contextBuilder = al.newContextBuilder(workspace, "", 0)
+
+	// Subagents share the main agent's approval and tool policies and budget ledger.
+	if root == nil {
+		subagentManager.SetToolGate(func(ctx context.Context, tc providers.ToolCall) (string, bool) {
+			return al.authorizeToolCall(ctx, tc, func(ToolEvent) {})
+		})
+		subagentManager.SetLLMObserver(al.recordSubagentLLMCall)
+		subagentManager.SetLLMCheck(al.checkSubagentLLMCall)
 	}
 
-	al.SetUserForAgent(user.UUID, msg.UserID)
+	return al
 }
 
+// SetUserForAgent sets the default user for messages that do not carry a
+// user ID (multiuser support). Per-message users are resolved per run.
+func (al *AgentLoop) SetUserForAgent(userUUID string, userID int64) {
+	al.userUUID = userUUID
+	al.userID = userID
+	for _, p := range al.profiles {
+		p.SetUserForAgent(userUUID, userID)
+	}
+}
+
+// Run consumes inbound messages and processes them on a bounded worker pool.
+// Messages for the same session are handled strictly in order; different
+// sessions run concurrently up to the configured cap.
 func (al *AgentLoop) Run(ctx context.Context) error {
 	al.running.Store(true)
 
+	dispatcher := newSessionDispatcher()
+	workers := dispatcher.startWorkers(ctx, al.maxConcurrent, func(ctx context.Context, msg bus.InboundMessage) {
+		al.route(msg).handleInbound(ctx, msg)
+	})
+	defer func() {
+		dispatcher.Close()
+		workers.Wait()
+	}()
+
 	for al.running.Load() {
 		select {
 		case <-ctx.Done():
@@ -254,33 +418,84 @@ func (al *AgentLoop) Run(ctx context.Context) error {
 				continue
 			}
 
-			al.applyMessageUserContext(msg)
-
-			response, err := al.processMessage(ctx, msg)
-			if err != nil {
-				response = fmt.Sprintf("Error processing message: %v", err)
+			// Approval replies must bypass the session queue: the session is
+			// blocked on the very tool call they approve.
+			if al.approvals.HandleInbound(msg) {
+				continue
 			}
 
-			if response != "" {
-				al.bus.PublishOutbound(bus.OutboundMessage{
-					UserID:  msg.UserID,
-					Channel: msg.Channel,
-					ChatID:  msg.ChatID,
-					Content: response,
-				})
+			// Steering messages join the session's running turn instead of
+			// waiting for it to finish.
+			if al.route(msg).steerInbound(&msg) {
+				continue
 			}
+
+			dispatcher.Enqueue(msg)
 		}
 	}
 
 	return nil
 }
 
+// handleInbound processes a single inbound message and publishes the response.
+func (al *AgentLoop) handleInbound(ctx context.Context, msg bus.InboundMessage) {
+	response, err := al.processMessage(ctx, msg)
+	if err != nil {
+		response = fmt.Sprintf("Error processing message: %v", err)
+	}
+
+	if response != "" {
+		al.bus.PublishOutbound(bus.OutboundMessage{
+			UserID:  msg.UserID,
+			Channel: msg.Channel,
+			ChatID:  msg.ChatID,
+			Content: response,
+		})
+	}
+}
+
 func (al *AgentLoop) Stop() {
 	al.running.Store(false)
+	al.shells.Shutdown()
+	if al.root == nil {
+		al.processes.Shutdown()
+	}
+	for _, p := range al.profiles {
+		p.shells.Shutdown()
+	}
+}
+
+// Approvals returns the tool approval manager so front ends (e.g. the web
+// chat) can deliver prompts and resolve decisions.
+func (al *AgentLoop) Approvals() *approval.Manager {
+	return al.approvals
 }
 
+// ToolPolicy returns the tool permission policy so front ends can show and
+// edit it.
+func (al *AgentLoop) ToolPolicy() *policy.Manager {
+	return al.policy
+}
+
+// Subagents returns the subagent manager so front ends can list and cancel
+// subagent runs.
+func (al *AgentLoop) Subagents() *tools.SubagentManager {
+	return al.subagents
+}
+
+// Processes returns the background process manager so front ends can list
+// and stop the processes started by the process tool.
+func (al *AgentLoop) Processes() *tools.ProcessManager {
+	return al.processes
+}
+
+// RegisterTool adds a tool to this agent and to its named agents that allow
+// it.
 func (al *AgentLoop) RegisterTool(tool tools.Tool) {
 	al.tools.Register(tool)
+	for _, p := range al.profiles {
+		p.tools.Register(tool)
+	}
 }
 
 func (al *AgentLoop) ProcessDirect(ctx context.Context, content, sessionKey string) (string, error) {
@@ -313,16 +528,18 @@ func (al *AgentLoop) ProcessDirectWithUser(ctx context.Context, userID int64, co
 	return al.processMessage(ctx, msg)
 }
 
-// ProcessDirectWithModel processes a message using a specific model override.
+// ProcessDirectWithModel processes a message on behalf of userID (0 for the
+// default user) using a specific model override.
 // If modelOverride is empty, uses the default configured model.
 // excludeTools optionally specifies tool names to exclude from this request.
-func (al *AgentLoop) ProcessDirectWithModel(ctx context.Context, content, sessionKey, modelOverride string, excludeTools ...string) (string, error) {
+func (al *AgentLoop) ProcessDirectWithModel(ctx context.Context, userID int64, content, sessionKey, modelOverride string, excludeTools ...string) (string, error) {
 	msg := bus.InboundMessage{
 		Channel:    "cli",
 		SenderID:   "cron",
 		ChatID:     "direct",
 		Content:    content,
 		SessionKey: sessionKey,
+		UserID:     userID,
 	}
 
 	return al.processMessageWithModel(ctx, msg, modelOverride, excludeTools...)
@@ -334,17 +551,20 @@ type StreamCallback func(token string) error
 // ProcessDirectWithModelStream processes a message and streams the final response token-by-token.
 // If the provider doesn't support streaming, falls back to sending the full response at once.
 // The onToken callback is called for each token; the full accumulated response is still returned.
+// onReasoning, if set, receives the model's thinking separately from the answer.
 // excludeTools optionally specifies tool names to exclude from this request.
-func (al *AgentLoop) ProcessDirectWithModelStream(ctx context.Context, content, sessionKey, modelOverride string, onToken StreamCallback, onTool ToolCallback, excludeTools ...string) (string, error) {
+// Like ProcessDirectWithModel, the run is made on behalf of userID.
+func (al *AgentLoop) ProcessDirectWithModelStream(ctx context.Context, userID int64, content, sessionKey, modelOverride string, onToken, onReasoning StreamCallback, onTool ToolCallback, excludeTools ...string) (string, error) {
 	msg := bus.InboundMessage{
 		Channel:    "cli",
 		SenderID:   "cron",
 		ChatID:     "direct",
 		Content:    content,
 		SessionKey: sessionKey,
+		UserID:     userID,
 	}
 
-	return al.processMessageWithModelStream(ctx, msg, modelOverride, onToken, onTool, excludeTools...)
+	return al.processMessageWithModelStream(ctx, msg, modelOverride, onToken, onReasoning, onTool, excludeTools...)
 }
 
 // SupportsStreaming returns true if the current provider supports streaming.
@@ -358,7 +578,16 @@ func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage)
 }
 
 func (al *AgentLoop) processMessageWithModel(ctx context.Context, msg bus.InboundMessage, modelOverride string, excludeTools ...string) (string, error) {
-	al.applyMessageUserContext(msg)
+	return al.processUserMessage(ctx, msg, processOptions{
+		ModelOverride: modelOverride,
+		ExcludeTools:  excludeTools,
+	})
+}
+
+// processUserMessage runs msg through the agent loop. opts carries the
+// caller's per-request settings; the rest is filled in from msg.
+func (al *AgentLoop) processUserMessage(ctx context.Context, msg bus.InboundMessage, opts processOptions) (string, error) {
+	rs := al.resolveRunState(msg)
 
 	// Issue #9: Rate limiting
 	// Check user rate limit
@@ -385,24 +614,29 @@ func (al *AgentLoop) processMessageWithModel(ctx context.Context, msg bus.Inboun
 
 	// Route system messages to processSystemMessage
 	if msg.Channel == "system" {
-		return al.processSystemMessage(ctx, msg)
+		return al.processSystemMessage(ctx, rs, msg)
+	}
+
+	if reply, ok := al.handleGenerationCommand(rs, msg); ok {
+		return reply, nil
 	}
 
 	// Process as user message
-	return al.runAgentLoop(ctx, processOptions{
-		SessionKey:      msg.SessionKey,
-		Channel:         msg.Channel,
-		ChatID:          msg.ChatID,
-		UserMessage:     msg.Content,
-		DefaultResponse: "I've completed processing but have no response to give.",
-		EnableSummary:   true,
-		SendResponse:    false,
-		ModelOverride:   modelOverride,
-		ExcludeTools:    excludeTools,
-	})
+	opts.SessionKey = msg.SessionKey
+	opts.Channel = msg.Channel
+	opts.ChatID = msg.ChatID
+	opts.SenderID = msg.SenderID
+	opts.UserMessage = msg.Content
+	opts.Media = msg.Media
+	opts.DefaultResponse = "I've completed processing but have no response to give."
+	opts.EnableSummary = true
+	opts.SendResponse = false
+	return al.runAgentLoop(ctx, rs, opts)
 }
 
-func (al *AgentLoop) processMessageWithModelStream(ctx context.Context, msg bus.InboundMessage, modelOverride string, onToken StreamCallback, onTool ToolCallback, excludeTools ...string) (string, error) {
+func (al *AgentLoop) processMessageWithModelStream(ctx context.Context, msg bus.InboundMessage, modelOverride string, onToken, onReasoning StreamCallback, onTool ToolCallback, excludeTools ...string) (string, error) {
+	rs := al.resolveRunState(msg)
+
 	// Rate limiting
 	userKey := fmt.Sprintf("user:%s", msg.SenderID)
 	if !ratelimit.GetGlobalLimiter().Allow(userKey) {
@@ -418,25 +652,32 @@ func (al *AgentLoop) processMessageWithModelStream(ctx context.Context, msg bus.
 		})
 
 	if msg.Channel == "system" {
-		return al.processSystemMessage(ctx, msg)
+		return al.processSystemMessage(ctx, rs, msg)
+	}
+
+	if reply, ok := al.handleGenerationCommand(rs, msg); ok {
+		return reply, nil
 	}
 
-	return al.runAgentLoopStream(ctx, processOptions{
+	return al.runAgentLoopStream(ctx, rs, processOptions{
 		SessionKey:      msg.SessionKey,
 		Channel:         msg.Channel,
 		ChatID:          msg.ChatID,
+		SenderID:        msg.SenderID,
 		UserMessage:     msg.Content,
+		Media:           msg.Media,
 		DefaultResponse: "I've completed processing but have no response to give.",
 		EnableSummary:   true,
 		SendResponse:    false,
 		ModelOverride:   modelOverride,
 		ExcludeTools:    excludeTools,
 		OnToken:         onToken,
+		OnReasoning:     onReasoning,
 		OnTool:          onTool,
 	}, onToken)
 }
 
-func (al *AgentLoop) processSystemMessage(ctx context.Context, msg bus.InboundMessage) (string, error) {
+func (al *AgentLoop) processSystemMessage(ctx context.Context, rs *runState, msg bus.InboundMessage) (string, error) {
 	// Verify this is a system message
 	if msg.Channel != "system" {
 		return "", fmt.Errorf("processSystemMessage called with non-system message channel: %s", msg.Channel)
@@ -463,7 +704,7 @@ func (al *AgentLoop) processSystemMessage(ctx context.Context, msg bus.InboundMe
 	sessionKey := fmt.Sprintf("%s:%s", originChannel, originChatID)
 
 	// Process as system message with routing back to origin
-	return al.runAgentLoop(ctx, processOptions{
+	return al.runAgentLoop(ctx, rs, processOptions{
 		SessionKey:      sessionKey,
 		Channel:         originChannel,
 		ChatID:          originChatID,
@@ -476,34 +717,42 @@ func (al *AgentLoop) processSystemMessage(ctx context.Context, msg bus.InboundMe
 
 // runAgentLoop is the core message processing logic.
 // It handles context building, LLM calls, tool execution, and response handling.
-func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (string, error) {
+func (al *AgentLoop) runAgentLoop(ctx context.Context, rs *runState, opts processOptions) (string, error) {
 	agentStart := time.Now()
 
-	// 1. Update tool contexts
-	al.updateToolContexts(opts.Channel, opts.ChatID)
+	// Let messages that arrive during this turn steer it
+	opts.Steer = al.steering.begin(opts.SessionKey)
+	defer al.steering.end(opts.SessionKey, opts.Steer)
+
+	// 1. Attach per-run tool context (channel, chat, workspace, caller)
+	ctx = tools.WithExecutionContext(ctx, rs.executionContext(opts))
+	ctx = rs.withCaller(ctx, opts)
 
 	// 2. Build messages
-	history := al.sessions.GetHistoryForUser(al.userID, opts.SessionKey)
-	summary := al.sessions.GetSummaryForUser(al.userID, opts.SessionKey)
-	messages := al.contextBuilder.BuildMessages(
+	history := rs.sessions.GetHistoryForUser(rs.userID, opts.SessionKey)
+	summary := rs.sessions.GetSummaryForUser(rs.userID, opts.SessionKey)
+	messages := rs.contextBuilder.BuildMessages(
 		history,
 		summary,
 		opts.UserMessage,
-		nil,
+		opts.Media,
 		opts.Channel,
 		opts.ChatID,
 	)
+	if opts.ResponseSchema != nil {
+		messages = withStructuredOutputPrompt(messages, opts.ResponseSchema)
+	}
 
 	// 3. Save user message to session
-	al.sessions.AddMessageForUser(al.userID, opts.SessionKey, "user", opts.UserMessage)
+	rs.sessions.AddMessageForUser(rs.userID, opts.SessionKey, "user", opts.UserMessage)
 	if al.storage != nil {
-		if err := al.storage.SaveMessageForUser(al.userID, opts.SessionKey, "user", opts.UserMessage); err != nil {
+		if err := al.storage.SaveMessageForUser(rs.userID, opts.SessionKey, "user", opts.UserMessage); err != nil {
 			logger.ErrorCF("agent", "Failed to save user message to storage", map[string]interface{}{"error": err.Error()})
 		}
 	}
 
 	// 4. Run LLM iteration loop
-	finalContent, iteration, err := al.runLLMIteration(ctx, messages, opts)
+	finalContent, reasoning, iteration, err := al.runLLMIteration(ctx, rs, messages, opts)
 	observability.Global().RecordAgentRun(time.Since(agentStart), iteration, err)
 	if err != nil {
 		return "", err
@@ -515,23 +764,27 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 	}
 
 	// 6. Save final assistant message to session
-	al.sessions.AddMessageForUser(al.userID, opts.SessionKey, "assistant", finalContent)
-	al.sessions.SaveForUser(al.userID, al.sessions.GetOrCreateForUser(al.userID, opts.SessionKey))
+	rs.sessions.AddFullMessageForUser(rs.userID, opts.SessionKey, al.historyMessage(providers.Message{
+		Role:      "assistant",
+		Content:   finalContent,
+		Reasoning: reasoning,
+	}))
+	rs.sessions.SaveForUser(rs.userID, rs.sessions.GetOrCreateForUser(rs.userID, opts.SessionKey))
 	if al.storage != nil {
-		if err := al.storage.SaveMessageForUser(al.userID, opts.SessionKey, "assistant", finalContent); err != nil {
+		if err := al.storage.SaveAgentMessageForUser(rs.userID, opts.SessionKey, "assistant", finalContent, al.profile.Name); err != nil {
 			logger.ErrorCF("agent", "Failed to save assistant message to storage", map[string]interface{}{"error": err.Error()})
 		}
 	}
 
 	// 7. Optional: summarization
 	if opts.EnableSummary {
-		al.maybeSummarize(opts.SessionKey)
+		al.maybeSummarize(rs, opts)
 	}
 
 	// 8. Optional: send response via bus
 	if opts.SendResponse {
 		al.bus.PublishOutbound(bus.OutboundMessage{
-			UserID:  al.userID,
+			UserID:  rs.userID,
 			Channel: opts.Channel,
 			ChatID:  opts.ChatID,
 			Content: finalContent,
@@ -552,34 +805,39 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 
 // runAgentLoopStream is like runAgentLoop but streams the final text response token-by-token.
 // Tool call iterations are handled non-streaming. Only the final text answer is streamed.
-func (al *AgentLoop) runAgentLoopStream(ctx context.Context, opts processOptions, onToken StreamCallback) (string, error) {
+func (al *AgentLoop) runAgentLoopStream(ctx context.Context, rs *runState, opts processOptions, onToken StreamCallback) (string, error) {
 	agentStart := time.Now()
 
-	// 1. Update tool contexts
-	al.updateToolContexts(opts.Channel, opts.ChatID)
+	// Let messages that arrive during this turn steer it
+	opts.Steer = al.steering.begin(opts.SessionKey)
+	defer al.steering.end(opts.SessionKey, opts.Steer)
+
+	// 1. Attach per-run tool context (channel, chat, workspace, caller)
+	ctx = tools.WithExecutionContext(ctx, rs.executionContext(opts))
+	ctx = rs.withCaller(ctx, opts)
 
 	// 2. Build messages
-	history := al.sessions.GetHistoryForUser(al.userID, opts.SessionKey)
-	summary := al.sessions.GetSummaryForUser(al.userID, opts.SessionKey)
-	messages := al.contextBuilder.BuildMessages(
+	history := rs.sessions.GetHistoryForUser(rs.userID, opts.SessionKey)
+	summary := rs.sessions.GetSummaryForUser(rs.userID, opts.SessionKey)
+	messages := rs.contextBuilder.BuildMessages(
 		history,
 		summary,
 		opts.UserMessage,
-		nil,
+		opts.Media,
 		opts.Channel,
 		opts.ChatID,
 	)
 
 	// 3. Save user message to session
-	al.sessions.AddMessageForUser(al.userID, opts.SessionKey, "user", opts.UserMessage)
+	rs.sessions.AddMessageForUser(rs.userID, opts.SessionKey, "user", opts.UserMessage)
 	if al.storage != nil {
-		if err := al.storage.SaveMessageForUser(al.userID, opts.SessionKey, "user", opts.UserMessage); err != nil {
+		if err := al.storage.SaveMessageForUser(rs.userID, opts.SessionKey, "user", opts.UserMessage); err != nil {
 			logger.ErrorCF("agent", "Failed to save user message to storage", map[string]interface{}{"error": err.Error()})
 		}
 	}
 
 	// 4. Run LLM iteration loop with streaming on the final response
-	finalContent, iteration, err := al.runLLMIterationStream(ctx, messages, opts, onToken)
+	finalContent, reasoning, iteration, err := al.runLLMIterationStream(ctx, rs, messages, opts, onToken)
 	observability.Global().RecordAgentRun(time.Since(agentStart), iteration, err)
 	if err != nil {
 		return "", err
@@ -591,23 +849,27 @@ func (al *AgentLoop) runAgentLoopStream(ctx context.Context, opts processOptions
 	}
 
 	// 6. Save final assistant message to session
-	al.sessions.AddMessageForUser(al.userID, opts.SessionKey, "assistant", finalContent)
-	al.sessions.SaveForUser(al.userID, al.sessions.GetOrCreateForUser(al.userID, opts.SessionKey))
+	rs.sessions.AddFullMessageForUser(rs.userID, opts.SessionKey, al.historyMessage(providers.Message{
+		Role:      "assistant",
+		Content:   finalContent,
+		Reasoning: reasoning,
+	}))
+	rs.sessions.SaveForUser(rs.userID, rs.sessions.GetOrCreateForUser(rs.userID, opts.SessionKey))
 	if al.storage != nil {
-		if err := al.storage.SaveMessageForUser(al.userID, opts.SessionKey, "assistant", finalContent); err != nil {
+		if err := al.storage.SaveAgentMessageForUser(rs.userID, opts.SessionKey, "assistant", finalContent, al.profile.Name); err != nil {
 			logger.ErrorCF("agent", "Failed to save assistant message to storage", map[string]interface{}{"error": err.Error()})
 		}
 	}
 
 	// 7. Optional: summarization
 	if opts.EnableSummary {
-		al.maybeSummarize(opts.SessionKey)
+		al.maybeSummarize(rs, opts)
 	}
 
 	// 8. Optional: send response via bus
 	if opts.SendResponse {
 		al.bus.PublishOutbound(bus.OutboundMessage{
-			UserID:  al.userID,
+			UserID:  rs.userID,
 			Channel: opts.Channel,
 			ChatID:  opts.ChatID,
 			Content: finalContent,
@@ -627,10 +889,10 @@ func (al *AgentLoop) runAgentLoopStream(ctx context.Context, opts processOptions
 }
 
 // runLLMIteration executes the LLM call loop with tool handling.
-// Returns the final content, iteration count, and any error.
-func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.Message, opts processOptions) (string, int, error) {
+// Returns the final content, its reasoning, iteration count, and any error.
+func (al *AgentLoop) runLLMIteration(ctx context.Context, rs *runState, messages []providers.Message, opts processOptions) (string, string, int, error) {
 	iteration := 0
-	var finalContent string
+	var finalContent, finalReasoning string
 
 	// Determine which model to use (override or default)
 	model := al.model
@@ -638,8 +900,12 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 		model = opts.ModelOverride
 	}
 
+	var budgetNotice string
+	repairs := 0
+
 	for iteration < al.maxIterations {
 		iteration++
+		messages = al.applySteering(ctx, rs, messages, opts)
 
 		logger.DebugCF("agent", "LLM iteration",
 			map[string]interface{}{
@@ -647,11 +913,29 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 				"max":       al.maxIterations,
 			})
 
+		// Enforce spending caps before every call; a run may cross a cap midway.
+		decision := al.budget.Check(rs.budgetScope(opts), model)
+		if !decision.Allowed {
+			finalContent = decision.Notice
+			budgetNotice = ""
+			break
+		}
+		if decision.Model != model {
+			model = decision.Model
+			budgetNotice = decision.Notice
+		}
+		rs.model = model
+
 		// Build tool definitions
 		toolDefs := al.tools.GetDefinitions()
+		caller := policyCaller(ctx)
 		providerToolDefs := make([]providers.ToolDefinition, 0, len(toolDefs))
 		for _, td := range toolDefs {
 			toolName := td["function"].(map[string]interface{})["name"].(string)
+			// Skip tools the caller may not use
+			if !al.policy.Allows(caller, toolName) {
+				continue
+			}
 			// Skip excluded tools (e.g., web_search when user toggles it off)
 			if len(opts.ExcludeTools) > 0 {
 				excluded := false
@@ -675,6 +959,8 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 			})
 		}
 
+		llmOpts := al.chatOptions(ctx, rs, opts, model)
+
 		// Log LLM request details
 		logger.DebugCF("agent", "LLM request",
 			map[string]interface{}{
@@ -682,8 +968,7 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 				"model":             model,
 				"messages_count":    len(messages),
 				"tools_count":       len(providerToolDefs),
-				"max_tokens":        8192,
-				"temperature":       0.7,
+				"options":           llmOpts,
 				"system_prompt_len": len(messages[0].Content),
 			})
 
@@ -696,18 +981,15 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 			})
 
 		// Call LLM
+		sent := al.fitContext(messages, providerToolDefs, model, llmOpts)
 		llmStart := time.Now()
-		response, err := al.provider.Chat(ctx, messages, providerToolDefs, model, map[string]interface{}{
-			"max_tokens":  8192,
-			"temperature": 0.7,
-		})
+		response, err := al.provider.Chat(ctx, sent, providerToolDefs, model, llmOpts)
 		llmDur := time.Since(llmStart)
-		tokensIn := al.estimateTokens(messages)
-		tokensOut := 0
-		if err == nil {
-			tokensOut = len(response.Content) / 4 // rough estimate
+		if err != nil {
+			observability.Global().RecordLLMCall(model, llmDur, observability.TokenUsage{}, err)
+		} else {
+			al.recordLLMCall(rs, opts, servedModel(model, response.Model), llmDur, llmUsage(response.Usage, sent, providerToolDefs, response.Content, response.ToolCalls))
 		}
-		observability.Global().RecordLLMCall(model, llmDur, tokensIn, tokensOut, err)
 
 		if err != nil {
 			logger.ErrorCF("agent", "LLM call failed",
@@ -715,12 +997,26 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 					"iteration": iteration,
 					"error":     err.Error(),
 				})
-			return "", iteration, fmt.Errorf("LLM call failed: %w", err)
+			return "", "", iteration, fmt.Errorf("LLM call failed: %w", err)
 		}
+		logReasoning(iteration, response.Reasoning)
 
 		// Check if no tool calls - we're done
 		if len(response.ToolCalls) == 0 {
+			if opts.ResponseSchema != nil {
+				data, problems := checkStructuredOutput(opts.ResponseSchema, response.Content)
+				if problems != nil {
+					repairs++
+					var retry bool
+					if messages, retry = al.repairStructuredOutput(messages, response.Content, problems, repairs); retry {
+						continue
+					}
+					return "", "", iteration, &StructuredOutputError{Content: response.Content, Problems: problems}
+				}
+				response.Content = string(data)
+			}
 			finalContent = response.Content
+			finalReasoning = response.Reasoning
 			logger.InfoCF("agent", "LLM response without tool calls (direct answer)",
 				map[string]interface{}{
 					"iteration":     iteration,
@@ -742,80 +1038,33 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 			})
 
 		// Build assistant message with tool calls
-		assistantMsg := providers.Message{
-			Role:    "assistant",
-			Content: response.Content,
-		}
-		for _, tc := range response.ToolCalls {
-			argumentsJSON, _ := json.Marshal(tc.Arguments)
-			assistantMsg.ToolCalls = append(assistantMsg.ToolCalls, providers.ToolCall{
-				ID:   tc.ID,
-				Type: "function",
-				Function: &providers.FunctionCall{
-					Name:      tc.Name,
-					Arguments: string(argumentsJSON),
-				},
-			})
-		}
+		assistantMsg := buildToolCallMessage(response.Content, response.ToolCalls)
+		assistantMsg.Reasoning = response.Reasoning
+		assistantMsg.Thinking = response.Thinking
 		messages = append(messages, assistantMsg)
 
 		// Save assistant message with tool calls to session
-		al.sessions.AddFullMessageForUser(al.userID, opts.SessionKey, assistantMsg)
-
-		// Execute tool calls
-		for _, tc := range response.ToolCalls {
-			// Log tool call with arguments preview
-			argsJSON, _ := json.Marshal(tc.Arguments)
-			argsPreview := utils.Truncate(string(argsJSON), 200)
-			logger.InfoCF("agent", fmt.Sprintf("Tool call: %s(%s)", tc.Name, argsPreview),
-				map[string]interface{}{
-					"tool":      tc.Name,
-					"iteration": iteration,
-				})
-
-			// Notify start of tool call
-			if opts.OnTool != nil {
-				_ = opts.OnTool(ToolEvent{Name: tc.Name, Args: tc.Arguments, Status: "started"})
-			}
-
-			toolStart := time.Now()
-			result, err := al.tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, opts.Channel, opts.ChatID)
-			toolDur := time.Since(toolStart)
-			observability.Global().RecordToolCall(tc.Name, toolDur, err)
-			if err != nil {
-				result = fmt.Sprintf("Error: %v", err)
-			}
+		rs.sessions.AddFullMessageForUser(rs.userID, opts.SessionKey, al.historyMessage(assistantMsg))
 
-			// Notify end of tool call
-			if opts.OnTool != nil {
-				status := "finished"
-				if err != nil {
-					status = "error"
-				}
-				_ = opts.OnTool(ToolEvent{Name: tc.Name, Args: tc.Arguments, Result: result, Status: status})
-			}
-
-			toolResultMsg := providers.Message{
-				Role:       "tool",
-				Content:    result,
-				ToolCallID: tc.ID,
-			}
-			messages = append(messages, toolResultMsg)
+		// Execute tool calls (independent calls run in parallel)
+		results := al.executeToolCalls(ctx, response.ToolCalls, opts, iteration)
+		messages = al.appendToolResults(rs, messages, results, opts)
+	}
 
-			// Save tool result message to session
-			al.sessions.AddFullMessageForUser(al.userID, opts.SessionKey, toolResultMsg)
-		}
+	// A structured answer must stay valid JSON, so it goes without the notice.
+	if budgetNotice != "" && finalContent != "" && opts.ResponseSchema == nil {
+		finalContent = budgetNotice + "\n\n" + finalContent
 	}
 
-	return finalContent, iteration, nil
+	return finalContent, finalReasoning, iteration, nil
 }
 
 // runLLMIterationStream is like runLLMIteration but streams the final text response.
 // Tool call iterations use non-streaming Chat(). Only the last iteration (no tool calls)
 // uses ChatStream() if the provider supports it.
-func (al *AgentLoop) runLLMIterationStream(ctx context.Context, messages []providers.Message, opts processOptions, onToken StreamCallback) (string, int, error) {
+func (al *AgentLoop) runLLMIterationStream(ctx context.Context, rs *runState, messages []providers.Message, opts processOptions, onToken StreamCallback) (string, string, int, error) {
 	iteration := 0
-	var finalContent string
+	var finalContent, finalReasoning string
 
 	model := al.model
 	if opts.ModelOverride != "" {
@@ -824,8 +1073,11 @@ func (al *AgentLoop) runLLMIterationStream(ctx context.Context, messages []provi
 
 	streamingProvider, canStream := al.provider.(providers.StreamingLLMProvider)
 
+	var budgetNotice string
+
 	for iteration < al.maxIterations {
 		iteration++
+		messages = al.applySteering(ctx, rs, messages, opts)
 
 		logger.DebugCF("agent", "LLM streaming iteration",
 			map[string]interface{}{
@@ -834,11 +1086,33 @@ func (al *AgentLoop) runLLMIterationStream(ctx context.Context, messages []provi
 				"can_stream": canStream,
 			})
 
+		// Enforce spending caps before every call; a run may cross a cap midway.
+		decision := al.budget.Check(rs.budgetScope(opts), model)
+		if !decision.Allowed {
+			finalContent = decision.Notice
+			budgetNotice = ""
+			if onToken != nil {
+				_ = onToken(finalContent)
+			}
+			break
+		}
+		if decision.Model != model {
+			// The notice leads the final answer, which replaces the streamed text.
+			model = decision.Model
+			budgetNotice = decision.Notice
+		}
+		rs.model = model
+
 		// Build tool definitions
 		toolDefs := al.tools.GetDefinitions()
+		caller := policyCaller(ctx)
 		providerToolDefs := make([]providers.ToolDefinition, 0, len(toolDefs))
 		for _, td := range toolDefs {
 			toolName := td["function"].(map[string]interface{})["name"].(string)
+			// Skip tools the caller may not use
+			if !al.policy.Allows(caller, toolName) {
+				continue
+			}
 			// Skip excluded tools (e.g., web_search when user toggles it off)
 			if len(opts.ExcludeTools) > 0 {
 				excluded := false
@@ -862,72 +1136,131 @@ func (al *AgentLoop) runLLMIterationStream(ctx context.Context, messages []provi
 			})
 		}
 
-		llmOpts := map[string]interface{}{
-			"max_tokens":  8192,
-			"temperature": 0.7,
-		}
+		llmOpts := al.chatOptions(ctx, rs, opts, model)
+		sent := al.fitContext(messages, providerToolDefs, model, llmOpts)
 
 		// Try streaming for this iteration
 		if canStream {
 			llmStart := time.Now()
-			ch, err := streamingProvider.ChatStream(ctx, messages, providerToolDefs, model, llmOpts)
+			ch, err := streamingProvider.ChatStream(ctx, sent, providerToolDefs, model, llmOpts)
 			if err != nil {
-				observability.Global().RecordLLMCall(model, time.Since(llmStart), al.estimateTokens(messages), 0, err)
+				observability.Global().RecordLLMCall(model, time.Since(llmStart), observability.TokenUsage{}, err)
 				logger.ErrorCF("agent", "Streaming LLM call failed",
 					map[string]interface{}{
 						"iteration": iteration,
 						"error":     err.Error(),
 					})
-				return "", iteration, fmt.Errorf("streaming LLM call failed: %w", err)
+				return "", "", iteration, fmt.Errorf("streaming LLM call failed: %w", err)
 			}
 
 			// Accumulate the response from the stream
-			var contentBuilder strings.Builder
+			var contentBuilder, reasoningBuilder strings.Builder
+			var thinking []providers.ThinkingBlock
 			var toolCalls []providers.ToolCall
 			var finishReason string
-			// Map to accumulate fragmented tool call arguments
-			toolCallArgs := make(map[int]strings.Builder)
+			var streamUsage *providers.UsageInfo
+			var streamModel string
+			// Tool calls arrive in fragments keyed by their index in the response
+			toolCallArgs := make(map[int]*strings.Builder)
 			toolCallMeta := make(map[int]providers.ToolCall)
 
 			for chunk := range ch {
+				// Stream reasoning separately from the answer
+				if chunk.Reasoning != "" {
+					reasoningBuilder.WriteString(chunk.Reasoning)
+					if opts.OnReasoning != nil {
+						if err := opts.OnReasoning(chunk.Reasoning); err != nil {
+							return contentBuilder.String(), reasoningBuilder.String(), iteration, err
+						}
+					}
+				}
+				thinking = append(thinking, chunk.Thinking...)
+
 				// Stream text tokens to client
 				if chunk.Content != "" {
 					contentBuilder.WriteString(chunk.Content)
 					if onToken != nil {
 						if err := onToken(chunk.Content); err != nil {
 							// Client disconnected or error — stop processing
-							return contentBuilder.String(), iteration, err
+							return contentBuilder.String(), reasoningBuilder.String(), iteration, err
 						}
 					}
 				}
 
 				// Accumulate tool call fragments
 				for _, tc := range chunk.ToolCalls {
-					idx := 0 // Default index for tool calls
+					meta := toolCallMeta[tc.Index]
 					if tc.ID != "" {
-						// New tool call — store metadata
-						toolCallMeta[idx] = tc
+						meta.ID = tc.ID
+					}
+					if tc.Type != "" {
+						meta.Type = tc.Type
+					}
+					if tc.Name != "" {
+						meta.Name = tc.Name
+					} else if tc.Function != nil && tc.Function.Name != "" {
+						meta.Name = tc.Function.Name
 					}
+					if tc.Arguments != nil {
+						meta.Arguments = tc.Arguments
+					}
+					toolCallMeta[tc.Index] = meta
+
 					if tc.Function != nil && tc.Function.Arguments != "" {
-						b := toolCallArgs[idx]
+						b, ok := toolCallArgs[tc.Index]
+						if !ok {
+							b = &strings.Builder{}
+							toolCallArgs[tc.Index] = b
+						}
 						b.WriteString(tc.Function.Arguments)
-						toolCallArgs[idx] = b
 					}
 				}
 
 				if chunk.FinishReason != "" {
 					finishReason = chunk.FinishReason
 				}
+				if chunk.Usage != nil {
+					streamUsage = chunk.Usage
+				}
+				if chunk.Model != "" {
+					streamModel = chunk.Model
+				}
 			}
 
-			// Assemble completed tool calls
-			for idx, meta := range toolCallMeta {
-				args := make(map[string]interface{})
+			// A stream that broke off leaves a partial answer and partial tool
+			// arguments; neither may be saved or run.
+			if finishReason == "error" {
+				err := errors.New("the model's response stream failed before it completed")
+				observability.Global().RecordLLMCall(model, time.Since(llmStart), observability.TokenUsage{}, err)
+				logger.ErrorCF("agent", "Streaming LLM response failed",
+					map[string]interface{}{
+						"iteration": iteration,
+						"streamed":  contentBuilder.Len(),
+					})
+				return "", "", iteration, fmt.Errorf("streaming LLM call failed: %w", err)
+			}
+
+			// Assemble completed tool calls in response order
+			indexes := make([]int, 0, len(toolCallMeta))
+			for idx := range toolCallMeta {
+				indexes = append(indexes, idx)
+			}
+			sort.Ints(indexes)
+			for _, idx := range indexes {
+				meta := toolCallMeta[idx]
+				if meta.ID == "" && meta.Name == "" {
+					continue
+				}
+				args := meta.Arguments
 				if argsBuilder, ok := toolCallArgs[idx]; ok {
+					args = make(map[string]interface{})
 					if err := json.Unmarshal([]byte(argsBuilder.String()), &args); err != nil {
 						args["raw"] = argsBuilder.String()
 					}
 				}
+				if args == nil {
+					args = make(map[string]interface{})
+				}
 				toolCalls = append(toolCalls, providers.ToolCall{
 					ID:        meta.ID,
 					Name:      meta.Name,
@@ -938,17 +1271,18 @@ func (al *AgentLoop) runLLMIterationStream(ctx context.Context, messages []provi
 
 			// Record streaming LLM call metrics
 			streamContent := contentBuilder.String()
-			streamTokensOut := len(streamContent) / 4
-			observability.Global().RecordLLMCall(model, time.Since(llmStart), al.estimateTokens(messages), streamTokensOut, nil)
+			streamReasoning := reasoningBuilder.String()
+			al.recordLLMCall(rs, opts, servedModel(model, streamModel), time.Since(llmStart), llmUsage(streamUsage, sent, providerToolDefs, streamContent, toolCalls))
+			logReasoning(iteration, streamReasoning)
 
 			// If no tool calls — we're done
 			if len(toolCalls) == 0 {
 				finalContent = streamContent
+				finalReasoning = streamReasoning
 				break
 			}
 
 			// Tool calls — handle them (non-streaming for tool execution)
-			_ = finishReason
 			logger.InfoCF("agent", "Streaming iteration got tool calls",
 				map[string]interface{}{
 					"iteration": iteration,
@@ -956,84 +1290,36 @@ func (al *AgentLoop) runLLMIterationStream(ctx context.Context, messages []provi
 				})
 
 			// Build assistant message with tool calls
-			assistantMsg := providers.Message{
-				Role:    "assistant",
-				Content: contentBuilder.String(),
-			}
-			for _, tc := range toolCalls {
-				argumentsJSON, _ := json.Marshal(tc.Arguments)
-				assistantMsg.ToolCalls = append(assistantMsg.ToolCalls, providers.ToolCall{
-					ID:   tc.ID,
-					Type: "function",
-					Function: &providers.FunctionCall{
-						Name:      tc.Name,
-						Arguments: string(argumentsJSON),
-					},
-				})
-			}
+			assistantMsg := buildToolCallMessage(contentBuilder.String(), toolCalls)
+			assistantMsg.Reasoning = streamReasoning
+			assistantMsg.Thinking = thinking
 			messages = append(messages, assistantMsg)
-			al.sessions.AddFullMessageForUser(al.userID, opts.SessionKey, assistantMsg)
-
-			// Execute tool calls
-			for _, tc := range toolCalls {
-				argsJSON, _ := json.Marshal(tc.Arguments)
-				argsPreview := utils.Truncate(string(argsJSON), 200)
-				logger.InfoCF("agent", fmt.Sprintf("Tool call: %s(%s)", tc.Name, argsPreview),
-					map[string]interface{}{
-						"tool":      tc.Name,
-						"iteration": iteration,
-					})
-
-				// Notify start of tool call
-				if opts.OnTool != nil {
-					_ = opts.OnTool(ToolEvent{Name: tc.Name, Args: tc.Arguments, Status: "started"})
-				}
+			rs.sessions.AddFullMessageForUser(rs.userID, opts.SessionKey, al.historyMessage(assistantMsg))
 
-				toolStart := time.Now()
-				result, err := al.tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, opts.Channel, opts.ChatID)
-				toolDur := time.Since(toolStart)
-				observability.Global().RecordToolCall(tc.Name, toolDur, err)
-				if err != nil {
-					result = fmt.Sprintf("Error: %v", err)
-				}
-
-				// Notify end of tool call
-				if opts.OnTool != nil {
-					status := "finished"
-					if err != nil {
-						status = "error"
-					}
-					_ = opts.OnTool(ToolEvent{Name: tc.Name, Args: tc.Arguments, Result: result, Status: status})
-				}
-
-				toolResultMsg := providers.Message{
-					Role:       "tool",
-					Content:    result,
-					ToolCallID: tc.ID,
-				}
-				messages = append(messages, toolResultMsg)
-				al.sessions.AddFullMessageForUser(al.userID, opts.SessionKey, toolResultMsg)
-			}
+			// Execute tool calls (independent calls run in parallel)
+			results := al.executeToolCalls(ctx, toolCalls, opts, iteration)
+			messages = al.appendToolResults(rs, messages, results, opts)
 
 			continue // Next iteration
 		}
 
 		// Fallback: non-streaming Chat()
 		fallbackStart := time.Now()
-		response, err := al.provider.Chat(ctx, messages, providerToolDefs, model, llmOpts)
+		response, err := al.provider.Chat(ctx, sent, providerToolDefs, model, llmOpts)
 		fallbackDur := time.Since(fallbackStart)
-		fallbackTokensIn := al.estimateTokens(messages)
-		fallbackTokensOut := 0
-		if err == nil {
-			fallbackTokensOut = len(response.Content) / 4
-		}
-		observability.Global().RecordLLMCall(model, fallbackDur, fallbackTokensIn, fallbackTokensOut, err)
 		if err != nil {
-			return "", iteration, fmt.Errorf("LLM call failed: %w", err)
+			observability.Global().RecordLLMCall(model, fallbackDur, observability.TokenUsage{}, err)
+			return "", "", iteration, fmt.Errorf("LLM call failed: %w", err)
+		}
+		al.recordLLMCall(rs, opts, servedModel(model, response.Model), fallbackDur, llmUsage(response.Usage, sent, providerToolDefs, response.Content, response.ToolCalls))
+		logReasoning(iteration, response.Reasoning)
+		if opts.OnReasoning != nil && response.Reasoning != "" {
+			_ = opts.OnReasoning(response.Reasoning)
 		}
 
 		if len(response.ToolCalls) == 0 {
 			finalContent = response.Content
+			finalReasoning = response.Reasoning
 			// Send the full content as a single token for non-streaming providers
 			if onToken != nil && finalContent != "" {
 				_ = onToken(finalContent)
@@ -1042,79 +1328,43 @@ func (al *AgentLoop) runLLMIterationStream(ctx context.Context, messages []provi
 		}
 
 		// Tool calls — same handling as runLLMIteration
-		assistantMsg := providers.Message{
-			Role:    "assistant",
-			Content: response.Content,
-		}
-		for _, tc := range response.ToolCalls {
-			argumentsJSON, _ := json.Marshal(tc.Arguments)
-			assistantMsg.ToolCalls = append(assistantMsg.ToolCalls, providers.ToolCall{
-				ID:   tc.ID,
-				Type: "function",
-				Function: &providers.FunctionCall{
-					Name:      tc.Name,
-					Arguments: string(argumentsJSON),
-				},
-			})
-		}
+		assistantMsg := buildToolCallMessage(response.Content, response.ToolCalls)
+		assistantMsg.Reasoning = response.Reasoning
+		assistantMsg.Thinking = response.Thinking
 		messages = append(messages, assistantMsg)
-		al.sessions.AddFullMessageForUser(al.userID, opts.SessionKey, assistantMsg)
-
-		for _, tc := range response.ToolCalls {
-			argsJSON, _ := json.Marshal(tc.Arguments)
-			argsPreview := utils.Truncate(string(argsJSON), 200)
-			logger.InfoCF("agent", fmt.Sprintf("Tool call: %s(%s)", tc.Name, argsPreview),
-				map[string]interface{}{
-					"tool":      tc.Name,
-					"iteration": iteration,
-				})
-
-			toolStart := time.Now()
-			result, err := al.tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, opts.Channel, opts.ChatID)
-			toolDur := time.Since(toolStart)
-			observability.Global().RecordToolCall(tc.Name, toolDur, err)
-			if err != nil {
-				result = fmt.Sprintf("Error: %v", err)
-			}
+		rs.sessions.AddFullMessageForUser(rs.userID, opts.SessionKey, al.historyMessage(assistantMsg))
 
-			toolResultMsg := providers.Message{
-				Role:       "tool",
-				Content:    result,
-				ToolCallID: tc.ID,
-			}
-			messages = append(messages, toolResultMsg)
-			al.sessions.AddFullMessageForUser(al.userID, opts.SessionKey, toolResultMsg)
-		}
+		results := al.executeToolCalls(ctx, response.ToolCalls, opts, iteration)
+		messages = al.appendToolResults(rs, messages, results, opts)
 	}
 
-	return finalContent, iteration, nil
-}
-
-// updateToolContexts updates the context for tools that need channel/chatID info.
-func (al *AgentLoop) updateToolContexts(channel, chatID string) {
-	if tool, ok := al.tools.Get("message"); ok {
-		if mt, ok := tool.(*tools.MessageTool); ok {
-			mt.SetContext(channel, chatID)
-		}
-	}
-	if tool, ok := al.tools.Get("spawn"); ok {
-		if st, ok := tool.(*tools.SpawnTool); ok {
-			st.SetContext(channel, chatID)
-		}
+	if budgetNotice != "" && finalContent != "" {
+		finalContent = budgetNotice + "\n\n" + finalContent
 	}
+
+	return finalContent, finalReasoning, iteration, nil
 }
 
 // maybeSummarize triggers summarization if the session history exceeds thresholds.
-func (al *AgentLoop) maybeSummarize(sessionKey string) {
-	newHistory := al.sessions.GetHistoryForUser(al.userID, sessionKey)
-	tokenEstimate := al.estimateTokens(newHistory)
-	threshold := al.contextWindow * 75 / 100
+func (al *AgentLoop) maybeSummarize(rs *runState, opts processOptions) {
+	sessionKey := opts.SessionKey
+	newHistory := rs.sessions.GetHistoryForUser(rs.userID, sessionKey)
+
+	// Prefer the prompt size the provider reported for this run; it also
+	// covers the system prompt and tool schemas that the history omits.
+	contextTokens := rs.contextTokens
+	if contextTokens == 0 {
+		contextTokens = providers.EstimateMessagesTokens(newHistory)
+	}
+	model := rs.contextModel(al.model)
+	threshold := al.contextWindows.Lookup(model) * 75 / 100
 
-	if len(newHistory) > 20 || tokenEstimate > threshold {
-		if _, loading := al.summarizing.LoadOrStore(sessionKey, true); !loading {
+	if len(newHistory) > 20 || contextTokens > threshold {
+		key := rs.summaryKey(sessionKey)
+		if _, loading := al.summarizing.LoadOrStore(key, true); !loading {
 			go func() {
-				defer al.summarizing.Delete(sessionKey)
-				al.summarizeSession(sessionKey)
+				defer al.summarizing.Delete(key)
+				al.summarizeSession(rs, rs.budgetScope(opts), model)
 			}()
 		}
 	}
@@ -1132,7 +1382,16 @@ func (al *AgentLoop) GetStartupInfo() map[string]interface{} {
 	}
 
 	// Skills info
-	info["skills"] = al.contextBuilder.GetSkillsInfo()
+	info["skills"] = al.defaultScope.contextBuilder.GetSkillsInfo()
+
+	if len(al.profiles) > 0 {
+		names := make([]string, 0, len(al.profiles))
+		for name := range al.profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		info["agents"] = names
+	}
 
 	return info
 }
@@ -1189,12 +1448,14 @@ func formatToolsForLog(tools []providers.ToolDefinition) string {
 }
 
 // summarizeSession summarizes the conversation history for a session.
-func (al *AgentLoop) summarizeSession(sessionKey string) {
+// Summarizer calls are billed to scope; model is the model the run talked to.
+func (al *AgentLoop) summarizeSession(rs *runState, scope budget.Scope, model string) {
+	sessionKey := scope.SessionKey
 	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
 	defer cancel()
 
-	history := al.sessions.GetHistoryForUser(al.userID, sessionKey)
-	summary := al.sessions.GetSummaryForUser(al.userID, sessionKey)
+	history := rs.sessions.GetHistoryForUser(rs.userID, sessionKey)
+	summary := rs.sessions.GetSummaryForUser(rs.userID, sessionKey)
 
 	// Keep last 4 messages for continuity
 	if len(history) <= 4 {
@@ -1205,7 +1466,7 @@ func (al *AgentLoop) summarizeSession(sessionKey string) {
 
 	// Oversized Message Guard
 	// Skip messages larger than 50% of context window to prevent summarizer overflow
-	maxMessageTokens := al.contextWindow / 2
+	maxMessageTokens := al.contextWindows.Lookup(model) / 2
 	validMessages := make([]providers.Message, 0)
 	omitted := false
 
@@ -1214,7 +1475,7 @@ func (al *AgentLoop) summarizeSession(sessionKey string) {
 			continue
 		}
 		// Estimate tokens for this message
-		msgTokens := len(m.Content) / 4
+		msgTokens := providers.EstimateTokens(m.Content)
 		if msgTokens > maxMessageTokens {
 			omitted = true
 			continue
@@ -1234,22 +1495,19 @@ func (al *AgentLoop) summarizeSession(sessionKey string) {
 		part1 := validMessages[:mid]
 		part2 := validMessages[mid:]
 
-		s1, _ := al.summarizeBatch(ctx, part1, "")
-		s2, _ := al.summarizeBatch(ctx, part2, "")
+		s1, _ := al.summarizeBatch(ctx, scope, part1, "")
+		s2, _ := al.summarizeBatch(ctx, scope, part2, "")
 
 		// Merge them
 		mergePrompt := fmt.Sprintf("Merge these two conversation summaries into one cohesive summary:\n\n1: %s\n\n2: %s", s1, s2)
-		resp, err := al.provider.Chat(ctx, []providers.Message{{Role: "user", Content: mergePrompt}}, nil, al.model, map[string]interface{}{
-			"max_tokens":  1024,
-			"temperature": 0.3,
-		})
+		merged, err := al.summaryChat(ctx, scope, mergePrompt)
 		if err == nil {
-			finalSummary = resp.Content
+			finalSummary = merged
 		} else {
 			finalSummary = s1 + " " + s2
 		}
 	} else {
-		finalSummary, _ = al.summarizeBatch(ctx, validMessages, summary)
+		finalSummary, _ = al.summarizeBatch(ctx, scope, validMessages, summary)
 	}
 
 	if omitted && finalSummary != "" {
@@ -1257,14 +1515,14 @@ func (al *AgentLoop) summarizeSession(sessionKey string) {
 	}
 
 	if finalSummary != "" {
-		al.sessions.SetSummaryForUser(al.userID, sessionKey, finalSummary)
-		al.sessions.TruncateHistoryForUser(al.userID, sessionKey, 4)
-		al.sessions.SaveForUser(al.userID, al.sessions.GetOrCreateForUser(al.userID, sessionKey))
+		rs.sessions.SetSummaryForUser(rs.userID, sessionKey, finalSummary)
+		rs.sessions.TruncateHistoryForUser(rs.userID, sessionKey, 4)
+		rs.sessions.SaveForUser(rs.userID, rs.sessions.GetOrCreateForUser(rs.userID, sessionKey))
 	}
 }
 
 // summarizeBatch summarizes a batch of messages.
-func (al *AgentLoop) summarizeBatch(ctx context.Context, batch []providers.Message, existingSummary string) (string, error) {
+func (al *AgentLoop) summarizeBatch(ctx context.Context, scope budget.Scope, batch []providers.Message, existingSummary string) (string, error) {
 	prompt := "Provide a concise summary of this conversation segment, preserving core context and key points.\n"
 	if existingSummary != "" {
 		prompt += "Existing context: " + existingSummary + "\n"
@@ -1274,21 +1532,56 @@ func (al *AgentLoop) summarizeBatch(ctx context.Context, batch []providers.Messa
 		prompt += fmt.Sprintf("%s: %s\n", m.Role, m.Content)
 	}
 
-	response, err := al.provider.Chat(ctx, []providers.Message{{Role: "user", Content: prompt}}, nil, al.model, map[string]interface{}{
+	return al.summaryChat(ctx, scope, prompt)
+}
+
+// fitContext trims messages to the model's context window, leaving room for
+// the tool schemas and the reply. What was elided is logged at debug level.
+func (al *AgentLoop) fitContext(messages []providers.Message, toolDefs []providers.ToolDefinition, model string, llmOpts map[string]interface{}) []providers.Message {
+	window := al.contextWindows.Lookup(model)
+	reserve, _ := llmOpts["max_tokens"].(int)
+	if reserve > window/4 {
+		reserve = window / 4
+	}
+	budget := window - reserve - providers.EstimateToolDefinitionsTokens(toolDefs)
+
+	fitted, report := providers.FitContext(messages, budget)
+	if report.Trimmed() {
+		logger.DebugCF("agent", "Trimmed conversation to fit the context window",
+			map[string]interface{}{
+				"model":            model,
+				"context_window":   window,
+				"budget":           report.Budget,
+				"tokens_before":    report.TokensBefore,
+				"tokens_after":     report.TokensAfter,
+				"compacted_tools":  report.CompactedTools,
+				"dropped_messages": report.DroppedMessages,
+			})
+	}
+	if report.Overflow {
+		logger.WarnCF("agent", "Conversation still exceeds the context window after trimming",
+			map[string]interface{}{
+				"model":          model,
+				"context_window": window,
+				"tokens":         report.TokensAfter,
+			})
+	}
+	return fitted
+}
+
+// summaryChat sends a single summarization prompt and bills it to scope.
+func (al *AgentLoop) summaryChat(ctx context.Context, scope budget.Scope, prompt string) (string, error) {
+	messages := []providers.Message{{Role: "user", Content: prompt}}
+
+	start := time.Now()
+	response, err := al.provider.Chat(ctx, messages, nil, al.model, map[string]interface{}{
 		"max_tokens":  1024,
 		"temperature": 0.3,
 	})
 	if err != nil {
+		observability.Global().RecordLLMCall(al.model, time.Since(start), observability.TokenUsage{}, err)
 		return "", err
 	}
+	al.billLLMCall(scope, servedModel(al.model, response.Model), time.Since(start), llmUsage(response.Usage, messages, nil, response.Content, nil))
 	return response.Content, nil
 }
-
-// estimateTokens estimates the number of tokens in a message list.
-func (al *AgentLoop) estimateTokens(messages []providers.Message) int {
-	total := 0
-	for _, m := range messages {
-		total += len(m.Content) / 4 // Simple heuristic: 4 chars per token
-	}
-	return total
-}
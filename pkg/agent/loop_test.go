@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sipeed/kakoclaw/pkg/bus"
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/providers"
+)
+
+// streamProvider replays one scripted stream per ChatStream call and answers
+// Chat with reply.
+type streamProvider struct {
+	mu      sync.Mutex
+	streams [][]providers.StreamChunk
+	reply   string
+	models  []string
+	chats   int
+}
+
+func (p *streamProvider) GetDefaultModel() string { return "main" }
+
+func (p *streamProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, options map[string]interface{}) (*providers.LLMResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.chats++
+	p.models = append(p.models, model)
+	return &providers.LLMResponse{Content: p.reply, FinishReason: "stop"}, nil
+}
+
+func (p *streamProvider) ChatStream(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, options map[string]interface{}) (<-chan providers.StreamChunk, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.models = append(p.models, model)
+	script := p.streams[0]
+	if len(p.streams) > 1 {
+		p.streams = p.streams[1:]
+	}
+	ch := make(chan providers.StreamChunk, len(script))
+	for _, chunk := range script {
+		ch <- chunk
+	}
+	close(ch)
+	return ch, nil
+}
+
+func newTestAgentLoop(t *testing.T, provider providers.LLMProvider, configure func(*config.Config)) *AgentLoop {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Workspace = filepath.Join(dir, "workspace")
+	cfg.Agents.Defaults.Model = "main"
+	cfg.Storage.Path = filepath.Join(dir, "kakoclaw.db")
+	if configure != nil {
+		configure(cfg)
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+	t.Cleanup(al.Stop)
+	return al
+}
+
+// streamText runs content through the streaming path and returns the
+// answer and the text streamed to the client.
+func streamText(t *testing.T, al *AgentLoop, content, sessionKey string) (string, string, error) {
+	t.Helper()
+	var streamed strings.Builder
+	answer, err := al.ProcessDirectWithModelStream(context.Background(), 0, content, sessionKey, "",
+		func(token string) error {
+			streamed.WriteString(token)
+			return nil
+		}, nil, nil)
+	return answer, streamed.String(), err
+}
+
+func TestStreamingBudgetDowngrade(t *testing.T) {
+	provider := &streamProvider{streams: [][]providers.StreamChunk{
+		{{Content: "first"}, {Done: true, FinishReason: "stop", Usage: &providers.UsageInfo{PromptTokens: 10, CompletionTokens: 5}}},
+		{{Content: "hello "}, {Content: "world"}, {Done: true, FinishReason: "stop", Model: "cheap-2024", Usage: &providers.UsageInfo{PromptTokens: 10, CompletionTokens: 5}}},
+	}}
+	al := newTestAgentLoop(t, provider, func(cfg *config.Config) {
+		cfg.Budget = config.BudgetConfig{
+			Enabled:        true,
+			Session:        config.BudgetLimits{DailyTokens: 1},
+			OnExceeded:     "downgrade",
+			DowngradeModel: "cheap",
+		}
+	})
+
+	if _, _, err := streamText(t, al, "hi", "budget"); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	answer, streamed, err := streamText(t, al, "again", "budget")
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if provider.models[1] != "cheap" {
+		t.Fatalf("expected the second call to use the downgrade model, got %v", provider.models)
+	}
+	if streamed != "hello world" {
+		t.Fatalf("expected only the answer to be streamed, got %q", streamed)
+	}
+	if strings.Count(answer, "cheaper model") != 1 || !strings.HasSuffix(answer, "\n\nhello world") {
+		t.Fatalf("expected the notice once ahead of the answer, got %q", answer)
+	}
+
+	rows, err := al.storage.SpendBreakdown("model", time.Time{})
+	if err != nil {
+		t.Fatalf("SpendBreakdown: %v", err)
+	}
+	models := map[string]bool{}
+	for _, row := range rows {
+		models[row.Key] = true
+	}
+	if len(models) != 2 || !models["main"] || !models["cheap-2024"] {
+		t.Fatalf("expected calls to be billed to the models that served them, got %v", models)
+	}
+}
+
+func TestStreamingFailureMidResponse(t *testing.T) {
+	provider := &streamProvider{streams: [][]providers.StreamChunk{{
+		{Content: "Writing the file"},
+		{ToolCalls: []providers.ToolCall{{Index: 0, ID: "call-1", Name: "write_file",
+			Function: &providers.FunctionCall{Arguments: `{"path": "out.txt", "content": "par`}}}},
+		{Done: true, FinishReason: "error"},
+	}}}
+	al := newTestAgentLoop(t, provider, nil)
+
+	answer, _, err := streamText(t, al, "write out.txt", "broken")
+	if err == nil || answer != "" {
+		t.Fatalf("expected the run to fail, got %q, %v", answer, err)
+	}
+	if _, statErr := os.Stat(filepath.Join(al.defaultScope.workspace, "out.txt")); !os.IsNotExist(statErr) {
+		t.Fatal("expected the partial tool call not to run")
+	}
+	for _, msg := range al.defaultScope.sessions.GetHistoryForUser(al.userID, "broken") {
+		if msg.Role == "assistant" {
+			t.Fatalf("expected no assistant message to be saved, got %+v", msg)
+		}
+	}
+}
+
+func TestSummaryThresholdUsesRunModel(t *testing.T) {
+	al := newTestAgentLoop(t, &streamProvider{reply: "summary"}, func(cfg *config.Config) {
+		cfg.Providers.OpenAI.ContextWindows = map[string]int{"main": 1000000, "small": 1000}
+	})
+	seed := func(sessionKey string) {
+		for i := 0; i < 6; i++ {
+			al.defaultScope.sessions.AddMessageForUser(al.userID, sessionKey, "user", "note")
+		}
+	}
+	summary := func(sessionKey string) string {
+		return al.defaultScope.sessions.GetSummaryForUser(al.userID, sessionKey)
+	}
+
+	seed("default")
+	seed("override")
+	if _, err := al.ProcessDirectWithModel(context.Background(), 0, "hello", "default", ""); err != nil {
+		t.Fatalf("ProcessDirectWithModel: %v", err)
+	}
+	if _, err := al.ProcessDirectWithModel(context.Background(), 0, "hello", "override", "small"); err != nil {
+		t.Fatalf("ProcessDirectWithModel: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for summary("override") == "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if summary("override") == "" {
+		t.Fatal("expected the small override model's window to trigger summarization")
+	}
+	if got := summary("default"); got != "" {
+		t.Fatalf("expected no summary within the default model's window, got %q", got)
+	}
+}
@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"sort"
+
+	"github.com/sipeed/kakoclaw/pkg/bus"
+	"github.com/sipeed/kakoclaw/pkg/config"
+)
+
+// AgentInfo describes a named agent for front ends.
+type AgentInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Model       string `json:"model"`
+	Default     bool   `json:"default,omitempty"`
+}
+
+// Name returns the agent's profile name, DefaultAgentName for the default
+// agent.
+func (al *AgentLoop) Name() string {
+	if al.profile.Name == "" {
+		return config.DefaultAgentName
+	}
+	return al.profile.Name
+}
+
+// Agent returns the loop for the named agent. "" and DefaultAgentName return
+// the default agent; an unknown name returns nil.
+func (al *AgentLoop) Agent(name string) *AgentLoop {
+	if al.root != nil {
+		return al.root.Agent(name)
+	}
+	if name == "" || name == al.Name() {
+		return al
+	}
+	return al.profiles[name]
+}
+
+// Agents lists the default agent and the named agents, sorted by name after
+// the default one.
+func (al *AgentLoop) Agents() []AgentInfo {
+	if al.root != nil {
+		return al.root.Agents()
+	}
+	list := []AgentInfo{{Name: al.Name(), Description: al.profile.Description, Model: al.model, Default: true}}
+	named := make([]AgentInfo, 0, len(al.profiles))
+	for name, p := range al.profiles {
+		named = append(named, AgentInfo{Name: name, Description: p.profile.Description, Model: p.model})
+	}
+	sort.Slice(named, func(i, j int) bool { return named[i].Name < named[j].Name })
+	return append(list, named...)
+}
+
+// route picks the agent for an inbound message from agents.routes, unless a
+// handoff transferred the chat to another agent. System messages (e.g.
+// subagent results) name the agent that asked, so the answer comes from it.
+func (al *AgentLoop) route(msg bus.InboundMessage) *AgentLoop {
+	if len(al.profiles) == 0 {
+		return al
+	}
+
+	if name := msg.Metadata["agent"]; msg.Channel == "system" && name != "" {
+		if target := al.Agent(name); target != nil {
+			return target
+		}
+	}
+	al.routesMu.Lock()
+	name, ok := al.transfers[dispatchKey(msg)]
+	al.routesMu.Unlock()
+	if ok {
+		if target := al.Agent(name); target != nil {
+			return target
+		}
+	}
+	return al.AgentFor(msg.Channel, msg.ChatID, msg.SenderID, msg.UserID)
+}
+
+// AgentFor returns the agent that agents.routes picks for a message from
+// senderID in chatID on channel, falling back to the default agent.
+func (al *AgentLoop) AgentFor(channel, chatID, senderID string, userID int64) *AgentLoop {
+	if al.root != nil {
+		return al.root.AgentFor(channel, chatID, senderID, userID)
+	}
+	if target := al.Agent(al.agents.Route(channel, chatID, senderID, userID)); target != nil {
+		return target
+	}
+	return al
+}
@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/sipeed/kakoclaw/pkg/bus"
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/providers"
+	"github.com/sipeed/kakoclaw/pkg/tools"
+)
+
+func TestProfilesShareSubagentsAndRouteResultsBack(t *testing.T) {
+	al := newTestAgentLoop(t, providers.NewMockProvider(), func(cfg *config.Config) {
+		cfg.Agents.List = []config.AgentProfile{{Name: "coder", Workspace: filepath.Join(t.TempDir(), "coder")}}
+		cfg.Agents.Routes = []config.AgentRoute{{Agent: "coder", Channel: "telegram"}}
+	})
+	coder := al.Agent("coder")
+	if coder.Subagents() != al.Subagents() {
+		t.Fatal("expected named agents to share the root's subagent manager")
+	}
+
+	result := func(agent string) bus.InboundMessage {
+		return bus.InboundMessage{Channel: "system", SenderID: "subagent:1", ChatID: "telegram:42", Metadata: map[string]string{"agent": agent}}
+	}
+	if got := al.route(result(config.DefaultAgentName)); got != al {
+		t.Fatalf("expected the default agent's subagent to report to it, got %s", got.Name())
+	}
+	if got := al.route(result("coder")); got != coder {
+		t.Fatalf("expected coder's subagent to report to coder, got %s", got.Name())
+	}
+	if got := al.route(bus.InboundMessage{Channel: "telegram", ChatID: "42", SenderID: "u"}); got != coder {
+		t.Fatalf("expected agents.routes to pick coder, got %s", got.Name())
+	}
+}
+
+func TestHandoffRecordsOnlyDelegatedAnswers(t *testing.T) {
+	al := newTestAgentLoop(t, &streamProvider{reply: "done"}, func(cfg *config.Config) {
+		cfg.Agents.List = []config.AgentProfile{{Name: "coder", Workspace: filepath.Join(t.TempDir(), "coder")}}
+	})
+	ctx := tools.WithExecutionContext(context.Background(), tools.ExecutionContext{
+		Channel: "telegram", ChatID: "42", SessionKey: "telegram:42", UserID: al.userID,
+	})
+
+	answers := func() int {
+		msgs, err := al.storage.GetMessagesForUser(al.userID, "telegram:42")
+		if err != nil {
+			t.Fatalf("GetMessagesForUser: %v", err)
+		}
+		return len(msgs)
+	}
+	if _, err := al.runHandoff(ctx, tools.HandoffRequest{Agent: "coder", Task: "review"}); err != nil {
+		t.Fatalf("delegate: %v", err)
+	}
+	if n := answers(); n != 1 {
+		t.Fatalf("expected the delegated answer to be recorded, got %d messages", n)
+	}
+	if _, err := al.runHandoff(ctx, tools.HandoffRequest{Agent: "coder", Task: "take over", Transfer: true}); err != nil {
+		t.Fatalf("transfer: %v", err)
+	}
+	if n := answers(); n != 1 {
+		t.Fatalf("expected the caller to relay a transferred answer instead, got %d messages", n)
+	}
+}
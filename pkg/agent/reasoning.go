@@ -0,0 +1,30 @@
+package agent
+
+import (
+	"github.com/sipeed/kakoclaw/pkg/logger"
+	"github.com/sipeed/kakoclaw/pkg/providers"
+)
+
+// historyMessage returns msg as it should be stored in the session history.
+// Thinking blocks are only needed to continue the run that produced them, so
+// they are never stored; reasoning text is kept only when persist_reasoning
+// is enabled.
+func (al *AgentLoop) historyMessage(msg providers.Message) providers.Message {
+	msg.Thinking = nil
+	if !al.persistReasoning {
+		msg.Reasoning = ""
+	}
+	return msg
+}
+
+// logReasoning logs the size of the reasoning returned by an LLM call.
+func logReasoning(iteration int, reasoning string) {
+	if reasoning == "" {
+		return
+	}
+	logger.DebugCF("agent", "LLM returned reasoning",
+		map[string]interface{}{
+			"iteration":       iteration,
+			"reasoning_chars": len(reasoning),
+		})
+}
@@ -0,0 +1,186 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/sipeed/kakoclaw/pkg/budget"
+	"github.com/sipeed/kakoclaw/pkg/bus"
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/logger"
+	"github.com/sipeed/kakoclaw/pkg/observability"
+	"github.com/sipeed/kakoclaw/pkg/policy"
+	"github.com/sipeed/kakoclaw/pkg/session"
+	"github.com/sipeed/kakoclaw/pkg/tools"
+)
+
+// userScope holds the per-user state a run works against. Scopes are created
+// once per user and shared by every run for that user, so nothing here is
+// mutated after construction.
+type userScope struct {
+	workspace      string
+	sessions       *session.SessionManager
+	contextBuilder *ContextBuilder
+}
+
+// runState is the per-message state threaded through a single agent run.
+// It replaces the old approach of mutating the loop for each message, which
+// was unsafe once messages are processed concurrently.
+type runState struct {
+	userID int64
+	role   string // tool policy role: the sender's account role, or policy.GuestRole
+	*userScope
+
+	// contextTokens is the size of the conversation after the latest LLM
+	// call: its prompt tokens plus the completion that joins the history.
+	contextTokens int
+	// model is the model the run's LLM calls went to, after any override or
+	// budget downgrade. Empty until the first call.
+	model string
+}
+
+// executionContext returns the tool execution context for this run.
+func (rs *runState) executionContext(opts processOptions) tools.ExecutionContext {
+	return tools.ExecutionContext{
+		Channel:    opts.Channel,
+		ChatID:     opts.ChatID,
+		SenderID:   opts.SenderID,
+		SessionKey: opts.SessionKey,
+		Workspace:  rs.workspace,
+		UserID:     rs.userID,
+	}
+}
+
+// recordUsage tracks the token usage of an LLM call made during this run.
+func (rs *runState) recordUsage(usage observability.TokenUsage) {
+	rs.contextTokens = usage.In + usage.Out
+}
+
+// contextModel returns the model whose context window bounds this run's
+// conversation: the one its LLM calls used, or def before any call.
+func (rs *runState) contextModel(def string) string {
+	if rs.model != "" {
+		return rs.model
+	}
+	return def
+}
+
+// budgetScope returns who this run's LLM calls are billed to.
+func (rs *runState) budgetScope(opts processOptions) budget.Scope {
+	return budget.Scope{UserID: rs.userID, SessionKey: opts.SessionKey, Channel: opts.Channel}
+}
+
+// summaryKey namespaces a session key by user for the summarizing tracker.
+func (rs *runState) summaryKey(sessionKey string) string {
+	return fmt.Sprintf("%d:%s", rs.userID, sessionKey)
+}
+
+// withCaller attaches the tool policy caller for this run to ctx, unless a
+// front end already identified the caller (e.g. the signed-in web user).
+func (rs *runState) withCaller(ctx context.Context, opts processOptions) context.Context {
+	if _, ok := policy.CallerFrom(ctx); ok {
+		return ctx
+	}
+	caller := policy.Caller{UserID: rs.userID, Role: rs.role, Channel: opts.Channel, ChatID: opts.ChatID}
+	if rs.role == policy.GuestRole {
+		caller.UserID = 0
+	}
+	return policy.WithCaller(ctx, caller)
+}
+
+// resolveRunState builds the run state for an inbound message.
+func (al *AgentLoop) resolveRunState(msg bus.InboundMessage) *runState {
+	if msg.UserID == 0 {
+		return &runState{userID: al.userID, role: al.anonymousRole(msg), userScope: al.scopeFor(al.userUUID, al.userID)}
+	}
+	if al.storage == nil {
+		return &runState{userID: msg.UserID, role: "user", userScope: al.defaultScope}
+	}
+
+	user, err := al.storage.GetUserByID(msg.UserID)
+	if err != nil {
+		logger.WarnCF("agent", "Failed to resolve user UUID", map[string]interface{}{"error": err.Error()})
+		return &runState{userID: msg.UserID, role: policy.GuestRole, userScope: al.defaultScope}
+	}
+
+	return &runState{userID: msg.UserID, role: user.Role, userScope: al.scopeFor(user.UUID, msg.UserID)}
+}
+
+// anonymousRole returns the tool policy role for a message without a user.
+// Local runs (the CLI, and system messages about them) act as the default
+// user; senders on chat channels without a linked account are guests.
+func (al *AgentLoop) anonymousRole(msg bus.InboundMessage) string {
+	channel := msg.Channel
+	if channel == "system" {
+		channel = "cli"
+		if origin, _, ok := strings.Cut(msg.ChatID, ":"); ok {
+			channel = origin
+		}
+	}
+	if channel != "cli" && channel != "" {
+		return policy.GuestRole
+	}
+	if al.userID > 0 && al.storage != nil {
+		if user, err := al.storage.GetUserByID(al.userID); err == nil {
+			return user.Role
+		}
+	}
+	return "admin"
+}
+
+// scopeFor returns the cached scope for a user, creating it on first use.
+// An empty UUID maps to the default workspace scope.
+func (al *AgentLoop) scopeFor(userUUID string, userID int64) *userScope {
+	if userUUID == "" {
+		return al.defaultScope
+	}
+
+	al.scopesMu.Lock()
+	defer al.scopesMu.Unlock()
+
+	if scope, ok := al.scopes[userUUID]; ok {
+		return scope
+	}
+
+	var workspace string
+	var sessions *session.SessionManager
+	if al.root != nil {
+		// Named agents work in the user's workspace and history, like the
+		// default agent; only the context builder is their own.
+		base := al.root.scopeFor(userUUID, userID)
+		if base == al.root.defaultScope {
+			return al.defaultScope
+		}
+		workspace, sessions = base.workspace, base.sessions
+	} else {
+		var err error
+		workspace, err = config.EnsureUserWorkspace(userUUID)
+		if err != nil {
+			logger.WarnCF("agent", "Failed to ensure user workspace", map[string]interface{}{"error": err.Error()})
+			return al.defaultScope
+		}
+		sessions = session.NewSessionManager(filepath.Join(workspace, "sessions"))
+	}
+
+	scope := &userScope{
+		workspace:      workspace,
+		sessions:       sessions,
+		contextBuilder: al.newContextBuilder(workspace, userUUID, userID),
+	}
+	al.scopes[userUUID] = scope
+	return scope
+}
+
+// newContextBuilder creates a context builder for workspace with the loop's
+// user, agent profile and tools applied.
+func (al *AgentLoop) newContextBuilder(workspace, userUUID string, userID int64) *ContextBuilder {
+	cb := NewContextBuilder(workspace)
+	if userUUID != "" {
+		cb.WithUser(userUUID, userID)
+	}
+	cb.WithProfile(al.profile)
+	cb.SetToolsRegistry(al.tools)
+	return cb
+}
@@ -0,0 +1,187 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/sipeed/kakoclaw/pkg/bus"
+	"github.com/sipeed/kakoclaw/pkg/logger"
+	"github.com/sipeed/kakoclaw/pkg/providers"
+	"github.com/sipeed/kakoclaw/pkg/utils"
+)
+
+// steerCommand is the prefix that marks a message as steering the running
+// turn even when steer_mode is "queue".
+const steerCommand = "/steer "
+
+// steerMessage is a user message waiting to be injected into a running turn.
+type steerMessage struct {
+	content string
+	// requeue hands the message back to its front end as a normal message
+	// when the turn ends before the message could be injected.
+	requeue func()
+}
+
+// steerInbox holds the steering messages for one running turn.
+type steerInbox struct {
+	mu   sync.Mutex
+	msgs []steerMessage
+}
+
+func (in *steerInbox) drain() []steerMessage {
+	if in == nil {
+		return nil
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	msgs := in.msgs
+	in.msgs = nil
+	return msgs
+}
+
+// steering tracks the running turn of each session so that new messages can
+// be steered into it.
+type steering struct {
+	mu    sync.Mutex
+	runs  map[string]*steerInbox
+	steer bool // every message during a run steers it, not only /steer ones
+}
+
+func newSteering(mode string) *steering {
+	return &steering{runs: make(map[string]*steerInbox), steer: mode == "steer"}
+}
+
+// begin registers a running turn for sessionKey. It returns nil if the
+// session already has one; that turn keeps receiving the steering messages.
+func (s *steering) begin(sessionKey string) *steerInbox {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.runs[sessionKey]; ok {
+		return nil
+	}
+	in := &steerInbox{}
+	s.runs[sessionKey] = in
+	return in
+}
+
+// end unregisters the turn and requeues the messages it never consumed.
+func (s *steering) end(sessionKey string, in *steerInbox) {
+	if in == nil {
+		return
+	}
+	s.mu.Lock()
+	delete(s.runs, sessionKey)
+	s.mu.Unlock()
+
+	for _, m := range in.drain() {
+		logger.InfoCF("agent", "Requeuing steering message after the turn ended",
+			map[string]interface{}{"session_key": sessionKey})
+		if m.requeue != nil {
+			m.requeue()
+		}
+	}
+}
+
+// push adds a message to the running turn of sessionKey. It returns false if
+// no turn is running.
+func (s *steering) push(sessionKey string, m steerMessage) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	in, ok := s.runs[sessionKey]
+	if !ok {
+		return false
+	}
+	in.mu.Lock()
+	in.msgs = append(in.msgs, m)
+	in.mu.Unlock()
+	return true
+}
+
+// parseSteer reports whether content should steer a running turn and returns
+// the content with any /steer prefix removed.
+func (s *steering) parseSteer(content string) (string, bool) {
+	trimmed := strings.TrimSpace(content)
+	if strings.HasPrefix(strings.ToLower(trimmed), steerCommand) {
+		return strings.TrimSpace(trimmed[len(steerCommand):]), true
+	}
+	return content, s.steer
+}
+
+// steerInbound tries to steer a running turn with an inbound channel message.
+// It returns true if the message was taken; otherwise msg should be queued
+// as usual, with any /steer prefix already removed.
+func (al *AgentLoop) steerInbound(msg *bus.InboundMessage) bool {
+	if msg.Channel == "system" || len(msg.Media) > 0 {
+		return false
+	}
+	content, ok := al.steering.parseSteer(msg.Content)
+	msg.Content = content
+	if !ok || content == "" {
+		return false
+	}
+
+	requeued := *msg
+	if !al.steering.push(dispatchKey(*msg), steerMessage{
+		content: content,
+		requeue: func() { al.bus.PublishInbound(requeued) },
+	}) {
+		return false
+	}
+
+	al.bus.PublishOutbound(bus.OutboundMessage{
+		UserID:  msg.UserID,
+		Channel: msg.Channel,
+		ChatID:  msg.ChatID,
+		Content: "Got it, I'll take that into account in the current task.",
+	})
+	return true
+}
+
+// Steer injects content into the running turn of sessionKey before its next
+// LLM call. It returns false if the session has no running turn. If the turn
+// ends before the message is used, requeue is called so the front end can
+// process it as a normal message.
+func (al *AgentLoop) Steer(sessionKey, content string, requeue func()) bool {
+	content, _ = al.steering.parseSteer(content)
+	if strings.TrimSpace(content) == "" {
+		return false
+	}
+	return al.steering.push(sessionKey, steerMessage{content: content, requeue: requeue})
+}
+
+// SteerAll reports whether every message sent during a running turn should
+// steer it (steer_mode "steer") rather than only /steer messages.
+func (al *AgentLoop) SteerAll() bool {
+	return al.steering.steer
+}
+
+type steerListenerKey struct{}
+
+// WithSteerListener returns a copy of ctx whose turn calls fn with each
+// steering message as it is injected into the conversation.
+func WithSteerListener(ctx context.Context, fn func(content string)) context.Context {
+	return context.WithValue(ctx, steerListenerKey{}, fn)
+}
+
+// applySteering appends the steering messages that arrived since the last
+// LLM call to messages and records them in the session history.
+func (al *AgentLoop) applySteering(ctx context.Context, rs *runState, messages []providers.Message, opts processOptions) []providers.Message {
+	listener, _ := ctx.Value(steerListenerKey{}).(func(string))
+	for _, m := range opts.Steer.drain() {
+		logger.InfoCF("agent", "Steering running turn: "+utils.Truncate(m.content, 80),
+			map[string]interface{}{"session_key": opts.SessionKey})
+
+		messages = append(messages, providers.Message{Role: "user", Content: m.content})
+		rs.sessions.AddMessageForUser(rs.userID, opts.SessionKey, "user", m.content)
+		if al.storage != nil {
+			if err := al.storage.SaveMessageForUser(rs.userID, opts.SessionKey, "user", m.content); err != nil {
+				logger.ErrorCF("agent", "Failed to save steering message to storage", map[string]interface{}{"error": err.Error()})
+			}
+		}
+		if listener != nil {
+			listener(m.content)
+		}
+	}
+	return messages
+}
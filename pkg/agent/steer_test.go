@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/providers"
+)
+
+// steeringProvider calls onCall before answering each Chat call. The first
+// call asks for a tool so the turn takes a second iteration.
+type steeringProvider struct {
+	onCall func(call int, messages []providers.Message)
+	calls  int
+}
+
+func (p *steeringProvider) GetDefaultModel() string { return "main" }
+
+func (p *steeringProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, options map[string]interface{}) (*providers.LLMResponse, error) {
+	p.calls++
+	p.onCall(p.calls, messages)
+	if p.calls == 1 {
+		return &providers.LLMResponse{ToolCalls: []providers.ToolCall{{ID: "call-1", Name: "list_dir", Arguments: map[string]interface{}{"path": "."}}}}, nil
+	}
+	return &providers.LLMResponse{Content: "done", FinishReason: "stop"}, nil
+}
+
+func TestSteeringQueueAndRequeue(t *testing.T) {
+	s := newSteering("queue")
+	if s.push("s1", steerMessage{content: "early"}) {
+		t.Fatal("expected push to fail without a running turn")
+	}
+
+	in := s.begin("s1")
+	if in == nil || s.begin("s1") != nil {
+		t.Fatal("expected exactly one running turn per session")
+	}
+	var requeued []string
+	for _, content := range []string{"first", "second"} {
+		content := content
+		if !s.push("s1", steerMessage{content: content, requeue: func() { requeued = append(requeued, content) }}) {
+			t.Fatalf("expected %q to be queued", content)
+		}
+	}
+	if msgs := in.drain(); len(msgs) != 2 || msgs[0].content != "first" || msgs[1].content != "second" {
+		t.Fatalf("expected the messages in order, got %+v", msgs)
+	}
+
+	s.push("s1", steerMessage{content: "late", requeue: func() { requeued = append(requeued, "late") }})
+	s.end("s1", in)
+	if len(requeued) != 1 || requeued[0] != "late" {
+		t.Fatalf("expected only the unconsumed message to be requeued, got %v", requeued)
+	}
+	if s.push("s1", steerMessage{content: "after"}) {
+		t.Fatal("expected push to fail after the turn ended")
+	}
+}
+
+func TestSteerAll(t *testing.T) {
+	queue := newTestAgentLoop(t, providers.NewMockProvider(), nil)
+	if queue.SteerAll() {
+		t.Fatal("expected queue mode not to steer every message")
+	}
+	if content, ok := queue.steering.parseSteer("/steer  use tabs "); !ok || content != "use tabs" {
+		t.Fatalf("expected /steer to steer in queue mode, got %q, %v", content, ok)
+	}
+	if _, ok := queue.steering.parseSteer("use tabs"); ok {
+		t.Fatal("expected a plain message not to steer in queue mode")
+	}
+
+	steer := newTestAgentLoop(t, providers.NewMockProvider(), func(cfg *config.Config) {
+		cfg.Agents.Defaults.SteerMode = "steer"
+	})
+	if !steer.SteerAll() {
+		t.Fatal("expected steer mode to steer every message")
+	}
+	if content, ok := steer.steering.parseSteer("use tabs"); !ok || content != "use tabs" {
+		t.Fatalf("expected a plain message to steer in steer mode, got %q, %v", content, ok)
+	}
+	if steer.Steer("idle", "use tabs", nil) {
+		t.Fatal("expected Steer to fail without a running turn")
+	}
+}
+
+func TestSteeringDrainedBetweenIterations(t *testing.T) {
+	var al *AgentLoop
+	var secondCall []providers.Message
+	provider := &steeringProvider{onCall: func(call int, messages []providers.Message) {
+		switch call {
+		case 1:
+			if !al.Steer("steered", "/steer use tabs", func() { t.Error("expected the message not to be requeued") }) {
+				t.Error("expected the running turn to accept the message")
+			}
+		case 2:
+			secondCall = messages
+		}
+	}}
+	al = newTestAgentLoop(t, provider, nil)
+
+	var heard []string
+	ctx := WithSteerListener(context.Background(), func(content string) { heard = append(heard, content) })
+	if _, err := al.ProcessDirect(ctx, "tidy up", "steered"); err != nil {
+		t.Fatalf("ProcessDirect: %v", err)
+	}
+
+	last := secondCall[len(secondCall)-1]
+	if last.Role != "user" || last.Content != "use tabs" {
+		t.Fatalf("expected the steering message after the tool result, got %+v", last)
+	}
+	if len(heard) != 1 || heard[0] != "use tabs" {
+		t.Fatalf("expected the listener to hear the message once, got %v", heard)
+	}
+	found := false
+	for _, msg := range al.defaultScope.sessions.GetHistoryForUser(al.userID, "steered") {
+		found = found || (msg.Role == "user" && msg.Content == "use tabs")
+	}
+	if !found {
+		t.Fatal("expected the steering message in the session history")
+	}
+}
@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sipeed/kakoclaw/pkg/bus"
+	"github.com/sipeed/kakoclaw/pkg/jsonschema"
+	"github.com/sipeed/kakoclaw/pkg/logger"
+	"github.com/sipeed/kakoclaw/pkg/providers"
+)
+
+// StructuredOutputError is returned when the model's answer still does not
+// match the requested JSON Schema after all repair attempts.
+type StructuredOutputError struct {
+	Content  string   // The last answer
+	Problems []string // Why it does not match the schema
+}
+
+func (e *StructuredOutputError) Error() string {
+	return "response does not match the schema: " + strings.Join(e.Problems, "; ")
+}
+
+// ProcessDirectJSON processes a message like ProcessDirectWithModel and
+// returns an answer that is valid JSON matching schema. Providers with a
+// structured output mode are asked to use it; otherwise the answer is
+// validated and the model is asked to fix it, up to
+// structured_output_retries times.
+func (al *AgentLoop) ProcessDirectJSON(ctx context.Context, userID int64, content, sessionKey, modelOverride string, schema map[string]interface{}, excludeTools ...string) (json.RawMessage, error) {
+	if len(schema) == 0 {
+		return nil, fmt.Errorf("response schema is empty")
+	}
+	msg := bus.InboundMessage{
+		Channel:    "cli",
+		SenderID:   "cron",
+		ChatID:     "direct",
+		Content:    content,
+		SessionKey: sessionKey,
+		UserID:     userID,
+	}
+
+	answer, err := al.processUserMessage(ctx, msg, processOptions{
+		ModelOverride:  modelOverride,
+		ResponseSchema: schema,
+		ExcludeTools:   excludeTools,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Early exits such as rate limiting or a budget notice answer in text.
+	data, problems := checkStructuredOutput(schema, answer)
+	if problems != nil {
+		return nil, &StructuredOutputError{Content: answer, Problems: problems}
+	}
+	return data, nil
+}
+
+// structuredOutputPrompt tells the model how to answer when the provider
+// cannot enforce the schema itself.
+func structuredOutputPrompt(schema map[string]interface{}) string {
+	data, _ := json.MarshalIndent(schema, "", "  ")
+	return "## Response Format\n\nYour final answer must be a single JSON value that matches this JSON Schema, " +
+		"with no surrounding text or code fences:\n\n" + string(data)
+}
+
+// withStructuredOutputPrompt appends the response format instructions to the
+// system message. They go last so the cached system prompt prefix is kept.
+func withStructuredOutputPrompt(messages []providers.Message, schema map[string]interface{}) []providers.Message {
+	if len(messages) == 0 || messages[0].Role != "system" {
+		return messages
+	}
+	prompt := structuredOutputPrompt(schema)
+	system := messages[0]
+	system.Content += "\n\n---\n\n" + prompt
+	if len(system.Parts) > 0 {
+		system.Parts = append(system.Parts[:len(system.Parts):len(system.Parts)], providers.TextPart(prompt))
+	}
+	return append([]providers.Message{system}, messages[1:]...)
+}
+
+// checkStructuredOutput extracts the JSON value from an answer and validates
+// it against schema. It returns the compacted JSON, or the problems found.
+func checkStructuredOutput(schema map[string]interface{}, answer string) (json.RawMessage, []string) {
+	raw := extractJSON(answer)
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return nil, []string{"the answer is not valid JSON: " + err.Error()}
+	}
+	if problems := jsonschema.Validate(schema, value); problems != nil {
+		return nil, problems
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	return data, nil
+}
+
+// extractJSON returns the JSON part of an answer, dropping code fences and
+// any prose around the outermost object or array.
+func extractJSON(answer string) string {
+	s := strings.TrimSpace(answer)
+	if strings.HasPrefix(s, "```") {
+		s = strings.TrimPrefix(s, "```")
+		if nl := strings.IndexByte(s, '\n'); nl >= 0 {
+			s = s[nl+1:] // drop the language tag
+		}
+		s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "```"))
+	}
+	if json.Valid([]byte(s)) {
+		return s
+	}
+	start := strings.IndexAny(s, "{[")
+	if start < 0 {
+		return s
+	}
+	closer := "}"
+	if s[start] == '[' {
+		closer = "]"
+	}
+	if end := strings.LastIndex(s, closer); end > start {
+		return s[start : end+1]
+	}
+	return s
+}
+
+// repairStructuredOutput asks the model to fix an answer that failed the
+// response schema. It reports false once the repair attempts are used up.
+func (al *AgentLoop) repairStructuredOutput(messages []providers.Message, answer string, problems []string, attempt int) ([]providers.Message, bool) {
+	if attempt > al.jsonRetries {
+		return messages, false
+	}
+	logger.WarnCF("agent", "Answer does not match the response schema, asking for a fix",
+		map[string]interface{}{
+			"attempt":  attempt,
+			"problems": problems,
+		})
+	messages = append(messages,
+		providers.Message{Role: "assistant", Content: answer},
+		providers.Message{Role: "user", Content: "Your answer does not match the required JSON Schema:\n- " +
+			strings.Join(problems, "\n- ") +
+			"\n\nReply again with only the corrected JSON value."},
+	)
+	return messages, true
+}
@@ -0,0 +1,241 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sipeed/kakoclaw/pkg/approval"
+	"github.com/sipeed/kakoclaw/pkg/logger"
+	"github.com/sipeed/kakoclaw/pkg/observability"
+	"github.com/sipeed/kakoclaw/pkg/policy"
+	"github.com/sipeed/kakoclaw/pkg/providers"
+	"github.com/sipeed/kakoclaw/pkg/tools"
+	"github.com/sipeed/kakoclaw/pkg/utils"
+)
+
+// toolCallResult is the outcome of a single tool call.
+type toolCallResult struct {
+	call   providers.ToolCall
+	result string
+	err    error
+}
+
+// buildToolCallMessage builds the assistant message that carries the tool
+// calls requested by the LLM.
+func buildToolCallMessage(content string, calls []providers.ToolCall) providers.Message {
+	msg := providers.Message{
+		Role:    "assistant",
+		Content: content,
+	}
+	for _, tc := range calls {
+		argumentsJSON, _ := json.Marshal(tc.Arguments)
+		msg.ToolCalls = append(msg.ToolCalls, providers.ToolCall{
+			ID:   tc.ID,
+			Type: "function",
+			Function: &providers.FunctionCall{
+				Name:      tc.Name,
+				Arguments: string(argumentsJSON),
+			},
+		})
+	}
+	return msg
+}
+
+// executeToolCalls runs the tool calls from one LLM response. Independent
+// calls run concurrently; calls that share a serial key (see tools.SerialTool)
+// run one after another in the order the model issued them. Results are
+// returned in the same order as calls, regardless of completion order.
+func (al *AgentLoop) executeToolCalls(ctx context.Context, calls []providers.ToolCall, opts processOptions, iteration int) []toolCallResult {
+	results := make([]toolCallResult, len(calls))
+
+	// Group call indexes into lanes. Each lane runs sequentially; lanes run in
+	// parallel. A call that conflicts with several lanes joins them into one,
+	// kept in issue order.
+	var lanes [][]int
+	var laneKeys [][]string
+	for i, tc := range calls {
+		key := al.tools.SerialKey(ctx, tc.Name, tc.Arguments)
+		lane, keys := []int{i}, []string{key}
+		var rest [][]int
+		var restKeys [][]string
+		for l := range lanes {
+			conflict := false
+			for _, k := range laneKeys[l] {
+				if tools.SerialConflict(key, k) {
+					conflict = true
+					break
+				}
+			}
+			if conflict {
+				lane = append(lane, lanes[l]...)
+				keys = append(keys, laneKeys[l]...)
+				continue
+			}
+			rest = append(rest, lanes[l])
+			restKeys = append(restKeys, laneKeys[l])
+		}
+		sort.Ints(lane)
+		lanes, laneKeys = append(rest, lane), append(restKeys, keys)
+	}
+
+	// Tool callbacks may write to a shared connection, so never invoke them concurrently.
+	var notifyMu sync.Mutex
+	notify := func(ev ToolEvent) {
+		if opts.OnTool == nil {
+			return
+		}
+		notifyMu.Lock()
+		defer notifyMu.Unlock()
+		_ = opts.OnTool(ev)
+	}
+
+	run := func(i int) {
+		tc := calls[i]
+
+		argsJSON, _ := json.Marshal(tc.Arguments)
+		argsPreview := utils.Truncate(string(argsJSON), 200)
+		logger.InfoCF("agent", fmt.Sprintf("Tool call: %s(%s)", tc.Name, argsPreview),
+			map[string]interface{}{
+				"tool":      tc.Name,
+				"call_id":   tc.ID,
+				"iteration": iteration,
+			})
+
+		if denied, ok := al.authorizeToolCall(ctx, tc, notify); !ok {
+			results[i] = toolCallResult{call: tc, result: denied}
+			return
+		}
+
+		toolStart := time.Now()
+		notify(ToolEvent{ID: tc.ID, Name: tc.Name, Args: tc.Arguments, Status: "started", StartedAt: toolStart})
+
+		result, err := al.tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, opts.Channel, opts.ChatID)
+		toolEnd := time.Now()
+		observability.Global().RecordToolCall(tc.Name, toolEnd.Sub(toolStart), err)
+		if err != nil {
+			result = fmt.Sprintf("Error: %v", err)
+		}
+
+		status := "finished"
+		if err != nil {
+			status = "error"
+		}
+		notify(ToolEvent{
+			ID:         tc.ID,
+			Name:       tc.Name,
+			Args:       tc.Arguments,
+			Result:     result,
+			Status:     status,
+			StartedAt:  toolStart,
+			FinishedAt: toolEnd,
+			DurationMs: toolEnd.Sub(toolStart).Milliseconds(),
+		})
+
+		results[i] = toolCallResult{call: tc, result: result, err: err}
+	}
+
+	if len(lanes) == 1 {
+		for _, i := range lanes[0] {
+			run(i)
+		}
+		return results
+	}
+
+	var wg sync.WaitGroup
+	for _, lane := range lanes {
+		wg.Add(1)
+		go func(lane []int) {
+			defer wg.Done()
+			for _, i := range lane {
+				run(i)
+			}
+		}(lane)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// policyCaller returns who the tool policy checks calls made with ctx as.
+func policyCaller(ctx context.Context) policy.Caller {
+	ec, _ := tools.ExecutionContextFrom(ctx)
+	caller, ok := policy.CallerFrom(ctx)
+	if !ok {
+		caller = policy.Caller{UserID: ec.UserID, Channel: ec.Channel, ChatID: ec.ChatID}
+	}
+	caller.Workspace = ec.Workspace
+	return caller
+}
+
+// authorizeToolCall checks a tool call against the tool policy, then asks
+// the user to approve it if it is gated and waits for the decision. It
+// returns false and the tool result to report when the call must not run.
+func (al *AgentLoop) authorizeToolCall(ctx context.Context, tc providers.ToolCall, notify func(ToolEvent)) (string, bool) {
+	caller := policyCaller(ctx)
+	if err := al.policy.Check(caller, tc.Name, tc.Arguments); err != nil {
+		logger.WarnCF("agent", "Tool call blocked by policy",
+			map[string]interface{}{
+				"tool":    tc.Name,
+				"user_id": caller.UserID,
+				"role":    caller.Role,
+				"channel": caller.Channel,
+				"reason":  err.Error(),
+			})
+		now := time.Now()
+		notify(ToolEvent{ID: tc.ID, Name: tc.Name, Args: tc.Arguments, Result: err.Error(), Status: "denied", StartedAt: now, FinishedAt: now})
+		return err.Error(), false
+	}
+
+	ec, _ := tools.ExecutionContextFrom(ctx)
+	if !al.approvals.Requires(tc.Name, ec.UserID) {
+		return "", true
+	}
+	if tool, ok := al.tools.Get(tc.Name); ok {
+		if scoped, ok := tool.(tools.ApprovalScopedTool); ok && !scoped.NeedsApproval(tc.Arguments) {
+			return "", true
+		}
+	}
+
+	askedAt := time.Now()
+	notify(ToolEvent{ID: tc.ID, Name: tc.Name, Args: tc.Arguments, Status: "awaiting_approval", StartedAt: askedAt})
+
+	ok, denied := al.approvals.Authorize(ctx, approval.Request{
+		Tool:       tc.Name,
+		Args:       tc.Arguments,
+		UserID:     ec.UserID,
+		SenderID:   ec.SenderID,
+		SessionKey: ec.SessionKey,
+		Channel:    ec.Channel,
+		ChatID:     ec.ChatID,
+	})
+	if ok {
+		return "", true
+	}
+
+	now := time.Now()
+	notify(ToolEvent{
+		ID:         tc.ID,
+		Name:       tc.Name,
+		Args:       tc.Arguments,
+		Result:     denied,
+		Status:     "denied",
+		StartedAt:  askedAt,
+		FinishedAt: now,
+		DurationMs: now.Sub(askedAt).Milliseconds(),
+	})
+	return denied, false
+}
+
+// appendToolResults appends tool results to the conversation in call order
+// and records them in the session history.
+func (al *AgentLoop) appendToolResults(rs *runState, messages []providers.Message, results []toolCallResult, opts processOptions) []providers.Message {
+	for _, r := range results {
+		messages = rs.contextBuilder.AddToolResult(messages, r.call.ID, r.call.Name, r.result)
+		rs.sessions.AddFullMessageForUser(rs.userID, opts.SessionKey, messages[len(messages)-1])
+	}
+	return messages
+}
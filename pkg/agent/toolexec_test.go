@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sipeed/kakoclaw/pkg/providers"
+	"github.com/sipeed/kakoclaw/pkg/tools"
+)
+
+// laneTool records when each call ran. Its serial key comes from the "key"
+// argument.
+type laneTool struct {
+	mu    sync.Mutex
+	spans map[string][2]time.Time
+}
+
+func (t *laneTool) Name() string        { return "lane" }
+func (t *laneTool) Description() string { return "test tool" }
+func (t *laneTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{"type": "object"}
+}
+
+func (t *laneTool) SerialKey(ctx context.Context, args map[string]interface{}) string {
+	key, _ := args["key"].(string)
+	return key
+}
+
+func (t *laneTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	id, _ := args["id"].(string)
+	start := time.Now()
+	time.Sleep(30 * time.Millisecond)
+	t.mu.Lock()
+	t.spans[id] = [2]time.Time{start, time.Now()}
+	t.mu.Unlock()
+	return id, nil
+}
+
+func TestExecuteToolCallsLanes(t *testing.T) {
+	tool := &laneTool{spans: make(map[string][2]time.Time)}
+	registry := tools.NewToolRegistry()
+	registry.Register(tool)
+	al := &AgentLoop{tools: registry}
+
+	call := func(id, key string) providers.ToolCall {
+		return providers.ToolCall{ID: id, Name: "lane", Arguments: map[string]interface{}{"id": id, "key": key}}
+	}
+	calls := []providers.ToolCall{
+		call("write_a", "file:/ws/a"),
+		call("read_b", "file:/ws/b"),
+		call("exec", "workspace"),
+		call("edit_a", "file:/ws/a"),
+		call("free", ""),
+	}
+	results := al.executeToolCalls(context.Background(), calls, processOptions{}, 1)
+
+	for i, r := range results {
+		if r.call.ID != calls[i].ID || r.result != calls[i].ID {
+			t.Fatalf("result %d is %s (%q), want %s", i, r.call.ID, r.result, calls[i].ID)
+		}
+	}
+
+	before := func(a, b string) {
+		t.Helper()
+		if tool.spans[a][1].After(tool.spans[b][0]) {
+			t.Errorf("expected %s to finish before %s started", a, b)
+		}
+	}
+	// exec conflicts with every file call, so it waits for the calls issued
+	// before it and holds back the ones issued after it.
+	before("write_a", "exec")
+	before("read_b", "exec")
+	before("exec", "edit_a")
+	if !tool.spans["free"][0].Before(tool.spans["write_a"][1]) {
+		t.Error("expected an unkeyed call to run alongside the file calls")
+	}
+}
+
+func TestFileSerialKeysUseContextWorkspace(t *testing.T) {
+	registry := tools.NewToolRegistry()
+	registry.Register(tools.NewReadFileTool("/default", true))
+	registry.Register(tools.NewWriteFileTool("/default", true))
+	registry.Register(tools.NewExecTool("/default", true))
+	ctx := tools.WithExecutionContext(context.Background(), tools.ExecutionContext{Workspace: "/users/7"})
+	args := map[string]interface{}{"path": "notes.txt"}
+
+	read := registry.SerialKey(ctx, "read_file", args)
+	write := registry.SerialKey(ctx, "write_file", args)
+	if read != "file:/users/7/notes.txt" || read != write {
+		t.Fatalf("expected reads and writes of one file to share a lane in the caller's workspace, got %q and %q", read, write)
+	}
+	if exec := registry.SerialKey(ctx, "exec", nil); !tools.SerialConflict(exec, read) {
+		t.Fatalf("expected exec (%q) to conflict with file calls", exec)
+	}
+	if tools.SerialConflict(read, registry.SerialKey(ctx, "read_file", map[string]interface{}{"path": "other.txt"})) {
+		t.Fatal("expected calls on different files not to conflict")
+	}
+}
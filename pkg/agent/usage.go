@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sipeed/kakoclaw/pkg/budget"
+	"github.com/sipeed/kakoclaw/pkg/observability"
+	"github.com/sipeed/kakoclaw/pkg/providers"
+	"github.com/sipeed/kakoclaw/pkg/tools"
+)
+
+// llmUsage returns the token usage to record for an LLM call. Provider-reported
+// counts are used as-is; only counts the provider left out are estimated.
+func llmUsage(reported *providers.UsageInfo, messages []providers.Message, toolDefs []providers.ToolDefinition, content string, toolCalls []providers.ToolCall) observability.TokenUsage {
+	var usage observability.TokenUsage
+	if reported != nil {
+		usage.In = reported.PromptTokens
+		usage.Out = reported.CompletionTokens
+		usage.Cached = reported.CachedTokens
+		usage.CacheWrite = reported.CacheCreationTokens
+	}
+
+	if usage.In == 0 {
+		usage.In = providers.EstimateMessagesTokens(messages) + providers.EstimateToolDefinitionsTokens(toolDefs)
+		usage.Estimated = true
+	}
+	if usage.Out == 0 && (content != "" || len(toolCalls) > 0) {
+		usage.Out = providers.EstimateTokens(content) + providers.EstimateToolCallsTokens(toolCalls)
+		usage.Estimated = true
+	}
+	return usage
+}
+
+// servedModel returns the model that answered an LLM call: the one the
+// provider reported, since a failover backend may substitute its own, or else
+// the requested one.
+func servedModel(requested, reported string) string {
+	if reported != "" {
+		return reported
+	}
+	return requested
+}
+
+// recordLLMCall bills a successful LLM call made by a run and tracks it in
+// the run state.
+func (al *AgentLoop) recordLLMCall(rs *runState, opts processOptions, model string, duration time.Duration, usage observability.TokenUsage) {
+	rs.recordUsage(al.billLLMCall(rs.budgetScope(opts), model, duration, usage))
+}
+
+// billLLMCall prices a successful LLM call and records it in the metrics and
+// the usage ledger. It returns the usage with its cost filled in.
+func (al *AgentLoop) billLLMCall(scope budget.Scope, model string, duration time.Duration, usage observability.TokenUsage) observability.TokenUsage {
+	usage.CostUSD = al.budget.Cost(model, usage)
+	observability.Global().RecordLLMCall(model, duration, usage, nil)
+	al.budget.Record(scope, model, usage)
+	return usage
+}
+
+// recordSubagentLLMCall bills an LLM call made by a subagent to the user and
+// session that spawned it.
+func (al *AgentLoop) recordSubagentLLMCall(ctx context.Context, model string, duration time.Duration, sent []providers.Message, toolDefs []providers.ToolDefinition, resp *providers.LLMResponse, err error) {
+	if err != nil {
+		observability.Global().RecordLLMCall(model, duration, observability.TokenUsage{}, err)
+		return
+	}
+	al.billLLMCall(subagentBudgetScope(ctx), servedModel(model, resp.Model), duration, llmUsage(resp.Usage, sent, toolDefs, resp.Content, resp.ToolCalls))
+}
+
+// checkSubagentLLMCall enforces the spawning user's and session's spending
+// caps before a subagent LLM call, refusing it or downgrading the model.
+func (al *AgentLoop) checkSubagentLLMCall(ctx context.Context, model string) (string, error) {
+	decision := al.budget.Check(subagentBudgetScope(ctx), model)
+	if !decision.Allowed {
+		return "", errors.New(decision.Notice)
+	}
+	return decision.Model, nil
+}
+
+// subagentBudgetScope returns the budget scope of the run that spawned a
+// subagent.
+func subagentBudgetScope(ctx context.Context) budget.Scope {
+	ec, _ := tools.ExecutionContextFrom(ctx)
+	return budget.Scope{UserID: ec.UserID, SessionKey: ec.SessionKey, Channel: ec.Channel}
+}
@@ -0,0 +1,449 @@
+// Package approval implements human-in-the-loop approval for tool calls.
+// Gated calls pause until the user who started the turn approves or denies
+// them on the originating channel, or until the request times out.
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sipeed/kakoclaw/pkg/bus"
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/logger"
+	"github.com/sipeed/kakoclaw/pkg/storage"
+	"github.com/sipeed/kakoclaw/pkg/utils"
+)
+
+// Decision is the outcome of an approval request.
+type Decision string
+
+const (
+	ApproveOnce    Decision = "approve_once"
+	ApproveSession Decision = "approve_session"
+	Deny           Decision = "deny"
+	Timeout        Decision = "timeout"
+)
+
+// Approved reports whether the decision allows the call to run.
+func (d Decision) Approved() bool {
+	return d == ApproveOnce || d == ApproveSession
+}
+
+const defaultTimeout = 2 * time.Minute
+
+// Request describes a tool call waiting for approval.
+type Request struct {
+	ID         string                 `json:"id"`
+	Tool       string                 `json:"tool"`
+	Args       map[string]interface{} `json:"arguments"`
+	UserID     int64                  `json:"user_id"`
+	SenderID   string                 `json:"sender_id,omitempty"`
+	SessionKey string                 `json:"session_key"`
+	Channel    string                 `json:"channel"`
+	ChatID     string                 `json:"chat_id"`
+	CreatedAt  time.Time              `json:"created_at"`
+	ExpiresAt  time.Time              `json:"expires_at"`
+}
+
+// Prompter delivers approval requests to a user over a connection the caller
+// owns, such as the web chat WebSocket. Attach one to the run context with
+// WithPrompter; without one, requests are sent over the message bus.
+type Prompter interface {
+	Prompt(req Request) error
+	Resolved(req Request, decision Decision)
+}
+
+type prompterKey struct{}
+
+// WithPrompter returns a copy of ctx that delivers approval requests to p.
+func WithPrompter(ctx context.Context, p Prompter) context.Context {
+	return context.WithValue(ctx, prompterKey{}, p)
+}
+
+func prompterFrom(ctx context.Context) Prompter {
+	p, _ := ctx.Value(prompterKey{}).(Prompter)
+	return p
+}
+
+// Recorder persists approval decisions.
+type Recorder interface {
+	SaveToolApproval(a storage.ToolApproval) error
+}
+
+type pendingRequest struct {
+	req      Request
+	prompter Prompter
+	decided  chan Decision
+}
+
+// Manager gates tool calls behind human approval.
+type Manager struct {
+	mu       sync.Mutex
+	cfg      config.ApprovalConfig
+	timeout  time.Duration
+	bus      *bus.MessageBus
+	recorder Recorder
+	pending  map[string]*pendingRequest
+	grants   map[string]bool // user+session+tool approved for the rest of the session
+}
+
+// NewManager creates an approval manager. recorder may be nil.
+func NewManager(cfg config.ApprovalConfig, msgBus *bus.MessageBus, recorder Recorder) *Manager {
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Manager{
+		cfg:      cfg,
+		timeout:  timeout,
+		bus:      msgBus,
+		recorder: recorder,
+		pending:  make(map[string]*pendingRequest),
+		grants:   make(map[string]bool),
+	}
+}
+
+// Enabled reports whether approvals are turned on.
+func (m *Manager) Enabled() bool {
+	return m != nil && m.cfg.Enabled
+}
+
+// Requires reports whether a call to tool by userID needs approval.
+func (m *Manager) Requires(tool string, userID int64) bool {
+	if !m.Enabled() {
+		return false
+	}
+
+	required := matchesAny(tool, m.cfg.Tools)
+	if policy, ok := m.cfg.Users[strconv.FormatInt(userID, 10)]; ok {
+		if matchesAny(tool, policy.Require) {
+			required = true
+		}
+		if matchesAny(tool, policy.Exempt) {
+			required = false
+		}
+	}
+	return required
+}
+
+func matchesAny(tool string, patterns []string) bool {
+	for _, p := range patterns {
+		if p == tool || p == "*" {
+			return true
+		}
+		if ok, _ := path.Match(p, tool); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func grantKey(req Request) string {
+	return fmt.Sprintf("%d|%s|%s", req.UserID, req.SessionKey, req.Tool)
+}
+
+// Authorize blocks until the call described by req may run. It returns true
+// when the call is approved; otherwise it returns false and a message that
+// should be given to the model as the tool result.
+func (m *Manager) Authorize(ctx context.Context, req Request) (bool, string) {
+	if !m.Requires(req.Tool, req.UserID) {
+		return true, ""
+	}
+
+	m.mu.Lock()
+	if m.grants[grantKey(req)] {
+		m.mu.Unlock()
+		return true, ""
+	}
+
+	req.ID = strings.ReplaceAll(uuid.NewString(), "-", "")[:12]
+	req.CreatedAt = time.Now()
+	req.ExpiresAt = req.CreatedAt.Add(m.timeout)
+	p := &pendingRequest{req: req, prompter: prompterFrom(ctx), decided: make(chan Decision, 1)}
+	m.pending[req.ID] = p
+	m.mu.Unlock()
+
+	if err := m.deliver(p); err != nil {
+		logger.WarnCF("approval", "Could not deliver approval request", map[string]interface{}{
+			"tool":    req.Tool,
+			"channel": req.Channel,
+			"error":   err.Error(),
+		})
+		m.finish(req.ID, Deny, "system")
+		return false, fmt.Sprintf("Tool call denied: `%s` requires user approval, but no approval channel is available (%v). The tool was not executed.", req.Tool, err)
+	}
+
+	logger.InfoCF("approval", "Waiting for tool approval", map[string]interface{}{
+		"id":      req.ID,
+		"tool":    req.Tool,
+		"channel": req.Channel,
+		"chat_id": req.ChatID,
+	})
+
+	timer := time.NewTimer(m.timeout)
+	defer timer.Stop()
+
+	var decision Decision
+	select {
+	case decision = <-p.decided:
+	case <-timer.C:
+		m.finish(req.ID, Timeout, "system")
+		decision = <-p.decided
+	case <-ctx.Done():
+		m.finish(req.ID, Deny, "canceled")
+		return false, fmt.Sprintf("Tool call canceled: `%s` was not executed.", req.Tool)
+	}
+
+	switch decision {
+	case ApproveOnce, ApproveSession:
+		return true, ""
+	case Timeout:
+		return false, fmt.Sprintf("Tool call denied: approval for `%s` timed out after %s without a response from the user. The tool was not executed.", req.Tool, m.timeout)
+	default:
+		return false, fmt.Sprintf("Tool call denied by user: `%s` was not executed. Do not retry it unless the user asks; explain what you intended to do instead.", req.Tool)
+	}
+}
+
+// deliver sends the approval request to the user.
+func (m *Manager) deliver(p *pendingRequest) error {
+	if p.prompter != nil {
+		return p.prompter.Prompt(p.req)
+	}
+
+	req := p.req
+	if m.bus == nil || req.Channel == "" || req.Channel == "cli" || req.Channel == "system" {
+		return fmt.Errorf("channel %q cannot prompt for approval", req.Channel)
+	}
+
+	m.bus.PublishOutbound(bus.OutboundMessage{
+		UserID:  req.UserID,
+		Channel: req.Channel,
+		ChatID:  req.ChatID,
+		Content: PromptText(req, m.timeout),
+		Approval: &bus.ApprovalPrompt{
+			ID:      req.ID,
+			Tool:    req.Tool,
+			Options: Options(req.ID),
+		},
+	})
+	return nil
+}
+
+// Options returns the choices offered for an approval request.
+func Options(id string) []bus.ApprovalOption {
+	return []bus.ApprovalOption{
+		{Label: "Approve once", Command: "/approve " + id},
+		{Label: "Approve for session", Command: "/approve " + id + " session"},
+		{Label: "Deny", Command: "/deny " + id},
+	}
+}
+
+// PromptText renders a plain-text approval request.
+func PromptText(req Request, timeout time.Duration) string {
+	argsJSON, _ := json.Marshal(req.Args)
+	return fmt.Sprintf("🔐 Approval needed: the assistant wants to run `%s`\n\n%s\n\n"+
+		"Reply \"approve\", \"approve session\" or \"deny\" (or /approve %s, /deny %s). "+
+		"The request is denied automatically in %s.",
+		req.Tool, utils.Truncate(string(argsJSON), 500), req.ID, req.ID, timeout)
+}
+
+// Resolve records a decision for a pending request.
+func (m *Manager) Resolve(id string, decision Decision, decidedBy string) error {
+	if decision != ApproveOnce && decision != ApproveSession && decision != Deny {
+		return fmt.Errorf("invalid decision %q", decision)
+	}
+	if !m.finish(id, decision, decidedBy) {
+		return fmt.Errorf("no pending approval with id %q", id)
+	}
+	return nil
+}
+
+// finish completes a pending request exactly once and records the decision.
+func (m *Manager) finish(id string, decision Decision, decidedBy string) bool {
+	m.mu.Lock()
+	p, ok := m.pending[id]
+	if !ok {
+		m.mu.Unlock()
+		return false
+	}
+	delete(m.pending, id)
+	if decision == ApproveSession {
+		m.grants[grantKey(p.req)] = true
+	}
+	m.mu.Unlock()
+
+	p.decided <- decision
+
+	logger.InfoCF("approval", "Tool approval decided", map[string]interface{}{
+		"id":         id,
+		"tool":       p.req.Tool,
+		"decision":   string(decision),
+		"decided_by": decidedBy,
+	})
+
+	if p.prompter != nil {
+		p.prompter.Resolved(p.req, decision)
+	} else if decision == Timeout && m.bus != nil && p.req.Channel != "" {
+		m.bus.PublishOutbound(bus.OutboundMessage{
+			UserID:  p.req.UserID,
+			Channel: p.req.Channel,
+			ChatID:  p.req.ChatID,
+			Content: fmt.Sprintf("⏱️ Approval for `%s` timed out; the call was denied.", p.req.Tool),
+		})
+	}
+
+	if m.recorder != nil {
+		argsJSON, _ := json.Marshal(p.req.Args)
+		rec := storage.ToolApproval{
+			ID:         p.req.ID,
+			Tool:       p.req.Tool,
+			Arguments:  string(argsJSON),
+			UserID:     p.req.UserID,
+			SessionKey: p.req.SessionKey,
+			Channel:    p.req.Channel,
+			ChatID:     p.req.ChatID,
+			Decision:   string(decision),
+			DecidedBy:  decidedBy,
+			CreatedAt:  p.req.CreatedAt,
+			DecidedAt:  time.Now(),
+		}
+		if err := m.recorder.SaveToolApproval(rec); err != nil {
+			logger.WarnCF("approval", "Failed to record approval decision", map[string]interface{}{"error": err.Error()})
+		}
+	}
+	return true
+}
+
+// Pending returns the requests currently waiting for a decision, oldest
+// first. If userID is greater than zero, only that user's requests are returned.
+func (m *Manager) Pending(userID int64) []Request {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]Request, 0, len(m.pending))
+	for _, p := range m.pending {
+		if userID > 0 && p.req.UserID != userID {
+			continue
+		}
+		out = append(out, p.req)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// HandleInbound resolves a pending request from a chat reply. It accepts
+// "/approve <id> [session]" and "/deny <id>" (sent by channel buttons) as
+// well as bare "approve", "approve session" and "deny" replies, which apply
+// to the oldest pending request in the same chat. It returns true if the
+// message was consumed and must not be processed as a normal turn.
+func (m *Manager) HandleInbound(msg bus.InboundMessage) bool {
+	if !m.Enabled() {
+		return false
+	}
+
+	id, decision, ok := parseReply(msg.Content)
+	if !ok {
+		return false
+	}
+
+	m.mu.Lock()
+	var target *pendingRequest
+	if id != "" {
+		target = m.pending[id]
+	} else {
+		for _, p := range m.pending {
+			if p.req.Channel != msg.Channel || p.req.ChatID != msg.ChatID {
+				continue
+			}
+			if target == nil || p.req.CreatedAt.Before(target.req.CreatedAt) {
+				target = p
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	if target == nil {
+		if id == "" {
+			// A plain "yes"/"no" with nothing pending is a normal message.
+			return false
+		}
+		m.reply(msg, "No pending approval with that ID (it may have expired).")
+		return true
+	}
+
+	if target.req.Channel != msg.Channel || target.req.ChatID != msg.ChatID {
+		m.reply(msg, "That approval request belongs to a different chat.")
+		return true
+	}
+	if target.req.SenderID != "" && msg.SenderID != target.req.SenderID {
+		m.reply(msg, "Only the user who started this request can approve it.")
+		return true
+	}
+
+	if m.finish(target.req.ID, decision, msg.Channel+":"+msg.SenderID) {
+		switch decision {
+		case ApproveSession:
+			m.reply(msg, fmt.Sprintf("✅ Approved `%s` for the rest of this session.", target.req.Tool))
+		case ApproveOnce:
+			m.reply(msg, fmt.Sprintf("✅ Approved `%s`.", target.req.Tool))
+		default:
+			m.reply(msg, fmt.Sprintf("🚫 Denied `%s`.", target.req.Tool))
+		}
+	}
+	return true
+}
+
+func (m *Manager) reply(msg bus.InboundMessage, content string) {
+	if m.bus == nil {
+		return
+	}
+	m.bus.PublishOutbound(bus.OutboundMessage{
+		UserID:  msg.UserID,
+		Channel: msg.Channel,
+		ChatID:  msg.ChatID,
+		Content: content,
+	})
+}
+
+// parseReply extracts an approval decision from a chat message.
+func parseReply(content string) (id string, decision Decision, ok bool) {
+	fields := strings.Fields(strings.ToLower(strings.TrimSpace(content)))
+	if len(fields) == 0 || len(fields) > 3 {
+		return "", "", false
+	}
+
+	switch fields[0] {
+	case "/approve":
+		if len(fields) < 2 {
+			return "", "", false
+		}
+		if len(fields) == 3 && fields[2] == "session" {
+			return fields[1], ApproveSession, true
+		}
+		return fields[1], ApproveOnce, true
+	case "/deny":
+		if len(fields) != 2 {
+			return "", "", false
+		}
+		return fields[1], Deny, true
+	}
+
+	switch strings.Join(fields, " ") {
+	case "approve", "yes", "y", "ok":
+		return "", ApproveOnce, true
+	case "approve session", "always":
+		return "", ApproveSession, true
+	case "deny", "no", "n":
+		return "", Deny, true
+	}
+	return "", "", false
+}
@@ -0,0 +1,240 @@
+package approval
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sipeed/kakoclaw/pkg/bus"
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/storage"
+)
+
+type testPrompter struct {
+	mu       sync.Mutex
+	prompted chan Request
+	resolved []Decision
+}
+
+func newTestPrompter() *testPrompter {
+	return &testPrompter{prompted: make(chan Request, 4)}
+}
+
+func (p *testPrompter) Prompt(req Request) error {
+	p.prompted <- req
+	return nil
+}
+
+func (p *testPrompter) Resolved(req Request, decision Decision) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.resolved = append(p.resolved, decision)
+}
+
+type testRecorder struct {
+	mu      sync.Mutex
+	records []storage.ToolApproval
+}
+
+func (r *testRecorder) SaveToolApproval(a storage.ToolApproval) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, a)
+	return nil
+}
+
+func testConfig() config.ApprovalConfig {
+	return config.ApprovalConfig{
+		Enabled:        true,
+		Tools:          []string{"exec", "mcp_*"},
+		TimeoutSeconds: 1,
+		Users: map[string]config.ApprovalUserPolicy{
+			"7": {Require: []string{"read_file"}, Exempt: []string{"exec"}},
+		},
+	}
+}
+
+func TestRequires(t *testing.T) {
+	m := NewManager(testConfig(), nil, nil)
+
+	tests := []struct {
+		tool   string
+		userID int64
+		want   bool
+	}{
+		{"exec", 1, true},
+		{"mcp_github_create_issue", 1, true},
+		{"read_file", 1, false},
+		{"read_file", 7, true},
+		{"exec", 7, false},
+	}
+	for _, tt := range tests {
+		if got := m.Requires(tt.tool, tt.userID); got != tt.want {
+			t.Errorf("Requires(%q, %d) = %v, want %v", tt.tool, tt.userID, got, tt.want)
+		}
+	}
+
+	disabled := NewManager(config.ApprovalConfig{Tools: []string{"exec"}}, nil, nil)
+	if disabled.Requires("exec", 1) {
+		t.Error("disabled manager should not require approval")
+	}
+}
+
+// authorizeAsync runs Authorize in the background and returns the prompt it produced.
+func authorizeAsync(t *testing.T, m *Manager, p *testPrompter, req Request) (Request, <-chan string) {
+	t.Helper()
+	done := make(chan string, 1)
+	go func() {
+		ok, denied := m.Authorize(WithPrompter(context.Background(), p), req)
+		if ok {
+			done <- ""
+			return
+		}
+		done <- denied
+	}()
+
+	select {
+	case prompted := <-p.prompted:
+		return prompted, done
+	case <-time.After(2 * time.Second):
+		t.Fatal("approval was never prompted")
+	}
+	return Request{}, done
+}
+
+func TestAuthorizeApproveOnce(t *testing.T) {
+	rec := &testRecorder{}
+	m := NewManager(testConfig(), nil, rec)
+	p := newTestPrompter()
+
+	req := Request{Tool: "exec", Args: map[string]interface{}{"command": "ls"}, UserID: 1, SessionKey: "s1"}
+	prompted, done := authorizeAsync(t, m, p, req)
+
+	if pending := m.Pending(1); len(pending) != 1 || pending[0].ID != prompted.ID {
+		t.Fatalf("Pending(1) = %+v, want the prompted request", pending)
+	}
+	if err := m.Resolve(prompted.ID, ApproveOnce, "test"); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if denied := <-done; denied != "" {
+		t.Fatalf("expected approval, got denial %q", denied)
+	}
+
+	if len(rec.records) != 1 || rec.records[0].Decision != string(ApproveOnce) {
+		t.Fatalf("recorded = %+v, want one approve_once record", rec.records)
+	}
+	if err := m.Resolve(prompted.ID, Deny, "test"); err == nil {
+		t.Error("resolving a finished request should fail")
+	}
+
+	// Approve-once does not grant the next call.
+	_, done = authorizeAsync(t, m, p, req)
+	if err := m.Resolve(m.Pending(1)[0].ID, Deny, "test"); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if denied := <-done; !strings.Contains(denied, "denied by user") {
+		t.Fatalf("denial = %q, want a denied-by-user message", denied)
+	}
+}
+
+func TestAuthorizeApproveSession(t *testing.T) {
+	m := NewManager(testConfig(), nil, nil)
+	p := newTestPrompter()
+
+	req := Request{Tool: "exec", UserID: 1, SessionKey: "s1"}
+	prompted, done := authorizeAsync(t, m, p, req)
+	if err := m.Resolve(prompted.ID, ApproveSession, "test"); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	<-done
+
+	if ok, _ := m.Authorize(context.Background(), req); !ok {
+		t.Error("session grant should approve later calls without prompting")
+	}
+
+	other := req
+	other.SessionKey = "s2"
+	if ok, _ := m.Authorize(context.Background(), other); ok {
+		t.Error("session grant must not leak to another session")
+	}
+}
+
+func TestAuthorizeTimeout(t *testing.T) {
+	rec := &testRecorder{}
+	m := NewManager(testConfig(), nil, rec)
+	p := newTestPrompter()
+
+	_, done := authorizeAsync(t, m, p, Request{Tool: "exec", UserID: 1})
+	select {
+	case denied := <-done:
+		if !strings.Contains(denied, "timed out") {
+			t.Fatalf("denial = %q, want a timeout message", denied)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("approval did not time out")
+	}
+
+	if len(rec.records) != 1 || rec.records[0].Decision != string(Timeout) {
+		t.Fatalf("recorded = %+v, want one timeout record", rec.records)
+	}
+}
+
+func TestAuthorizeWithoutChannel(t *testing.T) {
+	m := NewManager(testConfig(), nil, nil)
+
+	ok, denied := m.Authorize(context.Background(), Request{Tool: "exec", Channel: "cli"})
+	if ok || !strings.Contains(denied, "no approval channel") {
+		t.Fatalf("Authorize = %v, %q; want a denial for an unpromptable channel", ok, denied)
+	}
+}
+
+func TestHandleInbound(t *testing.T) {
+	m := NewManager(testConfig(), bus.NewMessageBus(), nil)
+	p := newTestPrompter()
+
+	req := Request{Tool: "exec", UserID: 1, SenderID: "alice", Channel: "telegram", ChatID: "42"}
+	_, done := authorizeAsync(t, m, p, req)
+
+	if !m.HandleInbound(bus.InboundMessage{Channel: "telegram", ChatID: "42", SenderID: "mallory", Content: "yes"}) {
+		t.Fatal("reply from another sender should still be consumed")
+	}
+	if len(m.Pending(0)) != 1 {
+		t.Fatal("reply from another sender must not resolve the request")
+	}
+
+	if m.HandleInbound(bus.InboundMessage{Channel: "telegram", ChatID: "43", SenderID: "alice", Content: "yes"}) {
+		t.Fatal("bare reply in a chat with nothing pending should be a normal message")
+	}
+
+	if !m.HandleInbound(bus.InboundMessage{Channel: "telegram", ChatID: "42", SenderID: "alice", Content: "Approve"}) {
+		t.Fatal("approval reply was not consumed")
+	}
+	if denied := <-done; denied != "" {
+		t.Fatalf("expected approval, got %q", denied)
+	}
+}
+
+func TestParseReply(t *testing.T) {
+	tests := []struct {
+		in       string
+		id       string
+		decision Decision
+		ok       bool
+	}{
+		{"/approve abc", "abc", ApproveOnce, true},
+		{"/approve abc session", "abc", ApproveSession, true},
+		{"/deny abc", "abc", Deny, true},
+		{"approve session", "", ApproveSession, true},
+		{"no", "", Deny, true},
+		{"/approve", "", "", false},
+		{"yes please do it", "", "", false},
+	}
+	for _, tt := range tests {
+		id, decision, ok := parseReply(tt.in)
+		if id != tt.id || decision != tt.decision || ok != tt.ok {
+			t.Errorf("parseReply(%q) = %q, %q, %v; want %q, %q, %v", tt.in, id, decision, ok, tt.id, tt.decision, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Package budget attaches a cost to LLM calls and enforces per-user,
+// per-session and per-cron-job spending caps.
+package budget
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/logger"
+	"github.com/sipeed/kakoclaw/pkg/observability"
+	"github.com/sipeed/kakoclaw/pkg/storage"
+)
+
+// cronSessionPrefix is the session key prefix used for scheduled job runs.
+const cronSessionPrefix = "cron-"
+
+// Store is the persistence the manager needs; implemented by storage.Storage.
+type Store interface {
+	RecordLLMUsage(u storage.LLMUsage) error
+	SumLLMUsage(f storage.UsageFilter) (storage.UsageTotals, error)
+	GetUserBudget(userID int64) (storage.UserBudget, error)
+}
+
+// Scope identifies who an LLM call is billed to.
+type Scope struct {
+	UserID     int64
+	SessionKey string
+	Channel    string
+}
+
+// Decision is the outcome of a budget check.
+type Decision struct {
+	Allowed bool
+	Model   string // model to use; differs from the requested one when downgraded
+	Notice  string // user-facing explanation when a cap was hit
+}
+
+// Manager prices LLM calls, records them in the usage ledger and enforces caps.
+type Manager struct {
+	cfg    config.BudgetConfig
+	pricer *Pricer
+	store  Store
+	now    func() time.Time
+}
+
+// NewManager creates a budget manager. store may be nil, in which case calls
+// are priced but neither recorded nor capped.
+func NewManager(cfg config.BudgetConfig, provider string, store Store) *Manager {
+	return &Manager{
+		cfg:    cfg,
+		pricer: NewPricer(cfg.Pricing, provider),
+		store:  store,
+		now:    time.Now,
+	}
+}
+
+// Enabled reports whether caps are enforced.
+func (m *Manager) Enabled() bool {
+	return m != nil && m.cfg.Enabled && m.store != nil
+}
+
+// Cost returns the USD cost of a call.
+func (m *Manager) Cost(model string, usage observability.TokenUsage) float64 {
+	return m.pricer.Cost(model, usage)
+}
+
+// Record stores a priced LLM call in the usage ledger.
+func (m *Manager) Record(scope Scope, model string, usage observability.TokenUsage) {
+	if m == nil || m.store == nil {
+		return
+	}
+	err := m.store.RecordLLMUsage(storage.LLMUsage{
+		UserID:       scope.UserID,
+		SessionKey:   scope.SessionKey,
+		Channel:      scope.Channel,
+		Model:        model,
+		TokensIn:     usage.In,
+		TokensOut:    usage.Out,
+		TokensCached: usage.Cached,
+		CostUSD:      usage.CostUSD,
+		Estimated:    usage.Estimated,
+		CreatedAt:    m.now(),
+	})
+	if err != nil {
+		logger.WarnCF("budget", "Failed to record LLM usage", map[string]interface{}{"error": err.Error()})
+	}
+}
+
+// limitCheck is one cap to test against the ledger.
+type limitCheck struct {
+	what   string // "your account", "this session", ...
+	limits config.BudgetLimits
+	filter storage.UsageFilter
+}
+
+// Check decides whether scope may make another call to model.
+func (m *Manager) Check(scope Scope, model string) Decision {
+	if !m.Enabled() {
+		return Decision{Allowed: true, Model: model}
+	}
+
+	exceeded := m.exceeded(scope)
+	if exceeded == "" {
+		return Decision{Allowed: true, Model: model}
+	}
+
+	if m.cfg.OnExceeded == "downgrade" && m.cfg.DowngradeModel != "" {
+		if model == m.cfg.DowngradeModel {
+			return Decision{Allowed: true, Model: model}
+		}
+		logger.InfoCF("budget", "Budget reached, downgrading model", map[string]interface{}{
+			"user_id":     scope.UserID,
+			"session_key": scope.SessionKey,
+			"from":        model,
+			"to":          m.cfg.DowngradeModel,
+			"reason":      exceeded,
+		})
+		return Decision{
+			Allowed: true,
+			Model:   m.cfg.DowngradeModel,
+			Notice:  fmt.Sprintf("ℹ️ %s, so this reply uses the cheaper model %s.", exceeded, m.cfg.DowngradeModel),
+		}
+	}
+
+	logger.InfoCF("budget", "Budget reached, refusing LLM call", map[string]interface{}{
+		"user_id":     scope.UserID,
+		"session_key": scope.SessionKey,
+		"reason":      exceeded,
+	})
+	return Decision{
+		Allowed: false,
+		Model:   model,
+		Notice:  fmt.Sprintf("⚠️ %s. No further requests will be sent to the model until the budget resets or an administrator raises it.", exceeded),
+	}
+}
+
+// exceeded returns a description of the first cap scope has reached, or "".
+func (m *Manager) exceeded(scope Scope) string {
+	now := m.now()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	var checks []limitCheck
+	if scope.UserID > 0 {
+		checks = append(checks, limitCheck{
+			what:   "your account",
+			limits: m.userLimits(scope.UserID),
+			filter: storage.UsageFilter{UserID: scope.UserID},
+		})
+	}
+	if scope.SessionKey != "" {
+		what, limits := "this conversation", m.cfg.Session
+		if strings.HasPrefix(scope.SessionKey, cronSessionPrefix) {
+			what, limits = "this scheduled job", m.cfg.Cron
+		}
+		checks = append(checks, limitCheck{
+			what:   what,
+			limits: limits,
+			filter: storage.UsageFilter{SessionKey: scope.SessionKey},
+		})
+	}
+
+	for _, c := range checks {
+		periods := []struct {
+			name    string
+			since   time.Time
+			usd     float64
+			tokens  int64
+			enabled bool
+		}{
+			{"daily", dayStart, c.limits.DailyUSD, c.limits.DailyTokens, c.limits.DailyUSD > 0 || c.limits.DailyTokens > 0},
+			{"monthly", monthStart, c.limits.MonthlyUSD, c.limits.MonthlyTokens, c.limits.MonthlyUSD > 0 || c.limits.MonthlyTokens > 0},
+		}
+		for _, p := range periods {
+			if !p.enabled {
+				continue
+			}
+			f := c.filter
+			f.Since = p.since
+			totals, err := m.store.SumLLMUsage(f)
+			if err != nil {
+				// Fail open: a ledger error must not take the agent down.
+				logger.WarnCF("budget", "Failed to read usage ledger", map[string]interface{}{"error": err.Error()})
+				return ""
+			}
+			if p.usd > 0 && totals.CostUSD >= p.usd {
+				return fmt.Sprintf("The %s spending limit for %s ($%.2f) has been reached ($%.2f used)", p.name, c.what, p.usd, totals.CostUSD)
+			}
+			if p.tokens > 0 && totals.Tokens() >= p.tokens {
+				return fmt.Sprintf("The %s token limit for %s (%d tokens) has been reached (%d used)", p.name, c.what, p.tokens, totals.Tokens())
+			}
+		}
+	}
+	return ""
+}
+
+// userLimits merges a user's stored caps over the configured defaults.
+func (m *Manager) userLimits(userID int64) config.BudgetLimits {
+	limits := m.cfg.User
+	b, err := m.store.GetUserBudget(userID)
+	if err != nil {
+		return limits
+	}
+	if b.DailyUSD != nil {
+		limits.DailyUSD = *b.DailyUSD
+	}
+	if b.MonthlyUSD != nil {
+		limits.MonthlyUSD = *b.MonthlyUSD
+	}
+	if b.DailyTokens != nil {
+		limits.DailyTokens = *b.DailyTokens
+	}
+	if b.MonthlyTokens != nil {
+		limits.MonthlyTokens = *b.MonthlyTokens
+	}
+	return limits
+}
@@ -0,0 +1,163 @@
+package budget
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/observability"
+	"github.com/sipeed/kakoclaw/pkg/storage"
+)
+
+type fakeStore struct {
+	rows    []storage.LLMUsage
+	budgets map[int64]storage.UserBudget
+}
+
+func (f *fakeStore) RecordLLMUsage(u storage.LLMUsage) error {
+	f.rows = append(f.rows, u)
+	return nil
+}
+
+func (f *fakeStore) SumLLMUsage(filter storage.UsageFilter) (storage.UsageTotals, error) {
+	var t storage.UsageTotals
+	for _, u := range f.rows {
+		if filter.UserID > 0 && u.UserID != filter.UserID {
+			continue
+		}
+		if filter.SessionKey != "" && u.SessionKey != filter.SessionKey {
+			continue
+		}
+		if !filter.Since.IsZero() && u.CreatedAt.Before(filter.Since) {
+			continue
+		}
+		t.Calls++
+		t.TokensIn += int64(u.TokensIn)
+		t.TokensOut += int64(u.TokensOut)
+		t.CostUSD += u.CostUSD
+	}
+	return t, nil
+}
+
+func (f *fakeStore) GetUserBudget(userID int64) (storage.UserBudget, error) {
+	return f.budgets[userID], nil
+}
+
+func testPricing() map[string]config.ModelPricing {
+	return map[string]config.ModelPricing{
+		"gpt-4o":             {Input: 2.5, Output: 10, CachedRead: 1.25},
+		"anthropic/claude-*": {Input: 3, Output: 15},
+		"claude-3-haiku*":    {Input: 0.25, Output: 1.25},
+	}
+}
+
+func TestPricerLookup(t *testing.T) {
+	p := NewPricer(testPricing(), "anthropic")
+
+	tests := []struct {
+		model string
+		input float64
+		ok    bool
+	}{
+		{"gpt-4o", 2.5, true},
+		{"openai/gpt-4o", 2.5, true},
+		{"claude-sonnet-4", 3, true},
+		{"claude-3-haiku-20240307", 0.25, true},
+		{"unknown-model", 0, false},
+	}
+	for _, tt := range tests {
+		price, ok := p.Lookup(tt.model)
+		if ok != tt.ok || price.Input != tt.input {
+			t.Errorf("Lookup(%q) = %+v, %v; want input %v, %v", tt.model, price, ok, tt.input, tt.ok)
+		}
+	}
+}
+
+func TestPricerCost(t *testing.T) {
+	p := NewPricer(testPricing(), "")
+
+	// 1M prompt tokens of which 400k were cache reads, plus 100k output.
+	got := p.Cost("gpt-4o", observability.TokenUsage{In: 1_000_000, Cached: 400_000, Out: 100_000})
+	want := 0.6*2.5 + 0.4*1.25 + 0.1*10
+	if diff := got - want; diff > 1e-9 || diff < -1e-9 {
+		t.Errorf("Cost = %v, want %v", got, want)
+	}
+
+	if got := p.Cost("unknown-model", observability.TokenUsage{In: 1000, Out: 1000}); got != 0 {
+		t.Errorf("unpriced model cost = %v, want 0", got)
+	}
+}
+
+func newTestManager(cfg config.BudgetConfig, store *fakeStore) *Manager {
+	cfg.Enabled = true
+	cfg.Pricing = testPricing()
+	m := NewManager(cfg, "", store)
+	m.now = func() time.Time { return time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC) }
+	return m
+}
+
+func TestCheckRefusesOverUserBudget(t *testing.T) {
+	store := &fakeStore{}
+	m := newTestManager(config.BudgetConfig{User: config.BudgetLimits{DailyUSD: 1}}, store)
+	scope := Scope{UserID: 1, SessionKey: "web:1"}
+
+	if d := m.Check(scope, "gpt-4o"); !d.Allowed || d.Notice != "" {
+		t.Fatalf("fresh user should be allowed, got %+v", d)
+	}
+
+	m.Record(scope, "gpt-4o", observability.TokenUsage{In: 1000, Out: 500, CostUSD: 1.2})
+	d := m.Check(scope, "gpt-4o")
+	if d.Allowed || !strings.Contains(d.Notice, "daily spending limit") {
+		t.Fatalf("expected a daily-limit refusal, got %+v", d)
+	}
+
+	if d := m.Check(Scope{UserID: 2}, "gpt-4o"); !d.Allowed {
+		t.Fatalf("another user must not be affected, got %+v", d)
+	}
+
+	// A per-user override of zero lifts the cap.
+	unlimited := 0.0
+	store.budgets = map[int64]storage.UserBudget{1: {DailyUSD: &unlimited}}
+	if d := m.Check(scope, "gpt-4o"); !d.Allowed {
+		t.Fatalf("per-user override should lift the cap, got %+v", d)
+	}
+}
+
+func TestCheckDowngradesCronJobs(t *testing.T) {
+	store := &fakeStore{}
+	m := newTestManager(config.BudgetConfig{
+		Cron:           config.BudgetLimits{MonthlyTokens: 1000},
+		OnExceeded:     "downgrade",
+		DowngradeModel: "gpt-4o-mini",
+	}, store)
+	scope := Scope{SessionKey: "cron-nightly"}
+
+	m.Record(scope, "gpt-4o", observability.TokenUsage{In: 900, Out: 200})
+	d := m.Check(scope, "gpt-4o")
+	if !d.Allowed || d.Model != "gpt-4o-mini" || !strings.Contains(d.Notice, "scheduled job") {
+		t.Fatalf("expected a downgrade for the cron job, got %+v", d)
+	}
+
+	if d := m.Check(scope, "gpt-4o-mini"); !d.Allowed || d.Notice != "" {
+		t.Fatalf("already on the downgrade model should pass silently, got %+v", d)
+	}
+
+	// Interactive sessions have no cap configured.
+	if d := m.Check(Scope{SessionKey: "web:1"}, "gpt-4o"); d.Model != "gpt-4o" || d.Notice != "" {
+		t.Fatalf("uncapped session should keep its model, got %+v", d)
+	}
+}
+
+func TestDisabledManagerAllowsEverything(t *testing.T) {
+	store := &fakeStore{}
+	m := NewManager(config.BudgetConfig{User: config.BudgetLimits{DailyTokens: 1}}, "", store)
+	m.Record(Scope{UserID: 1}, "gpt-4o", observability.TokenUsage{In: 100})
+
+	if d := m.Check(Scope{UserID: 1}, "gpt-4o"); !d.Allowed {
+		t.Fatalf("disabled manager refused a call: %+v", d)
+	}
+	if len(store.rows) != 1 {
+		t.Fatalf("usage should be recorded even when caps are disabled, got %d rows", len(store.rows))
+	}
+}
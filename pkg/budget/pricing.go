@@ -0,0 +1,112 @@
+package budget
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/observability"
+)
+
+// Pricer looks up model prices and computes the cost of LLM calls.
+type Pricer struct {
+	provider string
+	exact    map[string]config.ModelPricing
+	globs    []string // glob keys, most specific first
+	table    map[string]config.ModelPricing
+}
+
+// NewPricer builds a pricer from the configured pricing table. provider is
+// the default provider name, used to match "provider/model" keys when the
+// model is configured without a prefix.
+func NewPricer(pricing map[string]config.ModelPricing, provider string) *Pricer {
+	p := &Pricer{
+		provider: strings.ToLower(provider),
+		exact:    make(map[string]config.ModelPricing),
+		table:    make(map[string]config.ModelPricing),
+	}
+	for key, price := range pricing {
+		key = strings.ToLower(key)
+		p.table[key] = price
+		if strings.ContainsAny(key, "*?[") {
+			p.globs = append(p.globs, key)
+		} else {
+			p.exact[key] = price
+		}
+	}
+	sort.Slice(p.globs, func(i, j int) bool {
+		si, sj := globSpecificity(p.globs[i]), globSpecificity(p.globs[j])
+		if si != sj {
+			return si > sj
+		}
+		return len(p.globs[i]) > len(p.globs[j])
+	})
+	return p
+}
+
+// globSpecificity ranks a glob key by the length of its model part, so
+// "claude-3-haiku*" beats "anthropic/claude-*" for a Haiku model.
+func globSpecificity(glob string) int {
+	if idx := strings.Index(glob, "/"); idx >= 0 {
+		glob = glob[idx+1:]
+	}
+	return len(glob)
+}
+
+// candidates returns the names a model may be priced under, most specific first.
+func (p *Pricer) candidates(model string) []string {
+	model = strings.ToLower(model)
+	names := []string{model}
+	if idx := strings.Index(model, "/"); idx > 0 {
+		names = append(names, model[idx+1:])
+	} else if p.provider != "" {
+		names = append([]string{p.provider + "/" + model}, names...)
+	}
+	return names
+}
+
+// Lookup returns the price of a model.
+func (p *Pricer) Lookup(model string) (config.ModelPricing, bool) {
+	names := p.candidates(model)
+	for _, name := range names {
+		if price, ok := p.exact[name]; ok {
+			return price, true
+		}
+	}
+	for _, glob := range p.globs {
+		for _, name := range names {
+			if ok, _ := path.Match(glob, name); ok {
+				return p.table[glob], true
+			}
+		}
+	}
+	return config.ModelPricing{}, false
+}
+
+// Cost returns the USD cost of a call. Unpriced models cost nothing.
+func (p *Pricer) Cost(model string, usage observability.TokenUsage) float64 {
+	price, ok := p.Lookup(model)
+	if !ok {
+		return 0
+	}
+
+	cachedRead := price.CachedRead
+	if cachedRead == 0 {
+		cachedRead = price.Input
+	}
+	cacheWrite := price.CacheWrite
+	if cacheWrite == 0 {
+		cacheWrite = price.Input
+	}
+
+	uncached := usage.In - usage.Cached - usage.CacheWrite
+	if uncached < 0 {
+		uncached = 0
+	}
+	cost := float64(uncached)*price.Input +
+		float64(usage.Cached)*cachedRead +
+		float64(usage.CacheWrite)*cacheWrite +
+		float64(usage.Out)*price.Output
+	return cost / 1_000_000
+}
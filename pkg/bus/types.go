@@ -12,10 +12,27 @@ type InboundMessage struct {
 }
 
 type OutboundMessage struct {
-	UserID  int64  `json:"user_id"` // User ID for routing
-	Channel string `json:"channel"`
-	ChatID  string `json:"chat_id"`
-	Content string `json:"content"`
+	UserID   int64           `json:"user_id"` // User ID for routing
+	Channel  string          `json:"channel"`
+	ChatID   string          `json:"chat_id"`
+	Content  string          `json:"content"`
+	Approval *ApprovalPrompt `json:"approval,omitempty"` // Set when the message asks the user to approve a tool call
+}
+
+// ApprovalPrompt marks an outbound message as a tool approval request.
+// Channels that support buttons render one per option; the others just send
+// Content, which always includes plain-text reply instructions.
+type ApprovalPrompt struct {
+	ID      string           `json:"id"`
+	Tool    string           `json:"tool"`
+	Options []ApprovalOption `json:"options"`
+}
+
+// ApprovalOption is a single choice on an approval prompt. Command is the
+// text the channel should submit as an inbound message when it is chosen.
+type ApprovalOption struct {
+	Label   string `json:"label"`
+	Command string `json:"command"`
 }
 
 type MessageHandler func(InboundMessage) error
@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"github.com/sipeed/kakoclaw/pkg/bus"
+	"github.com/sipeed/kakoclaw/pkg/logger"
+	"github.com/sipeed/kakoclaw/pkg/utils"
 )
 
 type Channel interface {
@@ -116,7 +118,7 @@ func (c *BaseChannel) HandleMessage(senderID, chatID, content string, media []st
 		SenderID:   senderID,
 		ChatID:     chatID,
 		Content:    content,
-		Media:      media,
+		Media:      inlineLocalImages(c.name, media),
 		SessionKey: sessionKey,
 		Metadata:   metadata,
 	}
@@ -125,6 +127,36 @@ func (c *BaseChannel) HandleMessage(senderID, chatID, content string, media []st
 	return nil
 }
 
+// maxInlineImageBytes caps images embedded in inbound messages; it matches
+// the largest image the LLM providers accept.
+const maxInlineImageBytes = 5 << 20
+
+// inlineLocalImages replaces downloaded image files with data URLs. Channels
+// delete their temp files as soon as HandleMessage returns, which is before
+// the agent picks the message up from the bus.
+func inlineLocalImages(channel string, media []string) []string {
+	if len(media) == 0 {
+		return media
+	}
+	out := make([]string, len(media))
+	for i, ref := range media {
+		out[i] = ref
+		if strings.Contains(ref, "://") || strings.HasPrefix(ref, "data:") || !utils.IsImageFile(ref) {
+			continue
+		}
+		dataURL, err := utils.ImageDataURL(ref, maxInlineImageBytes)
+		if err != nil {
+			logger.WarnCF(channel, "Failed to attach image", map[string]interface{}{
+				"file":  ref,
+				"error": err.Error(),
+			})
+			continue
+		}
+		out[i] = dataURL
+	}
+	return out
+}
+
 func (c *BaseChannel) setRunning(running bool) {
 	c.running = running
 }
@@ -1,6 +1,10 @@
 package channels
 
 import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -89,3 +93,23 @@ func TestBaseChannel_IsAllowed(t *testing.T) {
 		})
 	}
 }
+
+func TestInlineLocalImages(t *testing.T) {
+	dir := t.TempDir()
+	png := filepath.Join(dir, "photo.jpg")
+	// 1x1 PNG; content sniffing decides the media type, not the extension.
+	data, _ := base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mNkYAAAAAYAAjCB0C8AAAAASUVORK5CYII=")
+	if err := os.WriteFile(png, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	voice := filepath.Join(dir, "voice.ogg")
+	url := "https://cdn.example.com/cat.png"
+
+	got := inlineLocalImages("test", []string{png, voice, url})
+	if !strings.HasPrefix(got[0], "data:image/png;base64,") {
+		t.Errorf("local image = %q, want a data URL", got[0])
+	}
+	if got[1] != voice || got[2] != url {
+		t.Errorf("non-image and remote media should be left alone, got %v", got[1:])
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -60,6 +61,7 @@ func (c *DiscordChannel) Start(ctx context.Context) error {
 
 	c.ctx = ctx
 	c.session.AddHandler(c.handleMessage)
+	c.session.AddHandler(c.handleInteraction)
 
 	if err := c.session.Open(); err != nil {
 		return fmt.Errorf("failed to open discord session: %w", err)
@@ -108,7 +110,15 @@ func (c *DiscordChannel) Send(ctx context.Context, msg bus.OutboundMessage) erro
 
 	done := make(chan error, 1)
 	go func() {
-		_, err := c.session.ChannelMessageSend(channelID, message)
+		var err error
+		if msg.Approval != nil {
+			_, err = c.session.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+				Content:    message,
+				Components: approvalComponents(msg.Approval),
+			})
+		} else {
+			_, err = c.session.ChannelMessageSend(channelID, message)
+		}
 		done <- err
 	}()
 
@@ -123,6 +133,72 @@ func (c *DiscordChannel) Send(ctx context.Context, msg bus.OutboundMessage) erro
 	}
 }
 
+// approvalComponents builds one button per approval option. The button's
+// custom ID is the command it stands for.
+func approvalComponents(prompt *bus.ApprovalPrompt) []discordgo.MessageComponent {
+	buttons := make([]discordgo.MessageComponent, 0, len(prompt.Options))
+	for i, opt := range prompt.Options {
+		style := discordgo.SecondaryButton
+		if strings.HasPrefix(opt.Command, "/deny") {
+			style = discordgo.DangerButton
+		} else if i == 0 {
+			style = discordgo.SuccessButton
+		}
+		buttons = append(buttons, discordgo.Button{
+			Label:    opt.Label,
+			Style:    style,
+			CustomID: opt.Command,
+		})
+	}
+	return []discordgo.MessageComponent{discordgo.ActionsRow{Components: buttons}}
+}
+
+// handleInteraction turns an approval button press into an inbound
+// "/approve" or "/deny" command from the user who pressed it.
+func (c *DiscordChannel) handleInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i == nil || i.Type != discordgo.InteractionMessageComponent {
+		return
+	}
+
+	command := i.MessageComponentData().CustomID
+	if !strings.HasPrefix(command, "/approve") && !strings.HasPrefix(command, "/deny") {
+		return
+	}
+
+	user := i.User
+	if i.Member != nil && i.Member.User != nil {
+		user = i.Member.User
+	}
+	if user == nil || !c.IsAllowed(user.ID) {
+		return
+	}
+
+	// Acknowledge the press and drop the buttons so the prompt cannot be answered twice.
+	content := ""
+	if i.Message != nil {
+		content = i.Message.Content
+	}
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    appendContent(content, fmt.Sprintf("_%s by %s_", command, user.Username)),
+			Components: []discordgo.MessageComponent{},
+		},
+	}); err != nil {
+		logger.WarnCF("discord", "Failed to acknowledge interaction", map[string]any{"error": err.Error()})
+	}
+
+	metadata := map[string]string{
+		"user_id":    user.ID,
+		"username":   user.Username,
+		"guild_id":   i.GuildID,
+		"channel_id": i.ChannelID,
+		"is_action":  "true",
+	}
+
+	_ = c.HandleMessage(user.ID, i.ChannelID, command, nil, metadata)
+}
+
 // appendContent 安全地追加内容到现有文本
 func appendContent(content, suffix string) string {
 	if content == "" {
@@ -125,6 +125,15 @@ func (c *SlackChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 		opts = append(opts, slack.MsgOptionTS(threadTS))
 	}
 
+	// Approval prompts carry buttons and do not complete the pending ack.
+	if msg.Approval != nil {
+		opts = append(opts, slack.MsgOptionBlocks(approvalBlocks(msg)...))
+		if _, _, err := c.api.PostMessageContext(ctx, channelID, opts...); err != nil {
+			return fmt.Errorf("failed to send slack approval prompt: %w", err)
+		}
+		return nil
+	}
+
 	_, _, err := c.api.PostMessageContext(ctx, channelID, opts...)
 	if err != nil {
 		return fmt.Errorf("failed to send slack message: %w", err)
@@ -161,9 +170,7 @@ func (c *SlackChannel) eventLoop() {
 			case socketmode.EventTypeSlashCommand:
 				c.handleSlashCommand(event)
 			case socketmode.EventTypeInteractive:
-				if event.Request != nil {
-					c.socketClient.Ack(*event.Request)
-				}
+				c.handleInteractive(event)
 			}
 		}
 	}
@@ -353,6 +360,11 @@ func (c *SlackChannel) handleSlashCommand(event socketmode.Event) {
 	if strings.TrimSpace(content) == "" {
 		content = "help"
 	}
+	// Slack strips the command itself; keep it so that the agent can steer
+	// the running turn with it.
+	if cmd.Command == "/steer" {
+		content = "/steer " + content
+	}
 
 	metadata := map[string]string{
 		"channel_id": channelID,
@@ -370,6 +382,78 @@ func (c *SlackChannel) handleSlashCommand(event socketmode.Event) {
 	_ = c.HandleMessage(senderID, chatID, content, nil, metadata)
 }
 
+// slackApprovalBlockPrefix marks the action block of an approval prompt. The
+// rest of the block ID is the chat ID the prompt was sent to, so a button press
+// can be routed back to the same conversation.
+const slackApprovalBlockPrefix = "kakoclaw_approval:"
+
+// approvalBlocks builds the Block Kit layout for a tool approval prompt.
+func approvalBlocks(msg bus.OutboundMessage) []slack.Block {
+	buttons := make([]slack.BlockElement, 0, len(msg.Approval.Options))
+	for i, opt := range msg.Approval.Options {
+		button := slack.NewButtonBlockElement(
+			fmt.Sprintf("approval_%d", i),
+			opt.Command,
+			slack.NewTextBlockObject(slack.PlainTextType, opt.Label, false, false),
+		)
+		if strings.HasPrefix(opt.Command, "/deny") {
+			button.Style = slack.StyleDanger
+		} else if i == 0 {
+			button.Style = slack.StylePrimary
+		}
+		buttons = append(buttons, button)
+	}
+
+	return []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, msg.Content, false, false), nil, nil),
+		slack.NewActionBlock(slackApprovalBlockPrefix+msg.ChatID, buttons...),
+	}
+}
+
+// handleInteractive handles button presses on approval prompts.
+func (c *SlackChannel) handleInteractive(event socketmode.Event) {
+	if event.Request != nil {
+		c.socketClient.Ack(*event.Request)
+	}
+
+	callback, ok := event.Data.(slack.InteractionCallback)
+	if !ok || callback.Type != slack.InteractionTypeBlockActions {
+		return
+	}
+
+	for _, action := range callback.ActionCallback.BlockActions {
+		if !strings.HasPrefix(action.BlockID, slackApprovalBlockPrefix) {
+			continue
+		}
+		if !strings.HasPrefix(action.Value, "/approve") && !strings.HasPrefix(action.Value, "/deny") {
+			continue
+		}
+
+		senderID := callback.User.ID
+		if !c.IsAllowed(senderID) {
+			return
+		}
+		chatID := strings.TrimPrefix(action.BlockID, slackApprovalBlockPrefix)
+
+		// Replace the buttons with the choice so the prompt cannot be answered twice.
+		choice := fmt.Sprintf("%s\n_%s by <@%s>_", callback.Message.Text, action.Text.Text, senderID)
+		if _, _, _, err := c.api.UpdateMessage(callback.Channel.ID, callback.Message.Timestamp,
+			slack.MsgOptionText(choice, false),
+			slack.MsgOptionBlocks(slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, choice, false, false), nil, nil)),
+		); err != nil {
+			logger.WarnCF("slack", "Failed to update approval prompt", map[string]interface{}{"error": err.Error()})
+		}
+
+		metadata := map[string]string{
+			"channel_id": callback.Channel.ID,
+			"platform":   "slack",
+			"is_action":  "true",
+		}
+		_ = c.HandleMessage(senderID, chatID, action.Value, nil, metadata)
+		return
+	}
+}
+
 func (c *SlackChannel) downloadSlackFile(file slack.File) string {
 	downloadURL := file.URLPrivateDownload
 	if downloadURL == "" {
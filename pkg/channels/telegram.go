@@ -116,6 +116,9 @@ func (c *TelegramChannel) Start(ctx context.Context) error {
 				if update.Message != nil {
 					c.handleMessage(ctx, update)
 				}
+				if update.CallbackQuery != nil {
+					c.handleCallbackQuery(ctx, update.CallbackQuery)
+				}
 			}
 		}
 	}()
@@ -141,6 +144,12 @@ func (c *TelegramChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 		return fmt.Errorf("invalid chat ID: %w", err)
 	}
 
+	// Approval prompts get their own message with buttons and leave the
+	// "Thinking..." placeholder for the final reply.
+	if msg.Approval != nil {
+		return c.sendApprovalPrompt(ctx, chatID, msg)
+	}
+
 	// Stop thinking animation
 	c.cleanupThinking(msg.ChatID)
 
@@ -173,6 +182,53 @@ func (c *TelegramChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 	return nil
 }
 
+// sendApprovalPrompt sends a tool approval request with one inline button per option.
+func (c *TelegramChannel) sendApprovalPrompt(ctx context.Context, chatID int64, msg bus.OutboundMessage) error {
+	buttons := make([]telego.InlineKeyboardButton, 0, len(msg.Approval.Options))
+	for _, opt := range msg.Approval.Options {
+		buttons = append(buttons, tu.InlineKeyboardButton(opt.Label).WithCallbackData(opt.Command))
+	}
+
+	tgMsg := tu.Message(tu.ID(chatID), msg.Content).WithReplyMarkup(tu.InlineKeyboard(buttons))
+	_, err := c.bot.SendMessage(ctx, tgMsg)
+	return err
+}
+
+// handleCallbackQuery turns an approval button press into an inbound
+// "/approve" or "/deny" command from the user who pressed it.
+func (c *TelegramChannel) handleCallbackQuery(ctx context.Context, query *telego.CallbackQuery) {
+	_ = c.bot.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID))
+
+	data := query.Data
+	if !strings.HasPrefix(data, "/approve") && !strings.HasPrefix(data, "/deny") {
+		return
+	}
+
+	msg := query.Message.Message()
+	if msg == nil {
+		return
+	}
+
+	senderID := fmt.Sprintf("%d", query.From.ID)
+	if query.From.Username != "" {
+		senderID = fmt.Sprintf("%d|%s", query.From.ID, query.From.Username)
+	}
+	if !c.IsAllowed(senderID) {
+		return
+	}
+
+	// Remove the buttons so the prompt cannot be answered twice.
+	_, _ = c.bot.EditMessageReplyMarkup(ctx, &telego.EditMessageReplyMarkupParams{
+		ChatID:    tu.ID(msg.Chat.ID),
+		MessageID: msg.MessageID,
+	})
+
+	c.HandleMessage(senderID, fmt.Sprintf("%d", msg.Chat.ID), data, nil, map[string]string{
+		"user_id":  fmt.Sprintf("%d", query.From.ID),
+		"username": query.From.Username,
+	})
+}
+
 func (c *TelegramChannel) handleMessage(ctx context.Context, update telego.Update) {
 	message := update.Message
 	if message == nil {
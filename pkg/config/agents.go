@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DefaultAgentName names the agent built from agents.defaults. Routes may
+// point at it to send a chat back to the default agent.
+const DefaultAgentName = "default"
+
+// AgentProfile is a named agent with its own persona, model, workspace and
+// tool set. Unset fields inherit from agents.defaults.
+type AgentProfile struct {
+	Name              string   `json:"name"`
+	Description       string   `json:"description,omitempty"`
+	SystemPrompt      string   `json:"system_prompt,omitempty"` // Added to the system prompt after the identity section
+	Workspace         string   `json:"workspace,omitempty"`     // Bootstrap files (AGENTS.md, SOUL.md, ...), memory and skills
+	Model             string   `json:"model,omitempty"`
+	MaxToolIterations int      `json:"max_tool_iterations,omitempty"`
+	Tools             []string `json:"tools,omitempty"`  // Tool names or globs such as "mcp_github_*"; empty allows all
+	Skills            []string `json:"skills,omitempty"` // Skill names; empty allows all
+}
+
+// WorkspacePath returns the profile workspace with ~ expanded, or "" when
+// the profile uses the default workspace.
+func (p AgentProfile) WorkspacePath() string {
+	return expandHome(p.Workspace)
+}
+
+// AgentRoute sends matching inbound messages to an agent. Every field that
+// is set must match; ChatID and Sender accept path.Match globs.
+type AgentRoute struct {
+	Agent   string `json:"agent"`
+	Channel string `json:"channel,omitempty"`
+	ChatID  string `json:"chat_id,omitempty"`
+	Sender  string `json:"sender,omitempty"`
+	UserID  int64  `json:"user_id,omitempty"`
+}
+
+func (r AgentRoute) matches(channel, chatID, senderID string, userID int64) bool {
+	if r.Channel != "" && r.Channel != channel {
+		return false
+	}
+	if r.ChatID != "" && !matchGlob(r.ChatID, chatID) {
+		return false
+	}
+	if r.Sender != "" && !matchGlob(r.Sender, senderID) {
+		return false
+	}
+	if r.UserID != 0 && r.UserID != userID {
+		return false
+	}
+	return true
+}
+
+func matchGlob(pattern, value string) bool {
+	if pattern == value {
+		return true
+	}
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
+
+// Profile returns the agent profile called name.
+func (a AgentsConfig) Profile(name string) (AgentProfile, bool) {
+	for _, p := range a.List {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return AgentProfile{}, false
+}
+
+// Route returns the agent for an inbound message: the agent of the first
+// matching route, or DefaultAgentName when none matches.
+func (a AgentsConfig) Route(channel, chatID, senderID string, userID int64) string {
+	for _, r := range a.Routes {
+		if r.matches(channel, chatID, senderID, userID) {
+			return r.Agent
+		}
+	}
+	return DefaultAgentName
+}
+
+// Validate checks that agent names are unique and that every route points
+// at a known agent.
+func (a AgentsConfig) Validate() error {
+	names := map[string]bool{DefaultAgentName: true}
+	for i, p := range a.List {
+		name := strings.TrimSpace(p.Name)
+		if name == "" {
+			return fmt.Errorf("agent %d has no name", i)
+		}
+		if names[name] {
+			return fmt.Errorf("agent name %q is used twice or is reserved", name)
+		}
+		names[name] = true
+	}
+	for i, r := range a.Routes {
+		if !names[r.Agent] {
+			return fmt.Errorf("route %d points at unknown agent %q", i, r.Agent)
+		}
+		for _, glob := range []string{r.ChatID, r.Sender} {
+			if _, err := path.Match(glob, ""); err != nil {
+				return fmt.Errorf("route %d: bad pattern %q", i, glob)
+			}
+		}
+	}
+	return nil
+}
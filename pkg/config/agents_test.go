@@ -0,0 +1,52 @@
+package config
+
+import "testing"
+
+func TestAgentsRoute(t *testing.T) {
+	agents := AgentsConfig{
+		List: []AgentProfile{{Name: "coder"}, {Name: "support"}},
+		Routes: []AgentRoute{
+			{Agent: "coder", Channel: "telegram", ChatID: "-100*"},
+			{Agent: "support", Channel: "telegram"},
+			{Agent: "coder", UserID: 7},
+		},
+	}
+	if err := agents.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	tests := []struct {
+		channel, chatID string
+		userID          int64
+		want            string
+	}{
+		{"telegram", "-100123", 0, "coder"},
+		{"telegram", "42", 0, "support"},
+		{"web", "chat", 7, "coder"},
+		{"web", "chat", 1, DefaultAgentName},
+	}
+	for _, tt := range tests {
+		if got := agents.Route(tt.channel, tt.chatID, "sender", tt.userID); got != tt.want {
+			t.Errorf("Route(%s, %s, %d) = %q, want %q", tt.channel, tt.chatID, tt.userID, got, tt.want)
+		}
+	}
+
+	if p, ok := agents.Profile("support"); !ok || p.Name != "support" {
+		t.Errorf("Profile(support) = %+v, %v", p, ok)
+	}
+}
+
+func TestAgentsValidate(t *testing.T) {
+	bad := []AgentsConfig{
+		{List: []AgentProfile{{Name: "a"}, {Name: "a"}}},
+		{List: []AgentProfile{{Name: DefaultAgentName}}},
+		{List: []AgentProfile{{Name: ""}}},
+		{Routes: []AgentRoute{{Agent: "missing"}}},
+		{Routes: []AgentRoute{{Agent: DefaultAgentName, ChatID: "["}}},
+	}
+	for i, agents := range bad {
+		if agents.Validate() == nil {
+			t.Errorf("case %d: expected a validation error", i)
+		}
+	}
+}
@@ -53,6 +53,7 @@ type Config struct {
 	Web       WebConfig       `json:"web"`
 	Tools     ToolsConfig     `json:"tools"`
 	Storage   StorageConfig   `json:"storage"`
+	Budget    BudgetConfig    `json:"budget"`
 	mu        sync.RWMutex
 }
 
@@ -61,17 +62,41 @@ type StorageConfig struct {
 }
 
 type AgentsConfig struct {
-	Defaults AgentDefaults `json:"defaults"`
+	Defaults   AgentDefaults       `json:"defaults"`
+	Generation GenerationOverrides `json:"generation"`
+	Subagents  SubagentsConfig     `json:"subagents"`
+	List       []AgentProfile      `json:"list,omitempty"`   // Named agents besides the default one
+	Routes     []AgentRoute        `json:"routes,omitempty"` // First match picks the agent for a message
+}
+
+// SubagentsConfig controls background subagents started by the spawn tool.
+// Tools lists the parent tools a subagent may use (exact names or globs such
+// as "mcp_github_*"); when empty, subagents get every parent tool.
+type SubagentsConfig struct {
+	MaxIterations int      `json:"max_iterations" env:"KAKOCLAW_AGENTS_SUBAGENTS_MAX_ITERATIONS"`
+	MaxDepth      int      `json:"max_depth" env:"KAKOCLAW_AGENTS_SUBAGENTS_MAX_DEPTH"` // 1 means subagents cannot spawn subagents
+	Tools         []string `json:"tools,omitempty"`
 }
 
 type AgentDefaults struct {
-	Workspace           string  `json:"workspace" env:"KAKOCLAW_AGENTS_DEFAULTS_WORKSPACE"`
-	RestrictToWorkspace bool    `json:"restrict_to_workspace" env:"KAKOCLAW_AGENTS_DEFAULTS_RESTRICT_TO_WORKSPACE"`
-	Provider            string  `json:"provider" env:"KAKOCLAW_AGENTS_DEFAULTS_PROVIDER"`
-	Model               string  `json:"model" env:"KAKOCLAW_AGENTS_DEFAULTS_MODEL"`
-	MaxTokens           int     `json:"max_tokens" env:"KAKOCLAW_AGENTS_DEFAULTS_MAX_TOKENS"`
-	Temperature         float64 `json:"temperature" env:"KAKOCLAW_AGENTS_DEFAULTS_TEMPERATURE"`
-	MaxToolIterations   int     `json:"max_tool_iterations" env:"KAKOCLAW_AGENTS_DEFAULTS_MAX_TOOL_ITERATIONS"`
+	Workspace           string   `json:"workspace" env:"KAKOCLAW_AGENTS_DEFAULTS_WORKSPACE"`
+	RestrictToWorkspace bool     `json:"restrict_to_workspace" env:"KAKOCLAW_AGENTS_DEFAULTS_RESTRICT_TO_WORKSPACE"`
+	Provider            string   `json:"provider" env:"KAKOCLAW_AGENTS_DEFAULTS_PROVIDER"`
+	Model               string   `json:"model" env:"KAKOCLAW_AGENTS_DEFAULTS_MODEL"`
+	MaxTokens           int      `json:"max_tokens" env:"KAKOCLAW_AGENTS_DEFAULTS_MAX_TOKENS"`
+	Temperature         float64  `json:"temperature" env:"KAKOCLAW_AGENTS_DEFAULTS_TEMPERATURE"`
+	MaxToolIterations   int      `json:"max_tool_iterations" env:"KAKOCLAW_AGENTS_DEFAULTS_MAX_TOOL_ITERATIONS"`
+	MaxConcurrentRuns   int      `json:"max_concurrent_runs" env:"KAKOCLAW_AGENTS_DEFAULTS_MAX_CONCURRENT_RUNS"`
+	StructuredRetries   int      `json:"structured_output_retries" env:"KAKOCLAW_AGENTS_DEFAULTS_STRUCTURED_OUTPUT_RETRIES"`
+	Vision              string   `json:"vision" env:"KAKOCLAW_AGENTS_DEFAULTS_VISION"`             // "auto" (guess from model name), "on" or "off"
+	PromptCache         string   `json:"prompt_cache" env:"KAKOCLAW_AGENTS_DEFAULTS_PROMPT_CACHE"` // "on" (cache breakpoints for Claude) or "off"
+	SteerMode           string   `json:"steer_mode" env:"KAKOCLAW_AGENTS_DEFAULTS_STEER_MODE"`     // "queue" (only /steer messages join a running turn) or "steer" (all do)
+	TopP                float64  `json:"top_p,omitempty" env:"KAKOCLAW_AGENTS_DEFAULTS_TOP_P"`
+	Stop                []string `json:"stop,omitempty"`
+	ReasoningEffort     string   `json:"reasoning_effort,omitempty" env:"KAKOCLAW_AGENTS_DEFAULTS_REASONING_EFFORT"`
+	Seed                *int64   `json:"seed,omitempty"`
+	ThinkingBudget      int      `json:"thinking_budget,omitempty" env:"KAKOCLAW_AGENTS_DEFAULTS_THINKING_BUDGET"`     // Claude extended thinking tokens; 0 disables
+	PersistReasoning    bool     `json:"persist_reasoning,omitempty" env:"KAKOCLAW_AGENTS_DEFAULTS_PERSIST_REASONING"` // Keep reasoning text in session history
 }
 
 type ChannelsConfig struct {
@@ -159,6 +184,25 @@ type ProvidersConfig struct {
 	Nvidia     ProviderConfig `json:"nvidia"`
 	Moonshot   ProviderConfig `json:"moonshot"`
 	Ollama     ProviderConfig `json:"ollama"`
+	Failover   FailoverConfig `json:"failover"`
+}
+
+// FailoverConfig controls retries and the ordered list of backends tried when
+// the default provider fails.
+type FailoverConfig struct {
+	Fallbacks              []FallbackConfig `json:"fallbacks"`
+	MaxRetries             int              `json:"max_retries" env:"KAKOCLAW_PROVIDERS_FAILOVER_MAX_RETRIES"`
+	InitialBackoffMs       int              `json:"initial_backoff_ms" env:"KAKOCLAW_PROVIDERS_FAILOVER_INITIAL_BACKOFF_MS"`
+	MaxBackoffMs           int              `json:"max_backoff_ms" env:"KAKOCLAW_PROVIDERS_FAILOVER_MAX_BACKOFF_MS"`
+	BreakerThreshold       int              `json:"breaker_threshold" env:"KAKOCLAW_PROVIDERS_FAILOVER_BREAKER_THRESHOLD"`
+	BreakerCooldownSeconds int              `json:"breaker_cooldown_seconds" env:"KAKOCLAW_PROVIDERS_FAILOVER_BREAKER_COOLDOWN_SECONDS"`
+}
+
+// FallbackConfig is one backup backend. Provider is a provider name as used
+// in agents.defaults.provider; Model is the model to request from it.
+type FallbackConfig struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
 }
 
 type ProviderConfig struct {
@@ -167,6 +211,9 @@ type ProviderConfig struct {
 	Proxy      string   `json:"proxy,omitempty"`
 	AuthMethod string   `json:"auth_method,omitempty"`
 	Models     []string `json:"models,omitempty"`
+	// ContextWindows overrides the built-in context window sizes (in tokens)
+	// per model name or glob, e.g. {"llama3.1:*": 16384}.
+	ContextWindows map[string]int `json:"context_windows,omitempty"`
 }
 
 type GatewayConfig struct {
@@ -192,10 +239,133 @@ type WebToolsConfig struct {
 	Search WebSearchConfig `json:"search"`
 }
 
+// ExecToolsConfig configures the exec tool.
+type ExecToolsConfig struct {
+	Sandbox SandboxConfig `json:"sandbox"`
+}
+
+// ProcessToolsConfig configures background processes started by the process
+// tool. They run with the exec tool's guard and sandbox.
+type ProcessToolsConfig struct {
+	MaxProcesses      int `json:"max_processes" env:"KAKOCLAW_TOOLS_PROCESS_MAX_PROCESSES"`             // running processes per user or session
+	MaxOutputKB       int `json:"max_output_kb" env:"KAKOCLAW_TOOLS_PROCESS_MAX_OUTPUT_KB"`             // output kept per stream; older output is dropped
+	MaxRuntimeMinutes int `json:"max_runtime_minutes" env:"KAKOCLAW_TOOLS_PROCESS_MAX_RUNTIME_MINUTES"` // 0 = until killed or shutdown
+	RetainMinutes     int `json:"retain_minutes" env:"KAKOCLAW_TOOLS_PROCESS_RETAIN_MINUTES"`           // how long finished processes stay readable
+}
+
+// ShellToolsConfig configures the shell tool's persistent sessions, one per
+// agent session. They run with the exec tool's guard and sandbox.
+type ShellToolsConfig struct {
+	Shell                 string `json:"shell" env:"KAKOCLAW_TOOLS_SHELL_SHELL"`                                     // empty = bash if installed, else sh
+	CommandTimeoutSeconds int    `json:"command_timeout_seconds" env:"KAKOCLAW_TOOLS_SHELL_COMMAND_TIMEOUT_SECONDS"` // how long a call waits for a command to finish
+	IdleTimeoutMinutes    int    `json:"idle_timeout_minutes" env:"KAKOCLAW_TOOLS_SHELL_IDLE_TIMEOUT_MINUTES"`       // sessions unused this long are closed
+	MaxSessions           int    `json:"max_sessions" env:"KAKOCLAW_TOOLS_SHELL_MAX_SESSIONS"`
+}
+
+// SandboxConfig runs exec commands in a Linux sandbox: new user, mount, PID,
+// IPC and network namespaces, landlock limiting the filesystem to the
+// workspace, a seccomp filter and resource limits. Where the sandbox is not
+// available, commands fall back to the pattern guard unless Required is set.
+type SandboxConfig struct {
+	Enabled       bool     `json:"enabled" env:"KAKOCLAW_TOOLS_EXEC_SANDBOX_ENABLED"`
+	Required      bool     `json:"required" env:"KAKOCLAW_TOOLS_EXEC_SANDBOX_REQUIRED"` // refuse to run commands without the sandbox
+	Network       bool     `json:"network" env:"KAKOCLAW_TOOLS_EXEC_SANDBOX_NETWORK"`   // allow network access
+	ReadPaths     []string `json:"read_paths"`                                          // readable besides the workspace and system directories
+	WritePaths    []string `json:"write_paths"`                                         // writable besides the workspace
+	CPUSeconds    int      `json:"cpu_seconds"`
+	MemoryMB      int      `json:"memory_mb"`
+	MaxProcesses  int      `json:"max_processes"`
+	MaxFileSizeMB int      `json:"max_file_size_mb"`
+}
+
 type ToolsConfig struct {
-	Web   WebToolsConfig   `json:"web"`
-	Email EmailToolsConfig `json:"email"`
-	MCP   MCPConfig        `json:"mcp"`
+	Web      WebToolsConfig     `json:"web"`
+	Exec     ExecToolsConfig    `json:"exec"`
+	Process  ProcessToolsConfig `json:"process"`
+	Shell    ShellToolsConfig   `json:"shell"`
+	Email    EmailToolsConfig   `json:"email"`
+	MCP      MCPConfig          `json:"mcp"`
+	Approval ApprovalConfig     `json:"approval"`
+	Cache    ToolCacheConfig    `json:"cache"`
+	Limits   ToolLimitsConfig   `json:"limits"`
+	Policy   ToolPolicyConfig   `json:"policy"`
+}
+
+// ToolLimitsConfig bounds every tool call: how long it may run and how many
+// characters of output it may return; longer output is saved to a file in
+// the workspace. Tools overrides the defaults by tool name or glob, where 0
+// keeps the default and a negative value removes the limit.
+type ToolLimitsConfig struct {
+	TimeoutSeconds int                  `json:"timeout_seconds" env:"KAKOCLAW_TOOLS_LIMITS_TIMEOUT_SECONDS"`
+	MaxOutputChars int                  `json:"max_output_chars" env:"KAKOCLAW_TOOLS_LIMITS_MAX_OUTPUT_CHARS"`
+	Tools          map[string]ToolLimit `json:"tools"`
+}
+
+// ToolLimit overrides the default limits for a tool.
+type ToolLimit struct {
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	MaxOutputChars int `json:"max_output_chars,omitempty"`
+}
+
+// ToolCacheConfig controls caching of tool results, keyed by tool name and
+// arguments. Only tools listed in Tools (exact names or globs) are cached,
+// with the listed TTL in seconds: 0 uses TTLSeconds and a negative TTL turns
+// caching off for that tool.
+type ToolCacheConfig struct {
+	Enabled    bool           `json:"enabled" env:"KAKOCLAW_TOOLS_CACHE_ENABLED"`
+	TTLSeconds int            `json:"ttl_seconds" env:"KAKOCLAW_TOOLS_CACHE_TTL_SECONDS"`
+	MaxEntries int            `json:"max_entries" env:"KAKOCLAW_TOOLS_CACHE_MAX_ENTRIES"`
+	Tools      map[string]int `json:"tools"`
+}
+
+// ApprovalConfig controls which tool calls must be approved by a human
+// before they run. Tool entries may be exact names or glob patterns
+// (e.g. "mcp_github_*").
+type ApprovalConfig struct {
+	Enabled        bool                          `json:"enabled" env:"KAKOCLAW_TOOLS_APPROVAL_ENABLED"`
+	Tools          []string                      `json:"tools"`
+	TimeoutSeconds int                           `json:"timeout_seconds" env:"KAKOCLAW_TOOLS_APPROVAL_TIMEOUT_SECONDS"`
+	Users          map[string]ApprovalUserPolicy `json:"users,omitempty"` // keyed by user ID
+}
+
+// ApprovalUserPolicy adjusts the approval policy for a single user.
+type ApprovalUserPolicy struct {
+	Require []string `json:"require,omitempty"` // additional tools that need approval
+	Exempt  []string `json:"exempt,omitempty"`  // tools this user may run without approval
+}
+
+// BudgetConfig controls LLM pricing and spending caps. Prices are always
+// applied so every LLM call has a cost; caps are enforced only when Enabled.
+type BudgetConfig struct {
+	Enabled bool `json:"enabled" env:"KAKOCLAW_BUDGET_ENABLED"`
+	// Pricing is keyed by "provider/model", "model" or a glob such as "claude-sonnet-*".
+	Pricing map[string]ModelPricing `json:"pricing"`
+	// User caps apply to each user unless overridden in the users table.
+	User BudgetLimits `json:"user"`
+	// Session caps apply to each chat session.
+	Session BudgetLimits `json:"session"`
+	// Cron caps apply to each scheduled job's session instead of Session.
+	Cron BudgetLimits `json:"cron"`
+	// OnExceeded is "refuse" (default) or "downgrade".
+	OnExceeded string `json:"on_exceeded" env:"KAKOCLAW_BUDGET_ON_EXCEEDED"`
+	// DowngradeModel is used once a cap is reached when OnExceeded is "downgrade".
+	DowngradeModel string `json:"downgrade_model" env:"KAKOCLAW_BUDGET_DOWNGRADE_MODEL"`
+}
+
+// BudgetLimits caps spend per calendar day and month. Zero means unlimited.
+type BudgetLimits struct {
+	DailyUSD      float64 `json:"daily_usd,omitempty"`
+	MonthlyUSD    float64 `json:"monthly_usd,omitempty"`
+	DailyTokens   int64   `json:"daily_tokens,omitempty"`
+	MonthlyTokens int64   `json:"monthly_tokens,omitempty"`
+}
+
+// ModelPricing is the price of a model in USD per million tokens.
+type ModelPricing struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CachedRead float64 `json:"cached_read,omitempty"` // defaults to Input
+	CacheWrite float64 `json:"cache_write,omitempty"` // defaults to Input
 }
 
 type MCPConfig struct {
@@ -230,6 +400,15 @@ func DefaultConfig() *Config {
 				MaxTokens:           8192,
 				Temperature:         0.7,
 				MaxToolIterations:   20,
+				MaxConcurrentRuns:   4,
+				Vision:              "auto",
+				PromptCache:         "on",
+				SteerMode:           "queue",
+				StructuredRetries:   2,
+			},
+			Subagents: SubagentsConfig{
+				MaxIterations: 10,
+				MaxDepth:      2,
 			},
 		},
 		Channels: ChannelsConfig{
@@ -297,6 +476,14 @@ func DefaultConfig() *Config {
 			Nvidia:     ProviderConfig{},
 			Moonshot:   ProviderConfig{},
 			Ollama:     ProviderConfig{},
+			Failover: FailoverConfig{
+				Fallbacks:              []FallbackConfig{},
+				MaxRetries:             2,
+				InitialBackoffMs:       500,
+				MaxBackoffMs:           30000,
+				BreakerThreshold:       3,
+				BreakerCooldownSeconds: 60,
+			},
 		},
 		Gateway: GatewayConfig{
 			Host: "0.0.0.0",
@@ -317,6 +504,30 @@ func DefaultConfig() *Config {
 					MaxResults: 5,
 				},
 			},
+			Exec: ExecToolsConfig{
+				Sandbox: SandboxConfig{
+					Enabled:       false,
+					Network:       false,
+					ReadPaths:     []string{},
+					WritePaths:    []string{},
+					CPUSeconds:    60,
+					MemoryMB:      1024,
+					MaxProcesses:  128,
+					MaxFileSizeMB: 256,
+				},
+			},
+			Process: ProcessToolsConfig{
+				MaxProcesses:      8,
+				MaxOutputKB:       1024,
+				MaxRuntimeMinutes: 0,
+				RetainMinutes:     60,
+			},
+			Shell: ShellToolsConfig{
+				Shell:                 "",
+				CommandTimeoutSeconds: 60,
+				IdleTimeoutMinutes:    30,
+				MaxSessions:           16,
+			},
 			Email: EmailToolsConfig{
 				Enabled:  false,
 				Host:     "smtp.gmail.com",
@@ -329,10 +540,48 @@ func DefaultConfig() *Config {
 			MCP: MCPConfig{
 				Servers: map[string]MCPServerConfig{},
 			},
+			Approval: ApprovalConfig{
+				Enabled:        false,
+				Tools:          []string{"exec", "process", "shell", "write_file", "edit_file", "send_email_report"},
+				TimeoutSeconds: 120,
+			},
+			Cache: ToolCacheConfig{
+				Enabled:    false,
+				TTLSeconds: 300,
+				MaxEntries: 500,
+				Tools: map[string]int{
+					"web_search":      600,
+					"web_fetch":       0,
+					"read_file":       0,
+					"list_dir":        0,
+					"query_knowledge": 0,
+				},
+			},
+			Limits: ToolLimitsConfig{
+				TimeoutSeconds: 120,
+				MaxOutputChars: 20000,
+				Tools: map[string]ToolLimit{
+					"exec":    {MaxOutputChars: 10000},
+					"shell":   {TimeoutSeconds: 660},
+					"process": {TimeoutSeconds: 180},
+					"spawn":   {TimeoutSeconds: 900},
+					"handoff": {TimeoutSeconds: 900},
+				},
+			},
+			Policy: ToolPolicyConfig{
+				Enabled: false,
+				Default: "allow",
+				Rules:   []ToolPolicyRule{},
+			},
 		},
 		Storage: StorageConfig{
 			Path: "~/.kakoclaw/kakoclaw.db",
 		},
+		Budget: BudgetConfig{
+			Enabled:    false,
+			Pricing:    map[string]ModelPricing{},
+			OnExceeded: "refuse",
+		},
 	}
 }
 
@@ -362,6 +611,12 @@ func LoadConfig(path string) (*Config, error) {
 	if err := validateWebConfig(&cfg.Web); err != nil {
 		return nil, fmt.Errorf("web config: %w", err)
 	}
+	if err := cfg.Agents.Validate(); err != nil {
+		return nil, fmt.Errorf("agents config: %w", err)
+	}
+	if err := cfg.Tools.Policy.Validate(); err != nil {
+		return nil, fmt.Errorf("tools.policy config: %w", err)
+	}
 
 	return cfg, nil
 }
@@ -390,6 +645,12 @@ func LoadConfigForUser(userUUID string) (*Config, error) {
 		if err := validateWebConfig(&cfg.Web); err != nil {
 			return nil, fmt.Errorf("web config: %w", err)
 		}
+		if err := cfg.Agents.Validate(); err != nil {
+			return nil, fmt.Errorf("agents config: %w", err)
+		}
+		if err := cfg.Tools.Policy.Validate(); err != nil {
+			return nil, fmt.Errorf("tools.policy config: %w", err)
+		}
 		return cfg, nil
 	}
 
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// defaultGenerationMaxTokens is used when agents.defaults.max_tokens is unset.
+const defaultGenerationMaxTokens = 8192
+
+// minThinkingBudget is the smallest thinking budget Claude accepts.
+const minThinkingBudget = 1024
+
+// reasoningEfforts are the accepted reasoning_effort values.
+var reasoningEfforts = map[string]bool{"minimal": true, "low": true, "medium": true, "high": true}
+
+// GenerationParams are the sampling parameters sent with an LLM call. In an
+// override, unset fields (zero values and nil pointers) inherit from the
+// layer below.
+type GenerationParams struct {
+	MaxTokens       int      `json:"max_tokens,omitempty"`
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"top_p,omitempty"`
+	Stop            []string `json:"stop,omitempty"`
+	ReasoningEffort string   `json:"reasoning_effort,omitempty"` // "minimal", "low", "medium" or "high"
+	Seed            *int64   `json:"seed,omitempty"`
+	ThinkingBudget  int      `json:"thinking_budget,omitempty"` // Claude extended thinking tokens
+}
+
+// GenerationOverrides adjust generation parameters per model and per
+// channel. Model keys are model names or path.Match globs.
+type GenerationOverrides struct {
+	Models   map[string]GenerationParams `json:"models,omitempty"`
+	Channels map[string]GenerationParams `json:"channels,omitempty"`
+}
+
+// Merge returns p with every field set in over replacing p's value.
+func (p GenerationParams) Merge(over GenerationParams) GenerationParams {
+	if over.MaxTokens > 0 {
+		p.MaxTokens = over.MaxTokens
+	}
+	if over.Temperature != nil {
+		p.Temperature = over.Temperature
+	}
+	if over.TopP != nil {
+		p.TopP = over.TopP
+	}
+	if over.Stop != nil {
+		p.Stop = over.Stop
+	}
+	if over.ReasoningEffort != "" {
+		p.ReasoningEffort = over.ReasoningEffort
+	}
+	if over.Seed != nil {
+		p.Seed = over.Seed
+	}
+	if over.ThinkingBudget > 0 {
+		p.ThinkingBudget = over.ThinkingBudget
+	}
+	return p
+}
+
+// IsZero reports whether no parameter is set.
+func (p GenerationParams) IsZero() bool {
+	return p.MaxTokens == 0 && p.Temperature == nil && p.TopP == nil &&
+		p.Stop == nil && p.ReasoningEffort == "" && p.Seed == nil && p.ThinkingBudget == 0
+}
+
+// Validate checks parameter ranges.
+func (p GenerationParams) Validate() error {
+	if p.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative")
+	}
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2")
+	}
+	if p.TopP != nil && (*p.TopP <= 0 || *p.TopP > 1) {
+		return fmt.Errorf("top_p must be greater than 0 and at most 1")
+	}
+	if len(p.Stop) > 4 {
+		return fmt.Errorf("at most 4 stop sequences are allowed")
+	}
+	if p.ReasoningEffort != "" && !reasoningEfforts[p.ReasoningEffort] {
+		return fmt.Errorf("reasoning_effort must be one of minimal, low, medium, high")
+	}
+	if p.ThinkingBudget != 0 && p.ThinkingBudget < minThinkingBudget {
+		return fmt.Errorf("thinking_budget must be at least %d tokens", minThinkingBudget)
+	}
+	return nil
+}
+
+// Options renders the parameters as the provider options map.
+func (p GenerationParams) Options() map[string]interface{} {
+	opts := map[string]interface{}{}
+	if p.MaxTokens > 0 {
+		opts["max_tokens"] = p.MaxTokens
+	}
+	if p.Temperature != nil {
+		opts["temperature"] = *p.Temperature
+	}
+	if p.TopP != nil {
+		opts["top_p"] = *p.TopP
+	}
+	if len(p.Stop) > 0 {
+		opts["stop"] = p.Stop
+	}
+	if p.ReasoningEffort != "" {
+		opts["reasoning_effort"] = p.ReasoningEffort
+	}
+	if p.Seed != nil {
+		opts["seed"] = *p.Seed
+	}
+	if p.ThinkingBudget > 0 {
+		opts["thinking_budget"] = p.ThinkingBudget
+	}
+	return opts
+}
+
+// String formats the set parameters for display, e.g. in chat replies.
+func (p GenerationParams) String() string {
+	var parts []string
+	if p.MaxTokens > 0 {
+		parts = append(parts, fmt.Sprintf("max_tokens=%d", p.MaxTokens))
+	}
+	if p.Temperature != nil {
+		parts = append(parts, fmt.Sprintf("temperature=%g", *p.Temperature))
+	}
+	if p.TopP != nil {
+		parts = append(parts, fmt.Sprintf("top_p=%g", *p.TopP))
+	}
+	if len(p.Stop) > 0 {
+		parts = append(parts, fmt.Sprintf("stop=%q", p.Stop))
+	}
+	if p.ReasoningEffort != "" {
+		parts = append(parts, "reasoning_effort="+p.ReasoningEffort)
+	}
+	if p.Seed != nil {
+		parts = append(parts, fmt.Sprintf("seed=%d", *p.Seed))
+	}
+	if p.ThinkingBudget > 0 {
+		parts = append(parts, fmt.Sprintf("thinking_budget=%d", p.ThinkingBudget))
+	}
+	if len(parts) == 0 {
+		return "(none)"
+	}
+	return strings.Join(parts, " ")
+}
+
+// Generation returns the default generation parameters.
+func (d AgentDefaults) Generation() GenerationParams {
+	p := GenerationParams{
+		MaxTokens:       d.MaxTokens,
+		ReasoningEffort: d.ReasoningEffort,
+		Stop:            d.Stop,
+		Seed:            d.Seed,
+		ThinkingBudget:  d.ThinkingBudget,
+	}
+	if p.MaxTokens <= 0 {
+		p.MaxTokens = defaultGenerationMaxTokens
+	}
+	temperature := d.Temperature
+	p.Temperature = &temperature
+	if d.TopP > 0 {
+		topP := d.TopP
+		p.TopP = &topP
+	}
+	return p
+}
+
+// ForModel returns the override for model: an exact key first, then the
+// longest matching glob.
+func (o GenerationOverrides) ForModel(model string) (GenerationParams, bool) {
+	if p, ok := o.Models[model]; ok {
+		return p, true
+	}
+	var globs []string
+	for key := range o.Models {
+		if strings.ContainsAny(key, "*?[") {
+			globs = append(globs, key)
+		}
+	}
+	sort.Slice(globs, func(i, j int) bool {
+		if len(globs[i]) != len(globs[j]) {
+			return len(globs[i]) > len(globs[j])
+		}
+		return globs[i] < globs[j]
+	})
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, model); ok {
+			return o.Models[glob], true
+		}
+	}
+	return GenerationParams{}, false
+}
+
+// Resolve layers the defaults, the model override, the channel override and
+// any further overrides (session, workflow step), later layers winning.
+func (a AgentsConfig) Resolve(model, channel string, extra ...GenerationParams) GenerationParams {
+	p := a.Defaults.Generation()
+	if over, ok := a.Generation.ForModel(model); ok {
+		p = p.Merge(over)
+	}
+	if over, ok := a.Generation.Channels[channel]; ok {
+		p = p.Merge(over)
+	}
+	for _, over := range extra {
+		p = p.Merge(over)
+	}
+	return p
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func floatPtr(f float64) *float64 { return &f }
+
+func TestAgentsResolveGeneration(t *testing.T) {
+	agents := AgentsConfig{
+		Defaults: AgentDefaults{MaxTokens: 8192, Temperature: 0.7},
+		Generation: GenerationOverrides{
+			Models: map[string]GenerationParams{
+				"o3*":     {ReasoningEffort: "high", Temperature: floatPtr(1)},
+				"o3-mini": {ReasoningEffort: "low"},
+			},
+			Channels: map[string]GenerationParams{
+				"telegram": {MaxTokens: 2048},
+			},
+		},
+	}
+
+	got := agents.Resolve("o3-mini", "telegram", GenerationParams{Stop: []string{"END"}})
+	if got.MaxTokens != 2048 || *got.Temperature != 0.7 || got.ReasoningEffort != "low" || !reflect.DeepEqual(got.Stop, []string{"END"}) {
+		t.Fatalf("Resolve = %s, want channel max_tokens, exact model effort and session stop", got)
+	}
+
+	got = agents.Resolve("o3-pro", "web")
+	if got.MaxTokens != 8192 || *got.Temperature != 1 || got.ReasoningEffort != "high" {
+		t.Fatalf("Resolve = %s, want the glob model override", got)
+	}
+}
+
+func TestGenerationDefaults(t *testing.T) {
+	p := AgentDefaults{Temperature: 0}.Generation()
+	if p.MaxTokens != defaultGenerationMaxTokens || p.Temperature == nil || *p.Temperature != 0 || p.TopP != nil {
+		t.Fatalf("Generation() = %s", p)
+	}
+}
+
+func TestGenerationOptions(t *testing.T) {
+	seed := int64(42)
+	p := GenerationParams{MaxTokens: 100, TopP: floatPtr(0.9), Stop: []string{"\n\n"}, ReasoningEffort: "low", Seed: &seed}
+	want := map[string]interface{}{
+		"max_tokens":       100,
+		"top_p":            0.9,
+		"stop":             []string{"\n\n"},
+		"reasoning_effort": "low",
+		"seed":             int64(42),
+	}
+	if got := p.Options(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Options() = %v, want %v", got, want)
+	}
+}
+
+func TestGenerationValidate(t *testing.T) {
+	bad := []GenerationParams{
+		{Temperature: floatPtr(3)},
+		{TopP: floatPtr(0)},
+		{ReasoningEffort: "extreme"},
+		{Stop: []string{"a", "b", "c", "d", "e"}},
+		{MaxTokens: -1},
+		{ThinkingBudget: 500},
+	}
+	for _, p := range bad {
+		if p.Validate() == nil {
+			t.Errorf("Validate(%s) = nil, want an error", p)
+		}
+	}
+	if err := (GenerationParams{Temperature: floatPtr(0), TopP: floatPtr(1), ThinkingBudget: 1024}).Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"path"
+)
+
+// Tool policy effects.
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+)
+
+// ToolPolicyConfig limits which tools each caller may use. Rules are checked
+// in order and the first one that matches both the caller and the tool
+// decides; a call no rule matches gets Default. A policy saved through the
+// web API replaces this one.
+type ToolPolicyConfig struct {
+	Enabled bool             `json:"enabled" env:"KAKOCLAW_TOOLS_POLICY_ENABLED"`
+	Default string           `json:"default" env:"KAKOCLAW_TOOLS_POLICY_DEFAULT"` // "allow" (default) or "deny"
+	Rules   []ToolPolicyRule `json:"rules"`
+}
+
+// ToolPolicyRule allows or denies tools to the callers it matches. Every
+// selector that is set must match; Roles are user roles ("admin", "user")
+// or "guest" for senders without an account, and Chats accept path.Match
+// globs.
+type ToolPolicyRule struct {
+	Effect   string                            `json:"effect"` // "allow" or "deny"
+	Tools    []string                          `json:"tools"`  // tool names or globs
+	Users    []int64                           `json:"users,omitempty"`
+	Roles    []string                          `json:"roles,omitempty"`
+	Channels []string                          `json:"channels,omitempty"`
+	Chats    []string                          `json:"chats,omitempty"`
+	Args     map[string]ToolArgumentConstraint `json:"args,omitempty"` // allow rules only, keyed by argument name
+}
+
+// ToolArgumentConstraint restricts an argument of the tools an allow rule
+// covers; a call whose argument falls outside it is denied.
+type ToolArgumentConstraint struct {
+	PathPrefixes []string `json:"path_prefixes,omitempty"` // relative to the workspace unless absolute or starting with ~
+	Hosts        []string `json:"hosts,omitempty"`         // URL hosts; each also covers its subdomains
+}
+
+// Validate checks rule effects, patterns and argument constraints.
+func (p ToolPolicyConfig) Validate() error {
+	if p.Default != "" && p.Default != PolicyAllow && p.Default != PolicyDeny {
+		return fmt.Errorf("default must be %q or %q, not %q", PolicyAllow, PolicyDeny, p.Default)
+	}
+	for i, r := range p.Rules {
+		if r.Effect != PolicyAllow && r.Effect != PolicyDeny {
+			return fmt.Errorf("rule %d: effect must be %q or %q, not %q", i, PolicyAllow, PolicyDeny, r.Effect)
+		}
+		if len(r.Tools) == 0 {
+			return fmt.Errorf("rule %d lists no tools", i)
+		}
+		for _, glob := range append(append([]string{}, r.Tools...), r.Chats...) {
+			if _, err := path.Match(glob, ""); err != nil {
+				return fmt.Errorf("rule %d: bad pattern %q", i, glob)
+			}
+		}
+		if len(r.Args) > 0 && r.Effect != PolicyAllow {
+			return fmt.Errorf("rule %d: argument constraints only apply to allow rules", i)
+		}
+		for name, c := range r.Args {
+			if len(c.PathPrefixes) == 0 && len(c.Hosts) == 0 {
+				return fmt.Errorf("rule %d: constraint on %q sets neither path_prefixes nor hosts", i, name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package config
+
+import "testing"
+
+func TestToolPolicyValidate(t *testing.T) {
+	good := ToolPolicyConfig{
+		Enabled: true,
+		Default: PolicyDeny,
+		Rules: []ToolPolicyRule{
+			{Effect: PolicyAllow, Tools: []string{"read_file", "mcp_*"}, Roles: []string{"admin"}},
+			{Effect: PolicyAllow, Tools: []string{"web_fetch"}, Args: map[string]ToolArgumentConstraint{
+				"url": {Hosts: []string{"example.com"}},
+			}},
+		},
+	}
+	if err := good.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bad := []ToolPolicyConfig{
+		{Default: "maybe"},
+		{Rules: []ToolPolicyRule{{Effect: "permit", Tools: []string{"*"}}}},
+		{Rules: []ToolPolicyRule{{Effect: PolicyDeny}}},
+		{Rules: []ToolPolicyRule{{Effect: PolicyDeny, Tools: []string{"["}}}},
+		{Rules: []ToolPolicyRule{{Effect: PolicyAllow, Tools: []string{"*"}, Chats: []string{"["}}}},
+		{Rules: []ToolPolicyRule{{Effect: PolicyDeny, Tools: []string{"write_file"}, Args: map[string]ToolArgumentConstraint{
+			"path": {PathPrefixes: []string{"tmp/"}},
+		}}}},
+		{Rules: []ToolPolicyRule{{Effect: PolicyAllow, Tools: []string{"write_file"}, Args: map[string]ToolArgumentConstraint{
+			"path": {},
+		}}}},
+	}
+	for i, p := range bad {
+		if p.Validate() == nil {
+			t.Errorf("case %d: expected a validation error", i)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+// Package jsonschema validates decoded JSON values against the subset of
+// JSON Schema used for tool parameters and structured output: types,
+// properties, required, additionalProperties, items, enum, const, numeric
+// and length bounds, pattern, and the anyOf/oneOf/allOf combinators. It can
+// also coerce values that have the wrong but convertible type.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validate checks value, as decoded by encoding/json, against schema and
+// returns one message per violation, each prefixed with the JSON path of
+// the offending value. It returns nil when value is valid.
+func Validate(schema map[string]interface{}, value interface{}) []string {
+	var errs []string
+	validate(schema, value, "$", &errs)
+	return errs
+}
+
+// ValidateJSON decodes data and validates it against schema.
+func ValidateJSON(schema map[string]interface{}, data []byte) ([]string, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return Validate(schema, value), nil
+}
+
+// Coerce returns value with safe conversions applied where its type does not
+// match the schema: numeric and boolean strings become numbers and booleans,
+// numbers and booleans become strings, JSON-encoded objects and arrays are
+// decoded, and a lone value becomes a one-item array. Object properties and
+// array items are coerced recursively. value itself is not modified.
+func Coerce(schema map[string]interface{}, value interface{}) interface{} {
+	if len(schema) == 0 {
+		return value
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 && !hasAnyType(value, types) {
+		for _, t := range types {
+			if v, ok := coerceTo(t, value); ok {
+				value = v
+				break
+			}
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		props, _ := schema["properties"].(map[string]interface{})
+		extra, _ := schema["additionalProperties"].(map[string]interface{})
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			if sub, ok := props[k].(map[string]interface{}); ok {
+				out[k] = Coerce(sub, item)
+			} else {
+				out[k] = Coerce(extra, item)
+			}
+		}
+		return out
+	case []interface{}:
+		items, _ := schema["items"].(map[string]interface{})
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = Coerce(items, item)
+		}
+		return out
+	}
+	return value
+}
+
+// coerceTo converts value to type t when that loses no information.
+func coerceTo(t string, value interface{}) (interface{}, bool) {
+	s, isString := value.(string)
+	switch t {
+	case "number", "integer":
+		if !isString {
+			return nil, false
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, false
+		}
+		if t == "integer" && f != math.Trunc(f) {
+			return nil, false
+		}
+		return f, true
+	case "boolean":
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "true":
+			return true, isString
+		case "false":
+			return false, isString
+		}
+	case "string":
+		switch v := value.(type) {
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case bool:
+			return strconv.FormatBool(v), true
+		}
+	case "object", "array":
+		if isString {
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(s), &decoded); err == nil && hasType(decoded, t) {
+				return decoded, true
+			}
+		}
+		if t == "array" && value != nil {
+			return []interface{}{value}, true
+		}
+	}
+	return nil, false
+}
+
+func hasAnyType(value interface{}, types []string) bool {
+	for _, t := range types {
+		if hasType(value, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func validate(schema map[string]interface{}, value interface{}, path string, errs *[]string) {
+	if len(schema) == 0 {
+		return
+	}
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		if !hasAnyType(value, types) {
+			fail("expected %s, got %s", strings.Join(types, " or "), TypeOf(value))
+			// The remaining keywords assume the declared type.
+			return
+		}
+	}
+
+	if enum := values(schema["enum"]); enum != nil {
+		found := false
+		for _, e := range enum {
+			if equal(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %s", compact(enum))
+		}
+	}
+	if c, ok := schema["const"]; ok && !equal(c, value) {
+		fail("must be %s", compact(c))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		validateObject(schema, v, path, errs)
+	case []interface{}:
+		validateArray(schema, v, path, errs)
+	case string:
+		n := utf8.RuneCountInString(v)
+		if min, ok := number(schema["minLength"]); ok && float64(n) < min {
+			fail("must be at least %v characters", min)
+		}
+		if max, ok := number(schema["maxLength"]); ok && float64(n) > max {
+			fail("must be at most %v characters", max)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				fail("must match pattern %q", pattern)
+			}
+		}
+	case float64:
+		if min, ok := number(schema["minimum"]); ok && v < min {
+			fail("must be >= %v", min)
+		}
+		if max, ok := number(schema["maximum"]); ok && v > max {
+			fail("must be <= %v", max)
+		}
+		if min, ok := number(schema["exclusiveMinimum"]); ok && v <= min {
+			fail("must be > %v", min)
+		}
+		if max, ok := number(schema["exclusiveMaximum"]); ok && v >= max {
+			fail("must be < %v", max)
+		}
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if s, ok := sub.(map[string]interface{}); ok {
+				validate(s, value, path, errs)
+			}
+		}
+	}
+	if any, ok := schema["anyOf"].([]interface{}); ok && countMatches(any, value) == 0 {
+		fail("must match at least one of the anyOf schemas")
+	}
+	if one, ok := schema["oneOf"].([]interface{}); ok {
+		if n := countMatches(one, value); n != 1 {
+			fail("must match exactly one of the oneOf schemas, matched %d", n)
+		}
+	}
+}
+
+func validateObject(schema map[string]interface{}, obj map[string]interface{}, path string, errs *[]string) {
+	props, _ := schema["properties"].(map[string]interface{})
+
+	for _, name := range stringList(schema["required"]) {
+		if _, ok := obj[name]; !ok {
+			*errs = append(*errs, fmt.Sprintf("%s: missing required property %q", path, name))
+		}
+	}
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		child := path + "." + k
+		if sub, ok := props[k].(map[string]interface{}); ok {
+			validate(sub, obj[k], child, errs)
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				*errs = append(*errs, fmt.Sprintf("%s: unexpected property %q", path, k))
+			}
+		case map[string]interface{}:
+			validate(extra, obj[k], child, errs)
+		}
+	}
+
+	if min, ok := number(schema["minProperties"]); ok && float64(len(obj)) < min {
+		*errs = append(*errs, fmt.Sprintf("%s: must have at least %v properties", path, min))
+	}
+	if max, ok := number(schema["maxProperties"]); ok && float64(len(obj)) > max {
+		*errs = append(*errs, fmt.Sprintf("%s: must have at most %v properties", path, max))
+	}
+}
+
+func validateArray(schema map[string]interface{}, arr []interface{}, path string, errs *[]string) {
+	if min, ok := number(schema["minItems"]); ok && float64(len(arr)) < min {
+		*errs = append(*errs, fmt.Sprintf("%s: must have at least %v items", path, min))
+	}
+	if max, ok := number(schema["maxItems"]); ok && float64(len(arr)) > max {
+		*errs = append(*errs, fmt.Sprintf("%s: must have at most %v items", path, max))
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := range arr {
+			for j := 0; j < i; j++ {
+				if equal(arr[i], arr[j]) {
+					*errs = append(*errs, fmt.Sprintf("%s: items %d and %d are equal", path, j, i))
+				}
+			}
+		}
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for i, item := range arr {
+			validate(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+// countMatches returns how many of schemas value is valid against.
+func countMatches(schemas []interface{}, value interface{}) int {
+	n := 0
+	for _, sub := range schemas {
+		s, ok := sub.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var errs []string
+		validate(s, value, "$", &errs)
+		if len(errs) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// schemaTypes returns the types allowed by a "type" keyword, which is either
+// a single name or a list of names.
+func schemaTypes(v interface{}) []string {
+	if s, ok := v.(string); ok && s != "" {
+		return []string{s}
+	}
+	return stringList(v)
+}
+
+func hasType(value interface{}, t string) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f) && !math.IsInf(f, 0)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	// Unknown types are not ours to reject.
+	return true
+}
+
+// TypeOf returns the JSON Schema type name of a decoded JSON value.
+func TypeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// values returns a list keyword such as enum, which schemas built in Go may
+// declare as a []string.
+func values(v interface{}) []interface{} {
+	switch list := v.(type) {
+	case []interface{}:
+		return list
+	case []string:
+		out := make([]interface{}, len(list))
+		for i, s := range list {
+			out[i] = s
+		}
+		return out
+	}
+	return nil
+}
+
+func stringList(v interface{}) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// equal compares JSON values, treating numbers of any Go type alike so that
+// schemas written in Go compare equal to decoded JSON.
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func compact(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
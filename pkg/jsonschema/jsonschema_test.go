@@ -0,0 +1,129 @@
+package jsonschema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name":  map[string]interface{}{"type": "string", "minLength": 1},
+			"count": map[string]interface{}{"type": "integer", "minimum": 0},
+			"kind":  map[string]interface{}{"type": "string", "enum": []string{"a", "b"}},
+			"tags": map[string]interface{}{
+				"type":     "array",
+				"items":    map[string]interface{}{"type": "string"},
+				"maxItems": 2,
+			},
+			"note": map[string]interface{}{"type": []interface{}{"string", "null"}},
+		},
+		"required":             []interface{}{"name", "count"},
+		"additionalProperties": false,
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  []string // substrings of the expected errors, in order
+	}{
+		{"valid", `{"name":"x","count":2,"kind":"a","tags":["t"],"note":null}`, nil},
+		{"missing required", `{"name":"x"}`, []string{`$: missing required property "count"`}},
+		{"wrong type", `{"name":"x","count":1.5}`, []string{"$.count: expected integer, got number"}},
+		{"enum", `{"name":"x","count":1,"kind":"c"}`, []string{`$.kind: must be one of ["a","b"]`}},
+		{"items", `{"name":"x","count":1,"tags":["a",2,"c"]}`, []string{"$.tags: must have at most 2 items", "$.tags[1]: expected string"}},
+		{"extra property", `{"name":"x","count":1,"other":true}`, []string{`unexpected property "other"`}},
+		{"bounds", `{"name":"","count":-1}`, []string{"$.count: must be >= 0", "$.name: must be at least 1 characters"}},
+		{"root type", `[1]`, []string{"$: expected object, got array"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs, err := ValidateJSON(schema, []byte(tt.value))
+			if err != nil {
+				t.Fatalf("ValidateJSON: %v", err)
+			}
+			if len(errs) != len(tt.want) {
+				t.Fatalf("errors = %q, want %d", errs, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(errs[i], want) {
+					t.Errorf("error %d = %q, want it to contain %q", i, errs[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateCombinators(t *testing.T) {
+	schema := map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{"type": "integer"},
+		},
+	}
+	if errs := Validate(schema, "x"); errs != nil {
+		t.Errorf("string: %q", errs)
+	}
+	if errs := Validate(schema, true); len(errs) != 1 {
+		t.Errorf("boolean: %q, want one error", errs)
+	}
+
+	// An integer is also a number, so it matches both branches.
+	schema["oneOf"] = []interface{}{
+		map[string]interface{}{"type": "number"},
+		map[string]interface{}{"type": "integer"},
+	}
+	if errs := Validate(schema, float64(3)); len(errs) != 1 || !strings.Contains(errs[0], "matched 2") {
+		t.Errorf("ambiguous oneOf: %q", errs)
+	}
+}
+
+func TestValidateJSONRejectsInvalidJSON(t *testing.T) {
+	if _, err := ValidateJSON(map[string]interface{}{"type": "object"}, []byte("{not json")); err == nil {
+		t.Fatal("expected an error for invalid JSON")
+	}
+}
+
+func TestCoerce(t *testing.T) {
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"count":   map[string]interface{}{"type": "integer"},
+			"ratio":   map[string]interface{}{"type": "number"},
+			"enabled": map[string]interface{}{"type": "boolean"},
+			"label":   map[string]interface{}{"type": "string"},
+			"tags":    map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"options": map[string]interface{}{"type": "object"},
+		},
+	}
+
+	value := map[string]interface{}{
+		"count":   "5",
+		"ratio":   " 0.5 ",
+		"enabled": "TRUE",
+		"label":   float64(42),
+		"tags":    "solo",
+		"options": `{"deep":true}`,
+	}
+	got := Coerce(schema, value)
+	if errs := Validate(schema, got); errs != nil {
+		t.Fatalf("coerced value is still invalid: %v", errs)
+	}
+	obj := got.(map[string]interface{})
+	if obj["count"] != float64(5) || obj["label"] != "42" || obj["enabled"] != true {
+		t.Fatalf("unexpected coercion: %#v", obj)
+	}
+	if tags := obj["tags"].([]interface{}); len(tags) != 1 || tags[0] != "solo" {
+		t.Fatalf("expected a one-item array, got %#v", obj["tags"])
+	}
+	if value["count"] != "5" {
+		t.Fatal("Coerce must not modify its input")
+	}
+
+	// Lossy conversions are left for Validate to report.
+	got = Coerce(schema, map[string]interface{}{"count": "1.5", "enabled": "yes"})
+	if errs := Validate(schema, got); len(errs) != 2 {
+		t.Fatalf("expected 2 errors for unconvertible values, got %v", errs)
+	}
+}
@@ -29,6 +29,20 @@ type Metrics struct {
 	LLMTokensOut int64                    `json:"llm_tokens_out"`
 	LLMByModel   map[string]*ModelMetrics `json:"llm_by_model"`
 
+	// Prompt tokens served from and written to provider caches (both
+	// included in LLMTokensIn)
+	LLMTokensCached     int64 `json:"llm_tokens_cached"`
+	LLMTokensCacheWrite int64 `json:"llm_tokens_cache_write"`
+	// Calls whose usage was estimated because the provider did not report it
+	LLMUsageEstimated int64 `json:"llm_usage_estimated"`
+	// Priced cost of all calls, in USD
+	LLMCostUSD float64 `json:"llm_cost_usd"`
+	// Provider resilience: retried attempts, switches to a fallback backend
+	// and circuit breakers opened
+	LLMRetries      int64 `json:"llm_retries"`
+	LLMFailovers    int64 `json:"llm_failovers"`
+	LLMCircuitOpens int64 `json:"llm_circuit_opens"`
+
 	// Tool execution metrics
 	ToolCalls   int64                   `json:"tool_calls"`
 	ToolErrors  int64                   `json:"tool_errors"`
@@ -41,6 +55,13 @@ type Metrics struct {
 	AgentTotalMs   int64 `json:"agent_total_ms"`
 	AgentIterTotal int64 `json:"agent_iterations_total"`
 
+	// Agent dispatch queue gauges (in-memory only)
+	QueueDepth       int64 `json:"queue_depth"`
+	QueuePeakDepth   int64 `json:"queue_peak_depth"`
+	QueueActive      int64 `json:"queue_active"`
+	QueueWaits       int64 `json:"queue_waits"`
+	QueueWaitTotalMs int64 `json:"queue_wait_total_ms"`
+
 	// Recent events (ring buffer of last N events)
 	RecentEvents []Event `json:"recent_events"`
 
@@ -52,24 +73,51 @@ type Metrics struct {
 }
 
 type ModelMetrics struct {
-	Calls     int64 `json:"calls"`
-	Errors    int64 `json:"errors"`
-	TotalMs   int64 `json:"total_ms"`
-	TokensIn  int64 `json:"tokens_in"`
-	TokensOut int64 `json:"tokens_out"`
+	Calls        int64 `json:"calls"`
+	Errors       int64 `json:"errors"`
+	TotalMs      int64 `json:"total_ms"`
+	TokensIn     int64 `json:"tokens_in"`
+	TokensOut    int64 `json:"tokens_out"`
+	TokensCached int64 `json:"tokens_cached"`
+	// Prompt tokens written to the provider's cache
+	TokensCacheWrite int64   `json:"tokens_cache_write"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+// TokenUsage is the token accounting for a single LLM call. In includes
+// Cached and CacheWrite. Estimated is set when the provider did not report
+// usage and the counts were approximated locally.
+type TokenUsage struct {
+	In         int
+	Out        int
+	Cached     int
+	CacheWrite int
+	CostUSD    float64
+	Estimated  bool
 }
 
 type ToolMetrics struct {
 	Calls   int64 `json:"calls"`
 	Errors  int64 `json:"errors"`
 	TotalMs int64 `json:"total_ms"`
+	// Lookups in the tool result cache, for tools that are cached
+	CacheHits   int64 `json:"cache_hits"`
+	CacheMisses int64 `json:"cache_misses"`
+	// Calls cut short by the registry's limits
+	Timeouts  int64 `json:"timeouts"`
+	Truncated int64 `json:"truncated"` // Output over the cap, saved to a file
+	Panics    int64 `json:"panics"`
 }
 
 type Event struct {
-	Type       string    `json:"type"` // "llm_call", "tool_call", "agent_run", "error"
+	Type       string    `json:"type"` // "llm_call", "llm_failover", "llm_circuit_open", "tool_call", "agent_run", "error"
 	Model      string    `json:"model,omitempty"`
+	Provider   string    `json:"provider,omitempty"` // backend that failed (llm_failover, llm_circuit_open)
+	Fallback   string    `json:"fallback,omitempty"` // backend taking over (llm_failover)
 	Tool       string    `json:"tool,omitempty"`
 	DurationMs int64     `json:"duration_ms"`
+	TokensIn   int       `json:"tokens_in,omitempty"`
+	TokensOut  int       `json:"tokens_out,omitempty"`
 	Error      string    `json:"error,omitempty"`
 	Timestamp  time.Time `json:"timestamp"`
 }
@@ -125,6 +173,13 @@ func (m *Metrics) loadFromDB(s MetricsStorage) {
 	m.LLMTotalMs = counters["llm_total_ms"]
 	m.LLMTokensIn = counters["llm_tokens_in"]
 	m.LLMTokensOut = counters["llm_tokens_out"]
+	m.LLMTokensCached = counters["llm_tokens_cached"]
+	m.LLMTokensCacheWrite = counters["llm_tokens_cache_write"]
+	m.LLMUsageEstimated = counters["llm_usage_estimated"]
+	m.LLMCostUSD = float64(counters["llm_cost_microusd"]) / 1e6
+	m.LLMRetries = counters["llm_retries"]
+	m.LLMFailovers = counters["llm_failovers"]
+	m.LLMCircuitOpens = counters["llm_circuit_opens"]
 	m.ToolCalls = counters["tool_calls"]
 	m.ToolErrors = counters["tool_errors"]
 	m.ToolTotalMs = counters["tool_total_ms"]
@@ -167,6 +222,13 @@ func (m *Metrics) flushCounters() {
 		"llm_total_ms":           m.LLMTotalMs,
 		"llm_tokens_in":          m.LLMTokensIn,
 		"llm_tokens_out":         m.LLMTokensOut,
+		"llm_tokens_cached":      m.LLMTokensCached,
+		"llm_tokens_cache_write": m.LLMTokensCacheWrite,
+		"llm_usage_estimated":    m.LLMUsageEstimated,
+		"llm_cost_microusd":      int64(m.LLMCostUSD * 1e6),
+		"llm_retries":            m.LLMRetries,
+		"llm_failovers":          m.LLMFailovers,
+		"llm_circuit_opens":      m.LLMCircuitOpens,
 		"tool_calls":             m.ToolCalls,
 		"tool_errors":            m.ToolErrors,
 		"tool_total_ms":          m.ToolTotalMs,
@@ -204,14 +266,20 @@ func (m *Metrics) asyncFlush(evt Event) {
 }
 
 // RecordLLMCall records a single LLM API call.
-func (m *Metrics) RecordLLMCall(model string, duration time.Duration, tokensIn, tokensOut int, err error) {
+func (m *Metrics) RecordLLMCall(model string, duration time.Duration, usage TokenUsage, err error) {
 	m.mu.Lock()
 
 	ms := duration.Milliseconds()
 	m.LLMCalls++
 	m.LLMTotalMs += ms
-	m.LLMTokensIn += int64(tokensIn)
-	m.LLMTokensOut += int64(tokensOut)
+	m.LLMTokensIn += int64(usage.In)
+	m.LLMTokensOut += int64(usage.Out)
+	m.LLMTokensCached += int64(usage.Cached)
+	m.LLMTokensCacheWrite += int64(usage.CacheWrite)
+	if usage.Estimated {
+		m.LLMUsageEstimated++
+	}
+	m.LLMCostUSD += usage.CostUSD
 
 	if err != nil {
 		m.LLMErrors++
@@ -224,8 +292,11 @@ func (m *Metrics) RecordLLMCall(model string, duration time.Duration, tokensIn,
 	}
 	mm.Calls++
 	mm.TotalMs += ms
-	mm.TokensIn += int64(tokensIn)
-	mm.TokensOut += int64(tokensOut)
+	mm.TokensIn += int64(usage.In)
+	mm.TokensOut += int64(usage.Out)
+	mm.TokensCached += int64(usage.Cached)
+	mm.TokensCacheWrite += int64(usage.CacheWrite)
+	mm.CostUSD += usage.CostUSD
 	if err != nil {
 		mm.Errors++
 	}
@@ -234,6 +305,8 @@ func (m *Metrics) RecordLLMCall(model string, duration time.Duration, tokensIn,
 		Type:       "llm_call",
 		Model:      model,
 		DurationMs: ms,
+		TokensIn:   usage.In,
+		TokensOut:  usage.Out,
 		Timestamp:  time.Now(),
 	}
 	if err != nil {
@@ -246,6 +319,56 @@ func (m *Metrics) RecordLLMCall(model string, duration time.Duration, tokensIn,
 	m.asyncFlush(evt)
 }
 
+// RecordLLMRetry counts a failed LLM attempt that is being retried on the
+// same backend.
+func (m *Metrics) RecordLLMRetry() {
+	m.mu.Lock()
+	m.LLMRetries++
+	m.mu.Unlock()
+}
+
+// RecordLLMFailover records a switch from a failing backend to the next one
+// in the fallback chain.
+func (m *Metrics) RecordLLMFailover(from, to string, err error) {
+	m.mu.Lock()
+
+	m.LLMFailovers++
+	evt := Event{
+		Type:      "llm_failover",
+		Provider:  from,
+		Fallback:  to,
+		Timestamp: time.Now(),
+	}
+	if err != nil {
+		evt.Error = err.Error()
+	}
+	m.addEvent(evt)
+
+	m.mu.Unlock()
+
+	m.asyncFlush(evt)
+}
+
+// RecordCircuitOpen records a backend's circuit breaker opening.
+func (m *Metrics) RecordCircuitOpen(provider string, err error) {
+	m.mu.Lock()
+
+	m.LLMCircuitOpens++
+	evt := Event{
+		Type:      "llm_circuit_open",
+		Provider:  provider,
+		Timestamp: time.Now(),
+	}
+	if err != nil {
+		evt.Error = err.Error()
+	}
+	m.addEvent(evt)
+
+	m.mu.Unlock()
+
+	m.asyncFlush(evt)
+}
+
 // RecordToolCall records a single tool execution.
 func (m *Metrics) RecordToolCall(toolName string, duration time.Duration, err error) {
 	m.mu.Lock()
@@ -258,11 +381,7 @@ func (m *Metrics) RecordToolCall(toolName string, duration time.Duration, err er
 		m.ToolErrors++
 	}
 
-	tm, ok := m.ToolByName[toolName]
-	if !ok {
-		tm = &ToolMetrics{}
-		m.ToolByName[toolName] = tm
-	}
+	tm := m.toolMetrics(toolName)
 	tm.Calls++
 	tm.TotalMs += ms
 	if err != nil {
@@ -285,6 +404,51 @@ func (m *Metrics) RecordToolCall(toolName string, duration time.Duration, err er
 	m.asyncFlush(evt)
 }
 
+// RecordToolCache records a tool result cache lookup.
+func (m *Metrics) RecordToolCache(toolName string, hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tm := m.toolMetrics(toolName)
+	if hit {
+		tm.CacheHits++
+	} else {
+		tm.CacheMisses++
+	}
+}
+
+// RecordToolTimeout records a tool call stopped by its timeout.
+func (m *Metrics) RecordToolTimeout(toolName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.toolMetrics(toolName).Timeouts++
+}
+
+// RecordToolTruncated records tool output cut at the output cap.
+func (m *Metrics) RecordToolTruncated(toolName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.toolMetrics(toolName).Truncated++
+}
+
+// RecordToolPanic records a tool call that panicked.
+func (m *Metrics) RecordToolPanic(toolName string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.toolMetrics(toolName).Panics++
+}
+
+// toolMetrics returns the metrics for toolName, creating them on first use.
+// The caller must hold m.mu.
+func (m *Metrics) toolMetrics(toolName string) *ToolMetrics {
+	tm, ok := m.ToolByName[toolName]
+	if !ok {
+		tm = &ToolMetrics{}
+		m.ToolByName[toolName] = tm
+	}
+	return tm
+}
+
 // RecordAgentRun records a full agent loop execution.
 func (m *Metrics) RecordAgentRun(duration time.Duration, iterations int, err error) {
 	m.mu.Lock()
@@ -313,6 +477,27 @@ func (m *Metrics) RecordAgentRun(duration time.Duration, iterations int, err err
 	m.asyncFlush(evt)
 }
 
+// RecordQueueState updates the agent dispatch queue gauges.
+func (m *Metrics) RecordQueueState(depth, active int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.QueueDepth = int64(depth)
+	m.QueueActive = int64(active)
+	if m.QueueDepth > m.QueuePeakDepth {
+		m.QueuePeakDepth = m.QueueDepth
+	}
+}
+
+// RecordQueueWait records how long a message waited before a worker picked it up.
+func (m *Metrics) RecordQueueWait(wait time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.QueueWaits++
+	m.QueueWaitTotalMs += wait.Milliseconds()
+}
+
 // Snapshot returns a read-only copy of the current metrics.
 func (m *Metrics) Snapshot() map[string]interface{} {
 	m.mu.RLock()
@@ -322,12 +507,16 @@ func (m *Metrics) Snapshot() map[string]interface{} {
 	models := make(map[string]interface{})
 	for k, v := range m.LLMByModel {
 		models[k] = map[string]interface{}{
-			"calls":      v.Calls,
-			"errors":     v.Errors,
-			"total_ms":   v.TotalMs,
-			"avg_ms":     avgMs(v.TotalMs, v.Calls),
-			"tokens_in":  v.TokensIn,
-			"tokens_out": v.TokensOut,
+			"calls":              v.Calls,
+			"errors":             v.Errors,
+			"total_ms":           v.TotalMs,
+			"avg_ms":             avgMs(v.TotalMs, v.Calls),
+			"tokens_in":          v.TokensIn,
+			"tokens_out":         v.TokensOut,
+			"tokens_cached":      v.TokensCached,
+			"tokens_cache_write": v.TokensCacheWrite,
+			"cache_hit_rate":     avgFloat(v.TokensCached, v.TokensIn),
+			"cost_usd":           v.CostUSD,
 		}
 	}
 
@@ -335,10 +524,16 @@ func (m *Metrics) Snapshot() map[string]interface{} {
 	toolsMap := make(map[string]interface{})
 	for k, v := range m.ToolByName {
 		toolsMap[k] = map[string]interface{}{
-			"calls":    v.Calls,
-			"errors":   v.Errors,
-			"total_ms": v.TotalMs,
-			"avg_ms":   avgMs(v.TotalMs, v.Calls),
+			"calls":          v.Calls,
+			"errors":         v.Errors,
+			"total_ms":       v.TotalMs,
+			"avg_ms":         avgMs(v.TotalMs, v.Calls),
+			"cache_hits":     v.CacheHits,
+			"cache_misses":   v.CacheMisses,
+			"cache_hit_rate": avgFloat(v.CacheHits, v.CacheHits+v.CacheMisses),
+			"timeouts":       v.Timeouts,
+			"truncated":      v.Truncated,
+			"panics":         v.Panics,
 		}
 	}
 
@@ -355,6 +550,14 @@ func (m *Metrics) Snapshot() map[string]interface{} {
 		"llm_avg_ms":             avgMs(m.LLMTotalMs, m.LLMCalls),
 		"llm_tokens_in":          m.LLMTokensIn,
 		"llm_tokens_out":         m.LLMTokensOut,
+		"llm_tokens_cached":      m.LLMTokensCached,
+		"llm_tokens_cache_write": m.LLMTokensCacheWrite,
+		"llm_cache_hit_rate":     avgFloat(m.LLMTokensCached, m.LLMTokensIn),
+		"llm_usage_estimated":    m.LLMUsageEstimated,
+		"llm_cost_usd":           m.LLMCostUSD,
+		"llm_retries":            m.LLMRetries,
+		"llm_failovers":          m.LLMFailovers,
+		"llm_circuit_opens":      m.LLMCircuitOpens,
 		"llm_by_model":           models,
 		"tool_calls":             m.ToolCalls,
 		"tool_errors":            m.ToolErrors,
@@ -367,6 +570,10 @@ func (m *Metrics) Snapshot() map[string]interface{} {
 		"agent_avg_ms":           avgMs(m.AgentTotalMs, m.AgentRuns),
 		"agent_iterations_total": m.AgentIterTotal,
 		"agent_avg_iterations":   avgFloat(m.AgentIterTotal, m.AgentRuns),
+		"queue_depth":            m.QueueDepth,
+		"queue_peak_depth":       m.QueuePeakDepth,
+		"queue_active":           m.QueueActive,
+		"queue_avg_wait_ms":      avgMs(m.QueueWaitTotalMs, m.QueueWaits),
 		"recent_events":          events,
 	}
 }
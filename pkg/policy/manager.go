@@ -0,0 +1,367 @@
+// Package policy decides which tools a caller may use. Rules match callers
+// by user, role, channel and chat, and allow rules may constrain tool
+// arguments, such as the paths write_file may touch or the hosts web_fetch
+// may reach.
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/logger"
+)
+
+// GuestRole is the role of senders that have no user account.
+const GuestRole = "guest"
+
+// settingKey is the settings entry holding a policy saved through the web API.
+const settingKey = "tool_policy"
+
+// Store is the persistence the manager needs; implemented by storage.Storage.
+type Store interface {
+	GetSetting(key string) (string, error)
+	SetSetting(key, value string) error
+}
+
+// Caller identifies who is calling a tool.
+type Caller struct {
+	UserID    int64  // 0 for guests
+	Role      string // user role, or GuestRole
+	Channel   string
+	ChatID    string
+	Workspace string // resolves relative paths in path constraints
+}
+
+type callerKey struct{}
+
+// WithCaller returns a copy of ctx whose tool calls are checked as c.
+func WithCaller(ctx context.Context, c Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, c)
+}
+
+// CallerFrom returns the caller attached with WithCaller, if any.
+func CallerFrom(ctx context.Context) (Caller, bool) {
+	c, ok := ctx.Value(callerKey{}).(Caller)
+	return c, ok
+}
+
+// Manager holds the active tool policy and checks calls against it.
+type Manager struct {
+	base  config.ToolPolicyConfig
+	store Store
+
+	mu     sync.RWMutex
+	policy config.ToolPolicyConfig
+	saved  bool // policy was saved through the API and replaces base
+}
+
+// NewManager creates a policy manager that starts from the stored policy,
+// falling back to cfg. store may be nil, in which case changes last until
+// restart.
+func NewManager(cfg config.ToolPolicyConfig, store Store) *Manager {
+	m := &Manager{base: normalize(cfg), store: store}
+	m.policy = m.base
+	if store == nil {
+		return m
+	}
+
+	raw, err := store.GetSetting(settingKey)
+	if err != nil {
+		logger.WarnCF("policy", "Failed to load saved tool policy", map[string]interface{}{"error": err.Error()})
+		return m
+	}
+	if raw == "" {
+		return m
+	}
+	var saved config.ToolPolicyConfig
+	if err := json.Unmarshal([]byte(raw), &saved); err == nil {
+		err = saved.Validate()
+	}
+	if err != nil {
+		logger.WarnCF("policy", "Ignoring invalid saved tool policy", map[string]interface{}{"error": err.Error()})
+		return m
+	}
+	m.policy, m.saved = normalize(saved), true
+	return m
+}
+
+func normalize(p config.ToolPolicyConfig) config.ToolPolicyConfig {
+	if p.Default == "" {
+		p.Default = config.PolicyAllow
+	}
+	if p.Rules == nil {
+		p.Rules = []config.ToolPolicyRule{}
+	}
+	return p
+}
+
+// Policy returns the active policy and whether it was saved through the API
+// rather than taken from the config file.
+func (m *Manager) Policy() (config.ToolPolicyConfig, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.policy, m.saved
+}
+
+// SetPolicy validates p, stores it and makes it the active policy.
+func (m *Manager) SetPolicy(p config.ToolPolicyConfig) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	p = normalize(p)
+	if m.store != nil {
+		data, err := json.Marshal(p)
+		if err != nil {
+			return fmt.Errorf("encoding tool policy: %w", err)
+		}
+		if err := m.store.SetSetting(settingKey, string(data)); err != nil {
+			return fmt.Errorf("saving tool policy: %w", err)
+		}
+	}
+
+	m.mu.Lock()
+	m.policy, m.saved = p, true
+	m.mu.Unlock()
+	logger.InfoCF("policy", "Tool policy updated", map[string]interface{}{
+		"enabled": p.Enabled,
+		"rules":   len(p.Rules),
+	})
+	return nil
+}
+
+// Reset drops the saved policy and goes back to the config file's.
+func (m *Manager) Reset() error {
+	if m.store != nil {
+		if err := m.store.SetSetting(settingKey, ""); err != nil {
+			return fmt.Errorf("resetting tool policy: %w", err)
+		}
+	}
+	m.mu.Lock()
+	m.policy, m.saved = m.base, false
+	m.mu.Unlock()
+	return nil
+}
+
+// Enabled reports whether the policy is enforced.
+func (m *Manager) Enabled() bool {
+	if m == nil {
+		return false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.policy.Enabled
+}
+
+// decide returns the first rule matching c and tool, or nil and the default
+// effect when none does.
+func (m *Manager) decide(c Caller, tool string) (*config.ToolPolicyRule, string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := range m.policy.Rules {
+		r := &m.policy.Rules[i]
+		if ruleMatches(r, c) && matchAny(r.Tools, tool) {
+			return r, r.Effect
+		}
+	}
+	return nil, m.policy.Default
+}
+
+// Allows reports whether c may use tool at all, and so whether it is offered
+// to the model. Argument constraints are only known at call time, so a tool
+// allowed here may still be refused by Check.
+func (m *Manager) Allows(c Caller, tool string) bool {
+	if !m.Enabled() {
+		return true
+	}
+	_, effect := m.decide(c, tool)
+	return effect == config.PolicyAllow
+}
+
+// Check returns an error explaining why c may not call tool with args, or
+// nil when the call is allowed.
+func (m *Manager) Check(c Caller, tool string, args map[string]interface{}) error {
+	if !m.Enabled() {
+		return nil
+	}
+	rule, effect := m.decide(c, tool)
+	if effect != config.PolicyAllow {
+		return fmt.Errorf("permission denied: the tool policy does not allow %s here", tool)
+	}
+	if rule == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(rule.Args))
+	for name := range rule.Args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// Arguments are checked before tools coerce them, so a value of
+		// another type could turn into a path or URL that was never checked.
+		value, ok := args[name].(string)
+		if _, present := args[name]; present && !ok {
+			return fmt.Errorf("permission denied: %s argument %q must be a string", tool, name)
+		}
+		if err := checkArgument(rule.Args[name], value, c.Workspace); err != nil {
+			return fmt.Errorf("permission denied: %s argument %q %v", tool, name, err)
+		}
+	}
+	return nil
+}
+
+func ruleMatches(r *config.ToolPolicyRule, c Caller) bool {
+	if len(r.Users) > 0 && !containsUser(r.Users, c.UserID) {
+		return false
+	}
+	if len(r.Roles) > 0 && !contains(r.Roles, c.Role) {
+		return false
+	}
+	if len(r.Channels) > 0 && !contains(r.Channels, c.Channel) {
+		return false
+	}
+	if len(r.Chats) > 0 && !matchAny(r.Chats, c.ChatID) {
+		return false
+	}
+	return true
+}
+
+func containsUser(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if id != 0 && v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if p == value || p == "*" {
+			return true
+		}
+		if ok, _ := path.Match(p, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkArgument tests an argument value against a constraint.
+func checkArgument(c config.ToolArgumentConstraint, value, workspace string) error {
+	if len(c.PathPrefixes) > 0 {
+		target := resolvePath(value, workspace)
+		ok := false
+		for _, prefix := range c.PathPrefixes {
+			if withinPath(target, resolvePath(expandHome(prefix), workspace)) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("must be a path under %s", strings.Join(c.PathPrefixes, ", "))
+		}
+	}
+	if len(c.Hosts) > 0 {
+		host := urlHost(value)
+		ok := false
+		for _, allowed := range c.Hosts {
+			if matchHost(host, allowed) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("must be a URL on %s", strings.Join(c.Hosts, ", "))
+		}
+	}
+	return nil
+}
+
+// resolvePath returns the absolute path p refers to, with symlinks resolved
+// so that a link inside an allowed directory cannot point outside it.
+func resolvePath(p, workspace string) string {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(workspace, p)
+	}
+	return evalSymlinks(filepath.Clean(p))
+}
+
+// evalSymlinks resolves the symlinks in p. Files that do not exist yet, such
+// as a file about to be written, resolve through their nearest existing
+// parent directory.
+func evalSymlinks(p string) string {
+	var missing []string
+	for {
+		if resolved, err := filepath.EvalSymlinks(p); err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...)
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return filepath.Join(append([]string{p}, missing...)...)
+		}
+		missing = append([]string{filepath.Base(p)}, missing...)
+		p = parent
+	}
+}
+
+// expandHome expands a leading ~ to the user's home directory.
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return home + p[1:]
+		}
+	}
+	return p
+}
+
+func withinPath(target, prefix string) bool {
+	if target == prefix {
+		return true
+	}
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	return strings.HasPrefix(target, prefix)
+}
+
+func urlHost(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+func matchHost(host, allowed string) bool {
+	allowed = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(allowed)), "*.")
+	if host == "" || allowed == "" {
+		return false
+	}
+	return host == allowed || strings.HasSuffix(host, "."+allowed)
+}
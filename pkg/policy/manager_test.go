@@ -0,0 +1,169 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+)
+
+type settingsStore map[string]string
+
+func (s settingsStore) GetSetting(key string) (string, error) { return s[key], nil }
+func (s settingsStore) SetSetting(key, value string) error {
+	s[key] = value
+	return nil
+}
+
+func testPolicy() config.ToolPolicyConfig {
+	return config.ToolPolicyConfig{
+		Enabled: true,
+		Rules: []config.ToolPolicyRule{
+			{Effect: "allow", Tools: []string{"*"}, Roles: []string{"admin"}},
+			{Effect: "deny", Tools: []string{"exec", "mcp_*"}, Channels: []string{"telegram"}},
+			{Effect: "allow", Tools: []string{"write_file"}, Args: map[string]config.ToolArgumentConstraint{
+				"path": {PathPrefixes: []string{"shared/"}},
+			}},
+			{Effect: "allow", Tools: []string{"web_fetch"}, Args: map[string]config.ToolArgumentConstraint{
+				"url": {Hosts: []string{"example.com"}},
+			}},
+			{Effect: "deny", Tools: []string{"*"}, Roles: []string{GuestRole}, Chats: []string{"-100*"}},
+		},
+	}
+}
+
+func TestManagerRules(t *testing.T) {
+	m := NewManager(testPolicy(), nil)
+	admin := Caller{UserID: 1, Role: "admin", Channel: "telegram"}
+	user := Caller{UserID: 2, Role: "user", Channel: "telegram", Workspace: "/ws"}
+	guest := Caller{Role: GuestRole, Channel: "telegram", ChatID: "-100123"}
+
+	tests := []struct {
+		caller Caller
+		tool   string
+		want   bool
+	}{
+		{admin, "exec", true},
+		{admin, "mcp_github_search", true},
+		{user, "exec", false},
+		{user, "mcp_github_search", false},
+		{user, "read_file", true}, // no rule matches; default allow
+		{user, "write_file", true},
+		{guest, "read_file", false},
+		{Caller{Role: "user", Channel: "cli"}, "exec", true},
+	}
+	for _, tt := range tests {
+		if got := m.Allows(tt.caller, tt.tool); got != tt.want {
+			t.Errorf("Allows(%+v, %s) = %v, want %v", tt.caller, tt.tool, got, tt.want)
+		}
+	}
+
+	calls := []struct {
+		tool string
+		args map[string]interface{}
+		want bool
+	}{
+		{"write_file", map[string]interface{}{"path": "shared/notes.md"}, true},
+		{"write_file", map[string]interface{}{"path": "/ws/shared/a/b.txt"}, true},
+		{"write_file", map[string]interface{}{"path": "shared/../secrets.txt"}, false},
+		{"write_file", map[string]interface{}{"path": "sharedfoo/x"}, false},
+		{"write_file", map[string]interface{}{}, false},
+		{"web_fetch", map[string]interface{}{"url": "https://docs.example.com/page"}, true},
+		{"web_fetch", map[string]interface{}{"url": "https://EXAMPLE.com"}, true},
+		{"web_fetch", map[string]interface{}{"url": "https://example.com.evil.net/"}, false},
+		{"web_fetch", map[string]interface{}{"url": "https://notexample.com/"}, false},
+	}
+	for _, tt := range calls {
+		err := m.Check(user, tt.tool, tt.args)
+		if (err == nil) != tt.want {
+			t.Errorf("Check(%s, %v) = %v, want allowed=%v", tt.tool, tt.args, err, tt.want)
+		}
+	}
+	if err := m.Check(user, "exec", nil); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("expected exec to be denied, got %v", err)
+	}
+}
+
+func TestManagerDefaultDeny(t *testing.T) {
+	m := NewManager(config.ToolPolicyConfig{
+		Enabled: true,
+		Default: "deny",
+		Rules:   []config.ToolPolicyRule{{Effect: "allow", Tools: []string{"read_file"}}},
+	}, nil)
+	if !m.Allows(Caller{}, "read_file") || m.Allows(Caller{}, "exec") {
+		t.Fatal("expected only read_file to be allowed")
+	}
+
+	disabled := NewManager(config.ToolPolicyConfig{Default: "deny"}, nil)
+	if !disabled.Allows(Caller{}, "exec") || disabled.Check(Caller{}, "exec", nil) != nil {
+		t.Fatal("a disabled policy must allow everything")
+	}
+}
+
+func TestManagerPersistsPolicy(t *testing.T) {
+	store := settingsStore{}
+	base := config.ToolPolicyConfig{Enabled: false}
+	m := NewManager(base, store)
+
+	if err := m.SetPolicy(config.ToolPolicyConfig{Rules: []config.ToolPolicyRule{{Effect: "maybe", Tools: []string{"*"}}}}); err == nil {
+		t.Fatal("expected an invalid policy to be rejected")
+	}
+	if err := m.SetPolicy(testPolicy()); err != nil {
+		t.Fatalf("SetPolicy: %v", err)
+	}
+
+	// A new manager picks up the saved policy instead of the config.
+	reloaded := NewManager(base, store)
+	p, saved := reloaded.Policy()
+	if !saved || !p.Enabled || len(p.Rules) != len(testPolicy().Rules) || p.Default != "allow" {
+		t.Fatalf("unexpected reloaded policy: %+v (saved=%v)", p, saved)
+	}
+	if reloaded.Allows(Caller{Role: "user", Channel: "telegram"}, "exec") {
+		t.Fatal("the saved policy should deny exec on telegram")
+	}
+
+	if err := reloaded.Reset(); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if _, saved := NewManager(base, store).Policy(); saved {
+		t.Fatal("expected Reset to drop the saved policy")
+	}
+}
+
+func TestManagerPathPrefixesResolvePaths(t *testing.T) {
+	workspace, outside, home := t.TempDir(), t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.Mkdir(filepath.Join(workspace, "shared"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(workspace, "shared", "escape")); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(config.ToolPolicyConfig{
+		Enabled: true,
+		Default: config.PolicyDeny,
+		Rules: []config.ToolPolicyRule{{Effect: "allow", Tools: []string{"write_file"}, Args: map[string]config.ToolArgumentConstraint{
+			"path": {PathPrefixes: []string{"shared", "~/notes"}},
+		}}},
+	}, nil)
+	user := Caller{UserID: 2, Role: "user", Workspace: workspace}
+
+	calls := []struct {
+		args map[string]interface{}
+		want bool
+	}{
+		{map[string]interface{}{"path": "shared/new/file.txt"}, true},
+		{map[string]interface{}{"path": "shared/escape/file.txt"}, false},
+		{map[string]interface{}{"path": filepath.Join(home, "notes", "todo.md")}, true},
+		{map[string]interface{}{"path": filepath.Join(home, "other.md")}, false},
+		{map[string]interface{}{"path": 42}, false},
+		{map[string]interface{}{"path": []interface{}{"shared/a.txt"}}, false},
+	}
+	for _, tt := range calls {
+		if err := m.Check(user, "write_file", tt.args); (err == nil) != tt.want {
+			t.Errorf("Check(%v) = %v, want allowed=%v", tt.args, err, tt.want)
+		}
+	}
+}
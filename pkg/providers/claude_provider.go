@@ -4,10 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
 	"github.com/sipeed/kakoclaw/pkg/auth"
+	"github.com/sipeed/kakoclaw/pkg/logger"
 )
 
 type ClaudeProvider struct {
@@ -49,7 +51,144 @@ func (p *ClaudeProvider) Chat(ctx context.Context, messages []Message, tools []T
 		return nil, fmt.Errorf("claude API call: %w", err)
 	}
 
-	return parseClaudeResponse(resp), nil
+	result := parseClaudeResponse(resp)
+	if responseSchema(options) != nil {
+		takeStructuredOutput(result)
+	}
+	return result, nil
+}
+
+// ChatStream implements StreamingLLMProvider using the Messages streaming API.
+func (p *ClaudeProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (<-chan StreamChunk, error) {
+	var opts []option.RequestOption
+	if p.tokenSource != nil {
+		tok, err := p.tokenSource()
+		if err != nil {
+			return nil, fmt.Errorf("refreshing token: %w", err)
+		}
+		opts = append(opts, option.WithAuthToken(tok))
+	}
+
+	params, err := buildClaudeParams(messages, tools, model, options)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := p.client.Messages.NewStreaming(ctx, params, opts...)
+	// Request errors (auth, rate limits, ...) are known before the first event.
+	if err := stream.Err(); err != nil {
+		stream.Close()
+		return nil, fmt.Errorf("claude API call: %w", err)
+	}
+
+	ch := make(chan StreamChunk, 64)
+
+	go func() {
+		defer close(ch)
+		defer stream.Close()
+
+		send := func(chunk StreamChunk) bool {
+			select {
+			case ch <- chunk:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		var usage anthropic.Usage
+		finishReason := "stop"
+		// Thinking blocks are assembled from deltas and returned on the final chunk.
+		thinking := make(map[int64]*ThinkingBlock)
+		var order []int64
+		for stream.Next() {
+			event := stream.Current()
+			switch event.Type {
+			case "message_start":
+				usage = event.Message.Usage
+
+			case "content_block_start":
+				switch event.ContentBlock.Type {
+				case "thinking":
+					thinking[event.Index] = &ThinkingBlock{}
+					order = append(order, event.Index)
+				case "redacted_thinking":
+					thinking[event.Index] = &ThinkingBlock{Redacted: event.ContentBlock.Data}
+					order = append(order, event.Index)
+				case "tool_use":
+					if !send(StreamChunk{ToolCalls: []ToolCall{{
+						ID:    event.ContentBlock.ID,
+						Type:  "function",
+						Name:  event.ContentBlock.Name,
+						Index: int(event.Index),
+					}}}) {
+						return
+					}
+				}
+
+			case "content_block_delta":
+				var chunk StreamChunk
+				switch event.Delta.Type {
+				case "text_delta":
+					chunk.Content = event.Delta.Text
+				case "thinking_delta":
+					if block := thinking[event.Index]; block != nil {
+						block.Thinking += event.Delta.Thinking
+					}
+					chunk.Reasoning = event.Delta.Thinking
+				case "signature_delta":
+					if block := thinking[event.Index]; block != nil {
+						block.Signature += event.Delta.Signature
+					}
+					continue
+				case "input_json_delta":
+					if event.Delta.PartialJSON == "" {
+						continue
+					}
+					chunk.ToolCalls = []ToolCall{{
+						Index:    int(event.Index),
+						Function: &FunctionCall{Arguments: event.Delta.PartialJSON},
+					}}
+				default:
+					continue
+				}
+				if !send(chunk) {
+					return
+				}
+
+			case "message_delta":
+				finishReason = claudeFinishReason(event.Delta.StopReason)
+				// message_delta usage is cumulative; input counts are only
+				// present on newer API versions.
+				usage.OutputTokens = event.Usage.OutputTokens
+				if event.Usage.InputTokens > 0 {
+					usage.InputTokens = event.Usage.InputTokens
+				}
+				if event.Usage.CacheReadInputTokens > 0 {
+					usage.CacheReadInputTokens = event.Usage.CacheReadInputTokens
+				}
+				if event.Usage.CacheCreationInputTokens > 0 {
+					usage.CacheCreationInputTokens = event.Usage.CacheCreationInputTokens
+				}
+			}
+		}
+
+		if err := stream.Err(); err != nil {
+			logger.ErrorCF("provider", "Claude stream ended with an error", map[string]interface{}{
+				"model": model,
+				"error": err.Error(),
+			})
+			finishReason = "error"
+		}
+
+		final := StreamChunk{Done: true, FinishReason: finishReason, Usage: claudeUsage(usage)}
+		for _, idx := range order {
+			final.Thinking = append(final.Thinking, *thinking[idx])
+		}
+		send(final)
+	}()
+
+	return ch, nil
 }
 
 func (p *ClaudeProvider) GetDefaultModel() string {
@@ -59,16 +198,30 @@ func (p *ClaudeProvider) GetDefaultModel() string {
 func buildClaudeParams(messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (anthropic.MessageNewParams, error) {
 	var system []anthropic.TextBlockParam
 	var anthropicMessages []anthropic.MessageParam
+	var images *imageLoader
+
+	maxTokens := int64(4096)
+	if mt, ok := options["max_tokens"].(int); ok {
+		maxTokens = int64(mt)
+	}
+	budget, _ := int64Option(options, "thinking_budget")
+	thinking := budget >= minThinkingBudget && claudeThinkingUsable(messages)
 
 	for _, msg := range messages {
 		switch msg.Role {
 		case "system":
-			system = append(system, anthropic.TextBlockParam{Text: msg.Content})
+			system = append(system, claudeSystemBlocks(msg)...)
 		case "user":
 			if msg.ToolCallID != "" {
 				anthropicMessages = append(anthropicMessages,
 					anthropic.NewUserMessage(anthropic.NewToolResultBlock(msg.ToolCallID, msg.Content, false)),
 				)
+			} else if len(msg.Parts) > 0 {
+				if images == nil {
+					// URLs are passed through, so the loader never does network I/O here.
+					images = newImageLoader(context.Background(), model, options, false)
+				}
+				anthropicMessages = append(anthropicMessages, anthropic.NewUserMessage(claudeContentBlocks(msg.Parts, images)...))
 			} else {
 				anthropicMessages = append(anthropicMessages,
 					anthropic.NewUserMessage(anthropic.NewTextBlock(msg.Content)),
@@ -77,11 +230,15 @@ func buildClaudeParams(messages []Message, tools []ToolDefinition, model string,
 		case "assistant":
 			if len(msg.ToolCalls) > 0 {
 				var blocks []anthropic.ContentBlockParamUnion
+				if thinking {
+					blocks = append(blocks, claudeThinkingBlocks(msg.Thinking)...)
+				}
 				if msg.Content != "" {
 					blocks = append(blocks, anthropic.NewTextBlock(msg.Content))
 				}
 				for _, tc := range msg.ToolCalls {
-					blocks = append(blocks, anthropic.NewToolUseBlock(tc.ID, tc.Arguments, tc.Name))
+					name, args := toolCallNameArgs(tc)
+					blocks = append(blocks, anthropic.NewToolUseBlock(tc.ID, args, name))
 				}
 				anthropicMessages = append(anthropicMessages, anthropic.NewAssistantMessage(blocks...))
 			} else {
@@ -96,11 +253,6 @@ func buildClaudeParams(messages []Message, tools []ToolDefinition, model string,
 		}
 	}
 
-	maxTokens := int64(4096)
-	if mt, ok := options["max_tokens"].(int); ok {
-		maxTokens = int64(mt)
-	}
-
 	params := anthropic.MessageNewParams{
 		Model:     anthropic.Model(model),
 		Messages:  anthropicMessages,
@@ -111,17 +263,157 @@ func buildClaudeParams(messages []Message, tools []ToolDefinition, model string,
 		params.System = system
 	}
 
-	if temp, ok := options["temperature"].(float64); ok {
+	// Thinking does not allow a custom temperature or a top_p below 0.95.
+	if temp, ok := options["temperature"].(float64); ok && !thinking {
 		params.Temperature = anthropic.Float(temp)
 	}
+	if topP, ok := options["top_p"].(float64); ok && (!thinking || topP >= 0.95) {
+		params.TopP = anthropic.Float(topP)
+	}
+	if stop := stringsOption(options, "stop"); len(stop) > 0 {
+		params.StopSequences = stop
+	}
+	if thinking {
+		// The budget counts toward max_tokens, which must stay above it.
+		params.Thinking = anthropic.ThinkingConfigParamOfEnabled(budget)
+		if params.MaxTokens <= budget {
+			params.MaxTokens = budget + maxTokens
+		}
+	}
+
+	// Claude returns structured output through a forced tool call. Thinking
+	// does not allow forcing tools; the caller validates the text instead.
+	forced := false
+	if schema := responseSchema(options); schema != nil && !thinking {
+		if def, ok := structuredOutputToolDef(schema); ok {
+			tools = append(tools[:len(tools):len(tools)], def)
+			forced = true
+		}
+	}
 
 	if len(tools) > 0 {
 		params.Tools = translateToolsForClaude(tools)
 	}
+	if forced {
+		if len(tools) == 1 {
+			params.ToolChoice = anthropic.ToolChoiceUnionParam{OfTool: &anthropic.ToolChoiceToolParam{Name: structuredOutputTool}}
+		} else {
+			params.ToolChoice = anthropic.ToolChoiceUnionParam{OfAny: &anthropic.ToolChoiceAnyParam{}}
+		}
+	}
+
+	if cache, ok := options["prompt_cache"].(bool); !ok || cache {
+		addClaudeCacheBreakpoints(&params)
+	}
 
 	return params, nil
 }
 
+// claudeSystemBlocks converts a system message to text blocks. A system
+// prompt split into parts keeps one block per part, so the stable prefix can
+// be cached apart from the parts that change between turns.
+func claudeSystemBlocks(msg Message) []anthropic.TextBlockParam {
+	if len(msg.Parts) == 0 {
+		return []anthropic.TextBlockParam{{Text: msg.Content}}
+	}
+	blocks := make([]anthropic.TextBlockParam, 0, len(msg.Parts))
+	for _, part := range msg.Parts {
+		if part.Type == PartText && part.Text != "" {
+			blocks = append(blocks, anthropic.TextBlockParam{Text: part.Text})
+		}
+	}
+	return blocks
+}
+
+// addClaudeCacheBreakpoints marks the prompt prefixes Claude should cache,
+// using all four breakpoints a request may carry: the tool definitions, the
+// first system block, and a rolling history prefix ending at the last
+// message and at the user message before it, where the previous request of
+// the conversation ended. Prefixes below the model's minimum cacheable
+// length are ignored by the API.
+func addClaudeCacheBreakpoints(params *anthropic.MessageNewParams) {
+	ephemeral := anthropic.NewCacheControlEphemeralParam()
+	if n := len(params.Tools); n > 0 {
+		if cc := params.Tools[n-1].GetCacheControl(); cc != nil {
+			*cc = ephemeral
+		}
+	}
+	if len(params.System) > 0 {
+		params.System[0].CacheControl = ephemeral
+	}
+
+	marked := 0
+	last := len(params.Messages) - 1
+	for i := last; i >= 0 && marked < 2; i-- {
+		msg := params.Messages[i]
+		if i != last && msg.Role != anthropic.MessageParamRoleUser {
+			continue
+		}
+		// Thinking blocks cannot carry a breakpoint, so use the last block that can.
+		for j := len(msg.Content) - 1; j >= 0; j-- {
+			if cc := msg.Content[j].GetCacheControl(); cc != nil {
+				*cc = ephemeral
+				marked++
+				break
+			}
+		}
+	}
+}
+
+// minThinkingBudget is the smallest thinking budget Claude accepts.
+const minThinkingBudget = 1024
+
+// claudeThinkingUsable reports whether thinking can be enabled for messages.
+// Claude rejects a tool-use continuation whose assistant turn has no
+// thinking blocks, e.g. when thinking was switched on in the middle of a run
+// or the turn came from another provider.
+func claudeThinkingUsable(messages []Message) bool {
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if msg.Role == "user" && msg.ToolCallID == "" {
+			return true
+		}
+		if msg.Role == "assistant" && len(msg.ToolCalls) > 0 {
+			return len(msg.Thinking) > 0
+		}
+	}
+	return true
+}
+
+// claudeThinkingBlocks converts stored thinking blocks back to params.
+func claudeThinkingBlocks(thinking []ThinkingBlock) []anthropic.ContentBlockParamUnion {
+	blocks := make([]anthropic.ContentBlockParamUnion, 0, len(thinking))
+	for _, t := range thinking {
+		if t.Redacted != "" {
+			blocks = append(blocks, anthropic.NewRedactedThinkingBlock(t.Redacted))
+		} else {
+			blocks = append(blocks, anthropic.NewThinkingBlock(t.Signature, t.Thinking))
+		}
+	}
+	return blocks
+}
+
+// claudeContentBlocks converts message parts to text and image blocks.
+func claudeContentBlocks(parts []ContentPart, images *imageLoader) []anthropic.ContentBlockParamUnion {
+	blocks := make([]anthropic.ContentBlockParamUnion, 0, len(parts))
+	for _, part := range parts {
+		if part.Type != PartImage {
+			blocks = append(blocks, anthropic.NewTextBlock(part.Text))
+			continue
+		}
+		img, note := images.load(part.Image)
+		switch {
+		case img == nil:
+			blocks = append(blocks, anthropic.NewTextBlock(note))
+		case img.URL != "":
+			blocks = append(blocks, anthropic.NewImageBlock(anthropic.URLImageSourceParam{URL: img.URL}))
+		default:
+			blocks = append(blocks, anthropic.NewImageBlockBase64(img.MediaType, img.Base64))
+		}
+	}
+	return blocks
+}
+
 func translateToolsForClaude(tools []ToolDefinition) []anthropic.ToolUnionParam {
 	result := make([]anthropic.ToolUnionParam, 0, len(tools))
 	for _, t := range tools {
@@ -150,6 +442,8 @@ func translateToolsForClaude(tools []ToolDefinition) []anthropic.ToolUnionParam
 
 func parseClaudeResponse(resp *anthropic.Message) *LLMResponse {
 	var content string
+	var reasoning []string
+	var thinking []ThinkingBlock
 	var toolCalls []ToolCall
 
 	for _, block := range resp.Content {
@@ -157,6 +451,11 @@ func parseClaudeResponse(resp *anthropic.Message) *LLMResponse {
 		case "text":
 			tb := block.AsText()
 			content += tb.Text
+		case "thinking":
+			reasoning = append(reasoning, block.Thinking)
+			thinking = append(thinking, ThinkingBlock{Thinking: block.Thinking, Signature: block.Signature})
+		case "redacted_thinking":
+			thinking = append(thinking, ThinkingBlock{Redacted: block.Data})
 		case "tool_use":
 			tu := block.AsToolUse()
 			var args map[string]interface{}
@@ -171,25 +470,37 @@ func parseClaudeResponse(resp *anthropic.Message) *LLMResponse {
 		}
 	}
 
-	finishReason := "stop"
-	switch resp.StopReason {
+	return &LLMResponse{
+		Content:      content,
+		Reasoning:    strings.Join(reasoning, "\n\n"),
+		ToolCalls:    toolCalls,
+		FinishReason: claudeFinishReason(resp.StopReason),
+		Usage:        claudeUsage(resp.Usage),
+		Thinking:     thinking,
+	}
+}
+
+func claudeFinishReason(reason anthropic.StopReason) string {
+	switch reason {
 	case anthropic.StopReasonToolUse:
-		finishReason = "tool_calls"
+		return "tool_calls"
 	case anthropic.StopReasonMaxTokens:
-		finishReason = "length"
-	case anthropic.StopReasonEndTurn:
-		finishReason = "stop"
+		return "length"
+	default:
+		return "stop"
 	}
+}
 
-	return &LLMResponse{
-		Content:      content,
-		ToolCalls:    toolCalls,
-		FinishReason: finishReason,
-		Usage: &UsageInfo{
-			PromptTokens:     int(resp.Usage.InputTokens),
-			CompletionTokens: int(resp.Usage.OutputTokens),
-			TotalTokens:      int(resp.Usage.InputTokens + resp.Usage.OutputTokens),
-		},
+// claudeUsage converts Anthropic usage. Anthropic reports cache reads and
+// writes separately from input_tokens, so they are added back into the prompt.
+func claudeUsage(u anthropic.Usage) *UsageInfo {
+	prompt := int(u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens)
+	return &UsageInfo{
+		PromptTokens:        prompt,
+		CompletionTokens:    int(u.OutputTokens),
+		TotalTokens:         prompt + int(u.OutputTokens),
+		CachedTokens:        int(u.CacheReadInputTokens),
+		CacheCreationTokens: int(u.CacheCreationInputTokens),
 	}
 }
 
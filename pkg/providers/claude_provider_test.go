@@ -122,6 +122,31 @@ func TestParseClaudeResponse_TextOnly(t *testing.T) {
 	}
 }
 
+func TestParseClaudeResponse_CacheUsage(t *testing.T) {
+	resp := &anthropic.Message{
+		Content: []anthropic.ContentBlockUnion{},
+		Usage: anthropic.Usage{
+			InputTokens:              10,
+			CacheReadInputTokens:     1000,
+			CacheCreationInputTokens: 200,
+			OutputTokens:             20,
+		},
+	}
+	result := parseClaudeResponse(resp)
+	if result.Usage.PromptTokens != 1210 {
+		t.Errorf("PromptTokens = %d, want 1210", result.Usage.PromptTokens)
+	}
+	if result.Usage.CachedTokens != 1000 {
+		t.Errorf("CachedTokens = %d, want 1000", result.Usage.CachedTokens)
+	}
+	if result.Usage.CacheCreationTokens != 200 {
+		t.Errorf("CacheCreationTokens = %d, want 200", result.Usage.CacheCreationTokens)
+	}
+	if result.Usage.TotalTokens != 1230 {
+		t.Errorf("TotalTokens = %d, want 1230", result.Usage.TotalTokens)
+	}
+}
+
 func TestParseClaudeResponse_StopReasons(t *testing.T) {
 	tests := []struct {
 		stopReason anthropic.StopReason
@@ -208,3 +233,310 @@ func createAnthropicTestClient(baseURL, token string) *anthropic.Client {
 	)
 	return &c
 }
+
+func TestClaudeProvider_ChatStreamText(t *testing.T) {
+	server := serveSSEFixture(t, "/v1/messages", "claude_stream_text.sse")
+
+	provider := NewClaudeProvider("test-token")
+	provider.client = createAnthropicTestClient(server.URL, "test-token")
+
+	ch, err := provider.ChatStream(t.Context(), []Message{{Role: "user", Content: "Hello"}}, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("ChatStream() error: %v", err)
+	}
+	res := collectStream(t, ch)
+
+	if res.Content != "Hello! How can I help?" {
+		t.Errorf("Content = %q", res.Content)
+	}
+	if !res.Done || res.FinishReason != "stop" {
+		t.Errorf("Done = %v, FinishReason = %q; want a stopped stream", res.Done, res.FinishReason)
+	}
+	if res.Usage == nil || res.Usage.PromptTokens != 15 || res.Usage.CompletionTokens != 9 {
+		t.Errorf("Usage = %+v, want 15 prompt / 9 completion tokens", res.Usage)
+	}
+}
+
+func TestClaudeProvider_ChatStreamToolUse(t *testing.T) {
+	server := serveSSEFixture(t, "/v1/messages", "claude_stream_tool_use.sse")
+
+	provider := NewClaudeProvider("test-token")
+	provider.client = createAnthropicTestClient(server.URL, "test-token")
+
+	ch, err := provider.ChatStream(t.Context(), []Message{{Role: "user", Content: "Weather and notes?"}}, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("ChatStream() error: %v", err)
+	}
+	res := collectStream(t, ch)
+
+	if res.Content != "Let me check both for you." {
+		t.Errorf("Content = %q", res.Content)
+	}
+	if len(res.ToolCalls) != 2 {
+		t.Fatalf("got %d tool calls, want 2: %+v", len(res.ToolCalls), res.ToolCalls)
+	}
+	if tc := res.ToolCalls[0]; tc.ID != "toolu_01T1x1fJ34qAmk2tNTrN7Up6" || tc.Name != "get_weather" || tc.Arguments["city"] != "San Francisco" {
+		t.Errorf("first call = %+v", tc)
+	}
+	if tc := res.ToolCalls[1]; tc.Name != "read_file" || tc.Arguments["path"] != "notes.txt" {
+		t.Errorf("second call = %+v", tc)
+	}
+	if res.FinishReason != "tool_calls" {
+		t.Errorf("FinishReason = %q, want tool_calls", res.FinishReason)
+	}
+	if u := res.Usage; u == nil || u.PromptTokens != 1496 || u.CachedTokens != 1024 || u.CompletionTokens != 89 {
+		t.Errorf("Usage = %+v, want 1496 prompt (1024 cached) / 89 completion tokens", u)
+	}
+}
+
+func TestClaudeProvider_ChatStreamRequestError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`))
+	}))
+	defer server.Close()
+
+	provider := NewClaudeProvider("bad-token")
+	provider.client = createAnthropicTestClient(server.URL, "bad-token")
+
+	if _, err := provider.ChatStream(t.Context(), []Message{{Role: "user", Content: "Hello"}}, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{}); err == nil {
+		t.Fatal("ChatStream() should return request errors before streaming")
+	} else if errorStatus(err) != http.StatusUnauthorized {
+		t.Errorf("error status = %d, want 401 (err: %v)", errorStatus(err), err)
+	}
+}
+
+func TestBuildClaudeParams_GenerationOptions(t *testing.T) {
+	params, err := buildClaudeParams([]Message{{Role: "user", Content: "Hi"}}, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{
+		"top_p": 0.8,
+		"stop":  []string{"END", "STOP"},
+	})
+	if err != nil {
+		t.Fatalf("buildClaudeParams() error: %v", err)
+	}
+	if params.TopP.Or(0) != 0.8 {
+		t.Errorf("TopP = %v, want 0.8", params.TopP)
+	}
+	if len(params.StopSequences) != 2 || params.StopSequences[0] != "END" {
+		t.Errorf("StopSequences = %v", params.StopSequences)
+	}
+}
+
+func TestBuildClaudeParams_Thinking(t *testing.T) {
+	messages := []Message{
+		{Role: "user", Content: "Weather in Paris?"},
+		{
+			Role: "assistant",
+			// Tool calls as the agent loop stores them, in the OpenAI wire shape.
+			ToolCalls: []ToolCall{{
+				ID:       "toolu_1",
+				Type:     "function",
+				Function: &FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`},
+			}},
+			Thinking: []ThinkingBlock{
+				{Thinking: "I should call the tool.", Signature: "sig"},
+				{Redacted: "opaque"},
+			},
+		},
+		{Role: "tool", Content: "18C", ToolCallID: "toolu_1"},
+	}
+	params, err := buildClaudeParams(messages, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{
+		"max_tokens":      4096,
+		"thinking_budget": 8000,
+		"temperature":     0.7,
+		"top_p":           0.5,
+	})
+	if err != nil {
+		t.Fatalf("buildClaudeParams() error: %v", err)
+	}
+	if params.Thinking.OfEnabled == nil || params.Thinking.OfEnabled.BudgetTokens != 8000 {
+		t.Fatalf("Thinking = %+v, want enabled with 8000 tokens", params.Thinking)
+	}
+	if params.MaxTokens != 12096 {
+		t.Errorf("MaxTokens = %d, want the budget plus max_tokens", params.MaxTokens)
+	}
+	if params.Temperature.Valid() || params.TopP.Valid() {
+		t.Errorf("Temperature = %v, TopP = %v; want both unset with thinking", params.Temperature, params.TopP)
+	}
+
+	blocks := params.Messages[1].Content
+	if len(blocks) != 3 {
+		t.Fatalf("assistant blocks = %d, want thinking, redacted_thinking, tool_use", len(blocks))
+	}
+	if b := blocks[0].OfThinking; b == nil || b.Signature != "sig" || b.Thinking != "I should call the tool." {
+		t.Errorf("block 0 = %+v, want the signed thinking block", blocks[0])
+	}
+	if b := blocks[1].OfRedactedThinking; b == nil || b.Data != "opaque" {
+		t.Errorf("block 1 = %+v, want the redacted thinking block", blocks[1])
+	}
+	if b := blocks[2].OfToolUse; b == nil || b.Name != "get_weather" {
+		t.Errorf("block 2 = %+v, want a get_weather tool_use", blocks[2])
+	}
+}
+
+func TestBuildClaudeParams_ThinkingNeedsBlocks(t *testing.T) {
+	// A tool-use turn without thinking blocks cannot be continued with thinking on.
+	messages := []Message{
+		{Role: "user", Content: "Weather?"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "toolu_1", Name: "get_weather"}}},
+		{Role: "tool", Content: "18C", ToolCallID: "toolu_1"},
+	}
+	params, err := buildClaudeParams(messages, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{"thinking_budget": 2048})
+	if err != nil {
+		t.Fatalf("buildClaudeParams() error: %v", err)
+	}
+	if params.Thinking.OfEnabled != nil {
+		t.Error("thinking should stay off for a continuation without thinking blocks")
+	}
+
+	// A new user turn starts fresh.
+	messages = append(messages, Message{Role: "assistant", Content: "18C"}, Message{Role: "user", Content: "Thanks"})
+	params, _ = buildClaudeParams(messages, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{"thinking_budget": 2048})
+	if params.Thinking.OfEnabled == nil {
+		t.Error("thinking should be enabled after a new user message")
+	}
+}
+
+func TestParseClaudeResponse_Thinking(t *testing.T) {
+	var resp anthropic.Message
+	if err := json.Unmarshal([]byte(`{
+		"content": [
+			{"type": "thinking", "thinking": "Let me think.", "signature": "sig"},
+			{"type": "redacted_thinking", "data": "opaque"},
+			{"type": "text", "text": "Done."}
+		],
+		"stop_reason": "end_turn",
+		"usage": {"input_tokens": 5, "output_tokens": 7}
+	}`), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	result := parseClaudeResponse(&resp)
+	if result.Content != "Done." || result.Reasoning != "Let me think." {
+		t.Errorf("Content = %q, Reasoning = %q", result.Content, result.Reasoning)
+	}
+	want := []ThinkingBlock{{Thinking: "Let me think.", Signature: "sig"}, {Redacted: "opaque"}}
+	if len(result.Thinking) != 2 || result.Thinking[0] != want[0] || result.Thinking[1] != want[1] {
+		t.Errorf("Thinking = %+v, want %+v", result.Thinking, want)
+	}
+}
+
+func TestClaudeProvider_ChatStreamThinking(t *testing.T) {
+	server := serveSSEFixture(t, "/v1/messages", "claude_stream_thinking.sse")
+
+	provider := NewClaudeProvider("test-token")
+	provider.client = createAnthropicTestClient(server.URL, "test-token")
+
+	ch, err := provider.ChatStream(t.Context(), []Message{{Role: "user", Content: "Weather in Paris?"}}, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{"thinking_budget": 2048})
+	if err != nil {
+		t.Fatalf("ChatStream() error: %v", err)
+	}
+	res := collectStream(t, ch)
+
+	if res.Content != "" {
+		t.Errorf("Content = %q, want the thinking kept out of it", res.Content)
+	}
+	if res.Reasoning != "The user wants the weather, so I should call the tool." {
+		t.Errorf("Reasoning = %q", res.Reasoning)
+	}
+	if len(res.Thinking) != 2 || res.Thinking[0].Signature != "EqQBCgIYAhIM1gbcDa9GJwZA2b3hGgxBdjrkzLoky3dl1pkiMOYds" || res.Thinking[1].Redacted == "" {
+		t.Errorf("Thinking = %+v, want a signed block and a redacted block", res.Thinking)
+	}
+	if len(res.ToolCalls) != 1 || res.ToolCalls[0].Arguments["city"] != "Paris" {
+		t.Errorf("ToolCalls = %+v", res.ToolCalls)
+	}
+}
+
+func TestBuildClaudeParams_CacheBreakpoints(t *testing.T) {
+	messages := []Message{
+		{
+			Role:    "system",
+			Content: "stable\n\n---\n\nturn",
+			Parts:   []ContentPart{TextPart("stable"), TextPart("turn")},
+		},
+		{Role: "user", Content: "first"},
+		{Role: "assistant", Content: "reply"},
+		{Role: "user", Content: "weather?"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "toolu_1", Name: "get_weather"}}},
+		{Role: "tool", Content: "18C", ToolCallID: "toolu_1"},
+	}
+	tools := []ToolDefinition{
+		{Type: "function", Function: ToolFunctionDefinition{Name: "get_weather", Parameters: map[string]interface{}{"type": "object"}}},
+		{Type: "function", Function: ToolFunctionDefinition{Name: "read_file", Parameters: map[string]interface{}{"type": "object"}}},
+	}
+	params, err := buildClaudeParams(messages, tools, "claude-sonnet-4-5-20250929", nil)
+	if err != nil {
+		t.Fatalf("buildClaudeParams() error: %v", err)
+	}
+
+	cached := func(cc *anthropic.CacheControlEphemeralParam) bool { return cc != nil && cc.Type == "ephemeral" }
+	if cached(params.Tools[0].GetCacheControl()) || !cached(params.Tools[1].GetCacheControl()) {
+		t.Error("want a breakpoint on the last tool only")
+	}
+	if len(params.System) != 2 || !cached(&params.System[0].CacheControl) || cached(&params.System[1].CacheControl) {
+		t.Errorf("System = %+v, want the stable block cached and the per-turn block not", params.System)
+	}
+
+	var marked []int
+	for i, msg := range params.Messages {
+		for _, block := range msg.Content {
+			if cached(block.GetCacheControl()) {
+				marked = append(marked, i)
+			}
+		}
+	}
+	// The tool result and the user message before it.
+	if len(marked) != 2 || marked[0] != 2 || marked[1] != 4 {
+		t.Errorf("history breakpoints on messages %v, want [2 4]", marked)
+	}
+
+	params, _ = buildClaudeParams(messages, tools, "claude-sonnet-4-5-20250929", map[string]interface{}{"prompt_cache": false})
+	if cached(params.Tools[1].GetCacheControl()) || cached(&params.System[0].CacheControl) {
+		t.Error("prompt_cache=false should disable breakpoints")
+	}
+}
+
+func TestBuildClaudeParams_ResponseSchema(t *testing.T) {
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"city": map[string]interface{}{"type": "string"}},
+		"required":   []interface{}{"city"},
+	}
+	params, err := buildClaudeParams([]Message{{Role: "user", Content: "Hi"}}, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{"response_schema": schema})
+	if err != nil {
+		t.Fatalf("buildClaudeParams() error: %v", err)
+	}
+	if len(params.Tools) != 1 || params.Tools[0].OfTool.Name != structuredOutputTool {
+		t.Fatalf("Tools = %+v, want only the structured output tool", params.Tools)
+	}
+	if params.ToolChoice.OfTool == nil || params.ToolChoice.OfTool.Name != structuredOutputTool {
+		t.Errorf("ToolChoice = %+v, want the structured output tool forced", params.ToolChoice)
+	}
+
+	// With other tools the model may still call them first.
+	tools := []ToolDefinition{{Type: "function", Function: ToolFunctionDefinition{Name: "get_weather", Parameters: map[string]interface{}{"type": "object"}}}}
+	params, _ = buildClaudeParams([]Message{{Role: "user", Content: "Hi"}}, tools, "claude-sonnet-4-5-20250929", map[string]interface{}{"response_schema": schema})
+	if len(params.Tools) != 2 || params.ToolChoice.OfAny == nil {
+		t.Errorf("Tools = %d, ToolChoice = %+v; want both tools and any", len(params.Tools), params.ToolChoice)
+	}
+}
+
+func TestTakeStructuredOutput(t *testing.T) {
+	resp := &LLMResponse{
+		FinishReason: "tool_calls",
+		ToolCalls:    []ToolCall{{ID: "toolu_1", Name: structuredOutputTool, Arguments: map[string]interface{}{"city": "Paris"}}},
+	}
+	takeStructuredOutput(resp)
+	if resp.Content != `{"city":"Paris"}` || len(resp.ToolCalls) != 0 || resp.FinishReason != "stop" {
+		t.Fatalf("resp = %+v, want the arguments as content", resp)
+	}
+
+	resp = &LLMResponse{ToolCalls: []ToolCall{
+		{ID: "toolu_1", Name: "get_weather"},
+		{ID: "toolu_2", Name: structuredOutputTool, Arguments: map[string]interface{}{}},
+	}}
+	takeStructuredOutput(resp)
+	if resp.Content != "" || len(resp.ToolCalls) != 1 || resp.ToolCalls[0].Name != "get_weather" {
+		t.Fatalf("resp = %+v, want the other tool call kept", resp)
+	}
+}
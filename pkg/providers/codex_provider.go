@@ -9,7 +9,9 @@ import (
 	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/option"
 	"github.com/openai/openai-go/v3/responses"
+	"github.com/openai/openai-go/v3/shared"
 	"github.com/sipeed/kakoclaw/pkg/auth"
+	"github.com/sipeed/kakoclaw/pkg/logger"
 )
 
 type CodexProvider struct {
@@ -62,6 +64,124 @@ func (p *CodexProvider) Chat(ctx context.Context, messages []Message, tools []To
 	return parseCodexResponse(resp), nil
 }
 
+// ChatStream implements StreamingLLMProvider using Responses API streaming.
+func (p *CodexProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (<-chan StreamChunk, error) {
+	var opts []option.RequestOption
+	if p.tokenSource != nil {
+		tok, accID, err := p.tokenSource()
+		if err != nil {
+			return nil, fmt.Errorf("refreshing token: %w", err)
+		}
+		opts = append(opts, option.WithAPIKey(tok))
+		if accID != "" {
+			opts = append(opts, option.WithHeader("Chatgpt-Account-Id", accID))
+		}
+	}
+
+	params := buildCodexParams(messages, tools, model, options)
+
+	stream := p.client.Responses.NewStreaming(ctx, params, opts...)
+	// Request errors (auth, rate limits, ...) are known before the first event.
+	if err := stream.Err(); err != nil {
+		stream.Close()
+		return nil, fmt.Errorf("codex API call: %w", err)
+	}
+
+	ch := make(chan StreamChunk, 64)
+
+	go func() {
+		defer close(ch)
+		defer stream.Close()
+
+		send := func(chunk StreamChunk) bool {
+			select {
+			case ch <- chunk:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		final := StreamChunk{Done: true, FinishReason: "stop"}
+		sawToolCall := false
+		summaryParts := 0
+		for stream.Next() {
+			event := stream.Current()
+			switch event.Type {
+			case "response.output_text.delta":
+				if event.Delta != "" && !send(StreamChunk{Content: event.Delta}) {
+					return
+				}
+
+			case "response.reasoning_summary_text.delta":
+				if event.Delta != "" && !send(StreamChunk{Reasoning: event.Delta}) {
+					return
+				}
+
+			case "response.reasoning_summary_part.added":
+				// Separate summary parts like the non-streaming response does.
+				summaryParts++
+				if summaryParts > 1 && !send(StreamChunk{Reasoning: "\n\n"}) {
+					return
+				}
+
+			case "response.output_item.added":
+				if event.Item.Type == "function_call" {
+					sawToolCall = true
+					if !send(StreamChunk{ToolCalls: []ToolCall{{
+						ID:    event.Item.CallID,
+						Type:  "function",
+						Name:  event.Item.Name,
+						Index: int(event.OutputIndex),
+					}}}) {
+						return
+					}
+				}
+
+			case "response.function_call_arguments.delta":
+				if event.Delta != "" && !send(StreamChunk{ToolCalls: []ToolCall{{
+					Index:    int(event.OutputIndex),
+					Function: &FunctionCall{Arguments: event.Delta},
+				}}}) {
+					return
+				}
+
+			case "response.completed", "response.incomplete":
+				final.Usage = codexUsage(event.Response.Usage)
+				if event.Type == "response.incomplete" {
+					final.FinishReason = "length"
+				}
+
+			case "response.failed", "error":
+				msg := event.Message
+				if msg == "" {
+					msg = event.Response.Error.Message
+				}
+				logger.ErrorCF("provider", "Codex stream failed", map[string]interface{}{
+					"model": model,
+					"error": msg,
+				})
+				final.FinishReason = "error"
+			}
+		}
+
+		if err := stream.Err(); err != nil {
+			logger.ErrorCF("provider", "Codex stream ended with an error", map[string]interface{}{
+				"model": model,
+				"error": err.Error(),
+			})
+			final.FinishReason = "error"
+		}
+		if sawToolCall && final.FinishReason == "stop" {
+			final.FinishReason = "tool_calls"
+		}
+
+		send(final)
+	}()
+
+	return ch, nil
+}
+
 func (p *CodexProvider) GetDefaultModel() string {
 	return "gpt-4o"
 }
@@ -69,6 +189,7 @@ func (p *CodexProvider) GetDefaultModel() string {
 func buildCodexParams(messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) responses.ResponseNewParams {
 	var inputItems responses.ResponseInputParam
 	var instructions string
+	var images *imageLoader
 
 	for _, msg := range messages {
 		switch msg.Role {
@@ -82,6 +203,17 @@ func buildCodexParams(messages []Message, tools []ToolDefinition, model string,
 						Output: responses.ResponseInputItemFunctionCallOutputOutputUnionParam{OfString: openai.Opt(msg.Content)},
 					},
 				})
+			} else if len(msg.Parts) > 0 {
+				if images == nil {
+					// URLs are passed through, so the loader never does network I/O here.
+					images = newImageLoader(context.Background(), model, options, false)
+				}
+				inputItems = append(inputItems, responses.ResponseInputItemUnionParam{
+					OfMessage: &responses.EasyInputMessageParam{
+						Role:    responses.EasyInputMessageRoleUser,
+						Content: responses.EasyInputMessageContentUnionParam{OfInputItemContentList: codexContentList(msg.Parts, images)},
+					},
+				})
 			} else {
 				inputItems = append(inputItems, responses.ResponseInputItemUnionParam{
 					OfMessage: &responses.EasyInputMessageParam{
@@ -101,11 +233,12 @@ func buildCodexParams(messages []Message, tools []ToolDefinition, model string,
 					})
 				}
 				for _, tc := range msg.ToolCalls {
-					argsJSON, _ := json.Marshal(tc.Arguments)
+					name, args := toolCallNameArgs(tc)
+					argsJSON, _ := json.Marshal(args)
 					inputItems = append(inputItems, responses.ResponseInputItemUnionParam{
 						OfFunctionCall: &responses.ResponseFunctionToolCallParam{
 							CallID:    tc.ID,
-							Name:      tc.Name,
+							Name:      name,
 							Arguments: string(argsJSON),
 						},
 					})
@@ -147,6 +280,22 @@ func buildCodexParams(messages []Message, tools []ToolDefinition, model string,
 	if temp, ok := options["temperature"].(float64); ok {
 		params.Temperature = openai.Opt(temp)
 	}
+	if topP, ok := options["top_p"].(float64); ok {
+		params.TopP = openai.Opt(topP)
+	}
+	if effort, ok := options["reasoning_effort"].(string); ok && effort != "" {
+		// Ask for a reasoning summary; the raw reasoning is never returned.
+		params.Reasoning = shared.ReasoningParam{
+			Effort:  shared.ReasoningEffort(effort),
+			Summary: shared.ReasoningSummaryAuto,
+		}
+	}
+
+	if schema := responseSchema(options); schema != nil {
+		params.Text = responses.ResponseTextConfigParam{
+			Format: responses.ResponseFormatTextConfigParamOfJSONSchema("response", schema),
+		}
+	}
 
 	if len(tools) > 0 {
 		params.Tools = translateToolsForCodex(tools)
@@ -155,6 +304,31 @@ func buildCodexParams(messages []Message, tools []ToolDefinition, model string,
 	return params
 }
 
+// codexContentList converts message parts to input_text and input_image items.
+func codexContentList(parts []ContentPart, images *imageLoader) responses.ResponseInputMessageContentListParam {
+	list := make(responses.ResponseInputMessageContentListParam, 0, len(parts))
+	for _, part := range parts {
+		text := part.Text
+		if part.Type == PartImage {
+			img, note := images.load(part.Image)
+			if img != nil {
+				list = append(list, responses.ResponseInputContentUnionParam{
+					OfInputImage: &responses.ResponseInputImageParam{
+						Detail:   responses.ResponseInputImageDetailAuto,
+						ImageURL: openai.Opt(img.DataURL()),
+					},
+				})
+				continue
+			}
+			text = note
+		}
+		list = append(list, responses.ResponseInputContentUnionParam{
+			OfInputText: &responses.ResponseInputTextParam{Text: text},
+		})
+	}
+	return list
+}
+
 func translateToolsForCodex(tools []ToolDefinition) []responses.ToolUnionParam {
 	result := make([]responses.ToolUnionParam, 0, len(tools))
 	for _, t := range tools {
@@ -173,10 +347,15 @@ func translateToolsForCodex(tools []ToolDefinition) []responses.ToolUnionParam {
 
 func parseCodexResponse(resp *responses.Response) *LLMResponse {
 	var content strings.Builder
+	var reasoning []string
 	var toolCalls []ToolCall
 
 	for _, item := range resp.Output {
 		switch item.Type {
+		case "reasoning":
+			for _, s := range item.Summary {
+				reasoning = append(reasoning, s.Text)
+			}
 		case "message":
 			for _, c := range item.Content {
 				if c.Type == "output_text" {
@@ -204,20 +383,25 @@ func parseCodexResponse(resp *responses.Response) *LLMResponse {
 		finishReason = "length"
 	}
 
-	var usage *UsageInfo
-	if resp.Usage.TotalTokens > 0 {
-		usage = &UsageInfo{
-			PromptTokens:     int(resp.Usage.InputTokens),
-			CompletionTokens: int(resp.Usage.OutputTokens),
-			TotalTokens:      int(resp.Usage.TotalTokens),
-		}
-	}
-
 	return &LLMResponse{
 		Content:      content.String(),
+		Reasoning:    strings.Join(reasoning, "\n\n"),
 		ToolCalls:    toolCalls,
 		FinishReason: finishReason,
-		Usage:        usage,
+		Usage:        codexUsage(resp.Usage),
+	}
+}
+
+// codexUsage converts Responses API usage; nil when the response carried none.
+func codexUsage(u responses.ResponseUsage) *UsageInfo {
+	if u.TotalTokens == 0 {
+		return nil
+	}
+	return &UsageInfo{
+		PromptTokens:     int(u.InputTokens),
+		CompletionTokens: int(u.OutputTokens),
+		TotalTokens:      int(u.TotalTokens),
+		CachedTokens:     int(u.InputTokensDetails.CachedTokens),
 	}
 }
 
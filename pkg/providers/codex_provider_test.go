@@ -262,3 +262,100 @@ func createOpenAITestClient(baseURL, token, accountID string) *openai.Client {
 	c := openai.NewClient(opts...)
 	return &c
 }
+
+func TestCodexProvider_ChatStreamToolCalls(t *testing.T) {
+	server := serveSSEFixture(t, "/responses", "codex_stream_tool_call.sse")
+
+	provider := NewCodexProvider("test-token", "acc-123")
+	provider.client = createOpenAITestClient(server.URL, "test-token", "acc-123")
+
+	ch, err := provider.ChatStream(t.Context(), []Message{{Role: "user", Content: "Weather in Paris?"}}, nil, "gpt-5-codex", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("ChatStream() error: %v", err)
+	}
+	res := collectStream(t, ch)
+
+	if res.Content != "Checking now." {
+		t.Errorf("Content = %q", res.Content)
+	}
+	if len(res.ToolCalls) != 2 {
+		t.Fatalf("got %d tool calls, want 2: %+v", len(res.ToolCalls), res.ToolCalls)
+	}
+	if tc := res.ToolCalls[0]; tc.ID != "call_weather" || tc.Name != "get_weather" || tc.Arguments["city"] != "Paris" {
+		t.Errorf("first call = %+v", tc)
+	}
+	if tc := res.ToolCalls[1]; tc.ID != "call_time" || tc.Arguments["tz"] != "Europe/Paris" {
+		t.Errorf("second call = %+v", tc)
+	}
+	if res.FinishReason != "tool_calls" {
+		t.Errorf("FinishReason = %q, want tool_calls", res.FinishReason)
+	}
+	if u := res.Usage; u == nil || u.PromptTokens != 120 || u.CachedTokens != 64 || u.CompletionTokens != 35 {
+		t.Errorf("Usage = %+v, want 120 prompt (64 cached) / 35 completion tokens", u)
+	}
+}
+
+func TestBuildCodexParams_GenerationOptions(t *testing.T) {
+	params := buildCodexParams([]Message{{Role: "user", Content: "Hi"}}, nil, "o3", map[string]interface{}{
+		"top_p":            0.5,
+		"reasoning_effort": "high",
+	})
+	if params.TopP.Or(0) != 0.5 {
+		t.Errorf("TopP = %v, want 0.5", params.TopP)
+	}
+	if params.Reasoning.Effort != "high" {
+		t.Errorf("Reasoning.Effort = %q, want high", params.Reasoning.Effort)
+	}
+	if params.Reasoning.Summary != "auto" {
+		t.Errorf("Reasoning.Summary = %q, want auto", params.Reasoning.Summary)
+	}
+}
+
+func TestBuildCodexParams_ResponseSchema(t *testing.T) {
+	schema := map[string]interface{}{"type": "object"}
+	params := buildCodexParams([]Message{{Role: "user", Content: "Hi"}}, nil, "gpt-5", map[string]interface{}{
+		"response_schema": schema,
+	})
+	format := params.Text.Format.OfJSONSchema
+	if format == nil || format.Name != "response" {
+		t.Fatalf("Text.Format = %+v, want a json_schema format", params.Text.Format)
+	}
+}
+
+func TestParseCodexResponse_ReasoningSummary(t *testing.T) {
+	respJSON := `{
+		"id": "resp_reason",
+		"object": "response",
+		"status": "completed",
+		"output": [
+			{
+				"id": "rs_1",
+				"type": "reasoning",
+				"summary": [
+					{"type": "summary_text", "text": "Checked the math."},
+					{"type": "summary_text", "text": "Answer is 4."}
+				]
+			},
+			{
+				"id": "msg_1",
+				"type": "message",
+				"role": "assistant",
+				"status": "completed",
+				"content": [{"type": "output_text", "text": "4"}]
+			}
+		]
+	}`
+
+	var resp responses.Response
+	if err := json.Unmarshal([]byte(respJSON), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	result := parseCodexResponse(&resp)
+	if result.Content != "4" {
+		t.Errorf("Content = %q, want 4", result.Content)
+	}
+	if result.Reasoning != "Checked the math.\n\nAnswer is 4." {
+		t.Errorf("Reasoning = %q", result.Reasoning)
+	}
+}
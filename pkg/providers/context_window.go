@@ -0,0 +1,136 @@
+package providers
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+)
+
+// DefaultContextWindow is assumed for models the registry does not know.
+const DefaultContextWindow = 32768
+
+// knownContextWindows maps model name prefixes to context window sizes in
+// tokens. The longest matching prefix wins.
+var knownContextWindows = map[string]int{
+	"gpt-3.5-turbo":    16385,
+	"gpt-4":            8192,
+	"gpt-4-turbo":      128000,
+	"gpt-4o":           128000,
+	"gpt-4.1":          1047576,
+	"gpt-5":            400000,
+	"o1":               200000,
+	"o3":               200000,
+	"o4":               200000,
+	"claude":           200000,
+	"gemini":           1048576,
+	"gemini-1.5-pro":   2097152,
+	"glm-4":            128000,
+	"glm-4.6":          200000,
+	"glm-4.7":          200000,
+	"kimi-k2":          262144,
+	"moonshot-v1-8k":   8192,
+	"moonshot-v1-32k":  32768,
+	"moonshot-v1-128k": 131072,
+	"deepseek":         128000,
+	"llama3":           8192,
+	"llama-3":          8192,
+	"llama3.1":         131072,
+	"llama-3.1":        131072,
+	"llama3.2":         131072,
+	"llama-3.2":        131072,
+	"llama3.3":         131072,
+	"llama-3.3":        131072,
+	"qwen":             32768,
+	"qwen3":            131072,
+	"mistral":          32768,
+	"mixtral":          32768,
+	"llama-4":          1048576,
+	"nemotron":         131072,
+	"grok":             131072,
+	"grok-4":           256000,
+	"command-r":        128000,
+	"phi3":             4096,
+	"phi4":             16384,
+	"gemma":            8192,
+	"gemma3":           131072,
+	"gpt-oss":          131072,
+}
+
+// ContextWindows resolves a model's context window from configured
+// overrides and the built-in table.
+type ContextWindows struct {
+	exact map[string]int
+	globs []string // overrides containing wildcards, most specific first
+	table map[string]int
+}
+
+// NewContextWindows collects the context_windows overrides of every
+// configured provider. Keys are model names or path.Match globs.
+func NewContextWindows(cfg *config.Config) *ContextWindows {
+	w := &ContextWindows{exact: map[string]int{}, table: map[string]int{}}
+	if cfg == nil {
+		return w
+	}
+	p := cfg.Providers
+	for _, pc := range []config.ProviderConfig{
+		p.Anthropic, p.OpenAI, p.OpenRouter, p.Groq, p.Zhipu,
+		p.VLLM, p.Gemini, p.Nvidia, p.Moonshot, p.Ollama,
+	} {
+		for key, size := range pc.ContextWindows {
+			key = strings.ToLower(key)
+			if size <= 0 {
+				continue
+			}
+			if _, dup := w.table[key]; dup {
+				continue
+			}
+			w.table[key] = size
+			if strings.ContainsAny(key, "*?[") {
+				w.globs = append(w.globs, key)
+			} else {
+				w.exact[key] = size
+			}
+		}
+	}
+	sort.Slice(w.globs, func(i, j int) bool {
+		if len(w.globs[i]) != len(w.globs[j]) {
+			return len(w.globs[i]) > len(w.globs[j])
+		}
+		return w.globs[i] < w.globs[j]
+	})
+	return w
+}
+
+// Lookup returns the context window for model in tokens.
+func (w *ContextWindows) Lookup(model string) int {
+	model = strings.ToLower(model)
+	bare := model
+	if idx := strings.LastIndex(model, "/"); idx >= 0 {
+		bare = model[idx+1:]
+	}
+
+	if w != nil {
+		for _, name := range []string{model, bare} {
+			if size, ok := w.exact[name]; ok {
+				return size
+			}
+		}
+		for _, glob := range w.globs {
+			for _, name := range []string{model, bare} {
+				if ok, _ := path.Match(glob, name); ok {
+					return w.table[glob]
+				}
+			}
+		}
+	}
+
+	best, size := 0, DefaultContextWindow
+	for prefix, n := range knownContextWindows {
+		if len(prefix) > best && (strings.HasPrefix(model, prefix) || strings.HasPrefix(bare, prefix)) {
+			best, size = len(prefix), n
+		}
+	}
+	return size
+}
@@ -0,0 +1,29 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+)
+
+func TestContextWindowsLookup(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Providers.Ollama.ContextWindows = map[string]int{"llama3.1:*": 16384, "my-model": 4096}
+	w := NewContextWindows(cfg)
+
+	tests := map[string]int{
+		"claude-sonnet-4-5-20250929": 200000,
+		"openrouter/openai/gpt-4o":   128000,
+		"gpt-4":                      8192,
+		"gpt-4-turbo":                128000,
+		"llama3.1:8b":                16384, // glob override
+		"my-model":                   4096,  // exact override
+		"llama3.1":                   131072,
+		"something-unknown":          DefaultContextWindow,
+	}
+	for model, want := range tests {
+		if got := w.Lookup(model); got != want {
+			t.Errorf("Lookup(%q) = %d, want %d", model, got, want)
+		}
+	}
+}
@@ -0,0 +1,480 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go/v3"
+
+	"github.com/sipeed/kakoclaw/pkg/logger"
+	"github.com/sipeed/kakoclaw/pkg/observability"
+)
+
+// Backend is one provider in a failover chain.
+type Backend struct {
+	Name     string // label for logs and metrics, e.g. "anthropic"
+	Provider LLMProvider
+	Model    string // model to request; empty uses the caller's model
+}
+
+// FailoverOptions tunes retries and circuit breaking. Zero values fall back
+// to the defaults below.
+type FailoverOptions struct {
+	MaxRetries       int           // retries per backend for transient errors
+	InitialBackoff   time.Duration // first retry delay, doubled each attempt
+	MaxBackoff       time.Duration // cap on a single delay, including Retry-After
+	BreakerThreshold int           // consecutive failed calls that open the circuit
+	BreakerCooldown  time.Duration // how long an open circuit rejects calls
+}
+
+const (
+	defaultInitialBackoff   = 500 * time.Millisecond
+	defaultMaxBackoff       = 30 * time.Second
+	defaultBreakerThreshold = 3
+	defaultBreakerCooldown  = time.Minute
+)
+
+// FailoverProvider tries an ordered list of backends. Transient errors
+// (rate limits, 5xx, timeouts) are retried with exponential backoff before
+// moving to the next backend, and a backend that keeps failing has its
+// circuit opened so later calls skip it until the cooldown expires.
+type FailoverProvider struct {
+	backends []*failoverBackend
+	opts     FailoverOptions
+
+	// Overridable in tests.
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewFailoverProvider creates a failover provider. The first backend is the
+// primary and supplies the default model.
+func NewFailoverProvider(backends []Backend, opts FailoverOptions) *FailoverProvider {
+	if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = defaultInitialBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultMaxBackoff
+	}
+	if opts.BreakerThreshold <= 0 {
+		opts.BreakerThreshold = defaultBreakerThreshold
+	}
+	if opts.BreakerCooldown <= 0 {
+		opts.BreakerCooldown = defaultBreakerCooldown
+	}
+
+	p := &FailoverProvider{
+		opts:  opts,
+		now:   time.Now,
+		sleep: sleepContext,
+	}
+	for _, b := range backends {
+		p.backends = append(p.backends, &failoverBackend{Backend: b})
+	}
+	return p
+}
+
+func (p *FailoverProvider) GetDefaultModel() string {
+	if len(p.backends) == 0 {
+		return ""
+	}
+	return p.backends[0].Provider.GetDefaultModel()
+}
+
+func (p *FailoverProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	var resp *LLMResponse
+	err := p.run(ctx, model, func(b *failoverBackend, model string) error {
+		r, err := b.Provider.Chat(ctx, messages, tools, model, options)
+		if err != nil {
+			return err
+		}
+		if r.Model == "" {
+			r.Model = model
+		}
+		resp = r
+		return nil
+	})
+	return resp, err
+}
+
+// ChatStream streams from the first backend that accepts the request.
+// Failover happens only while opening the stream: once tokens have been
+// delivered the response cannot be switched to another backend. Backends
+// without streaming support are called with Chat and replayed as a stream.
+func (p *FailoverProvider) ChatStream(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (<-chan StreamChunk, error) {
+	var ch <-chan StreamChunk
+	err := p.run(ctx, model, func(b *failoverBackend, model string) error {
+		if sp, ok := b.Provider.(StreamingLLMProvider); ok {
+			c, err := sp.ChatStream(ctx, messages, tools, model, options)
+			if err != nil {
+				return err
+			}
+			ch = labelStream(ctx, c, model)
+			return nil
+		}
+		r, err := b.Provider.Chat(ctx, messages, tools, model, options)
+		if err != nil {
+			return err
+		}
+		if r.Model == "" {
+			r.Model = model
+		}
+		ch = responseStream(r)
+		return nil
+	})
+	return ch, err
+}
+
+// responseStream replays a complete response as a stream.
+func responseStream(resp *LLMResponse) <-chan StreamChunk {
+	ch := make(chan StreamChunk, len(resp.ToolCalls)+3)
+	if resp.Reasoning != "" {
+		ch <- StreamChunk{Reasoning: resp.Reasoning}
+	}
+	if resp.Content != "" {
+		ch <- StreamChunk{Content: resp.Content}
+	}
+	for i, tc := range resp.ToolCalls {
+		tc.Index = i
+		ch <- StreamChunk{ToolCalls: []ToolCall{tc}}
+	}
+	ch <- StreamChunk{Done: true, FinishReason: resp.FinishReason, Usage: resp.Usage, Thinking: resp.Thinking, Model: resp.Model}
+	close(ch)
+	return ch
+}
+
+// labelStream relays a stream, marking its chunks with the model that
+// serves it unless the backend already did.
+func labelStream(ctx context.Context, in <-chan StreamChunk, model string) <-chan StreamChunk {
+	out := make(chan StreamChunk)
+	go func() {
+		defer close(out)
+		for chunk := range in {
+			if chunk.Model == "" {
+				chunk.Model = model
+			}
+			select {
+			case out <- chunk:
+			case <-ctx.Done():
+				// Let the backend finish without a reader.
+				for range in {
+				}
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// run calls each available backend in order until one succeeds.
+func (p *FailoverProvider) run(ctx context.Context, model string, call func(b *failoverBackend, model string) error) error {
+	if len(p.backends) == 0 {
+		return fmt.Errorf("no LLM providers configured")
+	}
+
+	var lastErr error
+	var failed *failoverBackend
+	candidates, forced := p.candidates()
+	for _, b := range candidates {
+		probe := false
+		if !forced {
+			var ok bool
+			if ok, probe = b.acquire(p.now()); !ok {
+				continue
+			}
+		}
+		if failed != nil {
+			logger.WarnCF("provider", "Failing over to next provider", map[string]interface{}{
+				"from":  failed.Name,
+				"to":    b.Name,
+				"error": lastErr.Error(),
+			})
+			observability.Global().RecordLLMFailover(failed.Name, b.Name, lastErr)
+		}
+
+		err := p.callWithRetry(ctx, b, b.model(model), call)
+		if probe {
+			b.release()
+		}
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || classifyError(err) == errClassFatal {
+			return err
+		}
+		lastErr, failed = err, b
+	}
+
+	if failed == nil {
+		return fmt.Errorf("all providers are unavailable (circuit open)")
+	}
+	if len(p.backends) == 1 {
+		return lastErr
+	}
+	return fmt.Errorf("all %d providers failed, last error from %s: %w", len(p.backends), failed.Name, lastErr)
+}
+
+// candidates returns the backends to try, in order. When every circuit is
+// open, the one closest to the end of its cooldown is returned with forced
+// set, so a call never fails without reaching some backend.
+func (p *FailoverProvider) candidates() ([]*failoverBackend, bool) {
+	now := p.now()
+	for _, b := range p.backends {
+		if b.allows(now) {
+			return p.backends, false
+		}
+	}
+
+	soonest := p.backends[0]
+	for _, b := range p.backends[1:] {
+		if b.openedUntil().Before(soonest.openedUntil()) {
+			soonest = b
+		}
+	}
+	return []*failoverBackend{soonest}, true
+}
+
+// callWithRetry calls one backend, retrying transient errors with backoff.
+func (p *FailoverProvider) callWithRetry(ctx context.Context, b *failoverBackend, model string, call func(b *failoverBackend, model string) error) error {
+	for attempt := 0; ; attempt++ {
+		err := call(b, model)
+		if err == nil {
+			b.succeeded()
+			return nil
+		}
+		if ctx.Err() != nil {
+			// The caller gave up; that says nothing about the backend.
+			return err
+		}
+
+		class := classifyError(err)
+		if class == errClassFatal {
+			// The request itself was rejected; the backend is healthy.
+			return err
+		}
+		if class != errClassRetryable || attempt >= p.opts.MaxRetries {
+			p.recordFailure(b, err)
+			return err
+		}
+
+		wait, ok := p.backoff(attempt, errorRetryAfter(err))
+		if !ok {
+			// Asked to wait longer than we are willing to: move on instead.
+			p.recordFailure(b, err)
+			return err
+		}
+
+		logger.WarnCF("provider", "LLM call failed, retrying", map[string]interface{}{
+			"provider": b.Name,
+			"model":    model,
+			"attempt":  attempt + 1,
+			"wait_ms":  wait.Milliseconds(),
+			"error":    err.Error(),
+		})
+		observability.Global().RecordLLMRetry()
+
+		if err := p.sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *FailoverProvider) recordFailure(b *failoverBackend, err error) {
+	if b.failed(p.now(), p.opts.BreakerThreshold, p.opts.BreakerCooldown) {
+		logger.WarnCF("provider", "Circuit opened for provider", map[string]interface{}{
+			"provider":    b.Name,
+			"cooldown_ms": p.opts.BreakerCooldown.Milliseconds(),
+			"error":       err.Error(),
+		})
+		observability.Global().RecordCircuitOpen(b.Name, err)
+	}
+}
+
+// backoff returns the delay before retry attempt+1. A Retry-After hint is
+// honoured as long as it is within MaxBackoff; ok is false when it is not.
+func (p *FailoverProvider) backoff(attempt int, retryAfter time.Duration) (time.Duration, bool) {
+	if retryAfter > 0 {
+		if retryAfter > p.opts.MaxBackoff {
+			return 0, false
+		}
+		return retryAfter, true
+	}
+
+	d := p.opts.InitialBackoff << uint(attempt)
+	if d <= 0 || d > p.opts.MaxBackoff {
+		d = p.opts.MaxBackoff
+	}
+	// Full jitter on the upper half keeps concurrent sessions from retrying in lockstep.
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1)), true
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// failoverBackend tracks circuit breaker state for one backend. The circuit
+// opens after BreakerThreshold consecutive failed calls; once the cooldown
+// expires a single probe call is let through, which closes the circuit on
+// success or reopens it on failure.
+type failoverBackend struct {
+	Backend
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *failoverBackend) model(requested string) string {
+	if b.Model != "" {
+		return b.Model
+	}
+	return requested
+}
+
+// allows reports whether the circuit would let a call through.
+func (b *failoverBackend) allows(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.openUntil.IsZero() || (!now.Before(b.openUntil) && !b.probing)
+}
+
+// acquire is allows for a call about to be made. Past the cooldown it takes
+// the single probe slot and reports probe; the caller must release it.
+func (b *failoverBackend) acquire(now time.Time) (ok, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openUntil.IsZero() {
+		return true, false
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false, false
+	}
+	b.probing = true
+	return true, true
+}
+
+func (b *failoverBackend) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *failoverBackend) openedUntil() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.openUntil
+}
+
+func (b *failoverBackend) succeeded() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openUntil = time.Time{}
+	b.probing = false
+}
+
+// failed records a failed call and reports whether it opened the circuit.
+func (b *failoverBackend) failed(now time.Time, threshold int, cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	wasOpen := !b.openUntil.IsZero()
+	if b.failures < threshold && !wasOpen {
+		return false
+	}
+	// A failed probe (or a call that raced with one) keeps the circuit
+	// open for another cooldown; only the transition is reported.
+	b.openUntil = now.Add(cooldown)
+	return !wasOpen
+}
+
+// errClass says how the failover provider should react to an error.
+type errClass int
+
+const (
+	errClassFailover  errClass = iota // backend-specific (auth, unknown model): try the next backend
+	errClassRetryable                 // transient (rate limit, overload, timeout): retry, then fail over
+	errClassFatal                     // the request is invalid: every backend would reject it
+)
+
+func classifyError(err error) errClass {
+	if status := errorStatus(err); status != 0 {
+		switch {
+		case status == http.StatusTooManyRequests, status == http.StatusRequestTimeout,
+			status == http.StatusConflict, status >= 500:
+			return errClassRetryable
+		case status == http.StatusBadRequest, status == http.StatusRequestEntityTooLarge,
+			status == http.StatusUnprocessableEntity:
+			return errClassFatal
+		default:
+			return errClassFailover
+		}
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.As(err, &netErr):
+		return errClassRetryable
+	}
+	return errClassFailover
+}
+
+// errorStatus extracts the HTTP status from the error types our providers return.
+func errorStatus(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	var anthropicErr *anthropic.Error
+	if errors.As(err, &anthropicErr) {
+		return anthropicErr.StatusCode
+	}
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) {
+		return openaiErr.StatusCode
+	}
+	return 0
+}
+
+func errorRetryAfter(err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.RetryAfter
+	}
+	var anthropicErr *anthropic.Error
+	if errors.As(err, &anthropicErr) && anthropicErr.Response != nil {
+		return retryAfter(anthropicErr.Response.Header, time.Now())
+	}
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) && openaiErr.Response != nil {
+		return retryAfter(openaiErr.Response.Header, time.Now())
+	}
+	return 0
+}
@@ -0,0 +1,261 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedProvider returns the queued errors in order, then succeeds.
+type scriptedProvider struct {
+	mu     sync.Mutex
+	errs   []error
+	calls  int
+	models []string
+	reply  string
+}
+
+func (p *scriptedProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	p.models = append(p.models, model)
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		return nil, err
+	}
+	return &LLMResponse{Content: p.reply, FinishReason: "stop"}, nil
+}
+
+func (p *scriptedProvider) GetDefaultModel() string { return "" }
+
+func newTestFailover(opts FailoverOptions, backends ...Backend) (*FailoverProvider, *[]time.Duration, *time.Time) {
+	p := NewFailoverProvider(backends, opts)
+	var waits []time.Duration
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+	p.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	return p, &waits, &now
+}
+
+func TestFailoverRetriesTransientErrors(t *testing.T) {
+	primary := &scriptedProvider{
+		errs: []error{
+			&APIError{StatusCode: 429, Body: "slow down", RetryAfter: 2 * time.Second},
+			&APIError{StatusCode: 503, Body: "overloaded"},
+		},
+		reply: "ok",
+	}
+	p, waits, _ := newTestFailover(FailoverOptions{MaxRetries: 2}, Backend{Name: "primary", Provider: primary})
+
+	resp, err := p.Chat(context.Background(), nil, nil, "model-a", nil)
+	if err != nil || resp.Content != "ok" {
+		t.Fatalf("Chat = %+v, %v; want success after retries", resp, err)
+	}
+	if primary.calls != 3 {
+		t.Fatalf("calls = %d, want 3", primary.calls)
+	}
+	if len(*waits) != 2 || (*waits)[0] != 2*time.Second {
+		t.Fatalf("waits = %v, want Retry-After honoured first", *waits)
+	}
+	if w := (*waits)[1]; w < 500*time.Millisecond || w > time.Second {
+		t.Fatalf("second backoff = %v, want within [500ms, 1s]", w)
+	}
+}
+
+func TestFailoverMovesToNextBackend(t *testing.T) {
+	primary := &scriptedProvider{errs: []error{
+		&APIError{StatusCode: 500, Body: "boom"},
+		&APIError{StatusCode: 500, Body: "boom"},
+	}}
+	fallback := &scriptedProvider{reply: "from fallback"}
+	p, _, _ := newTestFailover(FailoverOptions{MaxRetries: 1},
+		Backend{Name: "anthropic", Provider: primary},
+		Backend{Name: "ollama", Provider: fallback, Model: "llama3"},
+	)
+
+	resp, err := p.Chat(context.Background(), nil, nil, "claude", nil)
+	if err != nil || resp.Content != "from fallback" {
+		t.Fatalf("Chat = %+v, %v; want the fallback reply", resp, err)
+	}
+	if len(fallback.models) != 1 || fallback.models[0] != "llama3" {
+		t.Fatalf("fallback models = %v, want its configured model", fallback.models)
+	}
+	if resp.Model != "llama3" {
+		t.Fatalf("response model = %q, want the model that served it", resp.Model)
+	}
+}
+
+func TestFailoverAuthErrorSkipsRetries(t *testing.T) {
+	primary := &scriptedProvider{errs: []error{&APIError{StatusCode: 401, Body: "bad key"}}}
+	fallback := &scriptedProvider{reply: "ok"}
+	p, waits, _ := newTestFailover(FailoverOptions{MaxRetries: 3},
+		Backend{Name: "a", Provider: primary},
+		Backend{Name: "b", Provider: fallback},
+	)
+
+	if _, err := p.Chat(context.Background(), nil, nil, "m", nil); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if primary.calls != 1 || len(*waits) != 0 {
+		t.Fatalf("auth errors should fail over without retrying (calls=%d, waits=%v)", primary.calls, *waits)
+	}
+}
+
+func TestFailoverBadRequestIsFatal(t *testing.T) {
+	primary := &scriptedProvider{errs: []error{&APIError{StatusCode: 400, Body: "invalid"}}}
+	fallback := &scriptedProvider{reply: "ok"}
+	p, _, _ := newTestFailover(FailoverOptions{MaxRetries: 3},
+		Backend{Name: "a", Provider: primary},
+		Backend{Name: "b", Provider: fallback},
+	)
+
+	_, err := p.Chat(context.Background(), nil, nil, "m", nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 {
+		t.Fatalf("err = %v, want the 400 returned as-is", err)
+	}
+	if fallback.calls != 0 {
+		t.Fatal("a rejected request must not be sent to the fallback")
+	}
+}
+
+func TestFailoverCircuitBreaker(t *testing.T) {
+	down := &APIError{StatusCode: 502, Body: "bad gateway"}
+	primary := &scriptedProvider{errs: []error{down, down, down}}
+	fallback := &scriptedProvider{reply: "ok"}
+	p, _, now := newTestFailover(FailoverOptions{MaxRetries: 0, BreakerThreshold: 2, BreakerCooldown: time.Minute},
+		Backend{Name: "a", Provider: primary},
+		Backend{Name: "b", Provider: fallback},
+	)
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.Chat(context.Background(), nil, nil, "m", nil); err != nil {
+			t.Fatalf("Chat #%d: %v", i, err)
+		}
+	}
+	if primary.calls != 2 {
+		t.Fatalf("primary calls = %d, want 2 before the circuit opens", primary.calls)
+	}
+
+	// Circuit open: the primary is skipped.
+	if _, err := p.Chat(context.Background(), nil, nil, "m", nil); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if primary.calls != 2 {
+		t.Fatalf("primary called while its circuit was open")
+	}
+
+	// After the cooldown a probe is let through; it fails and reopens the circuit.
+	*now = now.Add(2 * time.Minute)
+	if _, err := p.Chat(context.Background(), nil, nil, "m", nil); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if primary.calls != 3 {
+		t.Fatalf("primary calls = %d, want one probe after the cooldown", primary.calls)
+	}
+	if _, err := p.Chat(context.Background(), nil, nil, "m", nil); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if primary.calls != 3 {
+		t.Fatalf("failed probe should reopen the circuit")
+	}
+
+	// The next probe succeeds and closes it.
+	*now = now.Add(2 * time.Minute)
+	for i := 0; i < 2; i++ {
+		if _, err := p.Chat(context.Background(), nil, nil, "m", nil); err != nil {
+			t.Fatalf("Chat: %v", err)
+		}
+	}
+	if primary.calls != 5 {
+		t.Fatalf("primary calls = %d, want the circuit closed after a good probe", primary.calls)
+	}
+}
+
+func TestFailoverAllBackendsFail(t *testing.T) {
+	p, _, _ := newTestFailover(FailoverOptions{},
+		Backend{Name: "a", Provider: &scriptedProvider{errs: []error{&APIError{StatusCode: 403, Body: "no"}}}},
+		Backend{Name: "b", Provider: &scriptedProvider{errs: []error{&APIError{StatusCode: 404, Body: "no model"}}}},
+	)
+
+	_, err := p.Chat(context.Background(), nil, nil, "m", nil)
+	if err == nil || !strings.Contains(err.Error(), "all 2 providers failed") || !strings.Contains(err.Error(), "no model") {
+		t.Fatalf("err = %v, want an aggregate error wrapping the last failure", err)
+	}
+}
+
+func TestFailoverChatStreamReplaysNonStreamingBackend(t *testing.T) {
+	primary := &scriptedProvider{errs: []error{&APIError{StatusCode: 401, Body: "bad key"}}}
+	fallback := &scriptedProvider{reply: "hello"}
+	p, _, _ := newTestFailover(FailoverOptions{},
+		Backend{Name: "a", Provider: primary},
+		Backend{Name: "b", Provider: fallback},
+	)
+
+	ch, err := p.ChatStream(context.Background(), nil, nil, "m", nil)
+	if err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	var content, model string
+	var done bool
+	for chunk := range ch {
+		content += chunk.Content
+		done = done || chunk.Done
+		if chunk.Model != "" {
+			model = chunk.Model
+		}
+	}
+	if content != "hello" || !done || model != "m" {
+		t.Fatalf("stream = %q (done=%v, model=%q), want the fallback reply", content, done, model)
+	}
+}
+
+func TestHTTPProviderReturnsAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"error":"rate limited"}`))
+	}))
+	defer server.Close()
+
+	_, err := NewHTTPProvider("key", server.URL, "").Chat(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, "gpt-4o", nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want *APIError", err)
+	}
+	if apiErr.StatusCode != 429 || apiErr.RetryAfter != 7*time.Second {
+		t.Fatalf("APIError = %+v, want 429 with a 7s Retry-After", apiErr)
+	}
+	if classifyError(err) != errClassRetryable {
+		t.Fatal("429 should be retryable")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header http.Header
+		want   time.Duration
+	}{
+		{http.Header{}, 0},
+		{http.Header{"Retry-After": {"3"}}, 3 * time.Second},
+		{http.Header{"Retry-After": {now.Add(10 * time.Second).Format(http.TimeFormat)}}, 10 * time.Second},
+		{http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"1"}}, 250 * time.Millisecond},
+		{http.Header{"Retry-After": {"soon"}}, 0},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.header, now); got != tt.want {
+			t.Errorf("retryAfter(%v) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -66,7 +67,7 @@ func (p *HTTPProvider) Chat(ctx context.Context, messages []Message, tools []Too
 
 	requestBody := map[string]interface{}{
 		"model":    model,
-		"messages": messages,
+		"messages": openAIMessages(ctx, messages, model, options),
 	}
 
 	if len(tools) > 0 {
@@ -74,25 +75,29 @@ func (p *HTTPProvider) Chat(ctx context.Context, messages []Message, tools []Too
 		requestBody["tool_choice"] = "auto"
 	}
 
-	if maxTokens, ok := options["max_tokens"].(int); ok {
-		lowerModel := strings.ToLower(model)
-		if strings.Contains(lowerModel, "glm") || strings.Contains(lowerModel, "o1") {
-			requestBody["max_completion_tokens"] = maxTokens
-		} else {
-			requestBody["max_tokens"] = maxTokens
-		}
-	}
+	applyOpenAIOptions(requestBody, model, options)
 
-	if temperature, ok := options["temperature"].(float64); ok {
-		lowerModel := strings.ToLower(model)
-		// Kimi k2 models only support temperature=1
-		if strings.Contains(lowerModel, "kimi") && strings.Contains(lowerModel, "k2") {
-			requestBody["temperature"] = 1.0
-		} else {
-			requestBody["temperature"] = temperature
-		}
+	body, err := p.postChat(ctx, requestBody, model)
+	var apiErr *APIError
+	if _, ok := requestBody["response_format"]; ok && errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest {
+		// Not every OpenAI-compatible server supports json_schema output;
+		// retry without it and let the caller validate the answer.
+		logger.WarnCF("provider", "Backend rejected response_format, retrying without it", map[string]interface{}{
+			"model": model,
+		})
+		delete(requestBody, "response_format")
+		body, err = p.postChat(ctx, requestBody, model)
+	}
+	if err != nil {
+		return nil, err
 	}
 
+	return p.parseResponse(body)
+}
+
+// postChat sends a non-streaming chat completions request and returns the
+// response body.
+func (p *HTTPProvider) postChat(ctx context.Context, requestBody map[string]interface{}, model string) ([]byte, error) {
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
@@ -126,27 +131,128 @@ func (p *HTTPProvider) Chat(ctx context.Context, messages []Message, tools []Too
 			"status_code": resp.StatusCode,
 			"body":        string(body),
 		})
-		return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, string(body))
+		return nil, newAPIError(resp, body)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
+	return body, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error: %s", string(body))
+// applyOpenAIOptions copies generation options into a chat completions
+// request body.
+func applyOpenAIOptions(requestBody map[string]interface{}, model string, options map[string]interface{}) {
+	lowerModel := strings.ToLower(model)
+
+	if maxTokens, ok := options["max_tokens"].(int); ok {
+		if strings.Contains(lowerModel, "glm") || strings.Contains(lowerModel, "o1") {
+			requestBody["max_completion_tokens"] = maxTokens
+		} else {
+			requestBody["max_tokens"] = maxTokens
+		}
 	}
 
-	return p.parseResponse(body)
+	if temperature, ok := options["temperature"].(float64); ok {
+		// Kimi k2 models only support temperature=1
+		if strings.Contains(lowerModel, "kimi") && strings.Contains(lowerModel, "k2") {
+			requestBody["temperature"] = 1.0
+		} else {
+			requestBody["temperature"] = temperature
+		}
+	}
+
+	if topP, ok := options["top_p"].(float64); ok {
+		requestBody["top_p"] = topP
+	}
+	if stop := stringsOption(options, "stop"); len(stop) > 0 {
+		requestBody["stop"] = stop
+	}
+	if seed, ok := int64Option(options, "seed"); ok {
+		requestBody["seed"] = seed
+	}
+	if effort, ok := options["reasoning_effort"].(string); ok && effort != "" {
+		requestBody["reasoning_effort"] = effort
+	}
+	if schema := responseSchema(options); schema != nil {
+		requestBody["response_format"] = map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   "response",
+				"schema": schema,
+			},
+		}
+	}
+}
+
+// openAIMessage is a chat message in the OpenAI wire format, where content
+// is either a string or a list of typed parts.
+type openAIMessage struct {
+	Role       string      `json:"role"`
+	Content    interface{} `json:"content"`
+	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"`
+	ToolCallID string      `json:"tool_call_id,omitempty"`
+}
+
+// openAIMessages converts messages to the OpenAI format, sending image parts
+// as image_url entries (inline data URLs for local files).
+func openAIMessages(ctx context.Context, messages []Message, model string, options map[string]interface{}) []openAIMessage {
+	var images *imageLoader
+	if hasImages(messages) {
+		images = newImageLoader(ctx, model, options, false)
+	}
+
+	out := make([]openAIMessage, len(messages))
+	for i, msg := range messages {
+		out[i] = openAIMessage{
+			Role:       msg.Role,
+			Content:    msg.Content,
+			ToolCalls:  msg.ToolCalls,
+			ToolCallID: msg.ToolCallID,
+		}
+		if len(msg.Parts) == 0 {
+			continue
+		}
+
+		var parts []map[string]interface{}
+		var text []string
+		hasImage := false
+		for _, part := range msg.Parts {
+			if part.Type == PartImage {
+				img, note := images.load(part.Image)
+				if img != nil {
+					hasImage = true
+					parts = append(parts, map[string]interface{}{
+						"type":      "image_url",
+						"image_url": map[string]interface{}{"url": img.DataURL()},
+					})
+					continue
+				}
+				part = TextPart(note)
+			}
+			text = append(text, part.Text)
+			parts = append(parts, map[string]interface{}{"type": "text", "text": part.Text})
+		}
+		if hasImage {
+			out[i].Content = parts
+		} else {
+			out[i].Content = strings.Join(text, "\n")
+		}
+	}
+	return out
 }
 
 func (p *HTTPProvider) parseResponse(body []byte) (*LLMResponse, error) {
 	var apiResponse struct {
 		Choices []struct {
 			Message struct {
-				Content   string `json:"content"`
-				ToolCalls []struct {
+				Content string `json:"content"`
+				// Reasoning models on OpenAI-compatible servers (DeepSeek,
+				// Kimi, vLLM, ...) return their thinking in one of these.
+				ReasoningContent string `json:"reasoning_content"`
+				Reasoning        string `json:"reasoning"`
+				ToolCalls        []struct {
 					ID       string `json:"id"`
 					Type     string `json:"type"`
 					Function *struct {
@@ -157,7 +263,7 @@ func (p *HTTPProvider) parseResponse(body []byte) (*LLMResponse, error) {
 			} `json:"message"`
 			FinishReason string `json:"finish_reason"`
 		} `json:"choices"`
-		Usage *UsageInfo `json:"usage"`
+		Usage *openAIUsage `json:"usage"`
 	}
 
 	if err := json.Unmarshal(body, &apiResponse); err != nil {
@@ -203,14 +309,56 @@ func (p *HTTPProvider) parseResponse(body []byte) (*LLMResponse, error) {
 		})
 	}
 
+	reasoning := choice.Message.ReasoningContent
+	if reasoning == "" {
+		reasoning = choice.Message.Reasoning
+	}
+
 	return &LLMResponse{
 		Content:      choice.Message.Content,
+		Reasoning:    reasoning,
 		ToolCalls:    toolCalls,
 		FinishReason: choice.FinishReason,
-		Usage:        apiResponse.Usage,
+		Usage:        apiResponse.Usage.toUsageInfo(),
 	}, nil
 }
 
+// openAIUsage is the usage object of OpenAI-compatible chat completions.
+type openAIUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	TotalTokens         int `json:"total_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+	// Some OpenAI-compatible servers report cache hits at the top level
+	// instead: DeepSeek as prompt_cache_hit_tokens, Moonshot as cached_tokens.
+	PromptCacheHitTokens int `json:"prompt_cache_hit_tokens"`
+	CachedTokens         int `json:"cached_tokens"`
+}
+
+func (u *openAIUsage) toUsageInfo() *UsageInfo {
+	if u == nil {
+		return nil
+	}
+	info := &UsageInfo{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+		CachedTokens:     u.PromptCacheHitTokens,
+	}
+	if u.CachedTokens > 0 {
+		info.CachedTokens = u.CachedTokens
+	}
+	if u.PromptTokensDetails != nil && u.PromptTokensDetails.CachedTokens > 0 {
+		info.CachedTokens = u.PromptTokensDetails.CachedTokens
+	}
+	if info.TotalTokens == 0 {
+		info.TotalTokens = info.PromptTokens + info.CompletionTokens
+	}
+	return info
+}
+
 func (p *HTTPProvider) GetDefaultModel() string {
 	return ""
 }
@@ -231,8 +379,10 @@ func (p *HTTPProvider) ChatStream(ctx context.Context, messages []Message, tools
 
 	requestBody := map[string]interface{}{
 		"model":    model,
-		"messages": messages,
+		"messages": openAIMessages(ctx, messages, model, options),
 		"stream":   true,
+		// Ask for a final usage chunk so streamed calls report real token counts.
+		"stream_options": map[string]interface{}{"include_usage": true},
 	}
 
 	if len(tools) > 0 {
@@ -240,49 +390,17 @@ func (p *HTTPProvider) ChatStream(ctx context.Context, messages []Message, tools
 		requestBody["tool_choice"] = "auto"
 	}
 
-	if maxTokens, ok := options["max_tokens"].(int); ok {
-		lowerModel := strings.ToLower(model)
-		if strings.Contains(lowerModel, "glm") || strings.Contains(lowerModel, "o1") {
-			requestBody["max_completion_tokens"] = maxTokens
-		} else {
-			requestBody["max_tokens"] = maxTokens
-		}
-	}
-
-	if temperature, ok := options["temperature"].(float64); ok {
-		lowerModel := strings.ToLower(model)
-		if strings.Contains(lowerModel, "kimi") && strings.Contains(lowerModel, "k2") {
-			requestBody["temperature"] = 1.0
-		} else {
-			requestBody["temperature"] = temperature
-		}
-	}
+	applyOpenAIOptions(requestBody, model, options)
 
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	resp, err := p.openStream(ctx, requestBody)
+	if err != nil && strings.Contains(err.Error(), "stream_options") {
+		// Some OpenAI-compatible servers reject stream_options; retry
+		// without it and let the caller estimate usage instead.
+		delete(requestBody, "stream_options")
+		resp, err = p.openStream(ctx, requestBody)
 	}
-
-	req, err := http.NewRequestWithContext(ctx, "POST", p.apiBase+"/chat/completions", bytes.NewReader(jsonData))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "text/event-stream")
-	if p.apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+p.apiKey)
-	}
-
-	resp, err := p.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		return nil, fmt.Errorf("API error: %s", string(body))
+		return nil, err
 	}
 
 	ch := make(chan StreamChunk, 64)
@@ -295,6 +413,7 @@ func (p *HTTPProvider) ChatStream(ctx context.Context, messages []Message, tools
 		// Allow lines up to 256KB for large tool call arguments
 		scanner.Buffer(make([]byte, 0, 64*1024), 256*1024)
 
+		finishReason := "stop"
 		for scanner.Scan() {
 			line := scanner.Text()
 
@@ -305,15 +424,17 @@ func (p *HTTPProvider) ChatStream(ctx context.Context, messages []Message, tools
 
 			data := strings.TrimPrefix(line, "data: ")
 			if data == "[DONE]" {
-				ch <- StreamChunk{Done: true, FinishReason: "stop"}
+				ch <- StreamChunk{Done: true, FinishReason: finishReason}
 				return
 			}
 
 			var sseEvent struct {
 				Choices []struct {
 					Delta struct {
-						Content   string `json:"content"`
-						ToolCalls []struct {
+						Content          string `json:"content"`
+						ReasoningContent string `json:"reasoning_content"`
+						Reasoning        string `json:"reasoning"`
+						ToolCalls        []struct {
 							Index    int    `json:"index"`
 							ID       string `json:"id"`
 							Type     string `json:"type"`
@@ -325,28 +446,41 @@ func (p *HTTPProvider) ChatStream(ctx context.Context, messages []Message, tools
 					} `json:"delta"`
 					FinishReason *string `json:"finish_reason"`
 				} `json:"choices"`
-				Usage *UsageInfo `json:"usage"`
+				Usage *openAIUsage `json:"usage"`
 			}
 
 			if err := json.Unmarshal([]byte(data), &sseEvent); err != nil {
 				continue
 			}
 
+			// With include_usage the usage arrives in a final chunk with no choices.
 			if len(sseEvent.Choices) == 0 {
+				if sseEvent.Usage != nil {
+					select {
+					case ch <- StreamChunk{Usage: sseEvent.Usage.toUsageInfo()}:
+					case <-ctx.Done():
+						return
+					}
+				}
 				continue
 			}
 
 			choice := sseEvent.Choices[0]
 			chunk := StreamChunk{
-				Content: choice.Delta.Content,
-				Usage:   sseEvent.Usage,
+				Content:   choice.Delta.Content,
+				Reasoning: choice.Delta.ReasoningContent,
+				Usage:     sseEvent.Usage.toUsageInfo(),
+			}
+			if chunk.Reasoning == "" {
+				chunk.Reasoning = choice.Delta.Reasoning
 			}
 
 			// Handle tool calls in delta
 			for _, tc := range choice.Delta.ToolCalls {
 				toolCall := ToolCall{
-					ID:   tc.ID,
-					Type: tc.Type,
+					ID:    tc.ID,
+					Type:  tc.Type,
+					Index: tc.Index,
 				}
 				if tc.Function != nil {
 					toolCall.Name = tc.Function.Name
@@ -361,9 +495,10 @@ func (p *HTTPProvider) ChatStream(ctx context.Context, messages []Message, tools
 				chunk.ToolCalls = append(chunk.ToolCalls, toolCall)
 			}
 
+			// Keep reading after the finish reason: the usage chunk follows it.
 			if choice.FinishReason != nil {
 				chunk.FinishReason = *choice.FinishReason
-				chunk.Done = true
+				finishReason = chunk.FinishReason
 			}
 
 			select {
@@ -371,19 +506,47 @@ func (p *HTTPProvider) ChatStream(ctx context.Context, messages []Message, tools
 			case <-ctx.Done():
 				return
 			}
-
-			if chunk.Done {
-				return
-			}
 		}
 
 		// If scanner exits without [DONE], send a final done chunk
-		ch <- StreamChunk{Done: true, FinishReason: "stop"}
+		ch <- StreamChunk{Done: true, FinishReason: finishReason}
 	}()
 
 	return ch, nil
 }
 
+// openStream sends a streaming chat completion request and returns the
+// response once the server has accepted it.
+func (p *HTTPProvider) openStream(ctx context.Context, requestBody map[string]interface{}) (*http.Response, error) {
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.apiBase+"/chat/completions", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, newAPIError(resp, body)
+	}
+	return resp, nil
+}
+
 func createClaudeAuthProvider() (LLMProvider, error) {
 	cred, err := auth.GetCredential("anthropic")
 	if err != nil {
@@ -406,7 +569,56 @@ func createCodexAuthProvider() (LLMProvider, error) {
 	return NewCodexProviderWithTokenSource(cred.AccessToken, cred.AccountID, createCodexTokenSource()), nil
 }
 
+// CreateProvider builds the provider for the default model. When failover is
+// configured the result wraps it together with the fallback backends.
 func CreateProvider(cfg *config.Config) (LLMProvider, error) {
+	primary, err := createProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	fo := cfg.Providers.Failover
+	if len(fo.Fallbacks) == 0 && fo.MaxRetries <= 0 {
+		return primary, nil
+	}
+
+	primaryName, _ := GetProviderForModel(cfg.Agents.Defaults.Model)
+	if cfg.Agents.Defaults.Provider != "" {
+		primaryName = strings.ToLower(cfg.Agents.Defaults.Provider)
+	}
+	backends := []Backend{{Name: primaryName, Provider: primary}}
+
+	for _, fb := range fo.Fallbacks {
+		// createProvider reads only the agent defaults and provider settings.
+		fbCfg := &config.Config{Agents: cfg.Agents, Providers: cfg.Providers}
+		fbCfg.Agents.Defaults.Provider = fb.Provider
+		fbCfg.Agents.Defaults.Model = fb.Model
+		provider, err := createProvider(fbCfg)
+		if err != nil {
+			logger.WarnCF("provider", "Skipping fallback provider", map[string]interface{}{
+				"provider": fb.Provider,
+				"model":    fb.Model,
+				"error":    err.Error(),
+			})
+			continue
+		}
+		name := strings.ToLower(fb.Provider)
+		if name == "" {
+			name, _ = GetProviderForModel(fb.Model)
+		}
+		backends = append(backends, Backend{Name: name, Provider: provider, Model: fb.Model})
+	}
+
+	return NewFailoverProvider(backends, FailoverOptions{
+		MaxRetries:       fo.MaxRetries,
+		InitialBackoff:   time.Duration(fo.InitialBackoffMs) * time.Millisecond,
+		MaxBackoff:       time.Duration(fo.MaxBackoffMs) * time.Millisecond,
+		BreakerThreshold: fo.BreakerThreshold,
+		BreakerCooldown:  time.Duration(fo.BreakerCooldownSeconds) * time.Second,
+	}), nil
+}
+
+func createProvider(cfg *config.Config) (LLMProvider, error) {
 	model := cfg.Agents.Defaults.Model
 	providerName := strings.ToLower(cfg.Agents.Defaults.Provider)
 
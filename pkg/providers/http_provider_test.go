@@ -1,6 +1,7 @@
 package providers
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -47,3 +48,39 @@ func TestGetProviderForModel(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyOpenAIOptions(t *testing.T) {
+	body := map[string]interface{}{}
+	applyOpenAIOptions(body, "gpt-4o", map[string]interface{}{
+		"max_tokens":       512,
+		"temperature":      0.2,
+		"top_p":            0.9,
+		"stop":             []string{"END"},
+		"seed":             int64(7),
+		"reasoning_effort": "low",
+	})
+	want := map[string]interface{}{
+		"max_tokens":       512,
+		"temperature":      0.2,
+		"top_p":            0.9,
+		"stop":             []string{"END"},
+		"seed":             int64(7),
+		"reasoning_effort": "low",
+	}
+	if !reflect.DeepEqual(body, want) {
+		t.Fatalf("body = %v, want %v", body, want)
+	}
+}
+
+func TestApplyOpenAIOptions_ResponseSchema(t *testing.T) {
+	schema := map[string]interface{}{"type": "object"}
+	body := map[string]interface{}{}
+	applyOpenAIOptions(body, "gpt-4o", map[string]interface{}{"response_schema": schema})
+	format, _ := body["response_format"].(map[string]interface{})
+	if format["type"] != "json_schema" {
+		t.Fatalf("response_format = %v, want json_schema", body["response_format"])
+	}
+	if js, _ := format["json_schema"].(map[string]interface{}); !reflect.DeepEqual(js["schema"], schema) {
+		t.Errorf("json_schema = %v, want the schema", format["json_schema"])
+	}
+}
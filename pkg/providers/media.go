@@ -0,0 +1,257 @@
+package providers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Content part types.
+const (
+	PartText  = "text"
+	PartImage = "image"
+)
+
+// MaxImageBytes is the largest image sent to a provider. It matches the
+// strictest per-image limit among the supported backends (Anthropic, 5 MB).
+const MaxImageBytes = 5 << 20
+
+// imageMediaTypes are the formats every supported backend accepts.
+var imageMediaTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// ContentPart is one piece of a multimodal message.
+type ContentPart struct {
+	Type  string       `json:"type"` // PartText or PartImage
+	Text  string       `json:"text,omitempty"`
+	Image *ImageSource `json:"image,omitempty"`
+}
+
+// ImageSource locates an image. Exactly one of Path, URL or Data is set.
+type ImageSource struct {
+	Path      string `json:"path,omitempty"`       // local file
+	URL       string `json:"url,omitempty"`        // http(s) URL
+	Data      string `json:"data,omitempty"`       // base64-encoded bytes
+	MediaType string `json:"media_type,omitempty"` // e.g. "image/png"; detected when empty
+}
+
+// TextPart returns a text content part.
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: PartText, Text: text}
+}
+
+// ImagePart returns an image content part for ref, which may be a local
+// path, an http(s) URL or a base64 data URL.
+func ImagePart(ref string) ContentPart {
+	src := &ImageSource{}
+	switch {
+	case strings.HasPrefix(ref, "data:"):
+		meta, data, _ := strings.Cut(strings.TrimPrefix(ref, "data:"), ",")
+		src.MediaType = strings.TrimSuffix(meta, ";base64")
+		src.Data = data
+	case strings.HasPrefix(ref, "http://"), strings.HasPrefix(ref, "https://"):
+		src.URL = ref
+	default:
+		src.Path = ref
+	}
+	return ContentPart{Type: PartImage, Image: src}
+}
+
+// IsImageRef reports whether a media reference (path, URL or data URL)
+// looks like an image, judging by its media type or file extension.
+func IsImageRef(ref string) bool {
+	if strings.HasPrefix(ref, "data:") {
+		return strings.HasPrefix(ref, "data:image/")
+	}
+	p := ref
+	if u, err := url.Parse(ref); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		p = u.Path
+	}
+	switch strings.ToLower(path.Ext(p)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+		return true
+	}
+	return false
+}
+
+// visionModelPatterns are substrings of model names known to accept images.
+var visionModelPatterns = []string{
+	"gpt-4o", "gpt-4.1", "gpt-4-turbo", "gpt-4-vision", "gpt-5", "o1", "o3", "o4",
+	"claude-3", "claude-sonnet-4", "claude-opus-4", "claude-haiku-4", "claude-4",
+	"gemini", "gemma3", "grok-2-vision", "grok-4", "pixtral", "llava", "bakllava",
+	"moondream", "minicpm-v", "llama3.2-vision", "llama-3.2-vision", "llama4", "llama-4",
+	"qwen-vl", "qwen2.5vl", "qwen2.5-vl", "qwen3-vl", "glm-4v", "glm-4.5v", "vision",
+}
+
+// textOnlyModelPatterns override visionModelPatterns for text-only variants.
+var textOnlyModelPatterns = []string{"o1-mini", "o3-mini", "gpt-4o-audio", "gpt-4o-realtime"}
+
+// SupportsVision reports whether model accepts image input. A boolean
+// options["vision"] overrides the name-based guess.
+func SupportsVision(model string, options map[string]interface{}) bool {
+	if v, ok := options["vision"].(bool); ok {
+		return v
+	}
+	m := strings.ToLower(model)
+	for _, p := range textOnlyModelPatterns {
+		if strings.Contains(m, p) {
+			return false
+		}
+	}
+	for _, p := range visionModelPatterns {
+		if strings.Contains(m, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// messageParts returns the parts of msg, or a single text part when the
+// message has none.
+func messageParts(msg Message) []ContentPart {
+	if len(msg.Parts) > 0 {
+		return msg.Parts
+	}
+	return []ContentPart{TextPart(msg.Content)}
+}
+
+// hasImages reports whether any message carries an image part.
+func hasImages(messages []Message) bool {
+	for _, msg := range messages {
+		for _, part := range msg.Parts {
+			if part.Type == PartImage {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// imageData is a validated image ready to send.
+type imageData struct {
+	MediaType string
+	Base64    string // set unless URL is
+	URL       string // set when the backend fetches the image itself
+}
+
+// DataURL renders the image as a data URL, or returns its URL.
+func (d *imageData) DataURL() string {
+	if d.URL != "" {
+		return d.URL
+	}
+	return "data:" + d.MediaType + ";base64," + d.Base64
+}
+
+// imageLoader resolves and validates image parts for one request.
+type imageLoader struct {
+	ctx      context.Context
+	vision   bool
+	model    string
+	fetchURL bool // download URLs instead of passing them through
+	client   *http.Client
+}
+
+func newImageLoader(ctx context.Context, model string, options map[string]interface{}, fetchURL bool) *imageLoader {
+	return &imageLoader{
+		ctx:      ctx,
+		vision:   SupportsVision(model, options),
+		model:    model,
+		fetchURL: fetchURL,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// load validates an image part. When the model cannot see images or the
+// image is unusable it returns a text note to send in its place.
+func (l *imageLoader) load(src *ImageSource) (*imageData, string) {
+	if !l.vision {
+		return nil, fmt.Sprintf("[image omitted: model %s does not accept images]", l.model)
+	}
+	if src == nil {
+		return nil, "[image omitted: empty image]"
+	}
+	img, err := l.resolve(src)
+	if err != nil {
+		return nil, fmt.Sprintf("[image omitted: %v]", err)
+	}
+	return img, ""
+}
+
+func (l *imageLoader) resolve(src *ImageSource) (*imageData, error) {
+	var raw []byte
+	switch {
+	case src.Data != "":
+		if base64.StdEncoding.DecodedLen(len(src.Data)) > MaxImageBytes+3 {
+			return nil, fmt.Errorf("image larger than %d MB", MaxImageBytes>>20)
+		}
+		b, err := base64.StdEncoding.DecodeString(src.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 image data")
+		}
+		raw = b
+	case src.Path != "":
+		info, err := os.Stat(src.Path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s", path.Base(src.Path))
+		}
+		if info.Size() > MaxImageBytes {
+			return nil, fmt.Errorf("%s is larger than %d MB", path.Base(src.Path), MaxImageBytes>>20)
+		}
+		b, err := os.ReadFile(src.Path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s", path.Base(src.Path))
+		}
+		raw = b
+	case src.URL != "":
+		if !l.fetchURL {
+			return &imageData{MediaType: src.MediaType, URL: src.URL}, nil
+		}
+		b, err := l.download(src.URL)
+		if err != nil {
+			return nil, err
+		}
+		raw = b
+	default:
+		return nil, fmt.Errorf("empty image")
+	}
+	if len(raw) > MaxImageBytes {
+		return nil, fmt.Errorf("image larger than %d MB", MaxImageBytes>>20)
+	}
+
+	mediaType := http.DetectContentType(raw)
+	if !imageMediaTypes[mediaType] {
+		return nil, fmt.Errorf("unsupported image format %s", mediaType)
+	}
+	return &imageData{MediaType: mediaType, Base64: base64.StdEncoding.EncodeToString(raw)}, nil
+}
+
+func (l *imageLoader) download(rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(l.ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image URL")
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download image: HTTP %d", resp.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, MaxImageBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image: %w", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,206 @@
+package providers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tinyPNG is a valid 1x1 PNG.
+var tinyPNG, _ = base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mNkYAAAAAYAAjCB0C8AAAAASUVORK5CYII=")
+
+func writeTestImage(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func imageMessage(text string, refs ...string) Message {
+	msg := Message{Role: "user", Content: text, Parts: []ContentPart{TextPart(text)}}
+	for _, ref := range refs {
+		msg.Parts = append(msg.Parts, ImagePart(ref))
+	}
+	return msg
+}
+
+func TestImagePart(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want ImageSource
+	}{
+		{"/tmp/photo.jpg", ImageSource{Path: "/tmp/photo.jpg"}},
+		{"https://cdn.example.com/a.png?x=1", ImageSource{URL: "https://cdn.example.com/a.png?x=1"}},
+		{"data:image/png;base64,AAAA", ImageSource{Data: "AAAA", MediaType: "image/png"}},
+	}
+	for _, tt := range tests {
+		got := ImagePart(tt.ref)
+		if got.Type != PartImage || got.Image == nil || *got.Image != tt.want {
+			t.Errorf("ImagePart(%q) = %+v, want %+v", tt.ref, got.Image, tt.want)
+		}
+	}
+}
+
+func TestIsImageRef(t *testing.T) {
+	for ref, want := range map[string]bool{
+		"/tmp/photo.JPG":                        true,
+		"https://cdn.example.com/a.webp?size=2": true,
+		"data:image/gif;base64,R0lG":            true,
+		"/tmp/voice.ogg":                        false,
+		"data:audio/ogg;base64,AAAA":            false,
+		"https://example.com/report.pdf":        false,
+	} {
+		if got := IsImageRef(ref); got != want {
+			t.Errorf("IsImageRef(%q) = %v, want %v", ref, got, want)
+		}
+	}
+}
+
+func TestSupportsVision(t *testing.T) {
+	for model, want := range map[string]bool{
+		"gpt-4o":                     true,
+		"claude-sonnet-4-5-20250929": true,
+		"ollama/llava:13b":           true,
+		"o3-mini":                    false,
+		"glm-4.7":                    false,
+		"deepseek-chat":              false,
+	} {
+		if got := SupportsVision(model, nil); got != want {
+			t.Errorf("SupportsVision(%q) = %v, want %v", model, got, want)
+		}
+	}
+	if !SupportsVision("my-custom-model", map[string]interface{}{"vision": true}) {
+		t.Error("vision option should override the name-based guess")
+	}
+}
+
+func TestOpenAIMessagesWithImage(t *testing.T) {
+	path := writeTestImage(t, "photo.png", tinyPNG)
+	msgs := openAIMessages(context.Background(), []Message{
+		{Role: "system", Content: "be brief"},
+		imageMessage("what is this?", path, "https://example.com/cat.jpg"),
+	}, "gpt-4o", nil)
+
+	if msgs[0].Content != "be brief" {
+		t.Fatalf("text-only message content = %#v, want a plain string", msgs[0].Content)
+	}
+	parts, ok := msgs[1].Content.([]map[string]interface{})
+	if !ok || len(parts) != 3 {
+		t.Fatalf("content = %#v, want 3 parts", msgs[1].Content)
+	}
+	url := parts[1]["image_url"].(map[string]interface{})["url"].(string)
+	if !strings.HasPrefix(url, "data:image/png;base64,") {
+		t.Errorf("local image url = %q, want a PNG data URL", url)
+	}
+	if got := parts[2]["image_url"].(map[string]interface{})["url"]; got != "https://example.com/cat.jpg" {
+		t.Errorf("remote image url = %v, want it passed through", got)
+	}
+
+	raw, _ := json.Marshal(msgs[1])
+	if strings.Contains(string(raw), `"parts"`) {
+		t.Errorf("wire message leaks internal parts field: %s", raw)
+	}
+}
+
+func TestOpenAIMessagesTextFallback(t *testing.T) {
+	path := writeTestImage(t, "photo.png", tinyPNG)
+	msgs := openAIMessages(context.Background(), []Message{imageMessage("hi", path)}, "glm-4.7", nil)
+
+	content, ok := msgs[0].Content.(string)
+	if !ok || !strings.Contains(content, "hi") || !strings.Contains(content, "does not accept images") {
+		t.Fatalf("content = %#v, want text with an omission note", msgs[0].Content)
+	}
+}
+
+func TestImageValidation(t *testing.T) {
+	big := make([]byte, MaxImageBytes+1)
+	copy(big, tinyPNG)
+	tests := map[string]struct {
+		src  *ImageSource
+		want string
+	}{
+		"too large":   {&ImageSource{Path: writeTestImage(t, "big.png", big)}, "larger than"},
+		"not image":   {&ImageSource{Path: writeTestImage(t, "fake.png", []byte("%PDF-1.4 not an image"))}, "unsupported image format"},
+		"missing":     {&ImageSource{Path: "/nonexistent/photo.png"}, "cannot read"},
+		"bad base64":  {&ImageSource{Data: "!!!"}, "invalid base64"},
+		"valid bytes": {&ImageSource{Data: base64.StdEncoding.EncodeToString(tinyPNG)}, ""},
+	}
+	loader := newImageLoader(context.Background(), "gpt-4o", nil, false)
+	for name, tt := range tests {
+		img, note := loader.load(tt.src)
+		if tt.want == "" {
+			if img == nil || img.MediaType != "image/png" {
+				t.Errorf("%s: load = %+v, %q; want a PNG", name, img, note)
+			}
+			continue
+		}
+		if img != nil || !strings.Contains(note, tt.want) {
+			t.Errorf("%s: note = %q, want it to mention %q", name, note, tt.want)
+		}
+	}
+}
+
+func TestBuildClaudeParams_Image(t *testing.T) {
+	path := writeTestImage(t, "photo.png", tinyPNG)
+	params, err := buildClaudeParams([]Message{imageMessage("describe", path, "https://example.com/cat.jpg")}, nil, "claude-sonnet-4-5-20250929", nil)
+	if err != nil {
+		t.Fatalf("buildClaudeParams() error: %v", err)
+	}
+	blocks := params.Messages[0].Content
+	if len(blocks) != 3 {
+		t.Fatalf("len(blocks) = %d, want 3", len(blocks))
+	}
+	if blocks[1].OfImage == nil || blocks[1].OfImage.Source.OfBase64 == nil || blocks[1].OfImage.Source.OfBase64.MediaType != "image/png" {
+		t.Errorf("block 1 = %+v, want a base64 PNG image", blocks[1])
+	}
+	if blocks[2].OfImage == nil || blocks[2].OfImage.Source.OfURL == nil {
+		t.Errorf("block 2 = %+v, want a URL image", blocks[2])
+	}
+}
+
+func TestBuildCodexParams_Image(t *testing.T) {
+	path := writeTestImage(t, "photo.png", tinyPNG)
+	params := buildCodexParams([]Message{imageMessage("describe", path)}, nil, "gpt-4o", nil)
+	content := params.Input.OfInputItemList[0].OfMessage.Content.OfInputItemContentList
+	if len(content) != 2 || content[0].OfInputText == nil || content[1].OfInputImage == nil {
+		t.Fatalf("content = %+v, want input_text then input_image", content)
+	}
+	if url := content[1].OfInputImage.ImageURL.Or(""); !strings.HasPrefix(url, "data:image/png;base64,") {
+		t.Errorf("image_url = %q, want a PNG data URL", url)
+	}
+}
+
+func TestOllamaMessagesDownloadsImages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(tinyPNG)
+	}))
+	defer server.Close()
+
+	msgs := toOllamaMessages(context.Background(), []Message{imageMessage("what is this?", server.URL+"/cat.png")}, "llava", nil)
+	if len(msgs[0].Images) != 1 || msgs[0].Images[0] != base64.StdEncoding.EncodeToString(tinyPNG) {
+		t.Fatalf("images = %v, want the downloaded PNG", msgs[0].Images)
+	}
+	if msgs[0].Content != "what is this?" {
+		t.Errorf("content = %q", msgs[0].Content)
+	}
+}
+
+func TestOllamaOptions(t *testing.T) {
+	opts := ollamaOptions(map[string]interface{}{
+		"max_tokens": 256,
+		"top_p":      0.9,
+		"stop":       []interface{}{"END"},
+		"seed":       float64(3),
+	})
+	if opts.NumPredict != 256 || opts.TopP != 0.9 || len(opts.Stop) != 1 || opts.Seed == nil || *opts.Seed != 3 {
+		t.Fatalf("ollamaOptions = %+v", opts)
+	}
+}
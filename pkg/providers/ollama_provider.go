@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/sipeed/kakoclaw/pkg/logger"
@@ -21,8 +22,9 @@ type OllamaProvider struct {
 
 // OllamaMessage represents a message in Ollama format
 type OllamaMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"` // base64-encoded images
 }
 
 // OllamaRequest represents the request body for Ollama API
@@ -30,21 +32,27 @@ type OllamaRequest struct {
 	Model    string          `json:"model"`
 	Messages []OllamaMessage `json:"messages"`
 	Stream   bool            `json:"stream"`
+	Think    bool            `json:"think,omitempty"`  // Return the thinking of reasoning models separately
+	Format   interface{}     `json:"format,omitempty"` // JSON Schema the answer must follow
 	Options  OllamaOptions   `json:"options,omitempty"`
 }
 
 // OllamaOptions represents model-specific options
 type OllamaOptions struct {
-	Temperature float64 `json:"temperature,omitempty"`
-	NumPredict  int     `json:"num_predict,omitempty"`
+	Temperature float64  `json:"temperature,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+	TopP        float64  `json:"top_p,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	Seed        *int64   `json:"seed,omitempty"`
 }
 
 // OllamaResponse represents the response from Ollama API
 type OllamaResponse struct {
 	Model   string `json:"model"`
 	Message struct {
-		Role    string `json:"role"`
-		Content string `json:"content"`
+		Role     string `json:"role"`
+		Content  string `json:"content"`
+		Thinking string `json:"thinking"`
 	} `json:"message"`
 	Done            bool `json:"done"`
 	EvalCount       int  `json:"eval_count"`
@@ -64,6 +72,70 @@ func NewOllamaProvider(baseURL string) *OllamaProvider {
 	}
 }
 
+// ollamaOptions translates generation options to Ollama's model options.
+func ollamaOptions(options map[string]interface{}) OllamaOptions {
+	var opts OllamaOptions
+	if temp, ok := options["temperature"].(float64); ok {
+		opts.Temperature = temp
+	}
+	if maxTokens, ok := options["max_tokens"].(int); ok {
+		opts.NumPredict = maxTokens
+	}
+	if topP, ok := options["top_p"].(float64); ok {
+		opts.TopP = topP
+	}
+	opts.Stop = stringsOption(options, "stop")
+	if seed, ok := int64Option(options, "seed"); ok {
+		opts.Seed = &seed
+	}
+	return opts
+}
+
+// ollamaThink reports whether reasoning was requested. Ollama has a single
+// on/off switch, so any thinking budget or reasoning effort turns it on.
+func ollamaThink(options map[string]interface{}) bool {
+	if effort, _ := options["reasoning_effort"].(string); effort != "" {
+		return true
+	}
+	budget, _ := int64Option(options, "thinking_budget")
+	return budget > 0
+}
+
+// toOllamaMessages converts messages to Ollama format. Ollama only takes
+// inline base64 images, so image URLs are downloaded first.
+func toOllamaMessages(ctx context.Context, messages []Message, model string, options map[string]interface{}) []OllamaMessage {
+	var images *imageLoader
+	if hasImages(messages) {
+		images = newImageLoader(ctx, model, options, true)
+	}
+
+	out := make([]OllamaMessage, len(messages))
+	for i, msg := range messages {
+		out[i] = OllamaMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+		}
+		if len(msg.Parts) == 0 {
+			continue
+		}
+		var text []string
+		for _, part := range msg.Parts {
+			if part.Type != PartImage {
+				text = append(text, part.Text)
+				continue
+			}
+			img, note := images.load(part.Image)
+			if img == nil {
+				text = append(text, note)
+				continue
+			}
+			out[i].Images = append(out[i].Images, img.Base64)
+		}
+		out[i].Content = strings.Join(text, "\n")
+	}
+	return out
+}
+
 // Chat implements LLMProvider.Chat
 func (p *OllamaProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
 	logger.InfoCF("ollama", "Sending chat request", map[string]interface{}{
@@ -72,13 +144,7 @@ func (p *OllamaProvider) Chat(ctx context.Context, messages []Message, tools []T
 	})
 
 	// Convert messages to Ollama format
-	ollamaMessages := make([]OllamaMessage, len(messages))
-	for i, msg := range messages {
-		ollamaMessages[i] = OllamaMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-		}
-	}
+	ollamaMessages := toOllamaMessages(ctx, messages, model, options)
 
 	// Build request
 	reqBody := OllamaRequest{
@@ -88,11 +154,10 @@ func (p *OllamaProvider) Chat(ctx context.Context, messages []Message, tools []T
 	}
 
 	// Add options if provided
-	if temp, ok := options["temperature"].(float64); ok {
-		reqBody.Options.Temperature = temp
-	}
-	if maxTokens, ok := options["max_tokens"].(int); ok {
-		reqBody.Options.NumPredict = maxTokens
+	reqBody.Options = ollamaOptions(options)
+	reqBody.Think = ollamaThink(options)
+	if schema := responseSchema(options); schema != nil {
+		reqBody.Format = schema
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -129,7 +194,8 @@ func (p *OllamaProvider) Chat(ctx context.Context, messages []Message, tools []T
 	}
 
 	return &LLMResponse{
-		Content: ollamaResp.Message.Content,
+		Content:   ollamaResp.Message.Content,
+		Reasoning: ollamaResp.Message.Thinking,
 		Usage: &UsageInfo{
 			PromptTokens:     ollamaResp.PromptEvalCount,
 			CompletionTokens: ollamaResp.EvalCount,
@@ -186,13 +252,7 @@ func (p *OllamaProvider) ChatStream(ctx context.Context, messages []Message, too
 	})
 
 	// Convert messages to Ollama format
-	ollamaMessages := make([]OllamaMessage, len(messages))
-	for i, msg := range messages {
-		ollamaMessages[i] = OllamaMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-		}
-	}
+	ollamaMessages := toOllamaMessages(ctx, messages, model, options)
 
 	reqBody := OllamaRequest{
 		Model:    model,
@@ -200,11 +260,10 @@ func (p *OllamaProvider) ChatStream(ctx context.Context, messages []Message, too
 		Stream:   true, // Enable streaming
 	}
 
-	if temp, ok := options["temperature"].(float64); ok {
-		reqBody.Options.Temperature = temp
-	}
-	if maxTokens, ok := options["max_tokens"].(int); ok {
-		reqBody.Options.NumPredict = maxTokens
+	reqBody.Options = ollamaOptions(options)
+	reqBody.Think = ollamaThink(options)
+	if schema := responseSchema(options); schema != nil {
+		reqBody.Format = schema
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -250,7 +309,8 @@ func (p *OllamaProvider) ChatStream(ctx context.Context, messages []Message, too
 			}
 
 			chunk := StreamChunk{
-				Content: ollamaResp.Message.Content,
+				Content:   ollamaResp.Message.Content,
+				Reasoning: ollamaResp.Message.Thinking,
 			}
 
 			if ollamaResp.Done {
@@ -0,0 +1,59 @@
+package providers
+
+import "encoding/json"
+
+// int64Option reads an integer option that may have been stored as any Go
+// integer type or as a JSON number.
+func int64Option(options map[string]interface{}, key string) (int64, bool) {
+	switch v := options[key].(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// stringsOption reads a string list option such as stop sequences.
+func stringsOption(options map[string]interface{}, key string) []string {
+	switch v := options[key].(type) {
+	case []string:
+		return v
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// toolCallNameArgs returns a tool call's name and arguments, whether it was
+// parsed from a response (Name, Arguments) or rebuilt for history in the
+// OpenAI wire shape (Function).
+func toolCallNameArgs(tc ToolCall) (string, map[string]interface{}) {
+	name, args := tc.Name, tc.Arguments
+	if tc.Function != nil {
+		if name == "" {
+			name = tc.Function.Name
+		}
+		if args == nil && tc.Function.Arguments != "" {
+			if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+				args = map[string]interface{}{"raw": tc.Function.Arguments}
+			}
+		}
+	}
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	return name, args
+}
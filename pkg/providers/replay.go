@@ -0,0 +1,239 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sipeed/kakoclaw/pkg/logger"
+	"github.com/sipeed/kakoclaw/pkg/utils"
+)
+
+// Fixture is a recorded LLM conversation: the requests an agent run made and
+// the responses it got, in call order.
+type Fixture struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one recorded Chat call. Tools only lists the tool names
+// offered, for reading; replay matches on Messages.
+type Interaction struct {
+	Model    string       `json:"model"`
+	Messages []Message    `json:"messages"`
+	Tools    []string     `json:"tools,omitempty"`
+	Response *LLMResponse `json:"response,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// LoadFixture reads a fixture file written by a RecordingProvider.
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading fixture: %w", err)
+	}
+	var f Fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parsing fixture %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// RecordingProvider wraps a provider and appends every Chat call it serves
+// to a fixture file, which is rewritten after each call so a crashed run
+// still leaves a usable recording. It does not stream, so agent runs use
+// the non-streaming path while recording.
+type RecordingProvider struct {
+	inner   LLMProvider
+	path    string
+	mu      sync.Mutex
+	fixture Fixture
+}
+
+// NewRecordingProvider records the calls made to inner into path.
+func NewRecordingProvider(inner LLMProvider, path string) *RecordingProvider {
+	return &RecordingProvider{inner: inner, path: path}
+}
+
+func (p *RecordingProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	resp, err := p.inner.Chat(ctx, messages, tools, model, options)
+
+	entry := Interaction{
+		Model:    model,
+		Messages: append([]Message(nil), messages...),
+		Response: resp,
+	}
+	for _, td := range tools {
+		entry.Tools = append(entry.Tools, td.Function.Name)
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fixture.Interactions = append(p.fixture.Interactions, entry)
+	if werr := p.save(); werr != nil {
+		logger.ErrorCF("provider", "Failed to write recording", map[string]interface{}{
+			"path":  p.path,
+			"error": werr.Error(),
+		})
+	}
+	return resp, err
+}
+
+func (p *RecordingProvider) GetDefaultModel() string {
+	return p.inner.GetDefaultModel()
+}
+
+// save writes the fixture atomically. Callers must hold p.mu.
+func (p *RecordingProvider) save() error {
+	data, err := json.MarshalIndent(p.fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(p.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
+
+// ErrReplayDiverged is returned by ReplayProvider when a request matches no
+// recorded interaction.
+var ErrReplayDiverged = errors.New("replay diverged from fixture")
+
+// ReplayProvider serves the responses of a fixture. Each request is matched
+// by message content against the recorded requests not yet served; system
+// messages are ignored, as they carry the current time. Requests normally
+// arrive in recorded order, but concurrent calls (e.g. from subagents) may
+// be served out of order.
+type ReplayProvider struct {
+	fixture *Fixture
+	model   string
+	mu      sync.Mutex
+	used    []bool
+}
+
+// NewReplayProvider replays fixture. The default model is that of the first
+// recorded call.
+func NewReplayProvider(fixture *Fixture) *ReplayProvider {
+	p := &ReplayProvider{fixture: fixture, used: make([]bool, len(fixture.Interactions))}
+	if len(fixture.Interactions) > 0 {
+		p.model = fixture.Interactions[0].Model
+	}
+	return p
+}
+
+func (p *ReplayProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	got := replayKeys(messages)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	next := -1
+	for i, entry := range p.fixture.Interactions {
+		if p.used[i] {
+			continue
+		}
+		if next < 0 {
+			next = i
+		}
+		if !equalReplayKeys(replayKeys(entry.Messages), got) {
+			continue
+		}
+		p.used[i] = true
+		if entry.Error != "" {
+			return nil, errors.New(entry.Error)
+		}
+		if entry.Response == nil {
+			return nil, fmt.Errorf("replay: interaction %d has no response", i)
+		}
+		resp := *entry.Response
+		return &resp, nil
+	}
+
+	var reason string
+	if next < 0 {
+		reason = fmt.Sprintf("all %d recorded interactions were already used", len(p.fixture.Interactions))
+	} else {
+		reason = fmt.Sprintf("interaction %d: %s", next, describeDivergence(replayKeys(p.fixture.Interactions[next].Messages), got))
+	}
+	logger.ErrorCF("provider", "Replay diverged from fixture", map[string]interface{}{"reason": reason})
+	return nil, fmt.Errorf("%w: %s", ErrReplayDiverged, reason)
+}
+
+func (p *ReplayProvider) GetDefaultModel() string {
+	return p.model
+}
+
+// Remaining returns how many recorded interactions were never served. A run
+// that replays its fixture completely leaves none.
+func (p *ReplayProvider) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, used := range p.used {
+		if !used {
+			n++
+		}
+	}
+	return n
+}
+
+// replayKey is the part of a message that replay compares.
+type replayKey struct {
+	Role       string
+	Content    string
+	ToolCallID string
+	ToolCalls  string
+}
+
+func replayKeys(messages []Message) []replayKey {
+	keys := make([]replayKey, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			continue
+		}
+		key := replayKey{Role: msg.Role, Content: msg.Content, ToolCallID: msg.ToolCallID}
+		for _, tc := range msg.ToolCalls {
+			name, args := toolCallNameArgs(tc)
+			data, _ := json.Marshal(args)
+			key.ToolCalls += tc.ID + ":" + name + string(data) + ";"
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func equalReplayKeys(a, b []replayKey) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// describeDivergence explains where a request differs from a recorded one.
+func describeDivergence(want, got []replayKey) string {
+	for i := 0; i < len(want) && i < len(got); i++ {
+		if want[i] != got[i] {
+			return fmt.Sprintf("message %d differs: recorded %s %q, got %s %q", i,
+				want[i].Role, utils.Truncate(want[i].Content+want[i].ToolCalls, 120),
+				got[i].Role, utils.Truncate(got[i].Content+got[i].ToolCalls, 120))
+		}
+	}
+	return fmt.Sprintf("recorded %d messages, got %d", len(want), len(got))
+}
@@ -0,0 +1,76 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixtures", "run.json")
+	tools := []ToolDefinition{{Type: "function", Function: ToolFunctionDefinition{Name: "message"}}}
+	first := []Message{{Role: "system", Content: "It is 10:00"}, {Role: "user", Content: "hi"}}
+
+	recorder := NewRecordingProvider(NewMockProvider(), path)
+	call, err := recorder.Chat(context.Background(), first, tools, "mock", nil)
+	if err != nil {
+		t.Fatalf("record Chat: %v", err)
+	}
+	second := append(first, buildAssistant(call), Message{Role: "tool", Content: "sent", ToolCallID: call.ToolCalls[0].ID})
+	answer, err := recorder.Chat(context.Background(), second, nil, "mock", nil)
+	if err != nil {
+		t.Fatalf("record Chat: %v", err)
+	}
+
+	fixture, err := LoadFixture(path)
+	if err != nil {
+		t.Fatalf("LoadFixture: %v", err)
+	}
+	if len(fixture.Interactions) != 2 || fixture.Interactions[0].Tools[0] != "message" {
+		t.Fatalf("unexpected fixture: %+v", fixture)
+	}
+
+	replay := NewReplayProvider(fixture)
+	if replay.GetDefaultModel() != "mock" {
+		t.Errorf("GetDefaultModel = %q, want mock", replay.GetDefaultModel())
+	}
+	// The system prompt may differ between runs.
+	first[0].Content = "It is 11:00"
+	got, err := replay.Chat(context.Background(), first, tools, "mock", nil)
+	if err != nil || len(got.ToolCalls) != 1 || got.ToolCalls[0].ID != call.ToolCalls[0].ID {
+		t.Fatalf("replay first call = %+v, %v", got, err)
+	}
+	got, err = replay.Chat(context.Background(), append(first, second[2:]...), nil, "mock", nil)
+	if err != nil || got.Content != answer.Content {
+		t.Fatalf("replay second call = %+v, %v", got, err)
+	}
+	if n := replay.Remaining(); n != 0 {
+		t.Errorf("Remaining = %d, want 0", n)
+	}
+
+	_, err = replay.Chat(context.Background(), first, tools, "mock", nil)
+	if !errors.Is(err, ErrReplayDiverged) {
+		t.Fatalf("expected divergence once the fixture is used up, got %v", err)
+	}
+}
+
+func TestReplayDivergence(t *testing.T) {
+	replay := NewReplayProvider(&Fixture{Interactions: []Interaction{{
+		Model:    "mock",
+		Messages: []Message{{Role: "user", Content: "hi"}},
+		Response: &LLMResponse{Content: "hello"},
+	}}})
+
+	_, err := replay.Chat(context.Background(), []Message{{Role: "user", Content: "bye"}}, nil, "mock", nil)
+	if !errors.Is(err, ErrReplayDiverged) {
+		t.Fatalf("expected ErrReplayDiverged, got %v", err)
+	}
+	if replay.Remaining() != 1 {
+		t.Errorf("a diverged request must not consume an interaction")
+	}
+}
+
+func buildAssistant(resp *LLMResponse) Message {
+	return Message{Role: "assistant", Content: resp.Content, ToolCalls: resp.ToolCalls}
+}
@@ -0,0 +1,159 @@
+package providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// serveSSEFixture serves a recorded SSE stream from testdata for any request to path.
+func serveSSEFixture(t *testing.T, path, fixture string) *httptest.Server {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatalf("reading fixture: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.Error(w, "not found: "+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// streamResult is a stream assembled the way the agent loop assembles it.
+type streamResult struct {
+	Content      string
+	Reasoning    string
+	Thinking     []ThinkingBlock
+	ToolCalls    []ToolCall
+	FinishReason string
+	Usage        *UsageInfo
+	Done         bool
+}
+
+func collectStream(t *testing.T, ch <-chan StreamChunk) streamResult {
+	t.Helper()
+	var res streamResult
+	var content, reasoning strings.Builder
+	calls := map[int]*ToolCall{}
+	args := map[int]*strings.Builder{}
+
+	for chunk := range ch {
+		content.WriteString(chunk.Content)
+		reasoning.WriteString(chunk.Reasoning)
+		res.Thinking = append(res.Thinking, chunk.Thinking...)
+		for _, tc := range chunk.ToolCalls {
+			call, ok := calls[tc.Index]
+			if !ok {
+				call = &ToolCall{}
+				calls[tc.Index] = call
+				args[tc.Index] = &strings.Builder{}
+			}
+			if tc.ID != "" {
+				call.ID = tc.ID
+			}
+			if tc.Name != "" {
+				call.Name = tc.Name
+			}
+			if tc.Function != nil {
+				args[tc.Index].WriteString(tc.Function.Arguments)
+			}
+		}
+		if chunk.FinishReason != "" {
+			res.FinishReason = chunk.FinishReason
+		}
+		if chunk.Usage != nil {
+			res.Usage = chunk.Usage
+		}
+		if chunk.Done {
+			res.Done = true
+		}
+	}
+
+	indexes := make([]int, 0, len(calls))
+	for idx := range calls {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+	for _, idx := range indexes {
+		call := calls[idx]
+		if err := json.Unmarshal([]byte(args[idx].String()), &call.Arguments); err != nil {
+			t.Fatalf("tool call %d arguments %q are not valid JSON: %v", idx, args[idx].String(), err)
+		}
+		res.ToolCalls = append(res.ToolCalls, *call)
+	}
+	res.Content = content.String()
+	res.Reasoning = reasoning.String()
+	return res
+}
+
+func TestHTTPProvider_ChatStreamMultipleToolCalls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, line := range []string{
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"get_time","arguments":"{\"tz\":\"UTC\"}"}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Oslo\"}"}}]}}]}`,
+			`{"choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+		} {
+			_, _ = w.Write([]byte("data: " + line + "\n\n"))
+		}
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	ch, err := NewHTTPProvider("key", server.URL, "").ChatStream(t.Context(), []Message{{Role: "user", Content: "hi"}}, nil, "gpt-4o", nil)
+	if err != nil {
+		t.Fatalf("ChatStream() error: %v", err)
+	}
+	res := collectStream(t, ch)
+
+	if len(res.ToolCalls) != 2 {
+		t.Fatalf("got %d tool calls, want 2: %+v", len(res.ToolCalls), res.ToolCalls)
+	}
+	if res.ToolCalls[0].Name != "get_weather" || res.ToolCalls[0].Arguments["city"] != "Oslo" {
+		t.Errorf("first call = %+v, want get_weather(city=Oslo)", res.ToolCalls[0])
+	}
+	if res.ToolCalls[1].Name != "get_time" || res.ToolCalls[1].Arguments["tz"] != "UTC" {
+		t.Errorf("second call = %+v, want get_time(tz=UTC)", res.ToolCalls[1])
+	}
+	if res.FinishReason != "tool_calls" {
+		t.Errorf("FinishReason = %q, want tool_calls", res.FinishReason)
+	}
+}
+
+func TestHTTPProvider_ChatStreamReasoning(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, line := range []string{
+			`{"choices":[{"delta":{"role":"assistant","reasoning_content":"Two plus two "}}]}`,
+			`{"choices":[{"delta":{"reasoning_content":"is four."}}]}`,
+			`{"choices":[{"delta":{"content":"4"},"finish_reason":"stop"}]}`,
+		} {
+			_, _ = w.Write([]byte("data: " + line + "\n\n"))
+		}
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	ch, err := NewHTTPProvider("key", server.URL, "").ChatStream(t.Context(), []Message{{Role: "user", Content: "2+2?"}}, nil, "deepseek-reasoner", nil)
+	if err != nil {
+		t.Fatalf("ChatStream() error: %v", err)
+	}
+	res := collectStream(t, ch)
+
+	if res.Content != "4" || res.Reasoning != "Two plus two is four." {
+		t.Errorf("Content = %q, Reasoning = %q", res.Content, res.Reasoning)
+	}
+}
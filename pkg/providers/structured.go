@@ -0,0 +1,61 @@
+package providers
+
+import "encoding/json"
+
+// structuredOutputTool is the tool Claude is made to call to return output
+// that follows the response schema, as Claude has no JSON output mode.
+const structuredOutputTool = "structured_output"
+
+// responseSchema returns the JSON Schema the answer must follow, set with the
+// "response_schema" option, or nil.
+func responseSchema(options map[string]interface{}) map[string]interface{} {
+	schema, _ := options["response_schema"].(map[string]interface{})
+	if len(schema) == 0 {
+		return nil
+	}
+	return schema
+}
+
+// structuredOutputToolDef returns the tool definition that carries the answer
+// as its arguments. Tool arguments are always an object, so only object
+// schemas can be forced this way.
+func structuredOutputToolDef(schema map[string]interface{}) (ToolDefinition, bool) {
+	if t, _ := schema["type"].(string); t != "object" {
+		return ToolDefinition{}, false
+	}
+	return ToolDefinition{
+		Type: "function",
+		Function: ToolFunctionDefinition{
+			Name:        structuredOutputTool,
+			Description: "Return the final answer. Call this instead of replying with text once the task is done.",
+			Parameters:  schema,
+		},
+	}, true
+}
+
+// takeStructuredOutput turns a call to the structured output tool into the
+// response content. Calls to other tools take precedence, so the run can
+// continue; the structured output call is dropped in that case.
+func takeStructuredOutput(resp *LLMResponse) {
+	var rest []ToolCall
+	var args map[string]interface{}
+	found := false
+	for _, tc := range resp.ToolCalls {
+		if tc.Name == structuredOutputTool {
+			args, found = tc.Arguments, true
+			continue
+		}
+		rest = append(rest, tc)
+	}
+	if !found {
+		return
+	}
+	resp.ToolCalls = rest
+	if len(rest) > 0 {
+		return
+	}
+	if data, err := json.Marshal(args); err == nil {
+		resp.Content = string(data)
+	}
+	resp.FinishReason = "stop"
+}
@@ -0,0 +1,137 @@
+package providers
+
+import (
+	"encoding/json"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Per-message framing overhead used by chat models (role markers and separators).
+const (
+	messageOverheadTokens = 4
+	replyPrimingTokens    = 3
+)
+
+// EstimateTokens approximates how many tokens a BPE tokenizer produces for
+// text. It is only used when a provider does not report usage, so it aims to
+// be close for English prose, code and JSON rather than exact:
+//
+//   - ASCII words cost one token per ~4 letters
+//   - digit runs split into groups of three
+//   - other scripts (accented, Cyrillic, ...) cost one token per ~2 letters
+//   - CJK ideographs and kana cost about one token each
+//   - each punctuation mark or symbol costs one token
+//   - whitespace is mostly merged into the following word
+func EstimateTokens(text string) int {
+	tokens := 0
+	i := 0
+	for i < len(text) {
+		r, size := utf8.DecodeRuneInString(text[i:])
+
+		switch {
+		case isCJK(r):
+			tokens++
+			i += size
+
+		case unicode.IsDigit(r):
+			n := 0
+			for i < len(text) {
+				r, size = utf8.DecodeRuneInString(text[i:])
+				if !unicode.IsDigit(r) {
+					break
+				}
+				n++
+				i += size
+			}
+			tokens += (n + 2) / 3
+
+		case unicode.IsLetter(r):
+			n, ascii := 0, true
+			for i < len(text) {
+				r, size = utf8.DecodeRuneInString(text[i:])
+				if !unicode.IsLetter(r) || isCJK(r) {
+					break
+				}
+				if r >= utf8.RuneSelf {
+					ascii = false
+				}
+				n++
+				i += size
+			}
+			if ascii {
+				tokens += (n + 3) / 4
+			} else {
+				tokens += (n + 1) / 2
+			}
+
+		case unicode.IsSpace(r):
+			// A single space is merged into the next word; runs of
+			// whitespace (indentation, blank lines) cost one token.
+			n := 0
+			for i < len(text) {
+				r, size = utf8.DecodeRuneInString(text[i:])
+				if !unicode.IsSpace(r) {
+					break
+				}
+				n++
+				i += size
+			}
+			if n > 1 {
+				tokens++
+			}
+
+		default:
+			tokens++
+			i += size
+		}
+	}
+	return tokens
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+// EstimateMessagesTokens approximates the prompt tokens for a conversation,
+// including per-message framing and any tool calls the messages carry.
+func EstimateMessagesTokens(messages []Message) int {
+	if len(messages) == 0 {
+		return 0
+	}
+	total := replyPrimingTokens
+	for _, m := range messages {
+		total += messageOverheadTokens + EstimateTokens(m.Content)
+		total += EstimateToolCallsTokens(m.ToolCalls)
+		for _, t := range m.Thinking {
+			total += EstimateTokens(t.Thinking)
+		}
+	}
+	return total
+}
+
+// EstimateToolCallsTokens approximates the tokens used by tool calls.
+func EstimateToolCallsTokens(calls []ToolCall) int {
+	total := 0
+	for _, tc := range calls {
+		name, args := tc.Name, ""
+		if tc.Function != nil {
+			name, args = tc.Function.Name, tc.Function.Arguments
+		} else if tc.Arguments != nil {
+			argsJSON, _ := json.Marshal(tc.Arguments)
+			args = string(argsJSON)
+		}
+		total += messageOverheadTokens + EstimateTokens(name) + EstimateTokens(args)
+	}
+	return total
+}
+
+// EstimateToolDefinitionsTokens approximates the tokens used by tool schemas
+// sent with a request.
+func EstimateToolDefinitionsTokens(defs []ToolDefinition) int {
+	if len(defs) == 0 {
+		return 0
+	}
+	defsJSON, _ := json.Marshal(defs)
+	return EstimateTokens(string(defsJSON))
+}
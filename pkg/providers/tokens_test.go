@@ -0,0 +1,122 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		text     string
+		min, max int
+	}{
+		{"", 0, 0},
+		{"hello", 1, 2},
+		{"The quick brown fox jumps over the lazy dog.", 9, 14},
+		{"1234567890", 3, 5},
+		{`{"path": "/tmp/a.txt", "content": "hi"}`, 12, 24},
+		{"你好世界", 4, 6},
+		{"función de búsqueda", 4, 12},
+	}
+	for _, tt := range tests {
+		got := EstimateTokens(tt.text)
+		if got < tt.min || got > tt.max {
+			t.Errorf("EstimateTokens(%q) = %d, want between %d and %d", tt.text, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestEstimateMessagesTokens(t *testing.T) {
+	if got := EstimateMessagesTokens(nil); got != 0 {
+		t.Errorf("EstimateMessagesTokens(nil) = %d, want 0", got)
+	}
+
+	plain := []Message{{Role: "user", Content: "list the files"}}
+	withCall := append(plain, Message{
+		Role: "assistant",
+		ToolCalls: []ToolCall{{
+			ID:       "call_1",
+			Function: &FunctionCall{Name: "list_dir", Arguments: `{"path":"."}`},
+		}},
+	})
+	if a, b := EstimateMessagesTokens(plain), EstimateMessagesTokens(withCall); b <= a {
+		t.Errorf("tool calls should add tokens: %d <= %d", b, a)
+	}
+}
+
+func TestHTTPProvider_ParseResponseUsage(t *testing.T) {
+	p := NewHTTPProvider("", "http://example.invalid", "")
+	body := `{
+		"choices": [{"message": {"content": "hi"}, "finish_reason": "stop"}],
+		"usage": {
+			"prompt_tokens": 120,
+			"completion_tokens": 8,
+			"total_tokens": 128,
+			"prompt_tokens_details": {"cached_tokens": 100}
+		}
+	}`
+	resp, err := p.parseResponse([]byte(body))
+	if err != nil {
+		t.Fatalf("parseResponse: %v", err)
+	}
+	if resp.Usage == nil {
+		t.Fatal("Usage is nil")
+	}
+	if resp.Usage.PromptTokens != 120 || resp.Usage.CompletionTokens != 8 || resp.Usage.CachedTokens != 100 {
+		t.Errorf("Usage = %+v, want prompt 120, completion 8, cached 100", resp.Usage)
+	}
+
+	// Moonshot reports cache hits at the top level of usage.
+	resp, err = p.parseResponse([]byte(`{
+		"choices": [{"message": {"content": "hi"}, "finish_reason": "stop"}],
+		"usage": {"prompt_tokens": 300, "completion_tokens": 4, "total_tokens": 304, "cached_tokens": 256}
+	}`))
+	if err != nil {
+		t.Fatalf("parseResponse: %v", err)
+	}
+	if resp.Usage.CachedTokens != 256 {
+		t.Errorf("CachedTokens = %d, want 256", resp.Usage.CachedTokens)
+	}
+}
+
+func TestHTTPProvider_ChatStreamUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{"content":"Hel"}}]}`)
+		fmt.Fprintln(w, `data: {"choices":[{"delta":{"content":"lo"},"finish_reason":"stop"}]}`)
+		fmt.Fprintln(w, `data: {"choices":[],"usage":{"prompt_tokens":50,"completion_tokens":2,"total_tokens":52}}`)
+		fmt.Fprintln(w, `data: [DONE]`)
+	}))
+	defer server.Close()
+
+	p := NewHTTPProvider("", server.URL, "")
+	ch, err := p.ChatStream(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, "test-model", nil)
+	if err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+
+	var content strings.Builder
+	var usage *UsageInfo
+	var last StreamChunk
+	for chunk := range ch {
+		content.WriteString(chunk.Content)
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		last = chunk
+	}
+
+	if content.String() != "Hello" {
+		t.Errorf("content = %q, want %q", content.String(), "Hello")
+	}
+	if usage == nil || usage.PromptTokens != 50 || usage.CompletionTokens != 2 {
+		t.Errorf("usage = %+v, want prompt 50, completion 2", usage)
+	}
+	if !last.Done || last.FinishReason != "stop" {
+		t.Errorf("last chunk = %+v, want done with finish reason stop", last)
+	}
+}
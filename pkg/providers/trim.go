@@ -0,0 +1,96 @@
+package providers
+
+import "fmt"
+
+// compactMinTokens is the smallest tool result worth compacting; shorter
+// results would barely shrink once replaced by a placeholder.
+const compactMinTokens = 64
+
+// TrimReport describes what FitContext removed from a conversation.
+type TrimReport struct {
+	Budget          int      // prompt tokens available for messages
+	TokensBefore    int      // estimated prompt tokens before trimming
+	TokensAfter     int      // estimated prompt tokens after trimming
+	CompactedTools  []string // tool call IDs whose results were replaced by a placeholder
+	DroppedMessages int      // messages removed with their turns
+	Overflow        bool     // still over budget after trimming everything allowed
+}
+
+// Trimmed reports whether FitContext changed the conversation.
+func (r TrimReport) Trimmed() bool {
+	return len(r.CompactedTools) > 0 || r.DroppedMessages > 0
+}
+
+// FitContext trims messages so their estimated size fits in budget tokens.
+// It first compacts old tool results (oldest first), then drops the oldest
+// whole turns. The system prompt, the latest user message and everything
+// after it are always kept; tool results the model has not seen yet (those
+// after the last assistant message) are never compacted. messages is not
+// modified.
+func FitContext(messages []Message, budget int) ([]Message, TrimReport) {
+	report := TrimReport{Budget: budget, TokensBefore: EstimateMessagesTokens(messages)}
+	report.TokensAfter = report.TokensBefore
+	if report.TokensBefore <= budget || len(messages) == 0 {
+		return messages, report
+	}
+
+	out := make([]Message, len(messages))
+	copy(out, messages)
+	tokens := report.TokensBefore
+
+	// The newest real user message starts the protected current turn.
+	current := len(out) - 1
+	for i := len(out) - 1; i >= 0; i-- {
+		if out[i].Role == "user" && out[i].ToolCallID == "" {
+			current = i
+			break
+		}
+	}
+	unseen := len(out)
+	for i := len(out) - 1; i >= 0; i-- {
+		if out[i].Role == "assistant" {
+			unseen = i + 1
+			break
+		}
+	}
+
+	// 1. Compact old tool results.
+	for i := 0; i < unseen && tokens > budget; i++ {
+		m := out[i]
+		if m.Role != "tool" && !(m.Role == "user" && m.ToolCallID != "") {
+			continue
+		}
+		size := EstimateTokens(m.Content)
+		if size < compactMinTokens {
+			continue
+		}
+		m.Content = fmt.Sprintf("[tool result elided to fit the context window: %d tokens]", size)
+		tokens -= size - EstimateTokens(m.Content)
+		out[i] = m
+		report.CompactedTools = append(report.CompactedTools, m.ToolCallID)
+	}
+
+	// 2. Drop the oldest turns. A turn runs from one user message to the
+	// next, so tool calls and their results leave together.
+	start := 0
+	if out[0].Role == "system" {
+		start = 1
+	}
+	end := start
+	for end < current && tokens > budget {
+		next := end + 1
+		for next < current && !(out[next].Role == "user" && out[next].ToolCallID == "") {
+			next++
+		}
+		tokens -= EstimateMessagesTokens(out[end:next]) - replyPrimingTokens
+		end = next
+	}
+	if end > start {
+		report.DroppedMessages = end - start
+		out = append(out[:start], out[end:]...)
+	}
+
+	report.TokensAfter = EstimateMessagesTokens(out)
+	report.Overflow = report.TokensAfter > budget
+	return out, report
+}
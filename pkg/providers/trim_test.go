@@ -0,0 +1,80 @@
+package providers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFitContextUnderBudget(t *testing.T) {
+	msgs := []Message{{Role: "system", Content: "sys"}, {Role: "user", Content: "hi"}}
+	out, report := FitContext(msgs, 1000)
+	if len(out) != 2 || report.Trimmed() {
+		t.Fatalf("FitContext changed a conversation that fits: %+v", report)
+	}
+}
+
+func longText(words int) string {
+	return strings.Repeat("lorem ipsum dolor ", words)
+}
+
+func TestFitContextCompactsOldToolResultsFirst(t *testing.T) {
+	msgs := []Message{
+		{Role: "system", Content: "sys"},
+		{Role: "user", Content: "list files"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "t1", Name: "ls"}}},
+		{Role: "tool", ToolCallID: "t1", Content: longText(500)},
+		{Role: "assistant", Content: "done"},
+		{Role: "user", Content: "thanks"},
+	}
+	budget := EstimateMessagesTokens(msgs) - 200
+	out, report := FitContext(msgs, budget)
+
+	if len(report.CompactedTools) != 1 || report.CompactedTools[0] != "t1" || report.DroppedMessages != 0 {
+		t.Fatalf("report = %+v, want only t1 compacted", report)
+	}
+	if len(out) != len(msgs) || !strings.Contains(out[3].Content, "elided") {
+		t.Fatalf("tool result = %q, want a placeholder", out[3].Content)
+	}
+	if msgs[3].Content == out[3].Content {
+		t.Fatal("FitContext must not modify its input")
+	}
+	if report.TokensAfter > budget {
+		t.Fatalf("tokens after = %d, budget %d", report.TokensAfter, budget)
+	}
+}
+
+func TestFitContextDropsOldestTurns(t *testing.T) {
+	msgs := []Message{
+		{Role: "system", Content: "sys"},
+		{Role: "user", Content: longText(200)},
+		{Role: "assistant", Content: longText(200)},
+		{Role: "user", Content: "second"},
+		{Role: "assistant", Content: "ok"},
+		{Role: "user", Content: "latest question"},
+	}
+	out, report := FitContext(msgs, 100)
+
+	if report.DroppedMessages != 2 || report.Overflow {
+		t.Fatalf("report = %+v, want the first turn dropped", report)
+	}
+	if out[0].Role != "system" || out[1].Content != "second" || out[len(out)-1].Content != "latest question" {
+		t.Fatalf("out = %+v", out)
+	}
+}
+
+func TestFitContextKeepsCurrentTurn(t *testing.T) {
+	msgs := []Message{
+		{Role: "system", Content: "sys"},
+		{Role: "user", Content: "read the log"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "t1", Name: "read_file"}}},
+		{Role: "tool", ToolCallID: "t1", Content: longText(500)},
+	}
+	out, report := FitContext(msgs, 50)
+
+	if len(out) != 4 || out[3].Content != msgs[3].Content {
+		t.Fatal("an unseen tool result and the latest user message must be kept")
+	}
+	if !report.Overflow {
+		t.Fatalf("report = %+v, want overflow flagged", report)
+	}
+}
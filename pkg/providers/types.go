@@ -8,6 +8,9 @@ type ToolCall struct {
 	Function  *FunctionCall          `json:"function,omitempty"`
 	Name      string                 `json:"name,omitempty"`
 	Arguments map[string]interface{} `json:"arguments,omitempty"`
+	// Index identifies which call a streamed fragment belongs to when a
+	// response carries several tool calls. Only set on StreamChunk fragments.
+	Index int `json:"-"`
 }
 
 type FunctionCall struct {
@@ -17,22 +20,48 @@ type FunctionCall struct {
 
 type LLMResponse struct {
 	Content      string     `json:"content"`
+	Reasoning    string     `json:"reasoning,omitempty"` // Thinking text, kept apart from Content
 	ToolCalls    []ToolCall `json:"tool_calls,omitempty"`
 	FinishReason string     `json:"finish_reason"`
 	Usage        *UsageInfo `json:"usage,omitempty"`
+	// Model is the model that produced the response, when the provider knows
+	// it; a failover backend may serve a different model than requested.
+	Model string `json:"model,omitempty"`
+	// Thinking holds the provider's signed thinking blocks. They must be
+	// sent back with the assistant message when it issued tool calls.
+	Thinking []ThinkingBlock `json:"thinking,omitempty"`
 }
 
+// ThinkingBlock is a Claude thinking block. Claude verifies the signature, so
+// blocks are passed back exactly as received.
+type ThinkingBlock struct {
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Redacted  string `json:"redacted,omitempty"` // Data of a redacted_thinking block
+}
+
+// UsageInfo is the token usage reported by a provider. PromptTokens is the
+// full prompt size, including any part served from or written to a cache.
 type UsageInfo struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	TotalTokens         int `json:"total_tokens"`
+	CachedTokens        int `json:"cached_tokens,omitempty"`         // Prompt tokens read from the provider's cache
+	CacheCreationTokens int `json:"cache_creation_tokens,omitempty"` // Prompt tokens written to the provider's cache
 }
 
 type Message struct {
-	Role       string     `json:"role"`
-	Content    string     `json:"content"`
-	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
-	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// Parts, when set, is the full multimodal body of the message; Content
+	// then holds its text for token estimates, logs and text-only backends.
+	Parts      []ContentPart `json:"parts,omitempty"`
+	ToolCalls  []ToolCall    `json:"tool_calls,omitempty"`
+	ToolCallID string        `json:"tool_call_id,omitempty"`
+	// Reasoning and Thinking carry an assistant message's thinking. Only
+	// Thinking is sent back to the provider; Reasoning is for display.
+	Reasoning string          `json:"reasoning,omitempty"`
+	Thinking  []ThinkingBlock `json:"thinking,omitempty"`
 }
 
 type LLMProvider interface {
@@ -42,11 +71,14 @@ type LLMProvider interface {
 
 // StreamChunk represents a single token/chunk from a streaming response.
 type StreamChunk struct {
-	Content      string     `json:"content"` // Text delta (incremental token)
-	ToolCalls    []ToolCall `json:"tool_calls,omitempty"`
-	FinishReason string     `json:"finish_reason"`   // Empty until stream ends, then "stop"/"tool_calls"/"length"
-	Done         bool       `json:"done"`            // True when stream is complete
-	Usage        *UsageInfo `json:"usage,omitempty"` // Only set on final chunk
+	Content      string          `json:"content"`             // Text delta (incremental token)
+	Reasoning    string          `json:"reasoning,omitempty"` // Thinking text delta
+	ToolCalls    []ToolCall      `json:"tool_calls,omitempty"`
+	FinishReason string          `json:"finish_reason"`      // Empty until stream ends, then "stop"/"tool_calls"/"length"
+	Done         bool            `json:"done"`               // True when stream is complete
+	Usage        *UsageInfo      `json:"usage,omitempty"`    // Only set on final chunk
+	Thinking     []ThinkingBlock `json:"thinking,omitempty"` // Completed thinking blocks; only set on final chunk
+	Model        string          `json:"model,omitempty"`    // Model that produced the response, if known
 }
 
 // StreamingLLMProvider is an optional interface for providers that support token-by-token streaming.
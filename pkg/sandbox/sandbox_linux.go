@@ -0,0 +1,188 @@
+//go:build linux && (amd64 || arm64)
+
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+)
+
+// helperFailed is the helper's exit code when it cannot confine a command.
+const helperFailed = 125
+
+func init() {
+	if raw, ok := os.LookupEnv(specEnv); ok {
+		runHelper(raw)
+	}
+}
+
+// Command returns a command that runs the shell command in the sandbox,
+// starting in dir. The workspace is the only writable directory besides
+// cfg.WritePaths and a private temporary directory; call cleanup once the
+// command has finished to remove the latter.
+func Command(ctx context.Context, cfg config.SandboxConfig, workspace, dir, command string) (*exec.Cmd, func(), error) {
+	workspace, err := filepath.Abs(workspace)
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolving workspace: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp("", "kakoclaw-sandbox-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating sandbox temp dir: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
+
+	data, err := json.Marshal(newSpec(cfg, workspace, tmpDir, command))
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("encoding sandbox spec: %w", err)
+	}
+
+	flags := uintptr(syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS)
+	if !cfg.Network {
+		flags |= syscall.CLONE_NEWNET
+	}
+
+	cmd := exec.CommandContext(ctx, "/proc/self/exe")
+	cmd.Args = []string{helperArg0}
+	cmd.Dir = dir
+	cmd.Env = []string{specEnv + "=" + string(data)}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  flags,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}},
+		// The command is PID 1 of its namespace, so killing it on timeout
+		// also kills everything it started.
+		Pdeathsig: syscall.SIGKILL,
+	}
+	return cmd, cleanup, nil
+}
+
+var probe struct {
+	once sync.Once
+	err  error
+}
+
+// Available reports whether commands can be sandboxed on this system. The
+// first call runs a trivial command in the sandbox; the result is cached.
+func Available() error {
+	probe.once.Do(func() { probe.err = runProbe() })
+	return probe.err
+}
+
+func runProbe() error {
+	dir, err := os.MkdirTemp("", "kakoclaw-sandbox-probe-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd, cleanup, err := Command(ctx, config.SandboxConfig{}, dir, dir, "true")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("sandbox unavailable: %s", msg)
+		}
+		return fmt.Errorf("sandbox unavailable: %w", err)
+	}
+	return nil
+}
+
+// runHelper confines the current process as described by raw and replaces
+// it with the shell. It never returns.
+func runHelper(raw string) {
+	// Landlock, seccomp and no_new_privs apply to the calling thread, which
+	// must therefore be the one that execs the shell.
+	runtime.LockOSThread()
+	os.Unsetenv(specEnv)
+
+	var s spec
+	if err := json.Unmarshal([]byte(raw), &s); err != nil {
+		helperFail(fmt.Errorf("decoding spec: %w", err))
+	}
+	if err := confine(s); err != nil {
+		helperFail(err)
+	}
+	err := syscall.Exec("/bin/sh", []string{"sh", "-c", s.Command}, s.Env)
+	helperFail(fmt.Errorf("exec /bin/sh: %w", err))
+}
+
+func helperFail(err error) {
+	fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+	os.Exit(helperFailed)
+}
+
+func confine(s spec) error {
+	proc := mountProc()
+	if err := setLimits(s); err != nil {
+		return fmt.Errorf("setting resource limits: %w", err)
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("setting no_new_privs: %w", err)
+	}
+	if err := restrictFilesystem(s, proc); err != nil {
+		return fmt.Errorf("landlock: %w", err)
+	}
+	if err := installSeccomp(); err != nil {
+		return fmt.Errorf("seccomp: %w", err)
+	}
+	return nil
+}
+
+// mountProc replaces /proc, in the sandbox's own mount namespace, with one
+// that shows only the sandbox's processes. It reports whether that worked;
+// otherwise /proc stays unreadable.
+func mountProc() bool {
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return false
+	}
+	return unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "") == nil
+}
+
+func setLimits(s spec) error {
+	const mb = 1 << 20
+	limits := []struct {
+		resource int
+		value    uint64
+	}{
+		{unix.RLIMIT_CORE, 0},
+		{unix.RLIMIT_CPU, uint64(s.CPUSeconds)},
+		{unix.RLIMIT_DATA, uint64(s.MemoryMB) * mb},
+		{unix.RLIMIT_NPROC, uint64(s.MaxProcesses)},
+		{unix.RLIMIT_FSIZE, uint64(s.MaxFileSizeMB) * mb},
+	}
+	for _, l := range limits {
+		if l.value == 0 && l.resource != unix.RLIMIT_CORE {
+			continue
+		}
+		var current unix.Rlimit
+		if err := unix.Getrlimit(l.resource, &current); err != nil {
+			return err
+		}
+		value := l.value
+		if current.Max < value {
+			value = current.Max
+		}
+		if err := unix.Setrlimit(l.resource, &unix.Rlimit{Cur: value, Max: value}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
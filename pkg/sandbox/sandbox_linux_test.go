@@ -0,0 +1,171 @@
+//go:build linux && (amd64 || arm64)
+
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+)
+
+func requireSandbox(t *testing.T) {
+	t.Helper()
+	if err := Available(); err != nil {
+		t.Skipf("sandbox not available here: %v", err)
+	}
+}
+
+func run(t *testing.T, cfg config.SandboxConfig, workspace, command string) (string, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	cmd, cleanup, err := Command(ctx, cfg, workspace, workspace, command)
+	if err != nil {
+		t.Fatalf("Command: %v", err)
+	}
+	defer cleanup()
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestSandboxConfinesFilesystem(t *testing.T) {
+	requireSandbox(t)
+	root := t.TempDir()
+	workspace := filepath.Join(root, "workspace")
+	outside := filepath.Join(root, "outside")
+	for _, dir := range []string{workspace, outside} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secret := filepath.Join(outside, "secret.txt")
+	if err := os.WriteFile(secret, []byte("top secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(workspace, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := run(t, config.SandboxConfig{}, workspace, "echo hello > notes.txt && cat notes.txt && ls /usr/bin >/dev/null"); err != nil || strings.TrimSpace(out) != "hello" {
+		t.Fatalf("commands inside the workspace should work: %q, %v", out, err)
+	}
+
+	escapes := []string{
+		"cat " + secret,
+		"cat ../outside/secret.txt",
+		`d=..; cat "$d/outside/secret.txt"`,
+		"cd .. && cd outside && cat secret.txt",
+		"cat link/secret.txt",
+		"echo pwned > " + filepath.Join(outside, "new.txt"),
+		"echo pwned > link/new.txt",
+		"ln -s " + secret + " alias && cat alias",
+		"cp " + secret + " copy.txt",
+		"cat " + filepath.Join(os.Getenv("HOME"), ".bashrc"),
+	}
+	for _, command := range escapes {
+		out, _ := run(t, config.SandboxConfig{}, workspace, command)
+		if strings.Contains(out, "top secret") {
+			t.Errorf("%q read the secret: %q", command, out)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new.txt")); err == nil {
+		t.Error("a command wrote outside the workspace")
+	}
+}
+
+func TestSandboxIsolatesProcessesAndEnvironment(t *testing.T) {
+	requireSandbox(t)
+	workspace := t.TempDir()
+	t.Setenv("KAKOCLAW_TEST_API_KEY", "sk-secret")
+
+	out, err := run(t, config.SandboxConfig{}, workspace, "env; echo pid=$$")
+	if err != nil {
+		t.Fatalf("run: %v: %s", err, out)
+	}
+	if strings.Contains(out, "sk-secret") {
+		t.Errorf("the agent's environment leaked into the sandbox: %q", out)
+	}
+	if !strings.Contains(out, "pid=1\n") {
+		t.Errorf("expected the command to be PID 1 of its own namespace: %q", out)
+	}
+
+	for _, command := range []string{"unshare -r true", "mount -t tmpfs none " + workspace} {
+		if _, err := exec.LookPath(strings.Fields(command)[0]); err != nil {
+			continue
+		}
+		if out, err := run(t, config.SandboxConfig{}, workspace, command); err == nil {
+			t.Errorf("%q should be refused, got %q", command, out)
+		}
+	}
+}
+
+func TestSandboxBlocksNetwork(t *testing.T) {
+	requireSandbox(t)
+	client := ""
+	if _, err := exec.LookPath("curl"); err == nil {
+		client = "curl -s -m 5 http://%s/"
+	} else if _, err := exec.LookPath("bash"); err == nil {
+		client = "bash -c 'echo > /dev/tcp/%s'"
+	} else {
+		t.Skip("no curl or bash to test the network with")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("HTTP/1.0 200 OK\r\nContent-Length: 7\r\n\r\nreached"))
+			conn.Close()
+		}
+	}()
+	addr := ln.Addr().String()
+	if strings.HasPrefix(client, "bash") {
+		addr = strings.Replace(addr, ":", "/", 1)
+	}
+	command := fmt.Sprintf(client, addr)
+
+	workspace := t.TempDir()
+	if out, err := run(t, config.SandboxConfig{}, workspace, command); err == nil {
+		t.Fatalf("expected the connection to fail without network access, got %q", out)
+	}
+	if out, err := run(t, config.SandboxConfig{Network: true}, workspace, command); err != nil {
+		t.Fatalf("expected the connection to work with network access: %v: %q", err, out)
+	}
+}
+
+func TestSandboxResourceLimits(t *testing.T) {
+	requireSandbox(t)
+	workspace := t.TempDir()
+	cfg := config.SandboxConfig{CPUSeconds: 7, MemoryMB: 256, MaxProcesses: 50, MaxFileSizeMB: 1}
+
+	out, err := run(t, cfg, workspace, "ulimit -t; ulimit -d; ulimit -p 2>/dev/null || ulimit -u; ulimit -f")
+	if err != nil {
+		t.Fatalf("run: %v: %s", err, out)
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 4 || fields[0] != "7" || fields[1] != "262144" || fields[2] != "50" || fields[3] != "2048" {
+		t.Fatalf("unexpected limits: %q", out)
+	}
+
+	if _, err := run(t, cfg, workspace, "head -c 2000000 /dev/zero > big.bin"); err == nil {
+		t.Error("expected writing past the file size limit to fail")
+	}
+	if info, err := os.Stat(filepath.Join(workspace, "big.bin")); err == nil && info.Size() > 1<<20 {
+		t.Errorf("file grew past the limit: %d bytes", info.Size())
+	}
+}
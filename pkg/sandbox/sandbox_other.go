@@ -0,0 +1,20 @@
+//go:build !linux || !(amd64 || arm64)
+
+package sandbox
+
+import (
+	"context"
+	"os/exec"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+)
+
+// Command is not supported on this platform.
+func Command(ctx context.Context, cfg config.SandboxConfig, workspace, dir, command string) (*exec.Cmd, func(), error) {
+	return nil, nil, ErrUnsupported
+}
+
+// Available reports that this platform has no sandbox.
+func Available() error {
+	return ErrUnsupported
+}
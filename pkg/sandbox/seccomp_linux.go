@@ -0,0 +1,133 @@
+//go:build linux && (amd64 || arm64)
+
+package sandbox
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// deniedSyscalls fail with EPERM inside the sandbox. They manage mounts,
+// namespaces, kernel modules, keys and the system clock, or inspect other
+// processes, none of which a workspace command needs.
+var deniedSyscalls = []uint32{
+	unix.SYS_PTRACE,
+	unix.SYS_PROCESS_VM_READV,
+	unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT,
+	unix.SYS_UMOUNT2,
+	unix.SYS_PIVOT_ROOT,
+	unix.SYS_CHROOT,
+	unix.SYS_FSOPEN,
+	unix.SYS_FSCONFIG,
+	unix.SYS_FSMOUNT,
+	unix.SYS_FSPICK,
+	unix.SYS_MOVE_MOUNT,
+	unix.SYS_OPEN_TREE,
+	unix.SYS_MOUNT_SETATTR,
+	unix.SYS_UNSHARE,
+	unix.SYS_SETNS,
+	unix.SYS_KEXEC_LOAD,
+	unix.SYS_KEXEC_FILE_LOAD,
+	unix.SYS_INIT_MODULE,
+	unix.SYS_FINIT_MODULE,
+	unix.SYS_DELETE_MODULE,
+	unix.SYS_REBOOT,
+	unix.SYS_SWAPON,
+	unix.SYS_SWAPOFF,
+	unix.SYS_ACCT,
+	unix.SYS_QUOTACTL,
+	unix.SYS_BPF,
+	unix.SYS_PERF_EVENT_OPEN,
+	unix.SYS_USERFAULTFD,
+	unix.SYS_KEYCTL,
+	unix.SYS_ADD_KEY,
+	unix.SYS_REQUEST_KEY,
+	unix.SYS_NAME_TO_HANDLE_AT,
+	unix.SYS_OPEN_BY_HANDLE_AT,
+	unix.SYS_SETTIMEOFDAY,
+	unix.SYS_CLOCK_SETTIME,
+	unix.SYS_CLOCK_ADJTIME,
+	unix.SYS_ADJTIMEX,
+}
+
+// namespaceFlags are the clone flags that create namespaces.
+const namespaceFlags = unix.CLONE_NEWNS | unix.CLONE_NEWCGROUP | unix.CLONE_NEWUTS | unix.CLONE_NEWIPC |
+	unix.CLONE_NEWUSER | unix.CLONE_NEWPID | unix.CLONE_NEWNET
+
+// seccompFilter builds the BPF program: foreign architectures are killed,
+// denied syscalls and namespace-creating clones get EPERM, and clone3,
+// whose flags the filter cannot read, gets ENOSYS so libc falls back to
+// clone.
+func seccompFilter() []unix.SockFilter {
+	type insn struct {
+		label  string
+		code   uint16
+		k      uint32
+		jt, jf string
+	}
+	const (
+		ld   = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+		jeq  = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+		jge  = unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K
+		jset = unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K
+		ret  = unix.BPF_RET | unix.BPF_K
+
+		// Offsets into struct seccomp_data.
+		offNr   = 0
+		offArch = 4
+		offArg0 = 16 // low 32 bits on little-endian machines
+	)
+
+	insns := []insn{
+		{code: ld, k: offArch},
+		{code: jeq, k: auditArch, jf: "kill"},
+		{code: ld, k: offNr},
+	}
+	if x32Bit != 0 {
+		insns = append(insns, insn{code: jge, k: x32Bit, jt: "eperm"})
+	}
+	insns = append(insns,
+		insn{code: jeq, k: unix.SYS_CLONE3, jt: "enosys"},
+		insn{code: jeq, k: unix.SYS_CLONE, jt: "clone"},
+	)
+	for _, nr := range deniedSyscalls {
+		insns = append(insns, insn{code: jeq, k: nr, jt: "eperm"})
+	}
+	insns = append(insns,
+		insn{code: ret, k: unix.SECCOMP_RET_ALLOW},
+		insn{label: "clone", code: ld, k: offArg0},
+		insn{code: jset, k: namespaceFlags, jt: "eperm"},
+		insn{code: ret, k: unix.SECCOMP_RET_ALLOW},
+		insn{label: "eperm", code: ret, k: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		insn{label: "enosys", code: ret, k: unix.SECCOMP_RET_ERRNO | uint32(unix.ENOSYS)},
+		insn{label: "kill", code: ret, k: unix.SECCOMP_RET_KILL_PROCESS},
+	)
+
+	labels := make(map[string]int)
+	for i, in := range insns {
+		if in.label != "" {
+			labels[in.label] = i
+		}
+	}
+	prog := make([]unix.SockFilter, len(insns))
+	for i, in := range insns {
+		prog[i] = unix.SockFilter{Code: in.code, K: in.k}
+		if in.jt != "" {
+			prog[i].Jt = uint8(labels[in.jt] - i - 1)
+		}
+		if in.jf != "" {
+			prog[i].Jf = uint8(labels[in.jf] - i - 1)
+		}
+	}
+	return prog
+}
+
+// installSeccomp applies the filter to the calling thread. no_new_privs must
+// already be set.
+func installSeccomp() error {
+	filter := seccompFilter()
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	return unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0)
+}
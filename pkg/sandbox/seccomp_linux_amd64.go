@@ -0,0 +1,9 @@
+package sandbox
+
+import "golang.org/x/sys/unix"
+
+const auditArch = unix.AUDIT_ARCH_X86_64
+
+// x32Bit marks x32 ABI syscalls, which the filter refuses outright so they
+// cannot be used to get around it.
+const x32Bit = 0x40000000
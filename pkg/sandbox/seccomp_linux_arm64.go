@@ -0,0 +1,8 @@
+package sandbox
+
+import "golang.org/x/sys/unix"
+
+const auditArch = unix.AUDIT_ARCH_AARCH64
+
+// x32Bit is zero on arm64, which has no second syscall ABI.
+const x32Bit = 0
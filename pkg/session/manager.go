@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/sipeed/kakoclaw/pkg/config"
 	"github.com/sipeed/kakoclaw/pkg/providers"
 )
 
@@ -15,8 +16,10 @@ type Session struct {
 	Key      string              `json:"key"`
 	Messages []providers.Message `json:"messages"`
 	Summary  string              `json:"summary,omitempty"`
-	Created  time.Time           `json:"created"`
-	Updated  time.Time           `json:"updated"`
+	// Generation overrides the configured generation parameters for this session.
+	Generation *config.GenerationParams `json:"generation,omitempty"`
+	Created    time.Time                `json:"created"`
+	Updated    time.Time                `json:"updated"`
 }
 
 type SessionManager struct {
@@ -180,6 +183,39 @@ func (sm *SessionManager) SetSummaryForUser(userID int64, key string, summary st
 	}
 }
 
+// GetGenerationForUser returns the generation overrides of a user's session,
+// or nil when none are set.
+func (sm *SessionManager) GetGenerationForUser(userID int64, key string) *config.GenerationParams {
+	nsKey := sm.namespaceKey(userID, key)
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	session, ok := sm.sessions[nsKey]
+	if !ok || session.Generation == nil {
+		return nil
+	}
+	params := *session.Generation
+	return &params
+}
+
+// SetGenerationForUser replaces the generation overrides of a user's session,
+// creating the session if needed, and saves it. A nil params clears them.
+func (sm *SessionManager) SetGenerationForUser(userID int64, key string, params *config.GenerationParams) error {
+	session := sm.GetOrCreateForUser(userID, key)
+
+	sm.mu.Lock()
+	if params != nil && !params.IsZero() {
+		p := *params
+		session.Generation = &p
+	} else {
+		session.Generation = nil
+	}
+	session.Updated = time.Now()
+	sm.mu.Unlock()
+
+	return sm.SaveForUser(userID, session)
+}
+
 func (sm *SessionManager) TruncateHistory(key string, keepLast int) {
 	sm.TruncateHistoryForUser(0, key, keepLast)
 }
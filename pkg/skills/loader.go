@@ -27,6 +27,7 @@ type SkillsLoader struct {
 	userSkillsPath  string // user-specific skills (~/.kakoclaw/users/<uuid>/skills)
 	globalSkills    string // 全局 skills (~/.KakoClaw/skills)
 	builtinSkills   string // 内置 skills
+	allowed         []string
 }
 
 func NewSkillsLoader(workspace string, globalSkills string, builtinSkills string) *SkillsLoader {
@@ -45,6 +46,24 @@ func (sl *SkillsLoader) SetUserSkillsPath(path string) {
 	sl.userSkillsPath = path
 }
 
+// SetAllowed limits the loader to the named skills. An empty list allows
+// every skill.
+func (sl *SkillsLoader) SetAllowed(names []string) {
+	sl.allowed = names
+}
+
+func (sl *SkillsLoader) isAllowed(name string) bool {
+	if len(sl.allowed) == 0 {
+		return true
+	}
+	for _, allowed := range sl.allowed {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (sl *SkillsLoader) ListSkills() []SkillInfo {
 	skills := make([]SkillInfo, 0)
 
@@ -176,10 +195,24 @@ func (sl *SkillsLoader) ListSkills() []SkillInfo {
 		}
 	}
 
+	if len(sl.allowed) > 0 {
+		filtered := skills[:0]
+		for _, skill := range skills {
+			if sl.isAllowed(skill.Name) {
+				filtered = append(filtered, skill)
+			}
+		}
+		skills = filtered
+	}
+
 	return skills
 }
 
 func (sl *SkillsLoader) LoadSkill(name string) (string, bool) {
+	if !sl.isAllowed(name) {
+		return "", false
+	}
+
 	// 1. 优先从 workspace skills 加载（项目级别）
 	if sl.workspaceSkills != "" {
 		skillFile := filepath.Join(sl.workspaceSkills, name, "SKILL.md")
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// ToolApproval records a human approval decision for a gated tool call.
+type ToolApproval struct {
+	ID         string    `json:"id"`
+	Tool       string    `json:"tool"`
+	Arguments  string    `json:"arguments"`
+	UserID     int64     `json:"user_id"`
+	SessionKey string    `json:"session_key"`
+	Channel    string    `json:"channel"`
+	ChatID     string    `json:"chat_id"`
+	Decision   string    `json:"decision"` // "approve_once", "approve_session", "deny", "timeout"
+	DecidedBy  string    `json:"decided_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	DecidedAt  time.Time `json:"decided_at"`
+}
+
+func (s *Storage) migrateApprovals() error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS tool_approvals (
+			id TEXT PRIMARY KEY,
+			tool TEXT NOT NULL,
+			arguments TEXT NOT NULL DEFAULT '{}',
+			user_id INTEGER NOT NULL DEFAULT 0,
+			session_key TEXT NOT NULL DEFAULT '',
+			channel TEXT NOT NULL DEFAULT '',
+			chat_id TEXT NOT NULL DEFAULT '',
+			decision TEXT NOT NULL,
+			decided_by TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			decided_at DATETIME NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_tool_approvals_user ON tool_approvals(user_id, decided_at DESC);`,
+	}
+
+	for _, q := range queries {
+		if _, err := s.db.Exec(q); err != nil {
+			return fmt.Errorf("approval migration: %w", err)
+		}
+	}
+	return nil
+}
+
+// SaveToolApproval stores an approval decision.
+func (s *Storage) SaveToolApproval(a ToolApproval) error {
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO tool_approvals
+		(id, tool, arguments, user_id, session_key, channel, chat_id, decision, decided_by, created_at, decided_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.ID, a.Tool, a.Arguments, a.UserID, a.SessionKey, a.Channel, a.ChatID,
+		a.Decision, a.DecidedBy, a.CreatedAt, a.DecidedAt)
+	if err != nil {
+		return fmt.Errorf("saving tool approval: %w", err)
+	}
+	return nil
+}
+
+// ListToolApprovals returns the most recent approval decisions. If userID is
+// greater than zero, only that user's decisions are returned.
+func (s *Storage) ListToolApprovals(userID int64, limit int) ([]ToolApproval, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	query := `SELECT id, tool, arguments, user_id, session_key, channel, chat_id, decision, decided_by, created_at, decided_at
+		FROM tool_approvals`
+	args := []interface{}{}
+	if userID > 0 {
+		query += ` WHERE user_id = ?`
+		args = append(args, userID)
+	}
+	query += ` ORDER BY decided_at DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("listing tool approvals: %w", err)
+	}
+	defer rows.Close()
+
+	var approvals []ToolApproval
+	for rows.Next() {
+		var a ToolApproval
+		if err := rows.Scan(&a.ID, &a.Tool, &a.Arguments, &a.UserID, &a.SessionKey, &a.Channel, &a.ChatID,
+			&a.Decision, &a.DecidedBy, &a.CreatedAt, &a.DecidedAt); err != nil {
+			return nil, fmt.Errorf("scanning tool approval: %w", err)
+		}
+		approvals = append(approvals, a)
+	}
+	return approvals, rows.Err()
+}
@@ -12,6 +12,7 @@ type Message struct {
 	SessionID string    `json:"session_id"`
 	Role      string    `json:"role"`
 	Content   string    `json:"content"`
+	Agent     string    `json:"agent,omitempty"` // Named agent that wrote the message, "" for the default agent
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -21,6 +22,7 @@ type Session struct {
 	SessionID string    `json:"session_id"`
 	Title     string    `json:"title"`
 	Archived  bool      `json:"archived"`
+	Agent     string    `json:"agent"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -30,6 +32,7 @@ type SessionSummary struct {
 	SessionID    string    `json:"session_id"`
 	Title        string    `json:"title"`
 	Archived     bool      `json:"archived"`
+	Agent        string    `json:"agent"`
 	LastMessage  string    `json:"last_message"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	MessageCount int       `json:"message_count"`
@@ -94,12 +97,18 @@ func (s *Storage) SaveMessage(sessionID, role, content string) error {
 
 // SaveMessageForUser saves a message scoped to a specific user.
 func (s *Storage) SaveMessageForUser(userID int64, sessionID, role, content string) error {
+	return s.SaveAgentMessageForUser(userID, sessionID, role, content, "")
+}
+
+// SaveAgentMessageForUser saves a message written by the named agent, so
+// history and exports show which agent answered.
+func (s *Storage) SaveAgentMessageForUser(userID int64, sessionID, role, content, agent string) error {
 	if err := s.ensureSessionForUser(sessionID, userID); err != nil {
 		return err
 	}
 	uid := normalizeUserID(userID)
-	query := `INSERT INTO chats (session_id, user_id, role, content) VALUES (?, ?, ?, ?)`
-	_, err := s.db.Exec(query, sessionID, uid, role, content)
+	query := `INSERT INTO chats (session_id, user_id, role, content, agent) VALUES (?, ?, ?, ?, ?)`
+	_, err := s.db.Exec(query, sessionID, uid, role, content, agent)
 	if err != nil {
 		return fmt.Errorf("saving message: %w", err)
 	}
@@ -162,7 +171,7 @@ func (s *Storage) GetMessages(sessionID string) ([]Message, error) {
 
 func (s *Storage) GetMessagesForUser(userID int64, sessionID string) ([]Message, error) {
 	uid := normalizeUserID(userID)
-	query := `SELECT id, session_id, role, content, created_at FROM chats WHERE session_id = ? AND user_id = ? ORDER BY created_at ASC`
+	query := `SELECT id, session_id, role, content, COALESCE(agent, ''), created_at FROM chats WHERE session_id = ? AND user_id = ? ORDER BY created_at ASC`
 	rows, err := s.db.Query(query, sessionID, uid)
 	if err != nil {
 		return nil, fmt.Errorf("querying messages: %w", err)
@@ -172,7 +181,7 @@ func (s *Storage) GetMessagesForUser(userID int64, sessionID string) ([]Message,
 	var messages []Message
 	for rows.Next() {
 		var msg Message
-		if err := rows.Scan(&msg.ID, &msg.SessionID, &msg.Role, &msg.Content, &msg.CreatedAt); err != nil {
+		if err := rows.Scan(&msg.ID, &msg.SessionID, &msg.Role, &msg.Content, &msg.Agent, &msg.CreatedAt); err != nil {
 			return nil, fmt.Errorf("scanning message: %w", err)
 		}
 		messages = append(messages, msg)
@@ -189,7 +198,7 @@ func (s *Storage) SearchMessages(query string) ([]Message, error) {
 
 func (s *Storage) SearchMessagesForUser(userID int64, query string) ([]Message, error) {
 	uid := normalizeUserID(userID)
-	sqlQuery := `SELECT id, session_id, role, content, created_at FROM chats WHERE user_id = ? AND content LIKE ? ORDER BY created_at DESC LIMIT 50`
+	sqlQuery := `SELECT id, session_id, role, content, COALESCE(agent, ''), created_at FROM chats WHERE user_id = ? AND content LIKE ? ORDER BY created_at DESC LIMIT 50`
 	rows, err := s.db.Query(sqlQuery, uid, "%"+query+"%")
 	if err != nil {
 		return nil, fmt.Errorf("searching messages: %w", err)
@@ -199,7 +208,7 @@ func (s *Storage) SearchMessagesForUser(userID int64, query string) ([]Message,
 	var messages []Message
 	for rows.Next() {
 		var msg Message
-		if err := rows.Scan(&msg.ID, &msg.SessionID, &msg.Role, &msg.Content, &msg.CreatedAt); err != nil {
+		if err := rows.Scan(&msg.ID, &msg.SessionID, &msg.Role, &msg.Content, &msg.Agent, &msg.CreatedAt); err != nil {
 			return nil, fmt.Errorf("scanning message: %w", err)
 		}
 		messages = append(messages, msg)
@@ -234,6 +243,7 @@ func (s *Storage) ListSessionsForUser(userID int64, archived *bool, limit, offse
 			sess.session_id,
 			COALESCE(sess.title, ''),
 			sess.archived,
+			COALESCE(sess.agent, ''),
 			COALESCE(c.content, ''),
 			COALESCE(c.created_at, sess.updated_at),
 			COALESCE(counts.msg_count, 0)
@@ -263,6 +273,7 @@ func (s *Storage) ListSessionsForUser(userID int64, archived *bool, limit, offse
 				counts.session_id,
 				'' AS title,
 				0 AS archived,
+				'' AS agent,
 				COALESCE(c.content, ''),
 				COALESCE(c.created_at, counts.last_created_at),
 				COALESCE(counts.msg_count, 0)
@@ -287,7 +298,7 @@ func (s *Storage) ListSessionsForUser(userID int64, archived *bool, limit, offse
 	for rows.Next() {
 		var ss SessionSummary
 		var updatedAtStr string
-		if err := rows.Scan(&ss.SessionID, &ss.Title, &ss.Archived, &ss.LastMessage, &updatedAtStr, &ss.MessageCount); err != nil {
+		if err := rows.Scan(&ss.SessionID, &ss.Title, &ss.Archived, &ss.Agent, &ss.LastMessage, &updatedAtStr, &ss.MessageCount); err != nil {
 			return nil, fmt.Errorf("scanning session: %w", err)
 		}
 		if updatedAtStr != "" {
@@ -321,9 +332,9 @@ func (s *Storage) GetSession(sessionID string) (*Session, error) {
 
 func (s *Storage) GetSessionForUser(userID int64, sessionID string) (*Session, error) {
 	uid := normalizeUserID(userID)
-	query := `SELECT id, session_id, COALESCE(title, ''), archived, created_at, updated_at FROM sessions WHERE session_id = ? AND user_id = ?`
+	query := `SELECT id, session_id, COALESCE(title, ''), archived, COALESCE(agent, ''), created_at, updated_at FROM sessions WHERE session_id = ? AND user_id = ?`
 	var sess Session
-	err := s.db.QueryRow(query, sessionID, uid).Scan(&sess.ID, &sess.SessionID, &sess.Title, &sess.Archived, &sess.CreatedAt, &sess.UpdatedAt)
+	err := s.db.QueryRow(query, sessionID, uid).Scan(&sess.ID, &sess.SessionID, &sess.Title, &sess.Archived, &sess.Agent, &sess.CreatedAt, &sess.UpdatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("session not found")
@@ -353,6 +364,19 @@ func (s *Storage) UpdateSessionForUser(userID int64, sessionID string, title *st
 	return s.GetSessionForUser(uid, sessionID)
 }
 
+// SetSessionAgentForUser sets the named agent a session talks to, creating
+// the session record if needed. An empty agent selects the default agent.
+func (s *Storage) SetSessionAgentForUser(userID int64, sessionID, agent string) error {
+	if err := s.ensureSessionForUser(sessionID, userID); err != nil {
+		return err
+	}
+	uid := normalizeUserID(userID)
+	if _, err := s.db.Exec(`UPDATE sessions SET agent = ? WHERE session_id = ? AND user_id = ?`, agent, sessionID, uid); err != nil {
+		return fmt.Errorf("updating session agent: %w", err)
+	}
+	return nil
+}
+
 // DeleteSession permanently removes a session and all its messages.
 func (s *Storage) DeleteSession(sessionID string) error {
 	return s.DeleteSessionForUser(0, sessionID)
@@ -420,14 +444,14 @@ func (s *Storage) ForkSessionForUser(userID int64, sourceSession, newSessionID s
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(`INSERT INTO chats (session_id, user_id, role, content, created_at) VALUES (?, ?, ?, ?, ?)`)
+	stmt, err := tx.Prepare(`INSERT INTO chats (session_id, user_id, role, content, agent, created_at) VALUES (?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return 0, fmt.Errorf("prepare: %w", err)
 	}
 	defer stmt.Close()
 
 	for _, m := range toFork {
-		if _, err := stmt.Exec(newSessionID, uid, m.Role, m.Content, m.CreatedAt); err != nil {
+		if _, err := stmt.Exec(newSessionID, uid, m.Role, m.Content, m.Agent, m.CreatedAt); err != nil {
 			return 0, fmt.Errorf("insert: %w", err)
 		}
 	}
@@ -159,6 +159,10 @@ func (s *Storage) migrate() error {
 		`ALTER TABLE chats ADD COLUMN user_id INTEGER DEFAULT 1;`,
 		`ALTER TABLE tasks ADD COLUMN user_id INTEGER DEFAULT 1;`,
 		`ALTER TABLE sessions ADD COLUMN user_id INTEGER DEFAULT 1;`,
+		// Named agent a chat session talks to ('' is the default agent)
+		`ALTER TABLE sessions ADD COLUMN agent TEXT NOT NULL DEFAULT '';`,
+		// Named agent that wrote an assistant message ('' is the default agent)
+		`ALTER TABLE chats ADD COLUMN agent TEXT NOT NULL DEFAULT '';`,
 	}
 
 	for _, query := range queries {
@@ -197,6 +201,21 @@ func (s *Storage) migrate() error {
 		return fmt.Errorf("metrics migration: %w", err)
 	}
 
+	// Tool approval audit log
+	if err := s.migrateApprovals(); err != nil {
+		return fmt.Errorf("approval migration: %w", err)
+	}
+
+	// LLM usage ledger and per-user budgets
+	if err := s.migrateUsage(); err != nil {
+		return fmt.Errorf("usage migration: %w", err)
+	}
+
+	// Subagent task history
+	if err := s.migrateSubagents(); err != nil {
+		return fmt.Errorf("subagent migration: %w", err)
+	}
+
 	return nil
 }
 
@@ -3,6 +3,7 @@ package storage
 import (
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/sipeed/kakoclaw/pkg/config"
 )
@@ -224,6 +225,55 @@ func TestGetAndUpdateSession(t *testing.T) {
 	}
 }
 
+func TestSetSessionAgent(t *testing.T) {
+	s := newTestStorage(t)
+
+	// Picking an agent before the first message creates the session.
+	if err := s.SetSessionAgentForUser(0, "agent:chat", "coder"); err != nil {
+		t.Fatalf("SetSessionAgentForUser: %v", err)
+	}
+	sess, err := s.GetSession("agent:chat")
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if sess.Agent != "coder" {
+		t.Fatalf("expected agent 'coder', got %q", sess.Agent)
+	}
+
+	_ = s.SaveMessage("agent:chat", "user", "hello")
+	sessions, err := s.ListSessions(nil, 10, 0)
+	if err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].Agent != "coder" {
+		t.Fatalf("expected the listed session to carry its agent, got %+v", sessions)
+	}
+}
+
+func TestSaveAgentMessage(t *testing.T) {
+	s := newTestStorage(t)
+	_ = s.SaveMessage("handoff:chat", "user", "review this")
+	if err := s.SaveAgentMessageForUser(0, "handoff:chat", "assistant", "looks good", "reviewer"); err != nil {
+		t.Fatalf("SaveAgentMessageForUser: %v", err)
+	}
+
+	msgs, err := s.GetMessages("handoff:chat")
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(msgs) != 2 || msgs[0].Agent != "" || msgs[1].Agent != "reviewer" {
+		t.Fatalf("expected the answer to carry its agent, got %+v", msgs)
+	}
+
+	if _, err := s.ForkSession("handoff:chat", "handoff:fork", 0); err != nil {
+		t.Fatalf("ForkSession: %v", err)
+	}
+	forked, _ := s.GetMessages("handoff:fork")
+	if len(forked) != 2 || forked[1].Agent != "reviewer" {
+		t.Fatalf("expected the fork to keep message agents, got %+v", forked)
+	}
+}
+
 func TestDeleteSession(t *testing.T) {
 	s := newTestStorage(t)
 	_ = s.SaveMessage("delete:me", "user", "bye")
@@ -399,6 +449,175 @@ func TestTaskLogs(t *testing.T) {
 	}
 }
 
+// --- Tool approvals ---
+
+func TestToolApprovalsRoundTrip(t *testing.T) {
+	s := newTestStorage(t)
+
+	now := time.Now()
+	for i, a := range []ToolApproval{
+		{ID: "a1", Tool: "exec", Arguments: `{"command":"ls"}`, UserID: 1, Decision: "approve_once", DecidedBy: "telegram:1", CreatedAt: now, DecidedAt: now},
+		{ID: "a2", Tool: "write_file", UserID: 2, Decision: "deny", DecidedBy: "web:2", CreatedAt: now, DecidedAt: now.Add(time.Second)},
+	} {
+		if err := s.SaveToolApproval(a); err != nil {
+			t.Fatalf("SaveToolApproval #%d: %v", i, err)
+		}
+	}
+
+	all, err := s.ListToolApprovals(0, 10)
+	if err != nil {
+		t.Fatalf("ListToolApprovals: %v", err)
+	}
+	if len(all) != 2 || all[0].ID != "a2" {
+		t.Fatalf("expected 2 approvals newest first, got %+v", all)
+	}
+
+	mine, err := s.ListToolApprovals(1, 10)
+	if err != nil {
+		t.Fatalf("ListToolApprovals(1): %v", err)
+	}
+	if len(mine) != 1 || mine[0].Tool != "exec" || mine[0].Arguments != `{"command":"ls"}` {
+		t.Fatalf("unexpected approvals for user 1: %+v", mine)
+	}
+}
+
+// --- Subagent runs ---
+
+func TestSubagentRunsRoundTrip(t *testing.T) {
+	s := newTestStorage(t)
+
+	now := time.Now()
+	run := SubagentRun{ID: "sa1", Depth: 1, Task: "summarize", Tools: []string{"read_file"}, UserID: 1, Status: "running", CreatedAt: now}
+	if err := s.SaveSubagentRun(run); err != nil {
+		t.Fatalf("SaveSubagentRun: %v", err)
+	}
+	if err := s.SaveSubagentRun(SubagentRun{ID: "sa2", Depth: 1, Task: "other", UserID: 2, Status: "running", CreatedAt: now.Add(time.Second)}); err != nil {
+		t.Fatalf("SaveSubagentRun: %v", err)
+	}
+
+	run.Status = "completed"
+	run.Result = "done"
+	run.Iterations = 3
+	run.FinishedAt = now.Add(2 * time.Second)
+	if err := s.SaveSubagentRun(run); err != nil {
+		t.Fatalf("SaveSubagentRun update: %v", err)
+	}
+
+	got, err := s.GetSubagentRun("sa1")
+	if err != nil || got == nil {
+		t.Fatalf("GetSubagentRun: %v, %v", got, err)
+	}
+	if got.Status != "completed" || got.Result != "done" || got.Iterations != 3 || len(got.Tools) != 1 || got.FinishedAt.IsZero() {
+		t.Fatalf("unexpected run: %+v", got)
+	}
+	if missing, err := s.GetSubagentRun("nope"); err != nil || missing != nil {
+		t.Fatalf("expected no run, got %+v, %v", missing, err)
+	}
+
+	all, err := s.ListSubagentRuns(0, 10)
+	if err != nil {
+		t.Fatalf("ListSubagentRuns: %v", err)
+	}
+	if len(all) != 2 || all[0].ID != "sa2" {
+		t.Fatalf("expected 2 runs newest first, got %+v", all)
+	}
+	mine, err := s.ListSubagentRuns(1, 10)
+	if err != nil || len(mine) != 1 || mine[0].ID != "sa1" {
+		t.Fatalf("unexpected runs for user 1: %+v, %v", mine, err)
+	}
+}
+
+// --- LLM usage ---
+
+func TestLLMUsageLedger(t *testing.T) {
+	s := newTestStorage(t)
+
+	user, err := s.CreateUser("alice", "secret-password", "user")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	now := time.Now()
+	for i, u := range []LLMUsage{
+		{UserID: user.ID, SessionKey: "web:1", Channel: "web", Model: "gpt-4o", TokensIn: 100, TokensOut: 50, CostUSD: 0.5, CreatedAt: now},
+		{UserID: user.ID, SessionKey: "web:1", Channel: "web", Model: "gpt-4o-mini", TokensIn: 10, TokensOut: 5, CostUSD: 0.01, CreatedAt: now},
+		{SessionKey: "cron-job1", Channel: "cron", Model: "gpt-4o", TokensIn: 20, TokensOut: 20, CostUSD: 0.2, CreatedAt: now.Add(-48 * time.Hour)},
+	} {
+		if err := s.RecordLLMUsage(u); err != nil {
+			t.Fatalf("RecordLLMUsage #%d: %v", i, err)
+		}
+	}
+
+	mine, err := s.SumLLMUsage(UsageFilter{UserID: user.ID})
+	if err != nil {
+		t.Fatalf("SumLLMUsage: %v", err)
+	}
+	if mine.Calls != 2 || mine.Tokens() != 165 {
+		t.Fatalf("unexpected user totals: %+v", mine)
+	}
+
+	recent, err := s.SumLLMUsage(UsageFilter{Since: now.Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("SumLLMUsage(since): %v", err)
+	}
+	if recent.Calls != 2 {
+		t.Fatalf("expected 2 calls in the last hour, got %+v", recent)
+	}
+
+	byModel, err := s.SpendBreakdown("model", time.Time{})
+	if err != nil {
+		t.Fatalf("SpendBreakdown(model): %v", err)
+	}
+	if len(byModel) != 2 || byModel[0].Key != "gpt-4o" || byModel[0].Calls != 2 {
+		t.Fatalf("unexpected model breakdown: %+v", byModel)
+	}
+
+	byUser, err := s.SpendBreakdown("user", now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("SpendBreakdown(user): %v", err)
+	}
+	if len(byUser) != 1 || byUser[0].Label != "alice" {
+		t.Fatalf("unexpected user breakdown: %+v", byUser)
+	}
+
+	if _, err := s.SpendBreakdown("bogus", now); err == nil {
+		t.Fatal("expected an error for an unknown grouping")
+	}
+}
+
+func TestUserBudgetRoundTrip(t *testing.T) {
+	s := newTestStorage(t)
+
+	user, err := s.CreateUser("bob", "secret-password", "user")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	b, err := s.GetUserBudget(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserBudget: %v", err)
+	}
+	if b.DailyUSD != nil || b.MonthlyTokens != nil {
+		t.Fatalf("new user should inherit defaults, got %+v", b)
+	}
+
+	daily, monthlyTokens := 2.5, int64(100000)
+	if err := s.SetUserBudget(user.ID, UserBudget{DailyUSD: &daily, MonthlyTokens: &monthlyTokens}); err != nil {
+		t.Fatalf("SetUserBudget: %v", err)
+	}
+	b, err = s.GetUserBudget(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserBudget: %v", err)
+	}
+	if b.DailyUSD == nil || *b.DailyUSD != daily || b.MonthlyTokens == nil || *b.MonthlyTokens != monthlyTokens || b.MonthlyUSD != nil {
+		t.Fatalf("unexpected budget after update: %+v", b)
+	}
+
+	if err := s.SetUserBudget(9999, UserBudget{}); err != ErrUserNotFound {
+		t.Fatalf("SetUserBudget(unknown) = %v, want ErrUserNotFound", err)
+	}
+}
+
 // --- Close / WAL checkpoint ---
 
 func TestCloseCheckpointsWAL(t *testing.T) {
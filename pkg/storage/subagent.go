@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SubagentRun records a background subagent task and its outcome.
+type SubagentRun struct {
+	ID            string    `json:"id"`
+	ParentID      string    `json:"parent_id,omitempty"` // spawning subagent, empty for the main agent
+	Depth         int       `json:"depth"`
+	Label         string    `json:"label"`
+	Task          string    `json:"task"`
+	Tools         []string  `json:"tools"`
+	UserID        int64     `json:"user_id"`
+	SessionKey    string    `json:"session_key"`
+	OriginChannel string    `json:"origin_channel"`
+	OriginChatID  string    `json:"origin_chat_id"`
+	Status        string    `json:"status"` // "running", "completed", "failed", "cancelled"
+	Result        string    `json:"result"`
+	Iterations    int       `json:"iterations"`
+	CreatedAt     time.Time `json:"created_at"`
+	FinishedAt    time.Time `json:"finished_at,omitempty"`
+}
+
+func (s *Storage) migrateSubagents() error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS subagent_runs (
+			id TEXT PRIMARY KEY,
+			parent_id TEXT NOT NULL DEFAULT '',
+			depth INTEGER NOT NULL DEFAULT 1,
+			label TEXT NOT NULL DEFAULT '',
+			task TEXT NOT NULL,
+			tools TEXT NOT NULL DEFAULT '[]',
+			user_id INTEGER NOT NULL DEFAULT 0,
+			session_key TEXT NOT NULL DEFAULT '',
+			origin_channel TEXT NOT NULL DEFAULT '',
+			origin_chat_id TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL,
+			result TEXT NOT NULL DEFAULT '',
+			iterations INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL,
+			finished_at DATETIME
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_subagent_runs_user ON subagent_runs(user_id, created_at DESC);`,
+		// Runs cannot survive a restart; close out any left running by a crash.
+		`UPDATE subagent_runs SET status = 'failed', result = 'Interrupted by restart', finished_at = CURRENT_TIMESTAMP
+			WHERE status = 'running';`,
+	}
+
+	for _, q := range queries {
+		if _, err := s.db.Exec(q); err != nil {
+			return fmt.Errorf("subagent migration: %w", err)
+		}
+	}
+	return nil
+}
+
+// SaveSubagentRun inserts or updates a subagent run.
+func (s *Storage) SaveSubagentRun(r SubagentRun) error {
+	tools, _ := json.Marshal(r.Tools)
+	var finished interface{}
+	if !r.FinishedAt.IsZero() {
+		finished = r.FinishedAt
+	}
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO subagent_runs
+		(id, parent_id, depth, label, task, tools, user_id, session_key, origin_channel, origin_chat_id,
+		 status, result, iterations, created_at, finished_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.ID, r.ParentID, r.Depth, r.Label, r.Task, string(tools), r.UserID, r.SessionKey,
+		r.OriginChannel, r.OriginChatID, r.Status, r.Result, r.Iterations, r.CreatedAt, finished)
+	if err != nil {
+		return fmt.Errorf("saving subagent run: %w", err)
+	}
+	return nil
+}
+
+const subagentRunColumns = `id, parent_id, depth, label, task, tools, user_id, session_key, origin_channel, origin_chat_id,
+	status, result, iterations, created_at, finished_at`
+
+// GetSubagentRun returns the subagent run with the given ID, or nil if there is none.
+func (s *Storage) GetSubagentRun(id string) (*SubagentRun, error) {
+	row := s.db.QueryRow(`SELECT `+subagentRunColumns+` FROM subagent_runs WHERE id = ?`, id)
+	r, err := scanSubagentRun(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting subagent run: %w", err)
+	}
+	return &r, nil
+}
+
+// ListSubagentRuns returns the most recent subagent runs. If userID is
+// greater than zero, only that user's runs are returned.
+func (s *Storage) ListSubagentRuns(userID int64, limit int) ([]SubagentRun, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	query := `SELECT ` + subagentRunColumns + ` FROM subagent_runs`
+	args := []interface{}{}
+	if userID > 0 {
+		query += ` WHERE user_id = ?`
+		args = append(args, userID)
+	}
+	query += ` ORDER BY created_at DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("listing subagent runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []SubagentRun
+	for rows.Next() {
+		r, err := scanSubagentRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning subagent run: %w", err)
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+func scanSubagentRun(row interface{ Scan(...interface{}) error }) (SubagentRun, error) {
+	var r SubagentRun
+	var tools string
+	var finished sql.NullTime
+	if err := row.Scan(&r.ID, &r.ParentID, &r.Depth, &r.Label, &r.Task, &tools, &r.UserID, &r.SessionKey,
+		&r.OriginChannel, &r.OriginChatID, &r.Status, &r.Result, &r.Iterations, &r.CreatedAt, &finished); err != nil {
+		return r, err
+	}
+	_ = json.Unmarshal([]byte(tools), &r.Tools)
+	if finished.Valid {
+		r.FinishedAt = finished.Time
+	}
+	return r, nil
+}
@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LLMUsage is one LLM call in the usage ledger.
+type LLMUsage struct {
+	ID           int64     `json:"id"`
+	UserID       int64     `json:"user_id"`
+	SessionKey   string    `json:"session_key"`
+	Channel      string    `json:"channel"`
+	Model        string    `json:"model"`
+	TokensIn     int       `json:"tokens_in"`
+	TokensOut    int       `json:"tokens_out"`
+	TokensCached int       `json:"tokens_cached"`
+	CostUSD      float64   `json:"cost_usd"`
+	Estimated    bool      `json:"estimated"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// UsageFilter selects ledger rows. Zero-valued fields match everything.
+type UsageFilter struct {
+	UserID     int64
+	SessionKey string
+	Since      time.Time
+}
+
+// UsageTotals aggregates ledger rows.
+type UsageTotals struct {
+	Calls        int64   `json:"calls"`
+	TokensIn     int64   `json:"tokens_in"`
+	TokensOut    int64   `json:"tokens_out"`
+	TokensCached int64   `json:"tokens_cached"`
+	CostUSD      float64 `json:"cost_usd"`
+}
+
+// Tokens returns input plus output tokens.
+func (t UsageTotals) Tokens() int64 {
+	return t.TokensIn + t.TokensOut
+}
+
+// SpendRow is one group of a spend breakdown.
+type SpendRow struct {
+	Key   string `json:"key"`
+	Label string `json:"label,omitempty"`
+	UsageTotals
+}
+
+// UserBudget holds per-user spending caps. A nil field inherits the
+// configured default; zero means unlimited.
+type UserBudget struct {
+	DailyUSD      *float64 `json:"daily_usd"`
+	MonthlyUSD    *float64 `json:"monthly_usd"`
+	DailyTokens   *int64   `json:"daily_tokens"`
+	MonthlyTokens *int64   `json:"monthly_tokens"`
+}
+
+func (s *Storage) migrateUsage() error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS llm_usage (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL DEFAULT 0,
+			session_key TEXT NOT NULL DEFAULT '',
+			channel TEXT NOT NULL DEFAULT '',
+			model TEXT NOT NULL DEFAULT '',
+			tokens_in INTEGER NOT NULL DEFAULT 0,
+			tokens_out INTEGER NOT NULL DEFAULT 0,
+			tokens_cached INTEGER NOT NULL DEFAULT 0,
+			cost_usd REAL NOT NULL DEFAULT 0,
+			estimated BOOLEAN NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_llm_usage_user ON llm_usage(user_id, created_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_llm_usage_session ON llm_usage(session_key, created_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_llm_usage_created ON llm_usage(created_at);`,
+		// Per-user budget caps (NULL = use the configured default)
+		`ALTER TABLE users ADD COLUMN budget_daily_usd REAL;`,
+		`ALTER TABLE users ADD COLUMN budget_monthly_usd REAL;`,
+		`ALTER TABLE users ADD COLUMN budget_daily_tokens INTEGER;`,
+		`ALTER TABLE users ADD COLUMN budget_monthly_tokens INTEGER;`,
+	}
+
+	for _, q := range queries {
+		if _, err := s.db.Exec(q); err != nil {
+			if strings.HasPrefix(q, "ALTER TABLE") {
+				continue
+			}
+			return fmt.Errorf("usage migration: %w", err)
+		}
+	}
+	return nil
+}
+
+// RecordLLMUsage appends an LLM call to the usage ledger.
+func (s *Storage) RecordLLMUsage(u LLMUsage) error {
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now()
+	}
+	_, err := s.db.Exec(`INSERT INTO llm_usage
+		(user_id, session_key, channel, model, tokens_in, tokens_out, tokens_cached, cost_usd, estimated, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		u.UserID, u.SessionKey, u.Channel, u.Model, u.TokensIn, u.TokensOut, u.TokensCached,
+		u.CostUSD, u.Estimated, u.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("recording llm usage: %w", err)
+	}
+	return nil
+}
+
+func (f UsageFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if f.UserID > 0 {
+		conds = append(conds, "user_id = ?")
+		args = append(args, f.UserID)
+	}
+	if f.SessionKey != "" {
+		conds = append(conds, "session_key = ?")
+		args = append(args, f.SessionKey)
+	}
+	if !f.Since.IsZero() {
+		conds = append(conds, "created_at >= ?")
+		args = append(args, f.Since.UTC())
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// SumLLMUsage totals the ledger rows matching f.
+func (s *Storage) SumLLMUsage(f UsageFilter) (UsageTotals, error) {
+	where, args := f.where()
+
+	var t UsageTotals
+	err := s.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(tokens_in), 0), COALESCE(SUM(tokens_out), 0),
+		COALESCE(SUM(tokens_cached), 0), COALESCE(SUM(cost_usd), 0)
+		FROM llm_usage`+where, args...).
+		Scan(&t.Calls, &t.TokensIn, &t.TokensOut, &t.TokensCached, &t.CostUSD)
+	if err != nil {
+		return UsageTotals{}, fmt.Errorf("summing llm usage: %w", err)
+	}
+	return t, nil
+}
+
+// SpendBreakdown groups ledger rows since the given time by "user", "model"
+// or "channel", highest cost first.
+func (s *Storage) SpendBreakdown(groupBy string, since time.Time) ([]SpendRow, error) {
+	var key, label, join string
+	switch groupBy {
+	case "user":
+		key, label, join = "CAST(l.user_id AS TEXT)", "COALESCE(u.username, '')", " LEFT JOIN users u ON u.id = l.user_id"
+	case "model":
+		key, label = "l.model", "''"
+	case "channel":
+		key, label = "l.channel", "''"
+	default:
+		return nil, fmt.Errorf("unknown spend grouping %q", groupBy)
+	}
+
+	rows, err := s.db.Query(`SELECT `+key+`, `+label+`, COUNT(*), COALESCE(SUM(l.tokens_in), 0),
+		COALESCE(SUM(l.tokens_out), 0), COALESCE(SUM(l.tokens_cached), 0), COALESCE(SUM(l.cost_usd), 0)
+		FROM llm_usage l`+join+`
+		WHERE l.created_at >= ?
+		GROUP BY 1, 2
+		ORDER BY 7 DESC`, since.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("querying spend breakdown: %w", err)
+	}
+	defer rows.Close()
+
+	result := []SpendRow{}
+	for rows.Next() {
+		var r SpendRow
+		if err := rows.Scan(&r.Key, &r.Label, &r.Calls, &r.TokensIn, &r.TokensOut, &r.TokensCached, &r.CostUSD); err != nil {
+			return nil, fmt.Errorf("scanning spend breakdown: %w", err)
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// GetUserBudget returns the budget caps stored for a user.
+func (s *Storage) GetUserBudget(userID int64) (UserBudget, error) {
+	var daily, monthly sql.NullFloat64
+	var dailyTokens, monthlyTokens sql.NullInt64
+	err := s.db.QueryRow(`SELECT budget_daily_usd, budget_monthly_usd, budget_daily_tokens, budget_monthly_tokens
+		FROM users WHERE id = ?`, userID).Scan(&daily, &monthly, &dailyTokens, &monthlyTokens)
+	if err == sql.ErrNoRows {
+		return UserBudget{}, ErrUserNotFound
+	}
+	if err != nil {
+		return UserBudget{}, fmt.Errorf("loading user budget: %w", err)
+	}
+
+	var b UserBudget
+	if daily.Valid {
+		b.DailyUSD = &daily.Float64
+	}
+	if monthly.Valid {
+		b.MonthlyUSD = &monthly.Float64
+	}
+	if dailyTokens.Valid {
+		b.DailyTokens = &dailyTokens.Int64
+	}
+	if monthlyTokens.Valid {
+		b.MonthlyTokens = &monthlyTokens.Int64
+	}
+	return b, nil
+}
+
+// SetUserBudget replaces the budget caps stored for a user.
+func (s *Storage) SetUserBudget(userID int64, b UserBudget) error {
+	res, err := s.db.Exec(`UPDATE users SET budget_daily_usd = ?, budget_monthly_usd = ?,
+		budget_daily_tokens = ?, budget_monthly_tokens = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, b.DailyUSD, b.MonthlyUSD, b.DailyTokens, b.MonthlyTokens, userID)
+	if err != nil {
+		return fmt.Errorf("saving user budget: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
@@ -1,6 +1,10 @@
 package tools
 
-import "context"
+import (
+	"context"
+	"path/filepath"
+	"strings"
+)
 
 type Tool interface {
 	Name() string
@@ -22,6 +26,56 @@ type WorkspaceTool interface {
 	SetWorkspace(workspace string)
 }
 
+// ApprovalScopedTool is an optional interface for tools gated by approval
+// whose calls need approval only for some arguments, e.g. a tool that can
+// both start commands and read their output. Gated tools that do not
+// implement it need approval for every call.
+type ApprovalScopedTool interface {
+	Tool
+	NeedsApproval(args map[string]interface{}) bool
+}
+
+// SerialTool is an optional interface for tools that are not safe to run in
+// parallel. When the LLM issues several tool calls at once, calls whose
+// SerialKeys conflict (see SerialConflict) run one at a time in the order
+// they were issued; all other calls run concurrently.
+type SerialTool interface {
+	Tool
+	SerialKey(ctx context.Context, args map[string]interface{}) string
+}
+
+// workspaceSerialKey is the key of calls that may touch any file, such as
+// shell commands.
+const workspaceSerialKey = "workspace"
+
+// SerialConflict reports whether calls with serial keys a and b must run one
+// after the other: they share a key, or one may touch any file and the other
+// touches a file.
+func SerialConflict(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	if a == b {
+		return true
+	}
+	return a == workspaceSerialKey && strings.HasPrefix(b, "file:") ||
+		b == workspaceSerialKey && strings.HasPrefix(a, "file:")
+}
+
+// fileSerialKey serializes calls that touch the same file path. Relative
+// paths resolve against ctx's workspace, falling back to workspace.
+func fileSerialKey(ctx context.Context, args map[string]interface{}, workspace string) string {
+	path, _ := args["path"].(string)
+	if path == "" {
+		return ""
+	}
+	workspace = workspaceFromContext(ctx, workspace)
+	if !filepath.IsAbs(path) && workspace != "" {
+		path = filepath.Join(workspace, path)
+	}
+	return "file:" + filepath.Clean(path)
+}
+
 func ToolToSchema(tool Tool) map[string]interface{} {
 	return map[string]interface{}{
 		"type": "function",
@@ -0,0 +1,166 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+)
+
+// CacheableTool is an optional interface for tools that decide whether their
+// results may be cached. Tools with side effects return false, so they are
+// never served from the cache even when tools.cache lists them.
+type CacheableTool interface {
+	Tool
+	Cacheable() bool
+}
+
+// FileBackedTool is an optional interface for tools whose results depend on
+// files. A cached result is dropped once any of the files changes.
+type FileBackedTool interface {
+	Tool
+	CachePaths(ctx context.Context, args map[string]interface{}) []string
+}
+
+// fileStamp records the state of a file when a result was cached.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+func stampFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size(), exists: true}
+}
+
+type cacheEntry struct {
+	result  string
+	expires time.Time
+	files   map[string]fileStamp
+}
+
+func (e *cacheEntry) fresh(now time.Time) bool {
+	if now.After(e.expires) {
+		return false
+	}
+	for path, stamp := range e.files {
+		if stampFile(path) != stamp {
+			return false
+		}
+	}
+	return true
+}
+
+// ResultCache caches tool results by tool name and canonical arguments. It
+// is safe for concurrent use and may be shared by several registries.
+type ResultCache struct {
+	cfg     config.ToolCacheConfig
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	now     func() time.Time
+}
+
+// NewResultCache returns a cache for cfg, or nil when caching is disabled.
+func NewResultCache(cfg config.ToolCacheConfig) *ResultCache {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &ResultCache{
+		cfg:     cfg,
+		entries: make(map[string]*cacheEntry),
+		now:     time.Now,
+	}
+}
+
+// ttl returns how long results of tool may be cached; zero means never.
+func (c *ResultCache) ttl(tool Tool) time.Duration {
+	if ct, ok := tool.(CacheableTool); ok && !ct.Cacheable() {
+		return 0
+	}
+
+	seconds, ok := toolSetting(c.cfg.Tools, tool.Name())
+	if !ok || seconds < 0 {
+		return 0
+	}
+	if seconds == 0 {
+		seconds = c.cfg.TTLSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// cacheKey identifies a call by tool, arguments, workspace and user, since
+// the same arguments may resolve to different data for different users.
+func cacheKey(ctx context.Context, name string, args map[string]interface{}) (string, bool) {
+	// encoding/json sorts map keys, so equal arguments encode identically.
+	data, err := json.Marshal(args)
+	if err != nil {
+		return "", false
+	}
+	ec, _ := ExecutionContextFrom(ctx)
+	return fmt.Sprintf("%s\x00%d\x00%s\x00%s", name, ec.UserID, ec.Workspace, data), true
+}
+
+func (c *ResultCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	if !entry.fresh(c.now()) {
+		delete(c.entries, key)
+		return "", false
+	}
+	return entry.result, true
+}
+
+func (c *ResultCache) put(key, result string, ttl time.Duration, files map[string]fileStamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if max := c.cfg.MaxEntries; max > 0 && len(c.entries) >= max {
+		c.evict(now, max)
+	}
+	c.entries[key] = &cacheEntry{result: result, expires: now.Add(ttl), files: files}
+}
+
+// evict drops expired entries, then the entries closest to expiry until
+// there is room for one more.
+func (c *ResultCache) evict(now time.Time, max int) {
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	if len(c.entries) < max {
+		return
+	}
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.entries[keys[i]].expires.Before(c.entries[keys[j]].expires)
+	})
+	for _, key := range keys[:len(keys)-max+1] {
+		delete(c.entries, key)
+	}
+}
+
+// Len returns the number of cached results.
+func (c *ResultCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
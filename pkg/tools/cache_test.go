@@ -0,0 +1,107 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+)
+
+type countingTool struct {
+	name  string
+	calls int
+}
+
+func (t *countingTool) Name() string        { return t.name }
+func (t *countingTool) Description() string { return "count" }
+func (t *countingTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{"type": "object"}
+}
+func (t *countingTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	t.calls++
+	return fmt.Sprintf("call %d", t.calls), nil
+}
+
+func TestToolRegistryCache(t *testing.T) {
+	cache := NewResultCache(config.ToolCacheConfig{
+		Enabled:    true,
+		TTLSeconds: 60,
+		Tools:      map[string]int{"web_*": 0, "web_fetch": -1},
+	})
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	r := NewToolRegistry()
+	r.SetCache(cache)
+	search := &countingTool{name: "web_search"}
+	fetch := &countingTool{name: "web_fetch"}
+	other := &countingTool{name: "other"}
+	r.Register(search)
+	r.Register(fetch)
+	r.Register(other)
+
+	ctx := context.Background()
+	args := map[string]interface{}{"query": "go", "count": 3}
+	first, _ := r.Execute(ctx, "web_search", args)
+	second, _ := r.Execute(ctx, "web_search", map[string]interface{}{"count": 3, "query": "go"})
+	if first != second || search.calls != 1 {
+		t.Fatalf("expected the second call to hit the cache, got %q then %q after %d calls", first, second, search.calls)
+	}
+	_, _ = r.Execute(ctx, "web_search", map[string]interface{}{"query": "rust"})
+	if search.calls != 2 {
+		t.Fatalf("different arguments must miss the cache")
+	}
+
+	now = now.Add(61 * time.Second)
+	_, _ = r.Execute(ctx, "web_search", args)
+	if search.calls != 3 {
+		t.Fatalf("expired results must not be served")
+	}
+
+	// A negative TTL and unlisted tools are never cached.
+	for _, name := range []string{"web_fetch", "other", "web_fetch", "other"} {
+		_, _ = r.Execute(ctx, name, args)
+	}
+	if fetch.calls != 2 || other.calls != 2 {
+		t.Fatalf("uncached tools ran %d and %d times, want 2", fetch.calls, other.calls)
+	}
+}
+
+func TestToolRegistryCacheFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(path, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewToolRegistry()
+	r.SetCache(NewResultCache(config.ToolCacheConfig{
+		Enabled:    true,
+		TTLSeconds: 60,
+		Tools:      map[string]int{"*": 0},
+	}))
+	r.Register(NewReadFileTool(dir, true))
+	r.Register(NewWriteFileTool(dir, true))
+
+	ctx := context.Background()
+	args := map[string]interface{}{"path": "notes.txt"}
+	if got, _ := r.Execute(ctx, "read_file", args); got != "v1" {
+		t.Fatalf("read_file = %q", got)
+	}
+	// write_file declares itself non-cacheable even though "*" lists it.
+	for i := 0; i < 2; i++ {
+		if _, err := r.Execute(ctx, "write_file", map[string]interface{}{"path": "notes.txt", "content": "version 2"}); err != nil {
+			t.Fatalf("write_file: %v", err)
+		}
+	}
+	if got, _ := r.Execute(ctx, "read_file", args); got != "version 2" {
+		t.Fatalf("expected the write to invalidate the cached read, got %q", got)
+	}
+	if n := r.Cache().Len(); n != 1 {
+		t.Fatalf("expected only the read to be cached, got %d entries", n)
+	}
+}
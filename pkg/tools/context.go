@@ -0,0 +1,48 @@
+package tools
+
+import "context"
+
+// ExecutionContext carries per-run information to tools. The agent loop
+// attaches it to the context of each tool call so that concurrent runs for
+// different sessions or users never share mutable tool state.
+type ExecutionContext struct {
+	Channel    string
+	ChatID     string
+	SenderID   string
+	SessionKey string
+	Workspace  string
+	UserID     int64
+}
+
+type executionContextKey struct{}
+
+// WithExecutionContext returns a copy of ctx carrying ec.
+func WithExecutionContext(ctx context.Context, ec ExecutionContext) context.Context {
+	return context.WithValue(ctx, executionContextKey{}, ec)
+}
+
+// ExecutionContextFrom returns the ExecutionContext attached to ctx, if any.
+func ExecutionContextFrom(ctx context.Context) (ExecutionContext, bool) {
+	if ctx == nil {
+		return ExecutionContext{}, false
+	}
+	ec, ok := ctx.Value(executionContextKey{}).(ExecutionContext)
+	return ec, ok
+}
+
+// channelFromContext returns the channel and chat ID from ctx, falling back to
+// the given defaults when the context does not provide them.
+func channelFromContext(ctx context.Context, defaultChannel, defaultChatID string) (string, string) {
+	if ec, ok := ExecutionContextFrom(ctx); ok && ec.Channel != "" && ec.ChatID != "" {
+		return ec.Channel, ec.ChatID
+	}
+	return defaultChannel, defaultChatID
+}
+
+// workspaceFromContext returns the workspace from ctx, falling back to def.
+func workspaceFromContext(ctx context.Context, def string) string {
+	if ec, ok := ExecutionContextFrom(ctx); ok && ec.Workspace != "" {
+		return ec.Workspace
+	}
+	return def
+}
@@ -40,6 +40,10 @@ func (t *CronTool) Name() string {
 	return "cron"
 }
 
+func (t *CronTool) Cacheable() bool {
+	return false
+}
+
 // Description returns the tool description
 func (t *CronTool) Description() string {
 	return "Schedule reminders and tasks. IMPORTANT: When user asks to be reminded or scheduled, you MUST call this tool. Use 'at_seconds' for one-time reminders (e.g., 'remind me in 10 minutes' → at_seconds=600). Use 'every_seconds' ONLY for recurring tasks (e.g., 'every 2 hours' → every_seconds=7200). Use 'cron_expr' for complex recurring schedules (e.g., '0 9 * * *' for daily at 9am)."
@@ -101,7 +105,7 @@ func (t *CronTool) Execute(ctx context.Context, args map[string]interface{}) (st
 
 	switch action {
 	case "add":
-		return t.addJob(args)
+		return t.addJob(ctx, args)
 	case "list":
 		return t.listJobs()
 	case "remove":
@@ -115,10 +119,9 @@ func (t *CronTool) Execute(ctx context.Context, args map[string]interface{}) (st
 	}
 }
 
-func (t *CronTool) addJob(args map[string]interface{}) (string, error) {
+func (t *CronTool) addJob(ctx context.Context, args map[string]interface{}) (string, error) {
 	t.mu.RLock()
-	channel := t.channel
-	chatID := t.chatID
+	channel, chatID := channelFromContext(ctx, t.channel, t.chatID)
 	t.mu.RUnlock()
 
 	if channel == "" || chatID == "" {
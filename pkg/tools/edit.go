@@ -26,10 +26,18 @@ func (t *EditFileTool) SetWorkspace(workspace string) {
 	t.allowedDir = workspace
 }
 
+func (t *EditFileTool) SerialKey(ctx context.Context, args map[string]interface{}) string {
+	return fileSerialKey(ctx, args, t.allowedDir)
+}
+
 func (t *EditFileTool) Name() string {
 	return "edit_file"
 }
 
+func (t *EditFileTool) Cacheable() bool {
+	return false
+}
+
 func (t *EditFileTool) Description() string {
 	return "Edit a file by replacing old_text with new_text. The old_text must exist exactly in the file."
 }
@@ -71,7 +79,7 @@ func (t *EditFileTool) Execute(ctx context.Context, args map[string]interface{})
 		return "", fmt.Errorf("new_text is required")
 	}
 
-	resolvedPath, err := validatePath(path, t.allowedDir, t.restrict)
+	resolvedPath, err := validatePath(path, workspaceFromContext(ctx, t.allowedDir), t.restrict)
 	if err != nil {
 		return "", err
 	}
@@ -118,10 +126,18 @@ func (t *AppendFileTool) SetWorkspace(workspace string) {
 	t.workspace = workspace
 }
 
+func (t *AppendFileTool) SerialKey(ctx context.Context, args map[string]interface{}) string {
+	return fileSerialKey(ctx, args, t.workspace)
+}
+
 func (t *AppendFileTool) Name() string {
 	return "append_file"
 }
 
+func (t *AppendFileTool) Cacheable() bool {
+	return false
+}
+
 func (t *AppendFileTool) Description() string {
 	return "Append content to the end of a file"
 }
@@ -154,7 +170,7 @@ func (t *AppendFileTool) Execute(ctx context.Context, args map[string]interface{
 		return "", fmt.Errorf("content is required")
 	}
 
-	resolvedPath, err := validatePath(path, t.workspace, t.restrict)
+	resolvedPath, err := validatePath(path, workspaceFromContext(ctx, t.workspace), t.restrict)
 	if err != nil {
 		return "", err
 	}
@@ -23,6 +23,10 @@ func (t *EmailTool) Name() string {
 	return "send_email_report"
 }
 
+func (t *EmailTool) Cacheable() bool {
+	return false
+}
+
 func (t *EmailTool) Description() string {
 	return "Send a report or content via email. Use this to send weekly summaries, task results, or important notifications to the user."
 }
@@ -49,12 +49,27 @@ func (t *ReadFileTool) SetWorkspace(workspace string) {
 	t.workspace = workspace
 }
 
+// SerialKey orders reads after writes to the same file issued before them.
+func (t *ReadFileTool) SerialKey(ctx context.Context, args map[string]interface{}) string {
+	return fileSerialKey(ctx, args, t.workspace)
+}
+
+// CachePaths ties cached results to the file read.
+func (t *ReadFileTool) CachePaths(ctx context.Context, args map[string]interface{}) []string {
+	path, _ := args["path"].(string)
+	resolvedPath, err := validatePath(path, workspaceFromContext(ctx, t.workspace), t.restrict)
+	if err != nil {
+		return nil
+	}
+	return []string{resolvedPath}
+}
+
 func (t *ReadFileTool) Name() string {
 	return "read_file"
 }
 
 func (t *ReadFileTool) Description() string {
-	return "Read the contents of a file"
+	return "Read the contents of a file, or a range of its lines"
 }
 
 func (t *ReadFileTool) Parameters() map[string]interface{} {
@@ -65,6 +80,16 @@ func (t *ReadFileTool) Parameters() map[string]interface{} {
 				"type":        "string",
 				"description": "Path to the file to read",
 			},
+			"offset": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     1,
+				"description": "Optional line to start reading at (1-based)",
+			},
+			"limit": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     1,
+				"description": "Optional maximum number of lines to read",
+			},
 		},
 		"required": []string{"path"},
 	}
@@ -76,7 +101,7 @@ func (t *ReadFileTool) Execute(ctx context.Context, args map[string]interface{})
 		return "", fmt.Errorf("path is required")
 	}
 
-	resolvedPath, err := validatePath(path, t.workspace, t.restrict)
+	resolvedPath, err := validatePath(path, workspaceFromContext(ctx, t.workspace), t.restrict)
 	if err != nil {
 		return "", err
 	}
@@ -86,7 +111,32 @@ func (t *ReadFileTool) Execute(ctx context.Context, args map[string]interface{})
 		return "", fmt.Errorf("failed to read file: %w", err)
 	}
 
-	return string(content), nil
+	offset, hasOffset := args["offset"].(float64)
+	limit, hasLimit := args["limit"].(float64)
+	if !hasOffset && !hasLimit {
+		return string(content), nil
+	}
+	return lineRange(string(content), int(offset), int(limit)), nil
+}
+
+// lineRange returns limit lines of content starting at the 1-based line
+// offset; limit <= 0 reads to the end.
+func lineRange(content string, offset, limit int) string {
+	lines := strings.SplitAfter(content, "\n")
+	if len(lines) > 1 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if offset < 1 {
+		offset = 1
+	}
+	if offset > len(lines) {
+		return fmt.Sprintf("(file has only %d lines)", len(lines))
+	}
+	end := len(lines)
+	if limit > 0 && offset-1+limit < end {
+		end = offset - 1 + limit
+	}
+	return strings.Join(lines[offset-1:end], "")
 }
 
 type WriteFileTool struct {
@@ -102,10 +152,18 @@ func (t *WriteFileTool) SetWorkspace(workspace string) {
 	t.workspace = workspace
 }
 
+func (t *WriteFileTool) SerialKey(ctx context.Context, args map[string]interface{}) string {
+	return fileSerialKey(ctx, args, t.workspace)
+}
+
 func (t *WriteFileTool) Name() string {
 	return "write_file"
 }
 
+func (t *WriteFileTool) Cacheable() bool {
+	return false
+}
+
 func (t *WriteFileTool) Description() string {
 	return "Write content to a file"
 }
@@ -138,7 +196,7 @@ func (t *WriteFileTool) Execute(ctx context.Context, args map[string]interface{}
 		return "", fmt.Errorf("content is required")
 	}
 
-	resolvedPath, err := validatePath(path, t.workspace, t.restrict)
+	resolvedPath, err := validatePath(path, workspaceFromContext(ctx, t.workspace), t.restrict)
 	if err != nil {
 		return "", err
 	}
@@ -168,6 +226,19 @@ func (t *ListDirTool) SetWorkspace(workspace string) {
 	t.workspace = workspace
 }
 
+// CachePaths ties cached results to the directory listed.
+func (t *ListDirTool) CachePaths(ctx context.Context, args map[string]interface{}) []string {
+	path, ok := args["path"].(string)
+	if !ok {
+		path = "."
+	}
+	resolvedPath, err := validatePath(path, workspaceFromContext(ctx, t.workspace), t.restrict)
+	if err != nil {
+		return nil
+	}
+	return []string{resolvedPath}
+}
+
 func (t *ListDirTool) Name() string {
 	return "list_dir"
 }
@@ -195,7 +266,7 @@ func (t *ListDirTool) Execute(ctx context.Context, args map[string]interface{})
 		path = "."
 	}
 
-	resolvedPath, err := validatePath(path, t.workspace, t.restrict)
+	resolvedPath, err := validatePath(path, workspaceFromContext(ctx, t.workspace), t.restrict)
 	if err != nil {
 		return "", err
 	}
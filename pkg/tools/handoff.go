@@ -0,0 +1,152 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// HandoffAgent describes an agent the handoff tool can pass work to.
+type HandoffAgent struct {
+	Name        string
+	Description string
+}
+
+// HandoffRequest is a handoff asked for by the model. With Transfer set, the
+// target agent also takes over the rest of the conversation.
+type HandoffRequest struct {
+	Agent    string
+	Task     string
+	Context  string
+	Transfer bool
+}
+
+// HandoffFunc runs a handoff and returns the target agent's answer.
+type HandoffFunc func(ctx context.Context, req HandoffRequest) (string, error)
+
+type handoffKey struct{}
+
+// WithHandoff marks ctx as running a handoff from agent, so the target cannot
+// hand the work on again.
+func WithHandoff(ctx context.Context, agent string) context.Context {
+	return context.WithValue(ctx, handoffKey{}, agent)
+}
+
+// HandoffFrom returns the agent that handed off the work running in ctx.
+func HandoffFrom(ctx context.Context) (string, bool) {
+	agent, ok := ctx.Value(handoffKey{}).(string)
+	return agent, ok
+}
+
+// HandoffTool passes a conversation or a sub-question to another configured
+// agent. Unlike spawn, the target runs with its own persona, model and tools,
+// and its answer comes back into the calling session.
+type HandoffTool struct {
+	agents  []HandoffAgent
+	handoff HandoffFunc
+}
+
+func NewHandoffTool(agents []HandoffAgent, handoff HandoffFunc) *HandoffTool {
+	return &HandoffTool{agents: agents, handoff: handoff}
+}
+
+func (t *HandoffTool) Name() string {
+	return "handoff"
+}
+
+func (t *HandoffTool) Cacheable() bool {
+	return false
+}
+
+func (t *HandoffTool) Description() string {
+	var sb strings.Builder
+	sb.WriteString("Hand the conversation or a sub-question to a specialist agent and get its answer back. Use mode 'delegate' to ask a question and carry on yourself, or 'transfer' to let the agent take over the rest of the conversation. Always include a summary of what the agent needs to know, since it does not see this conversation. Available agents:")
+	for _, a := range t.agents {
+		sb.WriteString("\n- " + a.Name)
+		if a.Description != "" {
+			sb.WriteString(": " + a.Description)
+		}
+	}
+	return sb.String()
+}
+
+func (t *HandoffTool) Parameters() map[string]interface{} {
+	names := make([]string, 0, len(t.agents))
+	for _, a := range t.agents {
+		names = append(names, a.Name)
+	}
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"agent": map[string]interface{}{
+				"type":        "string",
+				"enum":        names,
+				"description": "The agent to hand off to",
+			},
+			"task": map[string]interface{}{
+				"type":        "string",
+				"description": "What the agent should do or answer",
+			},
+			"context": map[string]interface{}{
+				"type":        "string",
+				"description": "Summary of the conversation so far: the user's goal, decisions made and relevant facts",
+			},
+			"mode": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"delegate", "transfer"},
+				"description": "delegate (default) returns the answer to you; transfer also routes the user's next messages to the agent",
+			},
+		},
+		"required": []string{"agent", "task", "context"},
+	}
+}
+
+func (t *HandoffTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	if t.handoff == nil {
+		return "Error: Handoff not configured", nil
+	}
+	if from, ok := HandoffFrom(ctx); ok {
+		return "", fmt.Errorf("this work was handed off by %s and cannot be handed off again; answer it yourself", from)
+	}
+	if _, ok := ctx.Value(subagentKey{}).(subagentInfo); ok {
+		return "", fmt.Errorf("subagents cannot hand off work")
+	}
+
+	req := HandoffRequest{}
+	req.Agent, _ = args["agent"].(string)
+	req.Task, _ = args["task"].(string)
+	req.Context, _ = args["context"].(string)
+	if req.Agent == "" || req.Task == "" {
+		return "", fmt.Errorf("agent and task are required")
+	}
+	if !t.knows(req.Agent) {
+		return "", fmt.Errorf("unknown agent %q", req.Agent)
+	}
+
+	mode, _ := args["mode"].(string)
+	switch mode {
+	case "", "delegate":
+	case "transfer":
+		req.Transfer = true
+	default:
+		return "", fmt.Errorf("unknown mode: %s", mode)
+	}
+
+	answer, err := t.handoff(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("handoff to %s failed: %w", req.Agent, err)
+	}
+	if req.Transfer {
+		return fmt.Sprintf("%s has taken over this conversation and will receive the user's next messages. Its answer:\n\n%s\n\nPass this answer on to the user without redoing the work.", req.Agent, answer), nil
+	}
+	return fmt.Sprintf("Answer from %s:\n\n%s", req.Agent, answer), nil
+}
+
+func (t *HandoffTool) knows(name string) bool {
+	for _, a := range t.agents {
+		if a.Name == name {
+			return true
+		}
+	}
+	return false
+}
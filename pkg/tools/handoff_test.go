@@ -0,0 +1,45 @@
+package tools
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestHandoffTool(t *testing.T) {
+	var got HandoffRequest
+	tool := NewHandoffTool([]HandoffAgent{{Name: "reviewer", Description: "Reviews code"}}, func(ctx context.Context, req HandoffRequest) (string, error) {
+		got = req
+		return "looks good", nil
+	})
+
+	if !strings.Contains(tool.Description(), "reviewer: Reviews code") {
+		t.Fatalf("description should list the agents, got %q", tool.Description())
+	}
+
+	result, err := tool.Execute(context.Background(), map[string]interface{}{
+		"agent":   "reviewer",
+		"task":    "review main.go",
+		"context": "user is refactoring",
+		"mode":    "transfer",
+	})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if !got.Transfer || got.Task != "review main.go" || got.Context != "user is refactoring" {
+		t.Fatalf("unexpected request: %+v", got)
+	}
+	if !strings.Contains(result, "looks good") {
+		t.Fatalf("result should carry the answer, got %q", result)
+	}
+
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"agent": "missing", "task": "x"}); err == nil {
+		t.Fatal("expected an error for an unknown agent")
+	}
+
+	// The target of a handoff cannot hand the work on.
+	ctx := WithHandoff(context.Background(), "default")
+	if _, err := tool.Execute(ctx, map[string]interface{}{"agent": "reviewer", "task": "x"}); err == nil {
+		t.Fatal("expected nested handoffs to be refused")
+	}
+}
@@ -0,0 +1,172 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/logger"
+	"github.com/sipeed/kakoclaw/pkg/observability"
+)
+
+// toolOutputDir is where output over the cap is saved, relative to the
+// workspace.
+const toolOutputDir = "tool_output"
+
+// toolSetting looks up the setting for the named tool in a map keyed by tool
+// names or globs. An exact entry wins over globs; among globs the longest
+// one wins.
+func toolSetting[V any](settings map[string]V, name string) (V, bool) {
+	if v, ok := settings[name]; ok {
+		return v, true
+	}
+	var value V
+	best, found := "", false
+	for pattern, v := range settings {
+		if len(pattern) > len(best) && matchesToolPattern(name, []string{pattern}) {
+			best, value, found = pattern, v, true
+		}
+	}
+	return value, found
+}
+
+// limitsFor returns the timeout and output cap for the named tool; zero
+// means no limit.
+func limitsFor(cfg config.ToolLimitsConfig, name string) (time.Duration, int) {
+	timeout, maxOutput := cfg.TimeoutSeconds, cfg.MaxOutputChars
+	if override, ok := toolSetting(cfg.Tools, name); ok {
+		if override.TimeoutSeconds != 0 {
+			timeout = override.TimeoutSeconds
+		}
+		if override.MaxOutputChars != 0 {
+			maxOutput = override.MaxOutputChars
+		}
+	}
+	if timeout < 0 {
+		timeout = 0
+	}
+	if maxOutput < 0 {
+		maxOutput = 0
+	}
+	return time.Duration(timeout) * time.Second, maxOutput
+}
+
+// runTool executes tool, giving up after timeout and turning a panic into
+// an error. The tool's context carries the deadline, so well-behaved tools
+// stop on their own; one that ignores it is left to finish in the
+// background while the agent moves on.
+func runTool(ctx context.Context, tool Tool, args map[string]interface{}, timeout time.Duration) (string, error) {
+	name := tool.Name()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	type outcome struct {
+		result string
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				observability.Global().RecordToolPanic(name)
+				logger.ErrorCF("tool", "Tool panicked",
+					map[string]interface{}{
+						"tool":  name,
+						"panic": fmt.Sprint(p),
+						"stack": string(debug.Stack()),
+					})
+				done <- outcome{err: fmt.Errorf("tool %s crashed: %v", name, p)}
+			}
+		}()
+		result, err := tool.Execute(ctx, args)
+		done <- outcome{result: result, err: err}
+	}()
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	timedOut := func() error {
+		observability.Global().RecordToolTimeout(name)
+		return fmt.Errorf("tool %s timed out after %v; try a smaller request", name, timeout)
+	}
+	select {
+	case o := <-done:
+		if o.err != nil && timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", timedOut()
+		}
+		return o.result, o.err
+	case <-deadline:
+		return "", timedOut()
+	}
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// capOutput cuts result to maxChars characters. The full output is saved to
+// a file in the workspace, and the returned text tells the model where to
+// find it.
+func capOutput(ctx context.Context, name, result string, maxChars int) string {
+	total := utf8.RuneCountInString(result)
+	if maxChars <= 0 || total <= maxChars {
+		return result
+	}
+	observability.Global().RecordToolTruncated(name)
+
+	cut := 0
+	for i := 0; i < maxChars; i++ {
+		_, size := utf8.DecodeRuneInString(result[cut:])
+		cut += size
+	}
+	head := result[:cut]
+
+	path, err := saveToolOutput(workspaceFromContext(ctx, ""), name, result)
+	if err != nil {
+		logger.WarnCF("tool", "Failed to save oversize tool output",
+			map[string]interface{}{
+				"tool":  name,
+				"error": err.Error(),
+			})
+		return head + fmt.Sprintf("\n... (truncated, %d more chars)", total-maxChars)
+	}
+	return head + fmt.Sprintf("\n\n[Output truncated at %d of %d characters. The full output is saved in %s; read it in parts with read_file and its offset and limit arguments.]", maxChars, total, path)
+}
+
+// saveToolOutput writes output to a new file under the workspace's
+// toolOutputDir and returns its path relative to the workspace, or an
+// absolute path when there is no workspace.
+func saveToolOutput(workspace, name, output string) (string, error) {
+	base := workspace
+	if base == "" {
+		base = os.TempDir()
+	}
+	dir := filepath.Join(base, toolOutputDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, unsafeFileChars.ReplaceAllString(name, "_")+"-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(output); err != nil {
+		return "", err
+	}
+	if workspace == "" {
+		return f.Name(), nil
+	}
+	return filepath.Join(toolOutputDir, filepath.Base(f.Name())), nil
+}
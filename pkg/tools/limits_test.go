@@ -0,0 +1,95 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+)
+
+type funcTool struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}
+
+func (t *funcTool) Name() string        { return t.name }
+func (t *funcTool) Description() string { return "test tool" }
+func (t *funcTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{"type": "object"}
+}
+func (t *funcTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	return t.run(ctx)
+}
+
+func TestToolRegistryLimits(t *testing.T) {
+	r := NewToolRegistry()
+	r.SetLimits(config.ToolLimitsConfig{
+		TimeoutSeconds: 1,
+		MaxOutputChars: 100,
+		Tools:          map[string]config.ToolLimit{"slow_ok": {TimeoutSeconds: -1}},
+	})
+	block := make(chan struct{})
+	defer close(block)
+	r.Register(&funcTool{name: "hang", run: func(ctx context.Context) (string, error) {
+		<-block // ignores its context
+		return "late", nil
+	}})
+	r.Register(&funcTool{name: "crash", run: func(ctx context.Context) (string, error) {
+		var m map[string]int
+		m["boom"]++
+		return "", nil
+	}})
+
+	if _, err := r.Execute(context.Background(), "hang", nil); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if _, err := r.Execute(context.Background(), "crash", nil); err == nil || !strings.Contains(err.Error(), "crashed") {
+		t.Fatalf("expected the panic to become an error, got %v", err)
+	}
+
+	if timeout, maxOutput := limitsFor(r.limits, "slow_ok"); timeout != 0 || maxOutput != 100 {
+		t.Fatalf("limitsFor(slow_ok) = %v, %d", timeout, maxOutput)
+	}
+}
+
+func TestToolRegistrySpillsLongOutput(t *testing.T) {
+	dir := t.TempDir()
+	var lines []string
+	for i := 1; i <= 50; i++ {
+		lines = append(lines, strings.Repeat("x", 9)+"\n")
+	}
+	full := strings.Join(lines, "")
+
+	r := NewToolRegistry()
+	r.SetLimits(config.ToolLimitsConfig{MaxOutputChars: 100})
+	r.Register(&funcTool{name: "mcp_big_dump", run: func(ctx context.Context) (string, error) {
+		return full, nil
+	}})
+	r.Register(NewReadFileTool(dir, true))
+
+	ctx := WithExecutionContext(context.Background(), ExecutionContext{Workspace: dir})
+	result, err := r.Execute(ctx, "mcp_big_dump", nil)
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if !strings.HasPrefix(result, full[:100]) {
+		t.Fatalf("expected the first 100 characters, got %q", result)
+	}
+	path := regexp.MustCompile(`saved in (\S+);`).FindStringSubmatch(result)
+	if path == nil {
+		t.Fatalf("result should point at the saved output: %q", result)
+	}
+	saved, err := os.ReadFile(filepath.Join(dir, path[1]))
+	if err != nil || string(saved) != full {
+		t.Fatalf("saved output does not match: %v", err)
+	}
+
+	part, err := r.Execute(ctx, "read_file", map[string]interface{}{"path": path[1], "offset": 49, "limit": 5})
+	if err != nil || part != lines[48]+lines[49] {
+		t.Fatalf("read_file range = %q, %v", part, err)
+	}
+}
@@ -0,0 +1,758 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/logger"
+)
+
+const (
+	defaultProcessReadBytes = 8000
+	maxProcessWait          = 60 * time.Second
+)
+
+// ProcessInfo describes a background process.
+type ProcessInfo struct {
+	ID          string     `json:"id"`
+	Command     string     `json:"command"`
+	Dir         string     `json:"dir,omitempty"`
+	PID         int        `json:"pid"`
+	Status      string     `json:"status"` // "running", "exited", "killed", "timed_out", "failed"
+	ExitCode    *int       `json:"exit_code,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	UserID      int64      `json:"user_id,omitempty"`
+	SessionKey  string     `json:"session_key,omitempty"`
+	Channel     string     `json:"channel,omitempty"`
+	ChatID      string     `json:"chat_id,omitempty"`
+	StdoutBytes int64      `json:"stdout_bytes"`
+	StderrBytes int64      `json:"stderr_bytes"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// ProcessChunk is output read from one stream. Offsets count every byte the
+// stream produced, so a reader resumes at NextOffset even after old output
+// was dropped.
+type ProcessChunk struct {
+	Data       string `json:"data"`
+	Offset     int64  `json:"offset"`
+	NextOffset int64  `json:"next_offset"`
+	Dropped    int64  `json:"dropped,omitempty"` // bytes after the requested offset that are no longer kept
+}
+
+// ProcessOutput is the result of reading a process's output.
+type ProcessOutput struct {
+	Process ProcessInfo  `json:"process"`
+	Stdout  ProcessChunk `json:"stdout"`
+	Stderr  ProcessChunk `json:"stderr"`
+}
+
+// ProcessScope selects the processes a caller may see: a user's processes
+// when UserID is set, otherwise the session's. The zero scope sees all.
+type ProcessScope struct {
+	UserID     int64
+	SessionKey string
+}
+
+// processScopeFromContext scopes tool calls to the calling user or session.
+func processScopeFromContext(ctx context.Context) ProcessScope {
+	ec, _ := ExecutionContextFrom(ctx)
+	return ProcessScope{UserID: ec.UserID, SessionKey: ec.SessionKey}
+}
+
+func (s ProcessScope) allows(p *backgroundProcess) bool {
+	if s.UserID > 0 {
+		return p.info.UserID == s.UserID
+	}
+	if s.SessionKey != "" {
+		return p.info.SessionKey == s.SessionKey
+	}
+	return true
+}
+
+// processStream keeps the most recent output of one stream.
+type processStream struct {
+	data  []byte
+	start int64 // offset of data[0]
+}
+
+func (s *processStream) total() int64 {
+	return s.start + int64(len(s.data))
+}
+
+func (s *processStream) append(p []byte, limit int) {
+	s.data = append(s.data, p...)
+	if over := len(s.data) - limit; limit > 0 && over > 0 {
+		s.data = append(s.data[:0], s.data[over:]...)
+		s.start += int64(over)
+	}
+}
+
+func (s *processStream) read(offset int64, max int, partial bool) ProcessChunk {
+	total := s.total()
+	if offset < 0 || offset > total {
+		offset = total
+	}
+	chunk := ProcessChunk{Offset: offset}
+	if offset < s.start {
+		chunk.Dropped = s.start - offset
+		chunk.Offset = s.start
+	}
+	data := s.data[chunk.Offset-s.start:]
+	if len(data) > max {
+		data = data[:max]
+		partial = true
+	}
+	if partial {
+		data = trimPartialRune(data)
+	}
+	chunk.Data = string(data)
+	chunk.NextOffset = chunk.Offset + int64(len(data))
+	return chunk
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence from the end of b, so a
+// multi-byte character split across reads is returned whole by the next one.
+func trimPartialRune(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+type backgroundProcess struct {
+	mu      sync.Mutex
+	info    ProcessInfo
+	stdout  processStream
+	stderr  processStream
+	limit   int
+	changed chan struct{} // closed and replaced whenever output arrives or the process ends
+	done    chan struct{}
+	stdin   io.WriteCloser
+	cmd     *exec.Cmd
+	cancel  context.CancelFunc
+	killed  bool
+}
+
+// streamWriter appends a process's output to one of its streams.
+type streamWriter struct {
+	p      *backgroundProcess
+	stream *processStream
+}
+
+func (w streamWriter) Write(b []byte) (int, error) {
+	w.p.mu.Lock()
+	w.stream.append(b, w.p.limit)
+	w.p.notifyLocked()
+	w.p.mu.Unlock()
+	return len(b), nil
+}
+
+func (p *backgroundProcess) notifyLocked() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *backgroundProcess) running() bool {
+	return p.info.FinishedAt == nil
+}
+
+func (p *backgroundProcess) snapshotLocked() ProcessInfo {
+	info := p.info
+	info.StdoutBytes = p.stdout.total()
+	info.StderrBytes = p.stderr.total()
+	return info
+}
+
+// ProcessManager runs commands in the background for the process tool. The
+// commands go through the exec tool's guard and sandbox. Finished processes
+// stay readable for a while; all running ones are killed on Shutdown.
+type ProcessManager struct {
+	mu     sync.Mutex
+	procs  map[string]*backgroundProcess
+	exec   *ExecTool
+	cfg    config.ProcessToolsConfig
+	closed bool
+}
+
+// NewProcessManager creates a manager that prepares commands with execTool.
+func NewProcessManager(execTool *ExecTool, cfg config.ProcessToolsConfig) *ProcessManager {
+	return &ProcessManager{
+		procs: make(map[string]*backgroundProcess),
+		exec:  execTool,
+		cfg:   cfg,
+	}
+}
+
+// Start runs command in the background and returns its description. ctx
+// identifies the caller and supplies the workspace; the process outlives it.
+func (pm *ProcessManager) Start(ctx context.Context, command, workingDir string) (ProcessInfo, error) {
+	ec, _ := ExecutionContextFrom(ctx)
+	scope := processScopeFromContext(ctx)
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.closed {
+		return ProcessInfo{}, errors.New("process manager is shut down")
+	}
+	pm.pruneLocked()
+	if pm.cfg.MaxProcesses > 0 && pm.countRunningLocked(scope) >= pm.cfg.MaxProcesses {
+		return ProcessInfo{}, fmt.Errorf("too many background processes (limit %d); kill one first", pm.cfg.MaxProcesses)
+	}
+
+	var procCtx context.Context
+	var cancel context.CancelFunc
+	if pm.cfg.MaxRuntimeMinutes > 0 {
+		procCtx, cancel = context.WithTimeout(context.Background(), time.Duration(pm.cfg.MaxRuntimeMinutes)*time.Minute)
+	} else {
+		procCtx, cancel = context.WithCancel(context.Background())
+	}
+	cmd, cleanup, err := pm.exec.prepare(ctx, procCtx, command, workingDir)
+	if err != nil {
+		cancel()
+		return ProcessInfo{}, err
+	}
+
+	p := &backgroundProcess{
+		info: ProcessInfo{
+			ID:         "proc-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:8],
+			Command:    command,
+			Dir:        cmd.Dir,
+			Status:     "running",
+			UserID:     ec.UserID,
+			SessionKey: ec.SessionKey,
+			Channel:    ec.Channel,
+			ChatID:     ec.ChatID,
+		},
+		limit:   pm.cfg.MaxOutputKB * 1024,
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+		cmd:     cmd,
+		cancel:  cancel,
+	}
+	cmd.Stdout = streamWriter{p, &p.stdout}
+	cmd.Stderr = streamWriter{p, &p.stderr}
+	setProcessGroup(cmd)
+	cmd.WaitDelay = 5 * time.Second
+
+	if p.stdin, err = cmd.StdinPipe(); err != nil {
+		cancel()
+		cleanup()
+		return ProcessInfo{}, fmt.Errorf("failed to open stdin: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		cleanup()
+		return ProcessInfo{}, fmt.Errorf("failed to start process: %w", err)
+	}
+	p.info.PID = cmd.Process.Pid
+	p.info.StartedAt = time.Now()
+	pm.procs[p.info.ID] = p
+
+	go pm.wait(p, procCtx, cleanup)
+
+	logger.InfoCF("tools", "Started background process", map[string]interface{}{
+		"id":      p.info.ID,
+		"pid":     p.info.PID,
+		"command": command,
+		"user_id": ec.UserID,
+	})
+	return p.snapshotLocked(), nil
+}
+
+func (pm *ProcessManager) wait(p *backgroundProcess, procCtx context.Context, cleanup func()) {
+	err := p.cmd.Wait()
+	p.cancel()
+	cleanup()
+
+	p.mu.Lock()
+	now := time.Now()
+	p.info.FinishedAt = &now
+	code := p.cmd.ProcessState.ExitCode() // -1 if killed by a signal or never waited for
+	switch {
+	case p.killed:
+		p.info.Status = "killed"
+	case errors.Is(procCtx.Err(), context.DeadlineExceeded):
+		p.info.Status = "timed_out"
+	case code >= 0:
+		p.info.Status = "exited"
+	case p.cmd.ProcessState != nil:
+		p.info.Status = "killed"
+	default:
+		p.info.Status = "failed"
+		p.info.Error = err.Error()
+	}
+	if code >= 0 {
+		p.info.ExitCode = &code
+	}
+	p.notifyLocked()
+	close(p.done)
+	info := p.info
+	p.mu.Unlock()
+
+	logger.InfoCF("tools", "Background process finished", map[string]interface{}{
+		"id":     info.ID,
+		"status": info.Status,
+	})
+}
+
+// List returns the processes in scope, newest first.
+func (pm *ProcessManager) List(scope ProcessScope) []ProcessInfo {
+	pm.mu.Lock()
+	pm.pruneLocked()
+	procs := pm.visibleLocked(scope)
+	pm.mu.Unlock()
+
+	infos := make([]ProcessInfo, 0, len(procs))
+	for _, p := range procs {
+		p.mu.Lock()
+		infos = append(infos, p.snapshotLocked())
+		p.mu.Unlock()
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].StartedAt.After(infos[j].StartedAt) })
+	return infos
+}
+
+// Get returns the process with the given ID if it is in scope.
+func (pm *ProcessManager) Get(id string, scope ProcessScope) (ProcessInfo, error) {
+	p, err := pm.lookup(id, scope)
+	if err != nil {
+		return ProcessInfo{}, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.snapshotLocked(), nil
+}
+
+// Read returns up to maxBytes of each stream from the given offsets. If there
+// is nothing new and the process is running, it waits up to wait for output
+// or for the process to end.
+func (pm *ProcessManager) Read(ctx context.Context, id string, scope ProcessScope, stdoutOffset, stderrOffset int64, maxBytes int, wait time.Duration) (ProcessOutput, error) {
+	p, err := pm.lookup(id, scope)
+	if err != nil {
+		return ProcessOutput{}, err
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultProcessReadBytes
+	}
+	if wait > maxProcessWait {
+		wait = maxProcessWait
+	}
+
+	var timeout <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		p.mu.Lock()
+		running := p.running()
+		out := ProcessOutput{
+			Process: p.snapshotLocked(),
+			Stdout:  p.stdout.read(stdoutOffset, maxBytes, running),
+			Stderr:  p.stderr.read(stderrOffset, maxBytes, running),
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		if !running || timeout == nil || out.Stdout.Data != "" || out.Stderr.Data != "" {
+			return out, nil
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			timeout = nil
+		case <-ctx.Done():
+			return out, nil
+		}
+	}
+}
+
+// Write sends input to the process's stdin and closes it if closeStdin is set.
+func (pm *ProcessManager) Write(id string, scope ProcessScope, input string, closeStdin bool) error {
+	p, err := pm.lookup(id, scope)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	running, stdin := p.running(), p.stdin
+	p.mu.Unlock()
+	if !running {
+		return fmt.Errorf("process %s is not running", id)
+	}
+	if input != "" {
+		if _, err := io.WriteString(stdin, input); err != nil {
+			return fmt.Errorf("failed to write to stdin: %w", err)
+		}
+	}
+	if closeStdin {
+		if err := stdin.Close(); err != nil {
+			return fmt.Errorf("failed to close stdin: %w", err)
+		}
+	}
+	return nil
+}
+
+// Signal sends the named signal (e.g. "INT" or "SIGTERM") to the process and
+// the processes it started.
+func (pm *ProcessManager) Signal(id string, scope ProcessScope, signal string) error {
+	p, err := pm.lookup(id, scope)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(signal)), "SIG")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.running() {
+		return fmt.Errorf("process %s is not running", id)
+	}
+	if name == "KILL" {
+		p.killed = true
+	}
+	return signalProcess(p.cmd, name)
+}
+
+// Kill stops the process and everything it started.
+func (pm *ProcessManager) Kill(id string, scope ProcessScope) error {
+	p, err := pm.lookup(id, scope)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	if !p.running() {
+		p.mu.Unlock()
+		return fmt.Errorf("process %s is not running", id)
+	}
+	p.killed = true
+	p.mu.Unlock()
+	p.cancel()
+	<-p.done
+	return nil
+}
+
+// Remove forgets the process, killing it first if it is still running.
+func (pm *ProcessManager) Remove(id string, scope ProcessScope) error {
+	p, err := pm.lookup(id, scope)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	if p.running() {
+		p.killed = true
+	}
+	p.mu.Unlock()
+	p.cancel()
+	<-p.done
+
+	pm.mu.Lock()
+	delete(pm.procs, id)
+	pm.mu.Unlock()
+	return nil
+}
+
+// Shutdown kills every running process and refuses new ones.
+func (pm *ProcessManager) Shutdown() {
+	pm.mu.Lock()
+	pm.closed = true
+	procs := make([]*backgroundProcess, 0, len(pm.procs))
+	for _, p := range pm.procs {
+		procs = append(procs, p)
+	}
+	pm.mu.Unlock()
+
+	for _, p := range procs {
+		p.mu.Lock()
+		if p.running() {
+			p.killed = true
+		}
+		p.mu.Unlock()
+		p.cancel()
+	}
+	for _, p := range procs {
+		<-p.done
+	}
+	if len(procs) > 0 {
+		logger.InfoCF("tools", "Stopped background processes", map[string]interface{}{"count": len(procs)})
+	}
+}
+
+func (pm *ProcessManager) lookup(id string, scope ProcessScope) (*backgroundProcess, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	p, ok := pm.procs[id]
+	if !ok || !scope.allows(p) {
+		return nil, fmt.Errorf("process %s not found", id)
+	}
+	return p, nil
+}
+
+func (pm *ProcessManager) visibleLocked(scope ProcessScope) []*backgroundProcess {
+	var procs []*backgroundProcess
+	for _, p := range pm.procs {
+		if scope.allows(p) {
+			procs = append(procs, p)
+		}
+	}
+	return procs
+}
+
+func (pm *ProcessManager) countRunningLocked(scope ProcessScope) int {
+	n := 0
+	for _, p := range pm.visibleLocked(scope) {
+		p.mu.Lock()
+		if p.running() {
+			n++
+		}
+		p.mu.Unlock()
+	}
+	return n
+}
+
+// pruneLocked forgets processes that finished longer ago than the retention
+// period.
+func (pm *ProcessManager) pruneLocked() {
+	if pm.cfg.RetainMinutes <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-time.Duration(pm.cfg.RetainMinutes) * time.Minute)
+	for id, p := range pm.procs {
+		p.mu.Lock()
+		expired := p.info.FinishedAt != nil && p.info.FinishedAt.Before(cutoff)
+		p.mu.Unlock()
+		if expired {
+			delete(pm.procs, id)
+		}
+	}
+}
+
+// ProcessTool lets the agent run commands in the background: dev servers,
+// long builds, log tails. Each call acts on the caller's own processes.
+type ProcessTool struct {
+	manager *ProcessManager
+}
+
+func NewProcessTool(manager *ProcessManager) *ProcessTool {
+	return &ProcessTool{manager: manager}
+}
+
+func (t *ProcessTool) Name() string {
+	return "process"
+}
+
+func (t *ProcessTool) Cacheable() bool {
+	return false
+}
+
+// NeedsApproval gates the actions that run or steer commands; reading output
+// and stopping processes need no approval.
+func (t *ProcessTool) NeedsApproval(args map[string]interface{}) bool {
+	switch action, _ := args["action"].(string); action {
+	case "list", "read", "kill", "remove":
+		return false
+	}
+	return true
+}
+
+func (t *ProcessTool) Description() string {
+	return "Run shell commands in the background (servers, long builds, tails). " +
+		"start returns a process id; read returns new stdout/stderr from the given offsets, " +
+		"optionally waiting for output; write sends stdin; signal, kill and remove stop processes; list shows them."
+}
+
+func (t *ProcessTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"action": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"start", "list", "read", "write", "signal", "kill", "remove"},
+				"description": "What to do",
+			},
+			"command": map[string]interface{}{
+				"type":        "string",
+				"description": "Shell command to run (for start)",
+			},
+			"working_dir": map[string]interface{}{
+				"type":        "string",
+				"description": "Optional working directory (for start)",
+			},
+			"id": map[string]interface{}{
+				"type":        "string",
+				"description": "Process id (for read, write, signal, kill and remove)",
+			},
+			"stdout_offset": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     0,
+				"description": "Read stdout from this byte offset; pass the previous read's next offset (for read)",
+			},
+			"stderr_offset": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     0,
+				"description": "Read stderr from this byte offset (for read)",
+			},
+			"max_bytes": map[string]interface{}{
+				"type":        "integer",
+				"minimum":     1,
+				"description": fmt.Sprintf("Maximum bytes to return per stream (default %d)", defaultProcessReadBytes),
+			},
+			"wait_seconds": map[string]interface{}{
+				"type":        "number",
+				"minimum":     0,
+				"maximum":     maxProcessWait.Seconds(),
+				"description": "Wait up to this long for new output or for the process to exit (for start and read)",
+			},
+			"input": map[string]interface{}{
+				"type":        "string",
+				"description": "Text to write to stdin; include a trailing newline for line-based programs (for write)",
+			},
+			"close_stdin": map[string]interface{}{
+				"type":        "boolean",
+				"description": "Close stdin after writing (for write)",
+			},
+			"signal": map[string]interface{}{
+				"type":        "string",
+				"description": "Signal name such as INT, TERM, HUP or KILL (for signal)",
+			},
+		},
+		"required": []string{"action"},
+	}
+}
+
+func (t *ProcessTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	if t.manager == nil {
+		return "Error: Process manager not configured", nil
+	}
+
+	scope := processScopeFromContext(ctx)
+	action, _ := args["action"].(string)
+	id, _ := args["id"].(string)
+	if id == "" && action != "start" && action != "list" {
+		return "", fmt.Errorf("id is required")
+	}
+	wait := time.Duration(floatArg(args, "wait_seconds") * float64(time.Second))
+
+	switch action {
+	case "start":
+		command, _ := args["command"].(string)
+		if strings.TrimSpace(command) == "" {
+			return "", fmt.Errorf("command is required")
+		}
+		workingDir, _ := args["working_dir"].(string)
+		info, err := t.manager.Start(ctx, command, workingDir)
+		if err != nil {
+			return fmt.Sprintf("Error: %v", err), nil
+		}
+		out, err := t.manager.Read(ctx, info.ID, scope, 0, 0, int(floatArg(args, "max_bytes")), wait)
+		if err != nil {
+			return "", err
+		}
+		return formatProcessOutput(out), nil
+	case "list":
+		infos := t.manager.List(scope)
+		if len(infos) == 0 {
+			return "No background processes", nil
+		}
+		var sb strings.Builder
+		for _, info := range infos {
+			sb.WriteString(formatProcessInfo(info))
+			sb.WriteString("\n")
+		}
+		return strings.TrimRight(sb.String(), "\n"), nil
+	case "read":
+		out, err := t.manager.Read(ctx, id, scope, int64(floatArg(args, "stdout_offset")), int64(floatArg(args, "stderr_offset")),
+			int(floatArg(args, "max_bytes")), wait)
+		if err != nil {
+			return "", err
+		}
+		return formatProcessOutput(out), nil
+	case "write":
+		input, _ := args["input"].(string)
+		closeStdin, _ := args["close_stdin"].(bool)
+		if input == "" && !closeStdin {
+			return "", fmt.Errorf("input or close_stdin is required")
+		}
+		if err := t.manager.Write(id, scope, input, closeStdin); err != nil {
+			return "", err
+		}
+		if closeStdin {
+			return fmt.Sprintf("Wrote %d bytes to process %s and closed its stdin", len(input), id), nil
+		}
+		return fmt.Sprintf("Wrote %d bytes to process %s", len(input), id), nil
+	case "signal":
+		signal, _ := args["signal"].(string)
+		if signal == "" {
+			return "", fmt.Errorf("signal is required")
+		}
+		if err := t.manager.Signal(id, scope, signal); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Sent %s to process %s", strings.ToUpper(signal), id), nil
+	case "kill":
+		if err := t.manager.Kill(id, scope); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Killed process %s", id), nil
+	case "remove":
+		if err := t.manager.Remove(id, scope); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Removed process %s", id), nil
+	default:
+		return "", fmt.Errorf("unknown action: %s", action)
+	}
+}
+
+func floatArg(args map[string]interface{}, name string) float64 {
+	v, _ := args[name].(float64)
+	return v
+}
+
+func formatProcessInfo(info ProcessInfo) string {
+	status := info.Status
+	if info.ExitCode != nil {
+		status += fmt.Sprintf(", exit code %d", *info.ExitCode)
+	}
+	if info.Error != "" {
+		status += ": " + info.Error
+	}
+	return fmt.Sprintf("%s (pid %d, %s): %s", info.ID, info.PID, status, info.Command)
+}
+
+func formatProcessOutput(out ProcessOutput) string {
+	var sb strings.Builder
+	sb.WriteString("Process " + formatProcessInfo(out.Process) + "\n")
+	for _, s := range []struct {
+		name  string
+		chunk ProcessChunk
+	}{{"stdout", out.Stdout}, {"stderr", out.Stderr}} {
+		if s.chunk.Dropped > 0 {
+			fmt.Fprintf(&sb, "(%d earlier %s bytes were dropped)\n", s.chunk.Dropped, s.name)
+		}
+		if s.chunk.Data != "" {
+			fmt.Fprintf(&sb, "--- %s [%d-%d] ---\n%s", s.name, s.chunk.Offset, s.chunk.NextOffset, s.chunk.Data)
+			if !strings.HasSuffix(s.chunk.Data, "\n") {
+				sb.WriteString("\n")
+			}
+		}
+	}
+	fmt.Fprintf(&sb, "Next offsets: stdout_offset=%d stderr_offset=%d", out.Stdout.NextOffset, out.Stderr.NextOffset)
+	return sb.String()
+}
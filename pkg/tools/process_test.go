@@ -0,0 +1,190 @@
+//go:build !windows
+
+package tools
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/sandbox"
+)
+
+func newTestProcessManager(t *testing.T, cfg config.ProcessToolsConfig) *ProcessManager {
+	t.Helper()
+	pm := NewProcessManager(NewExecTool(t.TempDir(), true), cfg)
+	t.Cleanup(pm.Shutdown)
+	return pm
+}
+
+func waitForProcess(t *testing.T, pm *ProcessManager, id string, scope ProcessScope, status string) ProcessInfo {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		info, err := pm.Get(id, scope)
+		if err != nil {
+			t.Fatalf("Get(%s): %v", id, err)
+		}
+		if info.Status == status {
+			return info
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("process %s is %s, want %s", id, info.Status, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProcessManagerOutputAndStdin(t *testing.T) {
+	pm := newTestProcessManager(t, config.ProcessToolsConfig{MaxProcesses: 4, MaxOutputKB: 64})
+	ctx := WithExecutionContext(context.Background(), ExecutionContext{SessionKey: "s1"})
+	scope := processScopeFromContext(ctx)
+
+	info, err := pm.Start(ctx, `echo ready; while read line; do echo "got $line"; echo "err $line" >&2; done; exit 3`, "")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	out, err := pm.Read(ctx, info.ID, scope, 0, 0, 0, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if out.Stdout.Data != "ready\n" || out.Stdout.NextOffset != 6 {
+		t.Fatalf("unexpected first read: %+v", out.Stdout)
+	}
+
+	if err := pm.Write(info.ID, scope, "one\n", true); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	info = waitForProcess(t, pm, info.ID, scope, "exited")
+	if info.ExitCode == nil || *info.ExitCode != 3 {
+		t.Fatalf("expected exit code 3, got %+v", info.ExitCode)
+	}
+
+	out, err = pm.Read(ctx, info.ID, scope, 6, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if out.Stdout.Data != "got one\n" || out.Stdout.Offset != 6 || out.Stderr.Data != "err one\n" {
+		t.Fatalf("unexpected incremental read: %+v", out)
+	}
+	if err := pm.Write(info.ID, scope, "two\n", false); err == nil {
+		t.Fatal("expected writing to a finished process to fail")
+	}
+}
+
+func TestProcessManagerDropsOldOutput(t *testing.T) {
+	pm := newTestProcessManager(t, config.ProcessToolsConfig{MaxOutputKB: 1})
+	ctx := context.Background()
+
+	info, err := pm.Start(ctx, "yes x | head -c 3000", "")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	waitForProcess(t, pm, info.ID, ProcessScope{}, "exited")
+
+	out, err := pm.Read(ctx, info.ID, ProcessScope{}, 0, 0, 100, 0)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if out.Stdout.Dropped != 3000-1024 || out.Stdout.Offset != 3000-1024 || len(out.Stdout.Data) != 100 {
+		t.Fatalf("unexpected read after overflow: offset %d, dropped %d, %d bytes",
+			out.Stdout.Offset, out.Stdout.Dropped, len(out.Stdout.Data))
+	}
+	if out.Process.StdoutBytes != 3000 {
+		t.Fatalf("expected 3000 stdout bytes in total, got %d", out.Process.StdoutBytes)
+	}
+}
+
+func TestProcessManagerScopesAndKills(t *testing.T) {
+	pm := newTestProcessManager(t, config.ProcessToolsConfig{MaxProcesses: 1})
+	alice := WithExecutionContext(context.Background(), ExecutionContext{UserID: 1, SessionKey: "a"})
+	bob := WithExecutionContext(context.Background(), ExecutionContext{UserID: 2, SessionKey: "b"})
+
+	info, err := pm.Start(alice, "sleep 60 & sleep 60; wait", "")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if _, err := pm.Start(alice, "sleep 60", ""); err == nil || !strings.Contains(err.Error(), "too many") {
+		t.Fatalf("expected the per-user limit to apply, got %v", err)
+	}
+	bobInfo, err := pm.Start(bob, "sleep 60", "")
+	if err != nil {
+		t.Fatalf("expected another user to have their own limit: %v", err)
+	}
+
+	bobScope := processScopeFromContext(bob)
+	if list := pm.List(bobScope); len(list) != 1 || list[0].ID != bobInfo.ID {
+		t.Fatalf("expected bob to see only his process, got %+v", list)
+	}
+	if err := pm.Kill(info.ID, bobScope); err == nil {
+		t.Fatal("expected bob not to be able to kill alice's process")
+	}
+	if list := pm.List(ProcessScope{}); len(list) != 2 {
+		t.Fatalf("expected the zero scope to see every process, got %d", len(list))
+	}
+
+	aliceScope := processScopeFromContext(alice)
+	if err := pm.Signal(info.ID, aliceScope, "TERM"); err != nil {
+		t.Fatalf("Signal: %v", err)
+	}
+	if info := waitForProcess(t, pm, info.ID, aliceScope, "killed"); info.ExitCode != nil {
+		t.Fatalf("expected no exit code for a signalled process, got %d", *info.ExitCode)
+	}
+
+	pm.Shutdown()
+	if info := waitForProcess(t, pm, bobInfo.ID, bobScope, "killed"); info.FinishedAt == nil {
+		t.Fatal("expected shutdown to finish bob's process")
+	}
+	if _, err := pm.Start(alice, "true", ""); err == nil {
+		t.Fatal("expected Start to fail after shutdown")
+	}
+}
+
+func TestProcessToolGuardAndApproval(t *testing.T) {
+	pm := newTestProcessManager(t, config.ProcessToolsConfig{})
+	tool := NewProcessTool(pm)
+
+	out, err := tool.Execute(context.Background(), map[string]interface{}{"action": "start", "command": "rm -rf /"})
+	if err != nil || !strings.Contains(out, "dangerous pattern") {
+		t.Fatalf("expected the exec guard to block the command, got %q, %v", out, err)
+	}
+
+	out, err = tool.Execute(context.Background(), map[string]interface{}{"action": "start", "command": "echo hi", "wait_seconds": float64(5)})
+	if err != nil || !strings.Contains(out, "hi\n") || !strings.Contains(out, "stdout_offset=3") {
+		t.Fatalf("unexpected start output: %q, %v", out, err)
+	}
+
+	for action, want := range map[string]bool{"start": true, "write": true, "signal": true, "read": false, "list": false, "kill": false} {
+		if got := tool.NeedsApproval(map[string]interface{}{"action": action}); got != want {
+			t.Errorf("NeedsApproval(%s) = %v, want %v", action, got, want)
+		}
+	}
+}
+
+func TestProcessManagerSandboxed(t *testing.T) {
+	if err := sandbox.Available(); err != nil {
+		t.Skipf("sandbox not available here: %v", err)
+	}
+	execTool := NewExecTool(t.TempDir(), true)
+	execTool.SetSandbox(config.SandboxConfig{Enabled: true, Required: true})
+	pm := NewProcessManager(execTool, config.ProcessToolsConfig{})
+	defer pm.Shutdown()
+
+	info, err := pm.Start(context.Background(), "sleep 60 & echo started; wait", "")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	out, err := pm.Read(context.Background(), info.ID, ProcessScope{}, 0, 0, 0, 5*time.Second)
+	if err != nil || out.Stdout.Data != "started\n" {
+		t.Fatalf("unexpected output: %+v, %v", out, err)
+	}
+	if err := pm.Kill(info.ID, ProcessScope{}); err != nil {
+		t.Fatalf("Kill: %v", err)
+	}
+	if info, _ := pm.Get(info.ID, ProcessScope{}); info.Status != "killed" {
+		t.Fatalf("expected the sandboxed process to be killed, got %s", info.Status)
+	}
+}
@@ -0,0 +1,48 @@
+//go:build !windows
+
+package tools
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+var processSignals = map[string]syscall.Signal{
+	"INT":  syscall.SIGINT,
+	"TERM": syscall.SIGTERM,
+	"KILL": syscall.SIGKILL,
+	"HUP":  syscall.SIGHUP,
+	"QUIT": syscall.SIGQUIT,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"STOP": syscall.SIGSTOP,
+	"CONT": syscall.SIGCONT,
+}
+
+// setProcessGroup starts cmd in its own process group, so that signals and
+// cancellation reach the processes it starts as well.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	killProcessGroupOnCancel(cmd)
+}
+
+// killProcessGroupOnCancel makes cancelling cmd's context kill its whole
+// process group. cmd must lead its group, e.g. by starting a new session.
+func killProcessGroupOnCancel(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// signalProcess sends the named signal to cmd's process group.
+func signalProcess(cmd *exec.Cmd, name string) error {
+	sig, ok := processSignals[name]
+	if !ok {
+		return fmt.Errorf("unsupported signal %q", name)
+	}
+	return syscall.Kill(-cmd.Process.Pid, sig)
+}
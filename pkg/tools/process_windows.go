@@ -0,0 +1,20 @@
+//go:build windows
+
+package tools
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroupOnCancel(cmd *exec.Cmd) {}
+
+// signalProcess supports only KILL, the one signal Windows can deliver.
+func signalProcess(cmd *exec.Cmd, name string) error {
+	if name != "KILL" {
+		return fmt.Errorf("unsupported signal %q on Windows (only KILL)", name)
+	}
+	return cmd.Process.Kill()
+}
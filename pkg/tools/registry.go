@@ -6,12 +6,17 @@ import (
 	"sync"
 	"time"
 
+	"github.com/sipeed/kakoclaw/pkg/config"
 	"github.com/sipeed/kakoclaw/pkg/logger"
+	"github.com/sipeed/kakoclaw/pkg/observability"
 )
 
 type ToolRegistry struct {
-	tools map[string]Tool
-	mu    sync.RWMutex
+	tools   map[string]Tool
+	allowed []string     // Tool names or globs; empty allows every tool
+	cache   *ResultCache // Optional result cache for idempotent tools
+	limits  config.ToolLimitsConfig
+	mu      sync.RWMutex
 }
 
 func NewToolRegistry() *ToolRegistry {
@@ -23,9 +28,50 @@ func NewToolRegistry() *ToolRegistry {
 func (r *ToolRegistry) Register(tool Tool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if len(r.allowed) > 0 && !matchesToolPattern(tool.Name(), r.allowed) {
+		return
+	}
 	r.tools[tool.Name()] = tool
 }
 
+// SetAllowed limits the registry to tools matching patterns, which may be
+// exact names or globs. Registered tools that do not match are dropped, and
+// so are later registrations. An empty list allows every tool.
+func (r *ToolRegistry) SetAllowed(patterns []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.allowed = patterns
+	if len(patterns) == 0 {
+		return
+	}
+	for name := range r.tools {
+		if !matchesToolPattern(name, patterns) {
+			delete(r.tools, name)
+		}
+	}
+}
+
+// SetCache enables result caching with c; nil turns caching off.
+func (r *ToolRegistry) SetCache(c *ResultCache) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cache = c
+}
+
+// Cache returns the registry's result cache, or nil when caching is off.
+func (r *ToolRegistry) Cache() *ResultCache {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cache
+}
+
+// SetLimits sets the timeouts and output caps applied to every tool call.
+func (r *ToolRegistry) SetLimits(limits config.ToolLimitsConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limits = limits
+}
+
 func (r *ToolRegistry) Get(name string) (Tool, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -53,14 +99,70 @@ func (r *ToolRegistry) ExecuteWithContext(ctx context.Context, name string, args
 		return "", fmt.Errorf("tool '%s' not found", name)
 	}
 
-	// If tool implements ContextualTool, set context
-	if contextualTool, ok := tool.(ContextualTool); ok && channel != "" && chatID != "" {
-		contextualTool.SetContext(channel, chatID)
+	// Pass the channel/chat through ctx rather than mutating the shared tool,
+	// so concurrent runs for different sessions do not race on tool state.
+	if channel != "" && chatID != "" {
+		ec, _ := ExecutionContextFrom(ctx)
+		ec.Channel = channel
+		ec.ChatID = chatID
+		ctx = WithExecutionContext(ctx, ec)
+	}
+
+	// Check the arguments against the tool's schema before it sees them.
+	args, err := validateArgs(tool, args)
+	if err != nil {
+		logger.WarnCF("tool", "Tool arguments rejected",
+			map[string]interface{}{
+				"tool":  name,
+				"error": err.Error(),
+			})
+		return "", err
 	}
 
+	// Serve repeated calls to cacheable tools from the cache.
+	var key string
+	var ttl time.Duration
+	var files map[string]fileStamp
+	if cache := r.Cache(); cache != nil {
+		if ttl = cache.ttl(tool); ttl > 0 {
+			if k, ok := cacheKey(ctx, name, args); ok {
+				if result, hit := cache.get(k); hit {
+					observability.Global().RecordToolCache(name, true)
+					logger.InfoCF("tool", "Tool result served from cache",
+						map[string]interface{}{
+							"tool":          name,
+							"result_length": len(result),
+						})
+					return result, nil
+				}
+				observability.Global().RecordToolCache(name, false)
+				key = k
+				// Stamp files before running, so a change made while the tool
+				// runs still invalidates the result.
+				if ft, ok := tool.(FileBackedTool); ok {
+					files = make(map[string]fileStamp)
+					for _, path := range ft.CachePaths(ctx, args) {
+						files[path] = stampFile(path)
+					}
+				}
+			}
+		}
+	}
+
+	r.mu.RLock()
+	timeout, maxOutput := limitsFor(r.limits, name)
+	r.mu.RUnlock()
+
 	start := time.Now()
-	result, err := tool.Execute(ctx, args)
+	result, err := runTool(ctx, tool, args, timeout)
 	duration := time.Since(start)
+	if err == nil {
+		result = capOutput(ctx, name, result, maxOutput)
+	}
+
+	if key != "" && err == nil {
+		r.Cache().put(key, result, ttl, files)
+	}
 
 	if err != nil {
 		logger.ErrorCF("tool", "Tool execution failed",
@@ -81,6 +183,19 @@ func (r *ToolRegistry) ExecuteWithContext(ctx context.Context, name string, args
 	return result, err
 }
 
+// SerialKey returns the serialization key for a call to the named tool, or ""
+// if the call may run in parallel with others.
+func (r *ToolRegistry) SerialKey(ctx context.Context, name string, args map[string]interface{}) string {
+	tool, ok := r.Get(name)
+	if !ok {
+		return ""
+	}
+	if st, ok := tool.(SerialTool); ok {
+		return st.SerialKey(ctx, args)
+	}
+	return ""
+}
+
 func (r *ToolRegistry) GetDefinitions() []map[string]interface{} {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -92,6 +207,23 @@ func (r *ToolRegistry) GetDefinitions() []map[string]interface{} {
 	return definitions
 }
 
+// Filter returns a new registry holding the tools for which keep returns true.
+// The tools themselves are shared with r.
+func (r *ToolRegistry) Filter(keep func(name string) bool) *ToolRegistry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	filtered := NewToolRegistry()
+	filtered.cache = r.cache
+	filtered.limits = r.limits
+	for name, tool := range r.tools {
+		if keep(name) {
+			filtered.tools[name] = tool
+		}
+	}
+	return filtered
+}
+
 // List returns a list of all registered tool names.
 func (r *ToolRegistry) List() []string {
 	r.mu.RLock()
@@ -0,0 +1,78 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestToolRegistrySetAllowed(t *testing.T) {
+	r := NewToolRegistry()
+	r.Register(&echoTool{name: "read_file"})
+	r.Register(&echoTool{name: "exec"})
+	r.Register(&echoTool{name: "mcp_github_search"})
+
+	r.SetAllowed([]string{"read_file", "mcp_github_*", "spawn"})
+	r.Register(&echoTool{name: "write_file"})
+	r.Register(&echoTool{name: "spawn"})
+
+	got := r.List()
+	sort.Strings(got)
+	want := []string{"mcp_github_search", "read_file", "spawn"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("List() = %v, want %v", got, want)
+	}
+}
+
+type argsTool struct{ got map[string]interface{} }
+
+func (t *argsTool) Name() string        { return "args" }
+func (t *argsTool) Description() string { return "records its arguments" }
+func (t *argsTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"path":  map[string]interface{}{"type": "string"},
+			"limit": map[string]interface{}{"type": "integer", "minimum": 1},
+			"mode":  map[string]interface{}{"type": "string", "enum": []string{"fast", "slow"}},
+		},
+		"required": []string{"path"},
+	}
+}
+func (t *argsTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	t.got = args
+	return "ok", nil
+}
+
+func TestToolRegistryValidatesArguments(t *testing.T) {
+	r := NewToolRegistry()
+	tool := &argsTool{}
+	r.Register(tool)
+	ctx := context.Background()
+
+	if _, err := r.Execute(ctx, "args", map[string]interface{}{"path": "a.txt", "limit": "5"}); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if tool.got["limit"] != float64(5) || tool.got["path"] != "a.txt" {
+		t.Fatalf("expected \"5\" to be coerced to 5, got %#v", tool.got)
+	}
+
+	tool.got = nil
+	_, err := r.Execute(ctx, "args", map[string]interface{}{"limit": 0, "mode": "medium"})
+	var argErr *ArgumentError
+	if !errors.As(err, &argErr) {
+		t.Fatalf("expected an ArgumentError, got %v", err)
+	}
+	if tool.got != nil {
+		t.Fatal("the tool must not run with invalid arguments")
+	}
+	msg := err.Error()
+	for _, want := range []string{`missing required property "path"`, "$.limit: must be >= 1", `$.mode: must be one of ["fast","slow"]`} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("error %q should mention %q", msg, want)
+		}
+	}
+}
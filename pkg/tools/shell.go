@@ -3,6 +3,7 @@ package tools
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -10,7 +11,12 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/logger"
+	"github.com/sipeed/kakoclaw/pkg/sandbox"
 )
 
 type ExecTool struct {
@@ -19,6 +25,8 @@ type ExecTool struct {
 	denyPatterns        []*regexp.Regexp
 	allowPatterns       []*regexp.Regexp
 	restrictToWorkspace bool
+	sandbox             config.SandboxConfig
+	sandboxWarning      sync.Once
 }
 
 func NewExecTool(workingDir string, restrict bool) *ExecTool {
@@ -46,10 +54,21 @@ func (t *ExecTool) SetWorkspace(workspace string) {
 	t.workingDir = workspace
 }
 
+// SerialKey serializes all exec calls: commands can have arbitrary side
+// effects on the workspace, so they never run concurrently with each other
+// or with file tools.
+func (t *ExecTool) SerialKey(ctx context.Context, args map[string]interface{}) string {
+	return workspaceSerialKey
+}
+
 func (t *ExecTool) Name() string {
 	return "exec"
 }
 
+func (t *ExecTool) Cacheable() bool {
+	return false
+}
+
 func (t *ExecTool) Description() string {
 	return "Execute a shell command and return its output. Use with caution."
 }
@@ -77,40 +96,22 @@ func (t *ExecTool) Execute(ctx context.Context, args map[string]interface{}) (st
 		return "", fmt.Errorf("command is required")
 	}
 
-	cwd := t.workingDir
-	if wd, ok := args["working_dir"].(string); ok && wd != "" {
-		cwd = wd
-	}
-
-	if cwd == "" {
-		wd, err := os.Getwd()
-		if err == nil {
-			cwd = wd
-		}
-	}
-
-	if guardError := t.guardCommand(command, cwd); guardError != "" {
-		return fmt.Sprintf("Error: %s", guardError), nil
-	}
+	workingDir, _ := args["working_dir"].(string)
 
 	cmdCtx, cancel := context.WithTimeout(ctx, t.timeout)
 	defer cancel()
 
-	var cmd *exec.Cmd
-	if runtime.GOOS == "windows" {
-		cmd = exec.CommandContext(cmdCtx, "cmd", "/c", command)
-	} else {
-		cmd = exec.CommandContext(cmdCtx, "sh", "-c", command)
-	}
-	if cwd != "" {
-		cmd.Dir = cwd
+	cmd, cleanup, err := t.prepare(ctx, cmdCtx, command, workingDir)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err), nil
 	}
+	defer cleanup()
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	err = cmd.Run()
 	output := stdout.String()
 	if stderr.Len() > 0 {
 		output += "\nSTDERR:\n" + stderr.String()
@@ -127,15 +128,82 @@ func (t *ExecTool) Execute(ctx context.Context, args map[string]interface{}) (st
 		output = "(no output)"
 	}
 
-	maxLen := 10000
-	if len(output) > maxLen {
-		output = output[:maxLen] + fmt.Sprintf("\n... (truncated, %d more chars)", len(output)-maxLen)
+	return output, nil
+}
+
+// prepare checks command against the guard and builds the command that runs
+// it in workingDir (the workspace by default), sandboxed when the sandbox is
+// enabled. ctx supplies the workspace and cmdCtx bounds the command. Errors
+// are meant for the model. Call cleanup once the command has finished.
+func (t *ExecTool) prepare(ctx, cmdCtx context.Context, command, workingDir string) (*exec.Cmd, func(), error) {
+	workspace := workspaceFromContext(ctx, t.workingDir)
+	cwd := workspace
+	if workingDir != "" {
+		cwd = workingDir
+	}
+	if cwd == "" {
+		wd, err := os.Getwd()
+		if err == nil {
+			cwd = wd
+		}
 	}
 
-	return output, nil
+	sandboxed, err := t.useSandbox()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The sandbox enforces the workspace boundary itself, so only the pattern
+	// checks apply to sandboxed commands.
+	if guardError := t.guardCommand(command, cwd, !sandboxed); guardError != "" {
+		return nil, nil, errors.New(guardError)
+	}
+
+	if sandboxed {
+		if workspace == "" {
+			return nil, nil, errors.New("sandboxed commands need a workspace")
+		}
+		if cwd, err = validatePath(cwd, workspace, true); err != nil {
+			return nil, nil, errors.New("working_dir must be inside the workspace when commands are sandboxed")
+		}
+		return sandbox.Command(cmdCtx, t.sandbox, workspace, cwd, command)
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(cmdCtx, "cmd", "/c", command)
+	} else {
+		cmd = exec.CommandContext(cmdCtx, "sh", "-c", command)
+	}
+	if cwd != "" {
+		cmd.Dir = cwd
+	}
+	return cmd, func() {}, nil
 }
 
-func (t *ExecTool) guardCommand(command, cwd string) string {
+// useSandbox reports whether commands run in the sandbox. When the sandbox is
+// enabled but unavailable, commands fall back to the pattern guard unless the
+// sandbox is required.
+func (t *ExecTool) useSandbox() (bool, error) {
+	if !t.sandbox.Enabled {
+		return false, nil
+	}
+	err := sandbox.Available()
+	if err == nil {
+		return true, nil
+	}
+	if t.sandbox.Required {
+		return false, fmt.Errorf("the command sandbox is required but unavailable: %w", err)
+	}
+	t.sandboxWarning.Do(func() {
+		logger.WarnCF("tools", "Exec sandbox unavailable, falling back to the command guard", map[string]interface{}{
+			"error": err.Error(),
+		})
+	})
+	return false, nil
+}
+
+func (t *ExecTool) guardCommand(command, cwd string, checkPaths bool) string {
 	cmd := strings.TrimSpace(command)
 	lower := strings.ToLower(cmd)
 
@@ -158,7 +226,7 @@ func (t *ExecTool) guardCommand(command, cwd string) string {
 		}
 	}
 
-	if t.restrictToWorkspace {
+	if t.restrictToWorkspace && checkPaths {
 		if strings.Contains(cmd, "..\\") || strings.Contains(cmd, "../") {
 			return "Command blocked by safety guard (path traversal detected)"
 		}
@@ -199,6 +267,11 @@ func (t *ExecTool) SetRestrictToWorkspace(restrict bool) {
 	t.restrictToWorkspace = restrict
 }
 
+// SetSandbox configures the sandbox that commands run in.
+func (t *ExecTool) SetSandbox(cfg config.SandboxConfig) {
+	t.sandbox = cfg
+}
+
 func (t *ExecTool) SetAllowPatterns(patterns []string) error {
 	t.allowPatterns = make([]*regexp.Regexp, 0, len(patterns))
 	for _, p := range patterns {
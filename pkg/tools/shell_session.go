@@ -0,0 +1,783 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/google/uuid"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/logger"
+)
+
+const (
+	// maxShellBuffer bounds the unread output kept for a session.
+	maxShellBuffer = 1 << 20
+	// shellSettle is how long read and input wait for output to pause before
+	// returning what a still-running command printed.
+	shellSettle = 500 * time.Millisecond
+	// maxShellWait caps how long one call waits for a command. The default
+	// tools.limits give the shell tool room for it.
+	maxShellWait = 10 * time.Minute
+)
+
+// ShellSessionManager keeps one interactive shell per agent session, so that
+// the working directory, variables and activated environments persist
+// between commands. Each shell runs on a pseudo-terminal and goes through the
+// exec tool's guard and sandbox. The prompt doubles as the completion
+// sentinel: it carries a per-session nonce, the exit status and the working
+// directory, and the shell prints it whenever it is ready for a command.
+type ShellSessionManager struct {
+	mu       sync.Mutex
+	sessions map[shellKey]*shellSession
+	exec     *ExecTool
+	cfg      config.ShellToolsConfig
+	closed   bool
+	stop     chan struct{}
+}
+
+// NewShellSessionManager creates a manager that starts shells with execTool's
+// guard and sandbox settings.
+func NewShellSessionManager(execTool *ExecTool, cfg config.ShellToolsConfig) *ShellSessionManager {
+	m := &ShellSessionManager{
+		sessions: make(map[shellKey]*shellSession),
+		exec:     execTool,
+		cfg:      cfg,
+		stop:     make(chan struct{}),
+	}
+	if cfg.IdleTimeoutMinutes > 0 {
+		go m.reapIdle(time.Duration(cfg.IdleTimeoutMinutes) * time.Minute)
+	}
+	return m
+}
+
+// ShellResult is the outcome of a call to a shell session.
+type ShellResult struct {
+	Output   string
+	ExitCode int
+	Dir      string
+	Running  bool // the command has not finished yet
+	Exited   bool // the shell itself exited, e.g. after "exit"
+	Notice   string
+
+	incomplete bool // the shell asked for a continuation line
+}
+
+type shellSession struct {
+	key       shellKey
+	workspace string
+	sandboxed bool
+
+	mu      sync.Mutex // serializes calls
+	running bool       // a command is running; output and the prompt are still due
+	dir     string
+
+	pty     *os.File
+	cmd     *exec.Cmd
+	cancel  context.CancelFunc
+	cleanup func()
+	ps1     *regexp.Regexp // matches the prompt, capturing the exit status and directory
+	ps2     []byte         // the continuation prompt: the shell wants more input
+
+	outMu    sync.Mutex
+	buf      []byte
+	dropped  bool
+	changed  chan struct{}
+	done     chan struct{} // closed when the shell exits
+	lastUsed time.Time
+}
+
+// Run runs command in the session for ctx's agent session, starting the
+// shell if needed, and waits up to timeout for it to finish.
+func (m *ShellSessionManager) Run(ctx context.Context, command string, timeout time.Duration) (ShellResult, error) {
+	s, err := m.session(ctx)
+	if err != nil {
+		return ShellResult{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch()
+
+	if s.running {
+		return ShellResult{}, errors.New("the previous command is still running; read its output, send it input or interrupt it first")
+	}
+	if guardError := m.exec.guardCommand(command, s.dir, !s.sandboxed); guardError != "" {
+		return ShellResult{}, errors.New(guardError)
+	}
+
+	s.takeOutput()
+	line := command
+	if strings.Contains(command, "\n") {
+		// A block is read whole before it runs, so the shell prompts once.
+		line = "{\n" + command + "\n}"
+	}
+	if err := s.write(line + "\n"); err != nil {
+		m.forget(s)
+		return ShellResult{}, err
+	}
+	s.running = true
+
+	// A single line that leaves the shell asking for more is incomplete.
+	res := s.wait(ctx, timeout, 0, line == command)
+	if res.incomplete {
+		s.write("\x03")
+		s.wait(ctx, 5*time.Second, 0, false)
+		return ShellResult{}, errors.New("the command is incomplete (unterminated quote or block?) and was discarded")
+	}
+	return m.finish(ctx, s, res), nil
+}
+
+// Read waits up to timeout for more output from the running command.
+func (m *ShellSessionManager) Read(ctx context.Context, timeout time.Duration) (ShellResult, error) {
+	s, err := m.existing(ctx)
+	if err != nil {
+		return ShellResult{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch()
+	if !s.running {
+		return ShellResult{}, errors.New("no command is running")
+	}
+	return m.finish(ctx, s, s.wait(ctx, timeout, shellSettle, false)), nil
+}
+
+// Input sends text to the running command, e.g. an answer to a prompt, and
+// waits up to timeout for its response.
+func (m *ShellSessionManager) Input(ctx context.Context, input string, timeout time.Duration) (ShellResult, error) {
+	s, err := m.existing(ctx)
+	if err != nil {
+		return ShellResult{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch()
+	if !s.running {
+		return ShellResult{}, errors.New("no command is running; use run to start one")
+	}
+	// The input may be read by a nested shell, so it gets the same checks
+	// as a command.
+	if guardError := m.exec.guardCommand(input, s.dir, !s.sandboxed); guardError != "" {
+		return ShellResult{}, errors.New(guardError)
+	}
+	if err := s.write(input); err != nil {
+		m.forget(s)
+		return ShellResult{}, err
+	}
+	return m.finish(ctx, s, s.wait(ctx, timeout, shellSettle, false)), nil
+}
+
+// Interrupt sends Ctrl-C to the running command.
+func (m *ShellSessionManager) Interrupt(ctx context.Context, timeout time.Duration) (ShellResult, error) {
+	s, err := m.existing(ctx)
+	if err != nil {
+		return ShellResult{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touch()
+	if !s.running {
+		return ShellResult{}, errors.New("no command is running")
+	}
+	if err := s.write("\x03"); err != nil {
+		m.forget(s)
+		return ShellResult{}, err
+	}
+	return m.finish(ctx, s, s.wait(ctx, timeout, 0, false)), nil
+}
+
+// Close ends the shell for ctx's agent session. It reports whether there was
+// one.
+func (m *ShellSessionManager) Close(ctx context.Context) bool {
+	m.mu.Lock()
+	key := shellSessionKey(ctx, m.exec.workingDir)
+	s, ok := m.sessions[key]
+	delete(m.sessions, key)
+	m.mu.Unlock()
+	if ok {
+		s.close()
+	}
+	return ok
+}
+
+// Shutdown ends every shell and refuses new ones.
+func (m *ShellSessionManager) Shutdown() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	close(m.stop)
+	sessions := m.sessions
+	m.sessions = make(map[shellKey]*shellSession)
+	m.mu.Unlock()
+
+	for _, s := range sessions {
+		s.close()
+	}
+}
+
+// shellKey identifies a shell. Users sharing a session key, such as the
+// web's default chat, and agents with different workspaces get their own.
+type shellKey struct {
+	userID    int64
+	workspace string
+	session   string
+}
+
+func shellSessionKey(ctx context.Context, def string) shellKey {
+	ec, _ := ExecutionContextFrom(ctx)
+	key := shellKey{userID: ec.UserID, workspace: workspaceFromContext(ctx, def), session: ec.SessionKey}
+	if key.session == "" {
+		key.session = "default"
+	}
+	return key
+}
+
+// existing returns the running shell for ctx's agent session.
+func (m *ShellSessionManager) existing(ctx context.Context) (*shellSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[shellSessionKey(ctx, m.exec.workingDir)]
+	if !ok {
+		return nil, errors.New("no shell session; use run to start one")
+	}
+	return s, nil
+}
+
+// session returns the shell for ctx's agent session, starting one if needed.
+func (m *ShellSessionManager) session(ctx context.Context) (*shellSession, error) {
+	key := shellSessionKey(ctx, m.exec.workingDir)
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, errors.New("shell sessions are shut down")
+	}
+	if s, ok := m.sessions[key]; ok {
+		select {
+		case <-s.done:
+			delete(m.sessions, key)
+			go s.close()
+		default:
+			m.mu.Unlock()
+			return s, nil
+		}
+	}
+	if m.cfg.MaxSessions > 0 && len(m.sessions) >= m.cfg.MaxSessions {
+		if !m.evictLocked() {
+			m.mu.Unlock()
+			return nil, fmt.Errorf("too many shell sessions (limit %d)", m.cfg.MaxSessions)
+		}
+	}
+	m.mu.Unlock()
+
+	s, err := m.start(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		go s.close()
+		return nil, errors.New("shell sessions are shut down")
+	}
+	if other, ok := m.sessions[key]; ok {
+		// A concurrent call started one first.
+		go s.close()
+		return other, nil
+	}
+	m.sessions[key] = s
+	return s, nil
+}
+
+// evictLocked closes the least recently used idle session to make room.
+func (m *ShellSessionManager) evictLocked() bool {
+	var oldest *shellSession
+	for _, s := range m.sessions {
+		if !s.mu.TryLock() {
+			continue
+		}
+		idle := !s.running
+		s.mu.Unlock()
+		if idle && (oldest == nil || s.idleSince().Before(oldest.idleSince())) {
+			oldest = s
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	delete(m.sessions, oldest.key)
+	go oldest.close()
+	return true
+}
+
+func (m *ShellSessionManager) start(ctx context.Context, key shellKey) (*shellSession, error) {
+	sandboxed, err := m.exec.useSandbox()
+	if err != nil {
+		return nil, err
+	}
+	workspace := key.workspace
+
+	shellCtx, cancel := context.WithCancel(context.Background())
+	cmd, cleanup, err := m.exec.prepare(ctx, shellCtx, "exec "+m.shellCommand(), "")
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	killProcessGroupOnCancel(cmd)
+	cmd.WaitDelay = 2 * time.Second
+
+	f, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: 50, Cols: 200})
+	if err != nil {
+		cancel()
+		cleanup()
+		if errors.Is(err, pty.ErrUnsupported) {
+			return nil, errors.New("shell sessions are not supported on this platform; use exec")
+		}
+		return nil, fmt.Errorf("failed to start shell: %w", err)
+	}
+
+	nonce := strings.ReplaceAll(uuid.NewString(), "-", "")[:12]
+	s := &shellSession{
+		key:       key,
+		workspace: workspace,
+		sandboxed: sandboxed,
+		dir:       cmd.Dir,
+		pty:       f,
+		cmd:       cmd,
+		cancel:    cancel,
+		cleanup:   cleanup,
+		ps1:       regexp.MustCompile(`__KC_` + nonce + `_(\d+)_(.*?)_` + nonce + `__`),
+		ps2:       []byte(`__KC_` + nonce + `_MORE__`),
+		changed:   make(chan struct{}),
+		done:      make(chan struct{}),
+		lastUsed:  time.Now(),
+	}
+	go s.readLoop()
+	go func() {
+		_ = cmd.Wait()
+		cleanup()
+	}()
+
+	// Terminal echo would repeat every command in the output, and pagers
+	// would wait for keys nobody presses. The prompts are set last so that
+	// the first sentinel marks the end of the setup.
+	setup := "stty -echo; export TERM=dumb PAGER=cat GIT_PAGER=cat; unset HISTFILE PROMPT_COMMAND; " +
+		"PS2='__KC_" + nonce + "_MORE__'; PS1='__KC_" + nonce + "_${?}_${PWD}_" + nonce + "__'\n"
+	s.running = true
+	if err := s.write(setup); err == nil {
+		if res := s.wait(ctx, 10*time.Second, 0, false); !res.Running && !res.Exited {
+			s.takeOutput()
+			logger.InfoCF("tools", "Started shell session", map[string]interface{}{
+				"session":   key.session,
+				"user_id":   key.userID,
+				"pid":       cmd.Process.Pid,
+				"sandboxed": sandboxed,
+			})
+			return s, nil
+		}
+	}
+	s.close()
+	return nil, errors.New("failed to start shell: it did not become ready")
+}
+
+// shellCommand is the command line that starts the interactive shell.
+func (m *ShellSessionManager) shellCommand() string {
+	if m.cfg.Shell != "" {
+		return m.cfg.Shell
+	}
+	if _, err := exec.LookPath("bash"); err == nil {
+		return "bash --noprofile --norc -i"
+	}
+	return "sh -i"
+}
+
+// finish completes a call: it resets the working directory when it left the
+// workspace, and forgets the session when the shell exited.
+func (m *ShellSessionManager) finish(ctx context.Context, s *shellSession, res ShellResult) ShellResult {
+	if res.Exited {
+		m.forget(s)
+		return res
+	}
+	if !res.Running && m.exec.restrictToWorkspace && !s.sandboxed && s.workspace != "" {
+		if _, err := validatePath(res.Dir, s.workspace, true); err != nil {
+			s.takeOutput()
+			s.write("cd -- " + shellQuote(s.workspace) + "\n")
+			s.running = true
+			if back := s.wait(ctx, 5*time.Second, 0, false); !back.Running {
+				res.Notice = fmt.Sprintf("The working directory %s is outside the workspace; it was reset to %s.", res.Dir, back.Dir)
+				res.Dir = back.Dir
+			}
+		}
+	}
+	return res
+}
+
+func (m *ShellSessionManager) forget(s *shellSession) {
+	m.mu.Lock()
+	if m.sessions[s.key] == s {
+		delete(m.sessions, s.key)
+	}
+	m.mu.Unlock()
+	s.close()
+}
+
+// reapIdle closes sessions that have not been used for idle.
+func (m *ShellSessionManager) reapIdle(idle time.Duration) {
+	interval := idle / 4
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+		m.closeIdle(idle)
+	}
+}
+
+func (m *ShellSessionManager) closeIdle(idle time.Duration) {
+	cutoff := time.Now().Add(-idle)
+	var expired []*shellSession
+	m.mu.Lock()
+	for key, s := range m.sessions {
+		if s.idleSince().Before(cutoff) && s.mu.TryLock() {
+			s.mu.Unlock()
+			delete(m.sessions, key)
+			expired = append(expired, s)
+		}
+	}
+	m.mu.Unlock()
+	for _, s := range expired {
+		logger.InfoCF("tools", "Closing idle shell session", map[string]interface{}{
+			"session": s.key.session,
+			"user_id": s.key.userID,
+		})
+		s.close()
+	}
+}
+
+func (s *shellSession) touch() {
+	s.outMu.Lock()
+	s.lastUsed = time.Now()
+	s.outMu.Unlock()
+}
+
+func (s *shellSession) idleSince() time.Time {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	return s.lastUsed
+}
+
+func (s *shellSession) write(text string) error {
+	if _, err := io.WriteString(s.pty, text); err != nil {
+		return fmt.Errorf("shell session ended: %w", err)
+	}
+	return nil
+}
+
+func (s *shellSession) readLoop() {
+	chunk := make([]byte, 4096)
+	for {
+		n, err := s.pty.Read(chunk)
+		s.outMu.Lock()
+		if n > 0 {
+			s.buf = append(s.buf, chunk[:n]...)
+			if over := len(s.buf) - maxShellBuffer; over > 0 {
+				s.buf = append(s.buf[:0], s.buf[over:]...)
+				s.dropped = true
+			}
+		}
+		close(s.changed)
+		s.changed = make(chan struct{})
+		if err != nil {
+			close(s.done)
+			s.outMu.Unlock()
+			return
+		}
+		s.outMu.Unlock()
+	}
+}
+
+// takeOutput removes and returns the unread output.
+func (s *shellSession) takeOutput() ([]byte, bool) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	out, dropped := s.buf, s.dropped
+	s.buf, s.dropped = nil, false
+	return out, dropped
+}
+
+// wait collects output until the prompt shows that the command finished, the
+// shell exits or timeout passes. With settle set, it also returns once output
+// has arrived and then paused for that long. With singleLine set, it returns
+// as soon as the shell asks for a continuation line.
+func (s *shellSession) wait(ctx context.Context, timeout, settle time.Duration, singleLine bool) ShellResult {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	var quiet <-chan time.Time
+
+	for {
+		s.outMu.Lock()
+		loc := s.ps1.FindSubmatchIndex(s.buf)
+		var res ShellResult
+		var ready bool
+		if loc != nil {
+			res.ExitCode, _ = strconv.Atoi(string(s.buf[loc[2]:loc[3]]))
+			res.Dir = string(s.buf[loc[4]:loc[5]])
+			res.Output = sanitizeTerminalOutput(s.buf[:loc[0]], s.ps2)
+			s.buf = append([]byte(nil), s.buf[loc[1]:]...)
+			s.running = false
+			s.dir = res.Dir
+			ready = true
+		}
+		incomplete := singleLine && !ready && bytes.HasSuffix(bytes.TrimRight(s.buf, " "), s.ps2)
+		changed, done, pending := s.changed, s.done, len(s.buf) > 0
+		s.outMu.Unlock()
+		if ready {
+			return res
+		}
+		if incomplete {
+			s.takeOutput()
+			return ShellResult{Running: true, Dir: s.dir, incomplete: true}
+		}
+
+		select {
+		case <-done:
+			out, _ := s.takeOutput()
+			s.running = false
+			return ShellResult{Output: sanitizeTerminalOutput(out, s.ps2), Exited: true}
+		default:
+		}
+		if pending && settle > 0 {
+			// Restarted on every change, so it fires once output pauses.
+			quiet = time.After(settle)
+		}
+
+		select {
+		case <-changed:
+			continue
+		case <-done:
+			continue
+		case <-quiet:
+		case <-deadline.C:
+		case <-ctx.Done():
+		}
+		out, dropped := s.takeOutput()
+		partial := ShellResult{Output: sanitizeTerminalOutput(out, s.ps2), Running: true, Dir: s.dir}
+		if dropped {
+			partial.Notice = "Earlier output was dropped because it exceeded the buffer."
+		}
+		return partial
+	}
+}
+
+func (s *shellSession) close() {
+	s.cancel()
+	s.pty.Close()
+	<-s.done
+}
+
+var ansiSequence = regexp.MustCompile(`\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[()][0-9A-Za-z]|[@-Z\\-_])`)
+
+// sanitizeTerminalOutput turns raw terminal output into plain text: it
+// removes escape sequences and continuation prompts, applies carriage
+// returns and backspaces the way a terminal would, and drops other control
+// characters.
+func sanitizeTerminalOutput(raw, continuation []byte) string {
+	text := ansiSequence.ReplaceAllString(string(raw), "")
+	text = strings.ReplaceAll(text, string(continuation), "")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		// A carriage return starts the line over, as progress bars use it.
+		if j := strings.LastIndex(strings.TrimRight(line, "\r"), "\r"); j >= 0 {
+			line = line[j+1:]
+		}
+		var b []rune
+		for _, r := range line {
+			switch {
+			case r == '\b':
+				if len(b) > 0 {
+					b = b[:len(b)-1]
+				}
+			case r == '\t' || r >= ' ' && r != 0x7f:
+				b = append(b, r)
+			}
+		}
+		lines[i] = string(b)
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// ShellTool lets the agent work in a persistent interactive shell: the
+// working directory, variables and activated environments carry over from
+// one command to the next.
+type ShellTool struct {
+	manager *ShellSessionManager
+}
+
+func NewShellTool(manager *ShellSessionManager) *ShellTool {
+	return &ShellTool{manager: manager}
+}
+
+func (t *ShellTool) Name() string {
+	return "shell"
+}
+
+func (t *ShellTool) Cacheable() bool {
+	return false
+}
+
+// SerialKey orders shell calls like exec calls: commands may touch any file.
+func (t *ShellTool) SerialKey(ctx context.Context, args map[string]interface{}) string {
+	return workspaceSerialKey
+}
+
+// NeedsApproval gates the actions that send text to the shell; reading
+// output, interrupting and closing need no approval.
+func (t *ShellTool) NeedsApproval(args map[string]interface{}) bool {
+	switch action, _ := args["action"].(string); action {
+	case "read", "interrupt", "close":
+		return false
+	}
+	return true
+}
+
+func (t *ShellTool) Description() string {
+	return "Run commands in a persistent interactive shell for this session; cd, exported variables " +
+		"and activated environments carry over between commands. run executes a command and waits for it; " +
+		"if it is still running, read collects more output, input answers its prompts and interrupt sends Ctrl-C. " +
+		"close ends the shell."
+}
+
+func (t *ShellTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"action": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"run", "read", "input", "interrupt", "close"},
+				"description": "What to do (default run)",
+			},
+			"command": map[string]interface{}{
+				"type":        "string",
+				"description": "Command to run (for run)",
+			},
+			"input": map[string]interface{}{
+				"type":        "string",
+				"description": "Text to send to the running command; include a trailing newline to submit a line (for input)",
+			},
+			"timeout_seconds": map[string]interface{}{
+				"type":        "number",
+				"minimum":     0,
+				"maximum":     maxShellWait.Seconds(),
+				"description": "How long to wait for the command to finish before returning what it printed so far",
+			},
+		},
+	}
+}
+
+func (t *ShellTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	if t.manager == nil {
+		return "Error: Shell sessions not configured", nil
+	}
+
+	action, _ := args["action"].(string)
+	timeout := time.Duration(floatArg(args, "timeout_seconds") * float64(time.Second))
+	if timeout <= 0 {
+		timeout = time.Duration(t.manager.cfg.CommandTimeoutSeconds) * time.Second
+	}
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	if timeout > maxShellWait {
+		timeout = maxShellWait
+	}
+	// Return before the registry gives up on the call: output the wait took
+	// from the session would be lost with a timed-out call.
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline) - time.Second; left > 0 && left < timeout {
+			timeout = left
+		}
+	}
+
+	var res ShellResult
+	var err error
+	switch action {
+	case "", "run":
+		command, _ := args["command"].(string)
+		if strings.TrimSpace(command) == "" {
+			return "", fmt.Errorf("command is required")
+		}
+		res, err = t.manager.Run(ctx, command, timeout)
+	case "read":
+		res, err = t.manager.Read(ctx, timeout)
+	case "input":
+		input, _ := args["input"].(string)
+		if input == "" {
+			return "", fmt.Errorf("input is required")
+		}
+		res, err = t.manager.Input(ctx, input, timeout)
+	case "interrupt":
+		res, err = t.manager.Interrupt(ctx, timeout)
+	case "close":
+		if t.manager.Close(ctx) {
+			return "Shell session closed", nil
+		}
+		return "No shell session to close", nil
+	default:
+		return "", fmt.Errorf("unknown action: %s", action)
+	}
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err), nil
+	}
+	return formatShellResult(res), nil
+}
+
+func formatShellResult(res ShellResult) string {
+	var sb strings.Builder
+	if res.Output != "" {
+		sb.WriteString(res.Output)
+		sb.WriteString("\n")
+	}
+	switch {
+	case res.Exited:
+		sb.WriteString("The shell exited; the next command starts a new one.\n")
+	case res.Running:
+		sb.WriteString("The command is still running; use read, input or interrupt.\n")
+	case res.ExitCode != 0:
+		fmt.Fprintf(&sb, "Exit code: %d\n", res.ExitCode)
+	}
+	if res.Notice != "" {
+		sb.WriteString(res.Notice + "\n")
+	}
+	if sb.Len() == 0 {
+		return "(no output)"
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
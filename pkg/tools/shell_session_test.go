@@ -0,0 +1,250 @@
+//go:build !windows
+
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/sandbox"
+)
+
+func newTestShellManager(t *testing.T, workspace string, cfg config.ShellToolsConfig) *ShellSessionManager {
+	t.Helper()
+	m := NewShellSessionManager(NewExecTool(workspace, true), cfg)
+	t.Cleanup(m.Shutdown)
+	return m
+}
+
+func TestShellSessionKeepsState(t *testing.T) {
+	workspace := t.TempDir()
+	if err := os.Mkdir(filepath.Join(workspace, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	m := newTestShellManager(t, workspace, config.ShellToolsConfig{})
+	ctx := WithExecutionContext(context.Background(), ExecutionContext{SessionKey: "s1"})
+
+	run := func(ctx context.Context, command string) ShellResult {
+		t.Helper()
+		res, err := m.Run(ctx, command, 10*time.Second)
+		if err != nil {
+			t.Fatalf("Run(%q): %v", command, err)
+		}
+		return res
+	}
+
+	run(ctx, "cd sub && export GREETING=hello")
+	res := run(ctx, `echo "$GREETING from $(basename "$PWD")"`)
+	if res.Output != "hello from sub" || res.ExitCode != 0 || res.Dir != filepath.Join(workspace, "sub") {
+		t.Fatalf("expected state to persist, got %+v", res)
+	}
+
+	if res := run(ctx, "false"); res.ExitCode != 1 || res.Output != "" {
+		t.Fatalf("expected exit code 1 and no output, got %+v", res)
+	}
+	if res := run(ctx, "for i in 1 2; do\n  echo line $i\ndone"); res.Output != "line 1\nline 2" {
+		t.Fatalf("unexpected output for a multi-line command: %q", res.Output)
+	}
+
+	other := WithExecutionContext(context.Background(), ExecutionContext{SessionKey: "s2"})
+	if res := run(other, `echo "[$GREETING]"`); res.Output != "[]" || res.Dir != workspace {
+		t.Fatalf("expected another session to get its own shell, got %+v", res)
+	}
+
+	if res := run(ctx, "exit 4"); !res.Exited {
+		t.Fatalf("expected the shell to exit, got %+v", res)
+	}
+	if res := run(ctx, `echo "[$GREETING]"`); res.Output != "[]" {
+		t.Fatalf("expected a new shell after exit, got %+v", res)
+	}
+}
+
+func TestShellSessionsPerUser(t *testing.T) {
+	workspace := t.TempDir()
+	for _, dir := range []string{"alice", "bob"} {
+		if err := os.Mkdir(filepath.Join(workspace, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := newTestShellManager(t, workspace, config.ShellToolsConfig{})
+	alice := WithExecutionContext(context.Background(), ExecutionContext{SessionKey: "web:chat", UserID: 1, Workspace: filepath.Join(workspace, "alice")})
+	bob := WithExecutionContext(context.Background(), ExecutionContext{SessionKey: "web:chat", UserID: 2, Workspace: filepath.Join(workspace, "bob")})
+
+	if _, err := m.Run(alice, "export SECRET=alice; echo started; sleep 60", 300*time.Millisecond); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	res, err := m.Run(bob, `echo "[$SECRET] $PWD"`, 10*time.Second)
+	if err != nil {
+		t.Fatalf("expected bob to get his own idle shell, got %v", err)
+	}
+	if res.Output != "[] "+filepath.Join(workspace, "bob") {
+		t.Fatalf("expected a separate shell in bob's workspace, got %+v", res)
+	}
+	if _, err := m.Read(bob, time.Second); err == nil {
+		t.Fatal("expected bob not to read alice's running command")
+	}
+	if res, err := m.Interrupt(alice, 5*time.Second); err != nil || strings.Contains(res.Output, "bob") {
+		t.Fatalf("expected alice's output to stay her own, got %+v, %v", res, err)
+	}
+}
+
+func TestShellSessionGuard(t *testing.T) {
+	workspace := t.TempDir()
+	m := newTestShellManager(t, workspace, config.ShellToolsConfig{})
+	ctx := context.Background()
+
+	if _, err := m.Run(ctx, "rm -rf /", 10*time.Second); err == nil || !strings.Contains(err.Error(), "dangerous pattern") {
+		t.Fatalf("expected the exec guard to block the command, got %v", err)
+	}
+
+	// The path heuristics cannot follow a computed directory; the prompt
+	// reveals it afterwards and the session is moved back.
+	res, err := m.Run(ctx, `cd "$(dirname "$PWD")"`, 10*time.Second)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.Dir != workspace || !strings.Contains(res.Notice, "outside the workspace") {
+		t.Fatalf("expected the directory to be reset, got %+v", res)
+	}
+	if res, _ := m.Run(ctx, "pwd", 10*time.Second); res.Output != workspace {
+		t.Fatalf("expected the shell to be back in the workspace, got %q", res.Output)
+	}
+
+	if _, err := m.Run(ctx, "echo 'unterminated", 10*time.Second); err == nil || !strings.Contains(err.Error(), "incomplete") {
+		t.Fatalf("expected an incomplete command to be rejected, got %v", err)
+	}
+	if res, err := m.Run(ctx, "echo still usable", 10*time.Second); err != nil || res.Output != "still usable" {
+		t.Fatalf("expected the shell to recover, got %+v, %v", res, err)
+	}
+}
+
+func TestShellSessionLongRunningCommand(t *testing.T) {
+	m := newTestShellManager(t, t.TempDir(), config.ShellToolsConfig{})
+	ctx := context.Background()
+
+	res, err := m.Run(ctx, `read -r name; echo "hi $name"; sleep 60`, 300*time.Millisecond)
+	if err != nil || !res.Running {
+		t.Fatalf("expected the command to still be running, got %+v, %v", res, err)
+	}
+	if _, err := m.Run(ctx, "true", time.Second); err == nil {
+		t.Fatal("expected run to refuse while a command is running")
+	}
+
+	res, err = m.Input(ctx, "bob\n", 5*time.Second)
+	if err != nil || res.Output != "hi bob" || !res.Running {
+		t.Fatalf("unexpected output after input: %+v, %v", res, err)
+	}
+
+	res, err = m.Interrupt(ctx, 5*time.Second)
+	if err != nil || res.Running || res.ExitCode != 130 {
+		t.Fatalf("expected the interrupt to stop the command, got %+v, %v", res, err)
+	}
+	if _, err := m.Read(ctx, time.Second); err == nil {
+		t.Fatal("expected read to fail with nothing running")
+	}
+}
+
+func TestShellSessionLimits(t *testing.T) {
+	m := newTestShellManager(t, t.TempDir(), config.ShellToolsConfig{MaxSessions: 1})
+	a := WithExecutionContext(context.Background(), ExecutionContext{SessionKey: "a"})
+	b := WithExecutionContext(context.Background(), ExecutionContext{SessionKey: "b"})
+
+	if _, err := m.Run(a, "sleep 60", 200*time.Millisecond); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if _, err := m.Run(b, "true", 5*time.Second); err == nil || !strings.Contains(err.Error(), "too many") {
+		t.Fatalf("expected a busy session not to be evicted, got %v", err)
+	}
+	if _, err := m.Interrupt(a, 5*time.Second); err != nil {
+		t.Fatalf("Interrupt: %v", err)
+	}
+	if _, err := m.Run(b, "true", 5*time.Second); err != nil {
+		t.Fatalf("expected the idle session to be evicted, got %v", err)
+	}
+	if _, err := m.Read(a, time.Second); err == nil || !strings.Contains(err.Error(), "no shell session") {
+		t.Fatalf("expected session a to be gone, got %v", err)
+	}
+
+	m.closeIdle(0)
+	if _, err := m.Read(b, time.Second); err == nil || !strings.Contains(err.Error(), "no shell session") {
+		t.Fatalf("expected the idle session to be closed, got %v", err)
+	}
+}
+
+func TestShellToolOutputAndApproval(t *testing.T) {
+	tool := NewShellTool(newTestShellManager(t, t.TempDir(), config.ShellToolsConfig{CommandTimeoutSeconds: 10}))
+	ctx := context.Background()
+
+	out, err := tool.Execute(ctx, map[string]interface{}{"command": `printf '\033[1;31mred\033[0m\n'; printf 'ab\bc\n'; exit_code() { return 3; }; exit_code`})
+	if err != nil || out != "red\nac\nExit code: 3" {
+		t.Fatalf("unexpected output: %q, %v", out, err)
+	}
+	if out, _ := tool.Execute(ctx, map[string]interface{}{"action": "close"}); out != "Shell session closed" {
+		t.Fatalf("unexpected close output: %q", out)
+	}
+
+	for action, want := range map[string]bool{"": true, "run": true, "input": true, "read": false, "interrupt": false, "close": false} {
+		if got := tool.NeedsApproval(map[string]interface{}{"action": action}); got != want {
+			t.Errorf("NeedsApproval(%q) = %v, want %v", action, got, want)
+		}
+	}
+}
+
+func TestShellToolWaitsWithinRegistryLimits(t *testing.T) {
+	limits := config.DefaultConfig().Tools.Limits
+	for name, wait := range map[string]time.Duration{"shell": maxShellWait, "process": maxProcessWait} {
+		if timeout, _ := limitsFor(limits, name); timeout != 0 && timeout <= wait {
+			t.Errorf("default %s timeout %v does not cover its longest wait %v", name, timeout, wait)
+		}
+	}
+
+	// A tighter registry limit still gets the output back instead of a
+	// timeout.
+	m := newTestShellManager(t, t.TempDir(), config.ShellToolsConfig{})
+	r := NewToolRegistry()
+	r.SetLimits(config.ToolLimitsConfig{TimeoutSeconds: 3})
+	r.Register(NewShellTool(m))
+
+	out, err := r.Execute(context.Background(), "shell", map[string]interface{}{"command": "echo ready; sleep 60", "timeout_seconds": float64(600)})
+	if err != nil || !strings.HasPrefix(out, "ready\n") || !strings.Contains(out, "still running") {
+		t.Fatalf("expected the output so far, got %q, %v", out, err)
+	}
+	if _, err := m.Interrupt(context.Background(), 5*time.Second); err != nil {
+		t.Fatalf("Interrupt: %v", err)
+	}
+}
+
+func TestSanitizeTerminalOutput(t *testing.T) {
+	raw := "\x1b[?2004hloading 10%\rloading 100%\r\nab\bc\x07\r\n\x1b]0;title\x07done\x1b[0m\r\nMORE> more\r\n"
+	if got := sanitizeTerminalOutput([]byte(raw), []byte("MORE> ")); got != "loading 100%\nac\ndone\nmore" {
+		t.Fatalf("unexpected sanitized output: %q", got)
+	}
+}
+
+func TestShellSessionSandboxed(t *testing.T) {
+	if err := sandbox.Available(); err != nil {
+		t.Skipf("sandbox not available here: %v", err)
+	}
+	workspace := t.TempDir()
+	execTool := NewExecTool(workspace, true)
+	execTool.SetSandbox(config.SandboxConfig{Enabled: true, Required: true})
+	m := NewShellSessionManager(execTool, config.ShellToolsConfig{})
+	defer m.Shutdown()
+
+	ctx := context.Background()
+	if _, err := m.Run(ctx, "cd .. && export X=1", 10*time.Second); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	res, err := m.Run(ctx, `echo "$X"; ls`, 10*time.Second)
+	if err != nil || !strings.HasPrefix(res.Output, "1") {
+		t.Fatalf("expected the sandboxed shell to keep state, got %+v, %v", res, err)
+	}
+	if strings.Contains(res.Output, filepath.Base(workspace)) {
+		t.Fatalf("expected the sandbox to hide the workspace's parent, got %q", res.Output)
+	}
+}
@@ -0,0 +1,64 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/sandbox"
+)
+
+func TestExecToolSandbox(t *testing.T) {
+	if err := sandbox.Available(); err != nil {
+		t.Skipf("sandbox not available here: %v", err)
+	}
+	root := t.TempDir()
+	workspace := filepath.Join(root, "workspace")
+	outside := filepath.Join(root, "outside")
+	for _, dir := range []string{workspace, outside} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("top secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tool := NewExecTool(workspace, true)
+	tool.SetSandbox(config.SandboxConfig{Enabled: true, Required: true})
+	exec := func(args map[string]interface{}) string {
+		out, err := tool.Execute(context.Background(), args)
+		if err != nil {
+			t.Fatalf("Execute(%v): %v", args, err)
+		}
+		return out
+	}
+
+	if out := exec(map[string]interface{}{"command": "mkdir sub && echo ok > sub/f && cat sub/f"}); strings.TrimSpace(out) != "ok" {
+		t.Fatalf("expected the command to run in the workspace, got %q", out)
+	}
+	if out := exec(map[string]interface{}{"command": "pwd", "working_dir": "sub"}); strings.TrimSpace(out) != filepath.Join(workspace, "sub") {
+		t.Fatalf("expected a relative working_dir to resolve in the workspace, got %q", out)
+	}
+
+	// The path heuristics cannot follow a computed directory; the sandbox
+	// does not need to.
+	escape := `cd "$(dirname "$PWD")" && cd outside && cat secret.txt`
+	if guard := tool.guardCommand(escape, workspace, true); guard != "" {
+		t.Fatalf("expected the guard to miss %q, got %q", escape, guard)
+	}
+	out := exec(map[string]interface{}{"command": escape})
+	if strings.Contains(out, "top secret") {
+		t.Fatalf("sandboxed command read outside the workspace: %q", out)
+	}
+
+	if out := exec(map[string]interface{}{"command": "rm -rf sub"}); !strings.Contains(out, "dangerous pattern") {
+		t.Fatalf("expected deny patterns to still apply, got %q", out)
+	}
+	if out := exec(map[string]interface{}{"command": "pwd", "working_dir": outside}); !strings.Contains(out, "inside the workspace") {
+		t.Fatalf("expected a working_dir outside the workspace to be refused, got %q", out)
+	}
+}
@@ -7,6 +7,8 @@ import (
 
 type SpawnTool struct {
 	manager       *SubagentManager
+	agent         string
+	tools         *ToolRegistry
 	originChannel string
 	originChatID  string
 }
@@ -23,48 +25,129 @@ func (t *SpawnTool) Name() string {
 	return "spawn"
 }
 
+func (t *SpawnTool) Cacheable() bool {
+	return false
+}
+
 func (t *SpawnTool) Description() string {
-	return "Spawn a subagent to handle a task in the background. Use this for complex or time-consuming tasks that can run independently. The subagent will complete the task and report back when done."
+	return "Spawn a subagent to handle a task in the background. Use this for complex or time-consuming tasks that can run independently. The subagent works with its own tool loop, then reports back when done. Use action 'status' or 'cancel' with the subagent id to check on or stop it."
 }
 
 func (t *SpawnTool) Parameters() map[string]interface{} {
 	return map[string]interface{}{
 		"type": "object",
 		"properties": map[string]interface{}{
+			"action": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"spawn", "status", "cancel"},
+				"description": "What to do (default: spawn)",
+			},
 			"task": map[string]interface{}{
 				"type":        "string",
-				"description": "The task for subagent to complete",
+				"description": "The task for subagent to complete (for spawn)",
 			},
 			"label": map[string]interface{}{
 				"type":        "string",
 				"description": "Optional short label for the task (for display)",
 			},
+			"tools": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Optional names of the tools the subagent may use; defaults to all tools allowed for subagents",
+			},
+			"id": map[string]interface{}{
+				"type":        "string",
+				"description": "Subagent id (for status and cancel)",
+			},
 		},
-		"required": []string{"task"},
 	}
 }
 
+// SetAgent ties the tool to the named agent when agents with different tools
+// share one manager: subagents it spawns choose from registry instead of the
+// manager's tools and report their result back to that agent.
+func (t *SpawnTool) SetAgent(name string, registry *ToolRegistry) {
+	t.agent = name
+	t.tools = registry
+}
+
 func (t *SpawnTool) SetContext(channel, chatID string) {
 	t.originChannel = channel
 	t.originChatID = chatID
 }
 
 func (t *SpawnTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	if t.manager == nil {
+		return "Error: Subagent manager not configured", nil
+	}
+
+	action, _ := args["action"].(string)
+	switch action {
+	case "", "spawn":
+		return t.spawn(ctx, args)
+	case "status":
+		return t.status(ctx, args)
+	case "cancel":
+		id, _ := args["id"].(string)
+		if id == "" {
+			return "", fmt.Errorf("id is required")
+		}
+		ec, _ := ExecutionContextFrom(ctx)
+		if err := t.manager.Cancel(id, ec.UserID); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Cancelled subagent %s", id), nil
+	default:
+		return "", fmt.Errorf("unknown action: %s", action)
+	}
+}
+
+func (t *SpawnTool) spawn(ctx context.Context, args map[string]interface{}) (string, error) {
 	task, ok := args["task"].(string)
-	if !ok {
+	if !ok || task == "" {
 		return "", fmt.Errorf("task is required")
 	}
 
 	label, _ := args["label"].(string)
 
-	if t.manager == nil {
-		return "Error: Subagent manager not configured", nil
+	var toolNames []string
+	if list, ok := args["tools"].([]interface{}); ok {
+		for _, v := range list {
+			if name, ok := v.(string); ok && name != "" {
+				toolNames = append(toolNames, name)
+			}
+		}
 	}
 
-	result, err := t.manager.Spawn(ctx, task, label, t.originChannel, t.originChatID)
+	channel, chatID := channelFromContext(ctx, t.originChannel, t.originChatID)
+	registry := t.tools
+	if registry == nil {
+		registry = t.manager.tools
+	}
+	result, err := t.manager.spawn(ctx, registry, t.agent, task, label, toolNames, channel, chatID)
 	if err != nil {
 		return "", fmt.Errorf("failed to spawn subagent: %w", err)
 	}
 
 	return result, nil
 }
+
+func (t *SpawnTool) status(ctx context.Context, args map[string]interface{}) (string, error) {
+	id, _ := args["id"].(string)
+	if id == "" {
+		return "", fmt.Errorf("id is required")
+	}
+	ec, _ := ExecutionContextFrom(ctx)
+	run, err := t.manager.Run(id, ec.UserID)
+	if err != nil {
+		return "", err
+	}
+	if run == nil {
+		return "", fmt.Errorf("subagent %s not found", id)
+	}
+	out := fmt.Sprintf("Subagent %s: %s after %d iterations", run.ID, run.Status, run.Iterations)
+	if run.Result != "" {
+		out += "\n\nResult:\n" + run.Result
+	}
+	return out, nil
+}
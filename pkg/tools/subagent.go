@@ -2,78 +2,274 @@ package tools
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"path"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/sipeed/kakoclaw/pkg/bus"
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/logger"
+	"github.com/sipeed/kakoclaw/pkg/observability"
 	"github.com/sipeed/kakoclaw/pkg/providers"
+	"github.com/sipeed/kakoclaw/pkg/storage"
+)
+
+const (
+	defaultSubagentMaxIterations = 10
+	defaultSubagentMaxDepth      = 2
 )
 
 type SubagentTask struct {
 	ID            string
+	ParentID      string
+	Depth         int
 	Task          string
 	Label         string
+	Tools         []string
+	UserID        int64
+	SessionKey    string
 	OriginChannel string
 	OriginChatID  string
+	Agent         string // Agent whose spawn tool started the task, if known
 	Status        string
 	Result        string
+	Iterations    int
 	Created       int64
+	Finished      int64
+
+	cancel context.CancelFunc
+}
+
+func (t *SubagentTask) record() storage.SubagentRun {
+	run := storage.SubagentRun{
+		ID:            t.ID,
+		ParentID:      t.ParentID,
+		Depth:         t.Depth,
+		Label:         t.Label,
+		Task:          t.Task,
+		Tools:         t.Tools,
+		UserID:        t.UserID,
+		SessionKey:    t.SessionKey,
+		OriginChannel: t.OriginChannel,
+		OriginChatID:  t.OriginChatID,
+		Status:        t.Status,
+		Result:        t.Result,
+		Iterations:    t.Iterations,
+		CreatedAt:     time.UnixMilli(t.Created),
+	}
+	if t.Finished > 0 {
+		run.FinishedAt = time.UnixMilli(t.Finished)
+	}
+	return run
+}
+
+// ChatOptionsFunc returns the provider options for an LLM call to model on
+// behalf of channel.
+type ChatOptionsFunc func(model, channel string) map[string]interface{}
+
+// ToolGateFunc decides whether a subagent may run a tool call. It returns
+// false and the tool result to report when the call must not run.
+type ToolGateFunc func(ctx context.Context, call providers.ToolCall) (string, bool)
+
+// LLMCallFunc is told about every LLM call a subagent makes, so that it can be
+// metered like the main agent's calls. resp is nil when err is set.
+type LLMCallFunc func(ctx context.Context, model string, duration time.Duration, sent []providers.Message, toolDefs []providers.ToolDefinition, resp *providers.LLMResponse, err error)
+
+// LLMCheckFunc runs before every LLM call a subagent makes. It returns the
+// model to call, which may differ from the requested one, or an error when
+// the call must not be made.
+type LLMCheckFunc func(ctx context.Context, model string) (string, error)
+
+// SubagentStore persists subagent runs. It is implemented by storage.Storage.
+type SubagentStore interface {
+	SaveSubagentRun(r storage.SubagentRun) error
+	GetSubagentRun(id string) (*storage.SubagentRun, error)
+	ListSubagentRuns(userID int64, limit int) ([]storage.SubagentRun, error)
 }
 
+// subagentKey marks the context of tool calls made by a subagent.
+type subagentKey struct{}
+
+type subagentInfo struct {
+	id    string
+	depth int
+	tools []string
+}
+
+// SubagentManager runs subagents: tool-using LLM loops that work on a task in
+// the background and report back to the chat that spawned them. Subagents
+// spawned by another subagent run synchronously and their result is returned
+// to the caller as the tool result.
 type SubagentManager struct {
-	tasks     map[string]*SubagentTask
-	mu        sync.RWMutex
-	provider  providers.LLMProvider
-	bus       *bus.MessageBus
-	workspace string
-	nextID    int
+	tasks       map[string]*SubagentTask
+	mu          sync.RWMutex
+	provider    providers.LLMProvider
+	bus         *bus.MessageBus
+	workspace   string
+	tools       *ToolRegistry
+	cfg         config.SubagentsConfig
+	chatOptions ChatOptionsFunc
+	gate        ToolGateFunc
+	onLLMCall   LLMCallFunc
+	checkLLM    LLMCheckFunc
+	store       SubagentStore
 }
 
-func NewSubagentManager(provider providers.LLMProvider, workspace string, bus *bus.MessageBus) *SubagentManager {
+// NewSubagentManager creates a subagent manager. Subagents may use the tools
+// in registry that cfg allows.
+func NewSubagentManager(provider providers.LLMProvider, workspace string, bus *bus.MessageBus, registry *ToolRegistry, cfg config.SubagentsConfig) *SubagentManager {
+	if registry == nil {
+		registry = NewToolRegistry()
+	}
 	return &SubagentManager{
 		tasks:     make(map[string]*SubagentTask),
 		provider:  provider,
 		bus:       bus,
 		workspace: workspace,
-		nextID:    1,
+		tools:     registry,
+		cfg:       cfg,
 	}
 }
 
-func (sm *SubagentManager) Spawn(ctx context.Context, task, label, originChannel, originChatID string) (string, error) {
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
+// SetChatOptions sets how subagent LLM calls get their generation options.
+func (sm *SubagentManager) SetChatOptions(fn ChatOptionsFunc) {
+	sm.chatOptions = fn
+}
+
+// SetToolGate sets the check every subagent tool call must pass.
+func (sm *SubagentManager) SetToolGate(fn ToolGateFunc) {
+	sm.gate = fn
+}
+
+// SetLLMObserver sets the function told about subagent LLM calls.
+func (sm *SubagentManager) SetLLMObserver(fn LLMCallFunc) {
+	sm.onLLMCall = fn
+}
+
+// SetLLMCheck sets the check every subagent LLM call must pass.
+func (sm *SubagentManager) SetLLMCheck(fn LLMCheckFunc) {
+	sm.checkLLM = fn
+}
 
-	taskID := fmt.Sprintf("subagent-%d", sm.nextID)
-	sm.nextID++
+// SetStore enables persisting subagent runs. store may be nil.
+func (sm *SubagentManager) SetStore(store SubagentStore) {
+	sm.store = store
+}
+
+func (sm *SubagentManager) maxIterations() int {
+	if sm.cfg.MaxIterations > 0 {
+		return sm.cfg.MaxIterations
+	}
+	return defaultSubagentMaxIterations
+}
+
+func (sm *SubagentManager) maxDepth() int {
+	if sm.cfg.MaxDepth > 0 {
+		return sm.cfg.MaxDepth
+	}
+	return defaultSubagentMaxDepth
+}
+
+// Spawn starts a subagent for task. toolNames optionally narrows the tools
+// the subagent may use; it can never widen the configured set or, for nested
+// subagents, the parent's set.
+func (sm *SubagentManager) Spawn(ctx context.Context, task, label string, toolNames []string, originChannel, originChatID string) (string, error) {
+	return sm.spawn(ctx, sm.tools, "", task, label, toolNames, originChannel, originChatID)
+}
+
+// spawn is Spawn on behalf of the named agent, with the subagent's tools
+// drawn from tools instead of the manager's registry.
+func (sm *SubagentManager) spawn(ctx context.Context, tools *ToolRegistry, agent, task, label string, toolNames []string, originChannel, originChatID string) (string, error) {
+	parent, nested := ctx.Value(subagentKey{}).(subagentInfo)
+	depth := parent.depth + 1
+	if depth > sm.maxDepth() {
+		return "", fmt.Errorf("subagent depth limit (%d) reached", sm.maxDepth())
+	}
+
+	registry := tools.Filter(func(name string) bool {
+		if name == "spawn" && depth >= sm.maxDepth() {
+			return false
+		}
+		if len(sm.cfg.Tools) > 0 && !matchesToolPattern(name, sm.cfg.Tools) {
+			return false
+		}
+		if len(toolNames) > 0 && !matchesToolPattern(name, toolNames) {
+			return false
+		}
+		if nested && !matchesToolPattern(name, parent.tools) {
+			return false
+		}
+		return true
+	})
+	allowed := registry.List()
+	sort.Strings(allowed)
 
+	ec, _ := ExecutionContextFrom(ctx)
 	subagentTask := &SubagentTask{
-		ID:            taskID,
+		ID:            "subagent-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:8],
+		ParentID:      parent.id,
+		Depth:         depth,
 		Task:          task,
 		Label:         label,
+		Tools:         allowed,
+		UserID:        ec.UserID,
+		SessionKey:    ec.SessionKey,
 		OriginChannel: originChannel,
 		OriginChatID:  originChatID,
+		Agent:         agent,
 		Status:        "running",
 		Created:       time.Now().UnixMilli(),
 	}
-	sm.tasks[taskID] = subagentTask
 
-	go sm.runTask(ctx, subagentTask)
+	// A top-level subagent outlives the tool call that spawned it; a nested
+	// one is cancelled together with its parent.
+	runCtx := ctx
+	if !nested {
+		runCtx = context.WithoutCancel(ctx)
+	}
+	runCtx, cancel := context.WithCancel(runCtx)
+	runCtx = context.WithValue(runCtx, subagentKey{}, subagentInfo{id: subagentTask.ID, depth: depth, tools: allowed})
+	subagentTask.cancel = cancel
+
+	sm.mu.Lock()
+	sm.tasks[subagentTask.ID] = subagentTask
+	sm.persist(subagentTask)
+	sm.mu.Unlock()
+
+	logger.InfoCF("subagent", "Subagent spawned",
+		map[string]interface{}{
+			"id":    subagentTask.ID,
+			"depth": depth,
+			"tools": allowed,
+		})
+
+	if nested {
+		sm.runTask(runCtx, subagentTask, registry)
+		sm.mu.RLock()
+		defer sm.mu.RUnlock()
+		return fmt.Sprintf("Subagent %s %s.\n\nResult:\n%s", subagentTask.ID, subagentTask.Status, subagentTask.Result), nil
+	}
+
+	go sm.runTask(runCtx, subagentTask, registry)
 
 	if label != "" {
-		return fmt.Sprintf("Spawned subagent '%s' for task: %s", label, task), nil
+		return fmt.Sprintf("Spawned subagent '%s' (%s) for task: %s", label, subagentTask.ID, task), nil
 	}
-	return fmt.Sprintf("Spawned subagent for task: %s", task), nil
+	return fmt.Sprintf("Spawned subagent %s for task: %s", subagentTask.ID, task), nil
 }
 
-func (sm *SubagentManager) runTask(ctx context.Context, task *SubagentTask) {
-	task.Status = "running"
-	task.Created = time.Now().UnixMilli()
+func (sm *SubagentManager) runTask(ctx context.Context, task *SubagentTask, registry *ToolRegistry) {
+	defer task.cancel()
 
 	messages := []providers.Message{
 		{
 			Role:    "system",
-			Content: "You are a subagent. Complete the given task independently and report the result.",
+			Content: "You are a subagent. Complete the given task independently using the available tools and report the result.",
 		},
 		{
 			Role:    "user",
@@ -81,34 +277,169 @@ func (sm *SubagentManager) runTask(ctx context.Context, task *SubagentTask) {
 		},
 	}
 
-	response, err := sm.provider.Chat(ctx, messages, nil, sm.provider.GetDefaultModel(), map[string]interface{}{
-		"max_tokens": 4096,
-	})
+	model := sm.provider.GetDefaultModel()
+	options := map[string]interface{}{"max_tokens": 4096}
+	if sm.chatOptions != nil {
+		options = sm.chatOptions(model, task.OriginChannel)
+	}
+	toolDefs := toolDefinitions(registry)
 
-	sm.mu.Lock()
-	defer sm.mu.Unlock()
+	status, result := "failed", ""
+	iterations := 0
+	for {
+		if iterations >= sm.maxIterations() {
+			result = fmt.Sprintf("Stopped after %d iterations without a final answer.", iterations)
+			break
+		}
+		iterations++
 
-	if err != nil {
-		task.Status = "failed"
-		task.Result = fmt.Sprintf("Error: %v", err)
-	} else {
-		task.Status = "completed"
-		task.Result = response.Content
+		if sm.checkLLM != nil {
+			checked, err := sm.checkLLM(ctx, model)
+			if err != nil {
+				result = err.Error()
+				break
+			}
+			if checked != model {
+				model = checked
+				if sm.chatOptions != nil {
+					options = sm.chatOptions(model, task.OriginChannel)
+				}
+			}
+		}
+
+		llmStart := time.Now()
+		response, err := sm.provider.Chat(ctx, messages, toolDefs, model, options)
+		if sm.onLLMCall != nil {
+			sm.onLLMCall(ctx, model, time.Since(llmStart), messages, toolDefs, response, err)
+		}
+		if err != nil {
+			result = fmt.Sprintf("Error: %v", err)
+			break
+		}
+
+		if len(response.ToolCalls) == 0 {
+			status, result = "completed", response.Content
+			break
+		}
+
+		assistantMsg := providers.Message{
+			Role:      "assistant",
+			Content:   response.Content,
+			Reasoning: response.Reasoning,
+			Thinking:  response.Thinking,
+		}
+		for _, tc := range response.ToolCalls {
+			argumentsJSON, _ := json.Marshal(tc.Arguments)
+			assistantMsg.ToolCalls = append(assistantMsg.ToolCalls, providers.ToolCall{
+				ID:   tc.ID,
+				Type: "function",
+				Function: &providers.FunctionCall{
+					Name:      tc.Name,
+					Arguments: string(argumentsJSON),
+				},
+			})
+		}
+		messages = append(messages, assistantMsg)
+
+		for _, tc := range response.ToolCalls {
+			messages = append(messages, providers.Message{
+				Role:       "tool",
+				Content:    sm.executeTool(ctx, task, registry, tc),
+				ToolCallID: tc.ID,
+			})
+		}
+
+		sm.mu.Lock()
+		task.Iterations = iterations
+		sm.mu.Unlock()
+	}
+
+	if ctx.Err() != nil {
+		status, result = "cancelled", "Cancelled"
 	}
 
+	sm.mu.Lock()
+	task.Status = status
+	task.Result = result
+	task.Iterations = iterations
+	task.Finished = time.Now().UnixMilli()
+	sm.persist(task)
+	sm.mu.Unlock()
+
+	logger.InfoCF("subagent", "Subagent finished",
+		map[string]interface{}{
+			"id":         task.ID,
+			"status":     status,
+			"iterations": iterations,
+		})
+
 	// Send announce message back to main agent
-	if sm.bus != nil {
-		announceContent := fmt.Sprintf("Task '%s' completed.\n\nResult:\n%s", task.Label, task.Result)
+	if sm.bus != nil && task.ParentID == "" {
+		announceContent := fmt.Sprintf("Task '%s' %s.\n\nResult:\n%s", task.Label, status, result)
 		sm.bus.PublishInbound(bus.InboundMessage{
 			Channel:  "system",
 			SenderID: fmt.Sprintf("subagent:%s", task.ID),
 			// Format: "original_channel:original_chat_id" for routing back
 			ChatID:  fmt.Sprintf("%s:%s", task.OriginChannel, task.OriginChatID),
 			Content: announceContent,
+			// The agent that spawned the task gets the result
+			Metadata: map[string]string{"agent": task.Agent},
 		})
 	}
 }
 
+// executeTool runs one tool call for a subagent and returns the result to
+// hand back to the LLM.
+func (sm *SubagentManager) executeTool(ctx context.Context, task *SubagentTask, registry *ToolRegistry, tc providers.ToolCall) string {
+	name, args := tc.Name, tc.Arguments
+	if _, ok := registry.Get(name); !ok {
+		return fmt.Sprintf("Error: tool '%s' is not available to this subagent", name)
+	}
+	if sm.gate != nil {
+		if denied, ok := sm.gate(ctx, providers.ToolCall{ID: tc.ID, Name: name, Arguments: args}); !ok {
+			return denied
+		}
+	}
+
+	start := time.Now()
+	result, err := registry.ExecuteWithContext(ctx, name, args, task.OriginChannel, task.OriginChatID)
+	observability.Global().RecordToolCall(name, time.Since(start), err)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+	return result
+}
+
+// persist saves task to the store, if any. The caller must hold sm.mu.
+func (sm *SubagentManager) persist(task *SubagentTask) {
+	if sm.store == nil {
+		return
+	}
+	if err := sm.store.SaveSubagentRun(task.record()); err != nil {
+		logger.WarnCF("subagent", "Failed to persist subagent run",
+			map[string]interface{}{
+				"id":    task.ID,
+				"error": err.Error(),
+			})
+	}
+}
+
+// Cancel stops a running subagent. If userID is greater than zero, only that
+// user's subagents may be cancelled.
+func (sm *SubagentManager) Cancel(taskID string, userID int64) error {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	task, ok := sm.tasks[taskID]
+	if !ok || (userID > 0 && task.UserID != userID) {
+		return fmt.Errorf("subagent %s not found", taskID)
+	}
+	if task.Status != "running" {
+		return fmt.Errorf("subagent %s is not running (status: %s)", taskID, task.Status)
+	}
+	task.cancel()
+	return nil
+}
+
 func (sm *SubagentManager) GetTask(taskID string) (*SubagentTask, bool) {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
@@ -126,3 +457,81 @@ func (sm *SubagentManager) ListTasks() []*SubagentTask {
 	}
 	return tasks
 }
+
+// Run returns the record of a subagent run, from the store when there is one
+// so that runs from before a restart are included. If userID is greater than
+// zero, only that user's runs are visible.
+func (sm *SubagentManager) Run(taskID string, userID int64) (*storage.SubagentRun, error) {
+	var run *storage.SubagentRun
+	if task, ok := sm.GetTask(taskID); ok {
+		sm.mu.RLock()
+		r := task.record()
+		sm.mu.RUnlock()
+		run = &r
+	} else if sm.store != nil {
+		var err error
+		if run, err = sm.store.GetSubagentRun(taskID); err != nil {
+			return nil, err
+		}
+	}
+	if run == nil || (userID > 0 && run.UserID != userID) {
+		return nil, nil
+	}
+	return run, nil
+}
+
+// Runs returns the most recent subagent runs, newest first. If userID is
+// greater than zero, only that user's runs are returned.
+func (sm *SubagentManager) Runs(userID int64, limit int) ([]storage.SubagentRun, error) {
+	if sm.store != nil {
+		return sm.store.ListSubagentRuns(userID, limit)
+	}
+
+	sm.mu.RLock()
+	runs := make([]storage.SubagentRun, 0, len(sm.tasks))
+	for _, task := range sm.tasks {
+		if userID > 0 && task.UserID != userID {
+			continue
+		}
+		runs = append(runs, task.record())
+	}
+	sm.mu.RUnlock()
+
+	sort.Slice(runs, func(i, j int) bool { return runs[i].CreatedAt.After(runs[j].CreatedAt) })
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+// toolDefinitions returns the definitions of the tools in registry, sorted by
+// name so that repeated calls send an identical tool list.
+func toolDefinitions(registry *ToolRegistry) []providers.ToolDefinition {
+	var defs []providers.ToolDefinition
+	registry.ForEach(func(tool Tool) {
+		defs = append(defs, providers.ToolDefinition{
+			Type: "function",
+			Function: providers.ToolFunctionDefinition{
+				Name:        tool.Name(),
+				Description: tool.Description(),
+				Parameters:  tool.Parameters(),
+			},
+		})
+	})
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Function.Name < defs[j].Function.Name })
+	return defs
+}
+
+// matchesToolPattern reports whether name matches one of patterns, which may
+// be exact tool names or path.Match globs.
+func matchesToolPattern(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if p == name || p == "*" {
+			return true
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,228 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sipeed/kakoclaw/pkg/bus"
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/providers"
+)
+
+// scriptedProvider replays canned responses and records the tools offered on
+// each call. Once the script runs out it blocks until the context is done.
+type scriptedProvider struct {
+	mu        sync.Mutex
+	responses []*providers.LLMResponse
+	toolSets  [][]string
+	models    []string
+}
+
+func (p *scriptedProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, options map[string]interface{}) (*providers.LLMResponse, error) {
+	p.mu.Lock()
+	names := make([]string, 0, len(tools))
+	for _, td := range tools {
+		names = append(names, td.Function.Name)
+	}
+	p.toolSets = append(p.toolSets, names)
+	p.models = append(p.models, model)
+	if len(p.responses) > 0 {
+		resp := p.responses[0]
+		p.responses = p.responses[1:]
+		p.mu.Unlock()
+		return resp, nil
+	}
+	p.mu.Unlock()
+
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (p *scriptedProvider) GetDefaultModel() string { return "test-model" }
+
+type echoTool struct{ name string }
+
+func (t *echoTool) Name() string        { return t.name }
+func (t *echoTool) Description() string { return "echo" }
+func (t *echoTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{"type": "object"}
+}
+func (t *echoTool) Execute(ctx context.Context, args map[string]interface{}) (string, error) {
+	return "echo:" + t.name, nil
+}
+
+func waitForStatus(t *testing.T, sm *SubagentManager, id, want string) *SubagentTask {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		sm.mu.RLock()
+		task := sm.tasks[id]
+		status := task.Status
+		sm.mu.RUnlock()
+		if status == want {
+			return task
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("subagent %s did not reach status %q", id, want)
+	return nil
+}
+
+func onlyTask(t *testing.T, sm *SubagentManager) *SubagentTask {
+	t.Helper()
+	tasks := sm.ListTasks()
+	if len(tasks) != 1 {
+		t.Fatalf("expected 1 subagent, got %d", len(tasks))
+	}
+	return tasks[0]
+}
+
+func TestSubagentRunsToolLoop(t *testing.T) {
+	provider := &scriptedProvider{responses: []*providers.LLMResponse{
+		{ToolCalls: []providers.ToolCall{{ID: "c1", Name: "read_file", Arguments: map[string]interface{}{}}}},
+		{Content: "all done"},
+	}}
+	registry := NewToolRegistry()
+	registry.Register(&echoTool{name: "read_file"})
+	registry.Register(&echoTool{name: "exec"})
+
+	sm := NewSubagentManager(provider, t.TempDir(), nil, registry, config.SubagentsConfig{Tools: []string{"read_*"}})
+	if _, err := sm.Spawn(context.Background(), "look around", "", nil, "cli", "direct"); err != nil {
+		t.Fatalf("Spawn: %v", err)
+	}
+
+	task := waitForStatus(t, sm, onlyTask(t, sm).ID, "completed")
+	if task.Result != "all done" || task.Iterations != 2 {
+		t.Fatalf("unexpected task: %+v", task)
+	}
+	if len(provider.toolSets) != 2 || strings.Join(provider.toolSets[0], ",") != "read_file" {
+		t.Fatalf("expected only read_file to be offered, got %v", provider.toolSets)
+	}
+}
+
+func TestSubagentMaxIterations(t *testing.T) {
+	call := &providers.LLMResponse{ToolCalls: []providers.ToolCall{{ID: "c1", Name: "read_file", Arguments: map[string]interface{}{}}}}
+	provider := &scriptedProvider{responses: []*providers.LLMResponse{call, call, call}}
+	registry := NewToolRegistry()
+	registry.Register(&echoTool{name: "read_file"})
+
+	sm := NewSubagentManager(provider, t.TempDir(), nil, registry, config.SubagentsConfig{MaxIterations: 2})
+	if _, err := sm.Spawn(context.Background(), "loop", "", nil, "cli", "direct"); err != nil {
+		t.Fatalf("Spawn: %v", err)
+	}
+
+	task := waitForStatus(t, sm, onlyTask(t, sm).ID, "failed")
+	if task.Iterations != 2 || !strings.Contains(task.Result, "2 iterations") {
+		t.Fatalf("unexpected task: %+v", task)
+	}
+}
+
+func TestSpawnToolForAgent(t *testing.T) {
+	provider := &scriptedProvider{responses: []*providers.LLMResponse{{Content: "done"}}}
+	shared := NewToolRegistry()
+	shared.Register(&echoTool{name: "exec"})
+	own := NewToolRegistry()
+	own.Register(&echoTool{name: "read_file"})
+	msgBus := bus.NewMessageBus()
+
+	sm := NewSubagentManager(provider, t.TempDir(), msgBus, shared, config.SubagentsConfig{})
+	tool := NewSpawnTool(sm)
+	tool.SetAgent("coder", own)
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"task": "look around"}); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok || msg.Channel != "system" || msg.Metadata["agent"] != "coder" {
+		t.Fatalf("expected the result to be addressed to coder, got %+v", msg)
+	}
+	if task := onlyTask(t, sm); strings.Join(task.Tools, ",") != "read_file" {
+		t.Fatalf("expected the agent's own tools, got %v", task.Tools)
+	}
+}
+
+func TestSubagentLLMCheck(t *testing.T) {
+	call := &providers.LLMResponse{ToolCalls: []providers.ToolCall{{ID: "c1", Name: "read_file", Arguments: map[string]interface{}{}}}}
+	provider := &scriptedProvider{responses: []*providers.LLMResponse{call, call, call}}
+	registry := NewToolRegistry()
+	registry.Register(&echoTool{name: "read_file"})
+
+	sm := NewSubagentManager(provider, t.TempDir(), nil, registry, config.SubagentsConfig{})
+	checks := 0
+	sm.SetLLMCheck(func(ctx context.Context, model string) (string, error) {
+		checks++
+		switch checks {
+		case 1:
+			return model, nil
+		case 2:
+			return "cheap", nil
+		default:
+			return "", errors.New("budget reached")
+		}
+	})
+	if _, err := sm.Spawn(context.Background(), "loop", "", nil, "cli", "direct"); err != nil {
+		t.Fatalf("Spawn: %v", err)
+	}
+
+	task := waitForStatus(t, sm, onlyTask(t, sm).ID, "failed")
+	if task.Result != "budget reached" {
+		t.Fatalf("expected the check to stop the task, got %+v", task)
+	}
+	if strings.Join(provider.models, ",") != "test-model,cheap" {
+		t.Fatalf("expected the second call to use the downgraded model, got %v", provider.models)
+	}
+}
+
+func TestSubagentDepthLimit(t *testing.T) {
+	provider := &scriptedProvider{}
+	registry := NewToolRegistry()
+	sm := NewSubagentManager(provider, t.TempDir(), nil, registry, config.SubagentsConfig{MaxDepth: 1})
+	registry.Register(NewSpawnTool(sm))
+
+	ctx := context.WithValue(context.Background(), subagentKey{}, subagentInfo{id: "parent", depth: 1})
+	if _, err := sm.Spawn(ctx, "nested", "", nil, "cli", "direct"); err == nil || !strings.Contains(err.Error(), "depth limit") {
+		t.Fatalf("expected depth limit error, got %v", err)
+	}
+
+	// At the depth limit the spawn tool is withheld from the subagent.
+	if _, err := sm.Spawn(context.Background(), "top", "", nil, "cli", "direct"); err != nil {
+		t.Fatalf("Spawn: %v", err)
+	}
+	task := onlyTask(t, sm)
+	if len(task.Tools) != 0 {
+		t.Fatalf("expected no tools at max depth, got %v", task.Tools)
+	}
+	_ = sm.Cancel(task.ID, 0)
+	waitForStatus(t, sm, task.ID, "cancelled")
+}
+
+func TestSpawnToolCancel(t *testing.T) {
+	provider := &scriptedProvider{}
+	sm := NewSubagentManager(provider, t.TempDir(), nil, NewToolRegistry(), config.SubagentsConfig{})
+	tool := NewSpawnTool(sm)
+
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"task": "wait forever"}); err != nil {
+		t.Fatalf("spawn: %v", err)
+	}
+	id := onlyTask(t, sm).ID
+
+	out, err := tool.Execute(context.Background(), map[string]interface{}{"action": "cancel", "id": id})
+	if err != nil || !strings.Contains(out, id) {
+		t.Fatalf("cancel: %q, %v", out, err)
+	}
+	waitForStatus(t, sm, id, "cancelled")
+
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"action": "cancel", "id": id}); err == nil {
+		t.Fatal("expected error cancelling a finished subagent")
+	}
+	out, err = tool.Execute(context.Background(), map[string]interface{}{"action": "status", "id": id})
+	if err != nil || !strings.Contains(out, "cancelled") {
+		t.Fatalf("status: %q, %v", out, err)
+	}
+}
@@ -26,6 +26,7 @@ func NewTaskTool(s *storage.Storage) (*TaskTool, error) {
 }
 
 func (t *TaskTool) Name() string { return "task_manager" }
+func (t *TaskTool) Cacheable() bool { return false }
 
 func (t *TaskTool) Description() string {
 	return "Manage tasks: create, list, update_status, archive, unarchive. (ID is integer)"
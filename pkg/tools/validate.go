@@ -0,0 +1,47 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sipeed/kakoclaw/pkg/jsonschema"
+)
+
+// ArgumentError reports tool arguments that do not match the tool's
+// parameter schema. Its message tells the model what to fix.
+type ArgumentError struct {
+	Tool     string
+	Problems []string
+}
+
+func (e *ArgumentError) Error() string {
+	return fmt.Sprintf("invalid arguments for %s:\n- %s\nFix these arguments to match the tool's parameters and call it again.",
+		e.Tool, strings.Join(e.Problems, "\n- "))
+}
+
+// validateArgs checks args against the tool's Parameters() schema after
+// applying safe coercions such as "5" to 5. It returns the arguments to run
+// the tool with, in the form encoding/json decodes them, so tools see the
+// same types whether a call came from the model or from Go code.
+func validateArgs(tool Tool, args map[string]interface{}) (map[string]interface{}, error) {
+	schema := tool.Parameters()
+	if len(schema) == 0 {
+		return args, nil
+	}
+
+	data, err := json.Marshal(args)
+	if err != nil {
+		return args, nil
+	}
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil || normalized == nil {
+		normalized = map[string]interface{}{}
+	}
+
+	coerced, _ := jsonschema.Coerce(schema, normalized).(map[string]interface{})
+	if problems := jsonschema.Validate(schema, coerced); problems != nil {
+		return nil, &ArgumentError{Tool: tool.Name(), Problems: problems}
+	}
+	return coerced, nil
+}
@@ -148,6 +148,12 @@ export default {
     return response.data
   },
 
+  // Named agents (agents.list)
+  fetchAgents: async () => {
+    const response = await client.get('/agents')
+    return response.data
+  },
+
   // Voice transcription
   transcribeAudio: async (audioBlob) => {
     const formData = new FormData()
@@ -67,10 +67,11 @@ export default {
     return response.data
   },
 
-  updateSession: async (sessionId, { title, archived } = {}) => {
+  updateSession: async (sessionId, { title, archived, agent } = {}) => {
     const payload = {}
     if (title !== undefined) payload.title = title
     if (archived !== undefined) payload.archived = archived
+    if (agent !== undefined) payload.agent = agent
     const response = await client.patch(`/chat/sessions/${encodeURIComponent(sessionId)}`, payload)
     return response.data
   },
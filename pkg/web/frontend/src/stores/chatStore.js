@@ -18,6 +18,9 @@ export const useChatStore = defineStore('chat', () => {
   const webSearchEnabled = ref(true)  // Whether web_search tool is available to the LLM (legacy/shortcut)
   const availableTools = ref([])      // All tools available from backend
   const enabledTools = ref([])        // Tools currently enabled by user
+  const pendingApprovals = ref([])    // Tool calls waiting for the user's decision
+  const availableAgents = ref([])     // Agents from /api/v1/agents
+  const selectedAgent = ref('')       // Agent for the current session (empty = session's agent or routes)
 
   function addMessage(message) {
     messages.value.push({
@@ -51,6 +54,15 @@ export const useChatStore = defineStore('chat', () => {
     }
   }
 
+  // Append reasoning (thinking) text to the currently streaming message
+  function appendReasoningToken(token) {
+    if (!streamingMessageId.value) return
+    const msg = messages.value.find(m => m.id === streamingMessageId.value)
+    if (msg) {
+      msg.reasoning = (msg.reasoning || '') + token
+    }
+  }
+
   // Finalize the streaming message (set final content, mark as not streaming)
   function endStreamingMessage(finalContent) {
     if (streamingMessageId.value) {
@@ -73,8 +85,12 @@ export const useChatStore = defineStore('chat', () => {
     if (msg) {
       if (!msg.toolCalls) msg.toolCalls = []
       
-      // Try to find an open tool call with the same name to update it
-      const existingIdx = msg.toolCalls.findLastIndex(tc => tc.name === toolCall.name && tc.status === 'started')
+      // Tool calls may run in parallel, so match the open call by its ID
+      // (falling back to the name for servers that do not send one)
+      const existingIdx = msg.toolCalls.findLastIndex(tc =>
+        (tc.status === 'started' || tc.status === 'awaiting_approval') &&
+        (toolCall.call_id ? tc.call_id === toolCall.call_id : tc.name === toolCall.name)
+      )
       if (existingIdx !== -1 && toolCall.status !== 'started') {
         msg.toolCalls[existingIdx] = { ...msg.toolCalls[existingIdx], ...toolCall }
       } else {
@@ -87,6 +103,26 @@ export const useChatStore = defineStore('chat', () => {
     }
   }
 
+  // Track a steering message: 'queued' until the agent injects it into the
+  // running turn ('applied'), or 'requeued' when it will run as its own turn
+  function updateSteer(content, status) {
+    const msg = [...messages.value].reverse().find(m =>
+      m.role === 'user' && m.steer && m.steer !== 'applied' && m.content === content
+    )
+    if (msg) {
+      msg.steer = status
+    }
+  }
+
+  function addApproval(request) {
+    if (pendingApprovals.value.some(a => a.id === request.id)) return
+    pendingApprovals.value.push(request)
+  }
+
+  function removeApproval(id) {
+    pendingApprovals.value = pendingApprovals.value.filter(a => a.id !== id)
+  }
+
   function setMessages(newMessages) {
     messages.value = newMessages
   }
@@ -125,6 +161,7 @@ export const useChatStore = defineStore('chat', () => {
         content,
         session_id: sessionId || 'web:chat:' + Date.now().toString(36),
         model: selectedModel.value || undefined,
+        agent: selectedAgent.value || undefined,
         exclude_tools: availableTools.value.filter(tool => !enabledTools.value.includes(tool))
       })
       return true
@@ -155,6 +192,17 @@ export const useChatStore = defineStore('chat', () => {
     selectedModel.value = model
   }
 
+  function setAgents(agents) {
+    availableAgents.value = Array.isArray(agents) ? agents : []
+    if (selectedAgent.value && !availableAgents.value.some(agent => agent.name === selectedAgent.value)) {
+      selectedAgent.value = ''
+    }
+  }
+
+  function setSelectedAgent(agent) {
+    selectedAgent.value = agent || ''
+  }
+
   function setWebSearchEnabled(enabled) {
     webSearchEnabled.value = enabled
     // Sync with tools list
@@ -241,10 +289,15 @@ export const useChatStore = defineStore('chat', () => {
     allModels,
     webSearchEnabled,
     addMessage,
+    updateSteer,
     startStreamingMessage,
     appendStreamToken,
+    appendReasoningToken,
     endStreamingMessage,
     addToolCall,
+    pendingApprovals,
+    addApproval,
+    removeApproval,
     setMessages,
     clearMessages,
     sendMessage,
@@ -257,6 +310,10 @@ export const useChatStore = defineStore('chat', () => {
     flushPendingMessages,
     setWebSocket,
     setSelectedModel,
+    availableAgents,
+    selectedAgent,
+    setAgents,
+    setSelectedAgent,
     setWebSearchEnabled,
     setAvailableTools: setTools,
     toggleTool,
@@ -0,0 +1,140 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/sipeed/kakoclaw/pkg/approval"
+	"github.com/sipeed/kakoclaw/pkg/storage"
+)
+
+// wsApprovalPrompter delivers tool approval requests over a /ws/chat
+// connection. The client answers with an "approval_response" message.
+type wsApprovalPrompter struct {
+	conn *websocket.Conn
+	mu   *sync.Mutex
+}
+
+func (p *wsApprovalPrompter) Prompt(req approval.Request) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conn.WriteJSON(map[string]interface{}{
+		"type":       "approval_request",
+		"id":         req.ID,
+		"tool":       req.Tool,
+		"args":       req.Args,
+		"expires_at": req.ExpiresAt,
+		"options":    approval.Options(req.ID),
+	})
+}
+
+func (p *wsApprovalPrompter) Resolved(req approval.Request, decision approval.Decision) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_ = p.conn.WriteJSON(map[string]interface{}{
+		"type":     "approval_resolved",
+		"id":       req.ID,
+		"tool":     req.Tool,
+		"decision": string(decision),
+	})
+}
+
+// handleApprovals handles GET /api/v1/approvals: pending requests and recent decisions.
+func (s *Server) handleApprovals(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.agentLoop == nil {
+		writeJSONError(w, "agent loop unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	userID, _ := s.getUserIDFromClaims(r)
+	if s.isAdminRequest(r) {
+		userID = 0
+	}
+
+	history := []storage.ToolApproval{}
+	if s.store != nil {
+		records, err := s.store.ListToolApprovals(userID, 100)
+		if err != nil {
+			writeJSONError(w, "failed to list approvals: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if records != nil {
+			history = records
+		}
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": s.agentLoop.Approvals().Enabled(),
+		"pending": s.agentLoop.Approvals().Pending(userID),
+		"history": history,
+	})
+}
+
+// handleApprovalAction handles POST /api/v1/approvals/{id} with {"decision": "approve_once"|"approve_session"|"deny"}.
+func (s *Server) handleApprovalAction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		writeJSONError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.agentLoop == nil {
+		writeJSONError(w, "agent loop unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/approvals/")
+	if id == "" {
+		writeJSONError(w, "approval id required", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Decision string `json:"decision"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := s.getUserIDFromClaims(r)
+	if !s.canResolveApproval(r, userID, id) {
+		writeJSONError(w, "approval not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.agentLoop.Approvals().Resolve(id, approval.Decision(body.Decision), fmt.Sprintf("web:%d", userID)); err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "decision": body.Decision})
+}
+
+// canResolveApproval reports whether the request may answer approval id:
+// admins may answer any request, other users only their own pending ones.
+func (s *Server) canResolveApproval(r *http.Request, userID int64, id string) bool {
+	if s.isAdminRequest(r) {
+		return true
+	}
+	if userID <= 0 {
+		return false
+	}
+	for _, req := range s.agentLoop.Approvals().Pending(userID) {
+		if req.ID == id {
+			return true
+		}
+	}
+	return false
+}
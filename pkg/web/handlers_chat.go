@@ -0,0 +1,168 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sipeed/kakoclaw/pkg/agent"
+	"github.com/sipeed/kakoclaw/pkg/logger"
+)
+
+// handleChat handles POST /api/v1/chat: runs one chat turn and returns the
+// answer. With response_schema set the answer is JSON matching that schema,
+// returned parsed in "json".
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		writeJSONError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.agentLoop == nil {
+		writeJSONError(w, "agent loop unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		Content        string                 `json:"content"`
+		SessionID      string                 `json:"session_id"`
+		Model          string                 `json:"model"`
+		ExcludeTools   []string               `json:"exclude_tools"`
+		ResponseSchema map[string]interface{} `json:"response_schema"`
+		Agent          string                 `json:"agent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	input := strings.TrimSpace(req.Content)
+	if input == "" {
+		writeJSONError(w, "content is required", http.StatusBadRequest)
+		return
+	}
+	userID, ok := s.getUserIDFromClaims(r)
+	if !ok {
+		writeJSONError(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sessionID := strings.TrimSpace(req.SessionID)
+	if sessionID == "" {
+		sessionID = fmt.Sprintf("web:%d:chat", userID)
+	}
+	target, err := s.sessionAgent(userID, sessionID, strings.TrimSpace(req.Agent))
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Track the run so /api/v1/chat/cancel can stop it.
+	ctx, cancel := context.WithCancel(r.Context())
+	ctx = s.withPolicyCaller(ctx, r, userID, sessionID)
+	execID := fmt.Sprintf("%s:%d", sessionID, time.Now().UnixNano())
+	s.execMu.Lock()
+	s.activeExecs[execID] = &activeExecution{
+		SessionID: sessionID,
+		StartedAt: time.Now(),
+		Cancel:    cancel,
+	}
+	s.execMu.Unlock()
+	defer func() {
+		s.execMu.Lock()
+		delete(s.activeExecs, execID)
+		s.execMu.Unlock()
+		cancel()
+	}()
+
+	if req.ResponseSchema == nil {
+		response, err := target.ProcessDirectWithModel(ctx, userID, input, sessionID, req.Model, req.ExcludeTools...)
+		if err != nil {
+			writeJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"session_id": sessionID,
+			"content":    response,
+		})
+		return
+	}
+
+	data, err := target.ProcessDirectJSON(ctx, userID, input, sessionID, req.Model, req.ResponseSchema, req.ExcludeTools...)
+	if err != nil {
+		var invalid *agent.StructuredOutputError
+		if errors.As(err, &invalid) {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":    "response does not match the schema",
+				"problems": invalid.Problems,
+				"content":  invalid.Content,
+			})
+			return
+		}
+		writeJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"session_id": sessionID,
+		"content":    string(data),
+		"json":       data,
+	})
+}
+
+// sessionAgent returns the agent a chat session talks to. A requested agent
+// is remembered for the session; otherwise the session's stored agent is
+// used, then agents.routes for the web channel.
+func (s *Server) sessionAgent(userID int64, sessionID, requested string) (*agent.AgentLoop, error) {
+	if requested != "" {
+		target := s.agentLoop.Agent(requested)
+		if target == nil {
+			return nil, fmt.Errorf("unknown agent %q", requested)
+		}
+		if s.store != nil {
+			if err := s.store.SetSessionAgentForUser(userID, sessionID, requested); err != nil {
+				logger.WarnCF("web", "Failed to save session agent", map[string]interface{}{
+					"session_id": sessionID,
+					"error":      err.Error(),
+				})
+			}
+		}
+		return target, nil
+	}
+	if s.store != nil {
+		if sess, err := s.store.GetSessionForUser(userID, sessionID); err == nil && sess.Agent != "" {
+			if target := s.agentLoop.Agent(sess.Agent); target != nil {
+				return target, nil
+			}
+		}
+	}
+	return s.agentLoop.AgentFor("web", sessionID, "", userID), nil
+}
+
+// ownsSession reports whether sessionID is a stored session of userID. A
+// running turn has always saved its session, so this guards access to it.
+func (s *Server) ownsSession(userID int64, sessionID string) bool {
+	if s.store == nil {
+		return false
+	}
+	_, err := s.store.GetSessionForUser(userID, sessionID)
+	return err == nil
+}
+
+// handleAgents handles GET /api/v1/agents: the agents a chat can talk to.
+func (s *Server) handleAgents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.agentLoop == nil {
+		writeJSONError(w, "agent loop unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"agents": s.agentLoop.Agents()})
+}
@@ -0,0 +1,70 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/policy"
+)
+
+// withPolicyCaller marks the tool calls made with ctx as coming from the
+// signed-in web user, so the tool policy applies their role and not that of
+// the agent's default user.
+func (s *Server) withPolicyCaller(ctx context.Context, r *http.Request, userID int64, sessionID string) context.Context {
+	role := "user"
+	if claims, ok := r.Context().Value(userClaimsKey).(*jwtClaims); ok && claims != nil && claims.Role != "" {
+		role = claims.Role
+	}
+	return policy.WithCaller(ctx, policy.Caller{UserID: userID, Role: role, Channel: "web", ChatID: sessionID})
+}
+
+// handleToolPolicy handles /api/v1/tools/policy (admin only): GET returns
+// the active tool policy, PUT replaces it and DELETE goes back to the policy
+// in the config file.
+func (s *Server) handleToolPolicy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !s.isAdminRequest(r) {
+		writeJSONError(w, "forbidden: admin role required", http.StatusForbidden)
+		return
+	}
+	if s.agentLoop == nil {
+		writeJSONError(w, "agent loop unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	manager := s.agentLoop.ToolPolicy()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var in config.ToolPolicyConfig
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeJSONError(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := in.Validate(); err != nil {
+			writeJSONError(w, "invalid policy: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := manager.SetPolicy(in); err != nil {
+			writeJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case http.MethodDelete:
+		if err := manager.Reset(); err != nil {
+			writeJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		writeJSONError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	current, saved := manager.Policy()
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"policy": current,
+		"saved":  saved,
+	})
+}
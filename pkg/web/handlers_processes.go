@@ -0,0 +1,108 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sipeed/kakoclaw/pkg/tools"
+)
+
+// processScope returns the background processes the request may see: all of
+// them for admins, otherwise the user's own.
+func (s *Server) processScope(r *http.Request) (tools.ProcessScope, bool) {
+	if s.isAdminRequest(r) {
+		return tools.ProcessScope{}, true
+	}
+	userID, ok := s.getUserIDFromClaims(r)
+	if !ok || userID <= 0 {
+		return tools.ProcessScope{}, false
+	}
+	return tools.ProcessScope{UserID: userID}, true
+}
+
+// handleProcesses handles GET /api/v1/processes: background processes started
+// by the process tool.
+func (s *Server) handleProcesses(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.agentLoop == nil {
+		writeJSONError(w, "agent loop unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	scope, ok := s.processScope(r)
+	if !ok {
+		writeJSONError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"processes": s.agentLoop.Processes().List(scope)})
+}
+
+// handleProcessAction handles GET and DELETE /api/v1/processes/{id},
+// GET /api/v1/processes/{id}/output and POST /api/v1/processes/{id}/kill.
+func (s *Server) handleProcessAction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if s.agentLoop == nil {
+		writeJSONError(w, "agent loop unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/processes/")
+	id, action, _ := strings.Cut(id, "/")
+	if id == "" {
+		writeJSONError(w, "process id required", http.StatusBadRequest)
+		return
+	}
+	scope, ok := s.processScope(r)
+	if !ok {
+		writeJSONError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	manager := s.agentLoop.Processes()
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		info, err := manager.Get(id, scope)
+		if err != nil {
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"process": info})
+	case action == "" && r.Method == http.MethodDelete:
+		if err := manager.Remove(id, scope); err != nil {
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "status": "removed"})
+	case action == "output" && r.Method == http.MethodGet:
+		q := r.URL.Query()
+		stdoutOffset, _ := strconv.ParseInt(q.Get("stdout_offset"), 10, 64)
+		stderrOffset, _ := strconv.ParseInt(q.Get("stderr_offset"), 10, 64)
+		maxBytes, _ := strconv.Atoi(q.Get("max_bytes"))
+		out, err := manager.Read(r.Context(), id, scope, stdoutOffset, stderrOffset, maxBytes, 0)
+		if err != nil {
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	case action == "kill" && r.Method == http.MethodPost:
+		if _, err := manager.Get(id, scope); err != nil {
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err := manager.Kill(id, scope); err != nil {
+			writeJSONError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "status": "killed"})
+	default:
+		writeJSONError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,101 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/sipeed/kakoclaw/pkg/storage"
+)
+
+// subagentScope returns the user whose subagent runs the request may see: 0
+// (every user) for admins, otherwise the caller. Callers without a resolvable
+// user get nothing, since 0 would widen their view to every user's runs.
+func (s *Server) subagentScope(r *http.Request) (int64, bool) {
+	if s.isAdminRequest(r) {
+		return 0, true
+	}
+	userID, ok := s.getUserIDFromClaims(r)
+	if !ok || userID <= 0 {
+		return 0, false
+	}
+	return userID, true
+}
+
+// handleSubagents handles GET /api/v1/subagents: recent subagent runs.
+func (s *Server) handleSubagents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		writeJSONError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.agentLoop == nil {
+		writeJSONError(w, "agent loop unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	userID, ok := s.subagentScope(r)
+	if !ok {
+		writeJSONError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	runs, err := s.agentLoop.Subagents().Runs(userID, 100)
+	if err != nil {
+		writeJSONError(w, "failed to list subagents: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if runs == nil {
+		runs = []storage.SubagentRun{}
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"subagents": runs})
+}
+
+// handleSubagentAction handles GET /api/v1/subagents/{id} and
+// POST /api/v1/subagents/{id}/cancel.
+func (s *Server) handleSubagentAction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if s.agentLoop == nil {
+		writeJSONError(w, "agent loop unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/subagents/")
+	id, action, _ := strings.Cut(id, "/")
+	if id == "" {
+		writeJSONError(w, "subagent id required", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := s.subagentScope(r)
+	if !ok {
+		writeJSONError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	manager := s.agentLoop.Subagents()
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		run, err := manager.Run(id, userID)
+		if err != nil {
+			writeJSONError(w, "failed to get subagent: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if run == nil {
+			writeJSONError(w, "subagent not found", http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"subagent": run})
+	case action == "cancel" && r.Method == http.MethodPost:
+		if err := manager.Cancel(id, userID); err != nil {
+			writeJSONError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "status": "cancelling"})
+	default:
+		writeJSONError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
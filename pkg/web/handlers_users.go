@@ -87,6 +87,11 @@ func (s *Server) handleUserAction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(pathParts) > 1 && pathParts[1] == "budget" {
+		s.handleUserBudget(w, r, userID)
+		return
+	}
+
 	if r.Method == http.MethodPut {
 		var in struct {
 			Password string `json:"password,omitempty"`
@@ -155,3 +160,54 @@ func (s *Server) handleUserAction(w http.ResponseWriter, r *http.Request) {
 
 	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 }
+
+// handleUserBudget reads or replaces a user's spending caps.
+// GET/PUT /api/v1/users/{id}/budget
+func (s *Server) handleUserBudget(w http.ResponseWriter, r *http.Request, userID int64) {
+	switch r.Method {
+	case http.MethodGet:
+		budget, err := s.store.GetUserBudget(userID)
+		if err == storage.ErrUserNotFound {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(budget)
+
+	case http.MethodPut:
+		var in storage.UserBudget
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		for _, v := range []*float64{in.DailyUSD, in.MonthlyUSD} {
+			if v != nil && *v < 0 {
+				http.Error(w, "budget limits must not be negative", http.StatusBadRequest)
+				return
+			}
+		}
+		for _, v := range []*int64{in.DailyTokens, in.MonthlyTokens} {
+			if v != nil && *v < 0 {
+				http.Error(w, "budget limits must not be negative", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := s.store.SetUserBudget(userID, in); err != nil {
+			if err == storage.ErrUserNotFound {
+				http.Error(w, "user not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(in)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
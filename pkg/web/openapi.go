@@ -95,6 +95,7 @@ const openAPISpec = `{
         "properties": {
           "role": { "type": "string", "enum": ["user", "assistant", "system"] },
           "content": { "type": "string" },
+          "agent": { "type": "string", "description": "Named agent that wrote the message; omitted for the default agent" },
           "timestamp": { "type": "string", "format": "date-time" }
         }
       },
@@ -102,11 +103,34 @@ const openAPISpec = `{
         "type": "object",
         "properties": {
           "session_id": { "type": "string" },
+          "title": { "type": "string" },
+          "archived": { "type": "boolean" },
+          "agent": { "type": "string", "description": "Named agent the session talks to; empty for the default agent" },
           "last_message": { "type": "string" },
           "message_count": { "type": "integer" },
           "updated_at": { "type": "string", "format": "date-time" }
         }
       },
+      "GenerationParams": {
+        "type": "object",
+        "properties": {
+          "max_tokens": { "type": "integer" },
+          "temperature": { "type": "number", "minimum": 0, "maximum": 2 },
+          "top_p": { "type": "number", "minimum": 0, "maximum": 1 },
+          "stop": { "type": "array", "items": { "type": "string" }, "maxItems": 4 },
+          "reasoning_effort": { "type": "string", "enum": ["minimal", "low", "medium", "high"] },
+          "seed": { "type": "integer" },
+          "thinking_budget": { "type": "integer", "minimum": 1024, "description": "Claude extended thinking budget in tokens" }
+        }
+      },
+      "SessionGeneration": {
+        "type": "object",
+        "properties": {
+          "session_id": { "type": "string" },
+          "overrides": { "$ref": "#/components/schemas/GenerationParams" },
+          "effective": { "$ref": "#/components/schemas/GenerationParams" }
+        }
+      },
       "CronJob": {
         "type": "object",
         "properties": {
@@ -121,6 +145,117 @@ const openAPISpec = `{
           "next_run": { "type": "string", "format": "date-time" }
         }
       },
+      "ChatRequest": {
+        "type": "object",
+        "required": ["content"],
+        "properties": {
+          "content": { "type": "string" },
+          "session_id": { "type": "string", "example": "web:1:chat", "description": "Defaults to web:<user id>:chat" },
+          "model": { "type": "string", "description": "Model override for this turn" },
+          "exclude_tools": { "type": "array", "items": { "type": "string" } },
+          "response_schema": { "type": "object", "description": "JSON Schema the answer must follow" },
+          "agent": { "type": "string", "description": "Named agent to talk to; remembered for the session. Defaults to the session's agent, then agents.routes" }
+        }
+      },
+      "Agent": {
+        "type": "object",
+        "properties": {
+          "name": { "type": "string", "example": "coder" },
+          "description": { "type": "string" },
+          "model": { "type": "string" },
+          "default": { "type": "boolean", "description": "True for the agent built from agents.defaults" }
+        }
+      },
+      "ChatResponse": {
+        "type": "object",
+        "properties": {
+          "session_id": { "type": "string" },
+          "content": { "type": "string" },
+          "json": { "description": "The validated answer, present when response_schema was given" }
+        }
+      },
+      "SubagentRun": {
+        "type": "object",
+        "properties": {
+          "id": { "type": "string" },
+          "parent_id": { "type": "string" },
+          "depth": { "type": "integer" },
+          "label": { "type": "string" },
+          "task": { "type": "string" },
+          "tools": { "type": "array", "items": { "type": "string" } },
+          "user_id": { "type": "integer" },
+          "session_key": { "type": "string" },
+          "origin_channel": { "type": "string" },
+          "origin_chat_id": { "type": "string" },
+          "status": { "type": "string", "enum": ["running", "completed", "failed", "cancelled"] },
+          "result": { "type": "string" },
+          "iterations": { "type": "integer" },
+          "created_at": { "type": "string", "format": "date-time" },
+          "finished_at": { "type": "string", "format": "date-time" }
+        }
+      },
+      "BackgroundProcess": {
+        "type": "object",
+        "properties": {
+          "id": { "type": "string" },
+          "command": { "type": "string" },
+          "dir": { "type": "string" },
+          "pid": { "type": "integer" },
+          "status": { "type": "string", "enum": ["running", "exited", "killed", "timed_out", "failed"] },
+          "exit_code": { "type": "integer" },
+          "error": { "type": "string" },
+          "user_id": { "type": "integer" },
+          "session_key": { "type": "string" },
+          "channel": { "type": "string" },
+          "chat_id": { "type": "string" },
+          "stdout_bytes": { "type": "integer", "description": "Bytes written to stdout so far" },
+          "stderr_bytes": { "type": "integer", "description": "Bytes written to stderr so far" },
+          "started_at": { "type": "string", "format": "date-time" },
+          "finished_at": { "type": "string", "format": "date-time" }
+        }
+      },
+      "ProcessChunk": {
+        "type": "object",
+        "description": "Output of one stream. Offsets count every byte the stream produced; pass next_offset to the next read.",
+        "properties": {
+          "data": { "type": "string" },
+          "offset": { "type": "integer" },
+          "next_offset": { "type": "integer" },
+          "dropped": { "type": "integer", "description": "Bytes after the requested offset that are no longer kept" }
+        }
+      },
+      "ToolPolicy": {
+        "type": "object",
+        "description": "Rules are checked in order; the first rule matching the caller and the tool decides, and calls no rule matches get the default.",
+        "properties": {
+          "enabled": { "type": "boolean" },
+          "default": { "type": "string", "enum": ["allow", "deny"] },
+          "rules": { "type": "array", "items": { "$ref": "#/components/schemas/ToolPolicyRule" } }
+        }
+      },
+      "ToolPolicyRule": {
+        "type": "object",
+        "required": ["effect", "tools"],
+        "properties": {
+          "effect": { "type": "string", "enum": ["allow", "deny"] },
+          "tools": { "type": "array", "items": { "type": "string" }, "description": "Tool names or globs" },
+          "users": { "type": "array", "items": { "type": "integer" } },
+          "roles": { "type": "array", "items": { "type": "string" }, "description": "admin, user or guest (senders without an account)" },
+          "channels": { "type": "array", "items": { "type": "string" }, "description": "Channel names; web UI requests use web" },
+          "chats": { "type": "array", "items": { "type": "string" }, "description": "Chat IDs or globs" },
+          "args": {
+            "type": "object",
+            "description": "Allow rules only: constraints keyed by argument name",
+            "additionalProperties": {
+              "type": "object",
+              "properties": {
+                "path_prefixes": { "type": "array", "items": { "type": "string" } },
+                "hosts": { "type": "array", "items": { "type": "string" } }
+              }
+            }
+          }
+        }
+      },
       "Skill": {
         "type": "object",
         "properties": {
@@ -380,6 +515,32 @@ const openAPISpec = `{
         }
       }
     },
+    "/api/v1/chat": {
+      "post": {
+        "tags": ["Chat"],
+        "summary": "Run one chat turn",
+        "description": "Sends a message to the agent and waits for the answer. With response_schema set, the answer is JSON matching that schema; providers with a structured output mode are asked to use it, otherwise the answer is validated and repaired up to agents.defaults.structured_output_retries times.",
+        "requestBody": {
+          "required": true,
+          "content": { "application/json": { "schema": { "$ref": "#/components/schemas/ChatRequest" } } }
+        },
+        "responses": {
+          "200": { "description": "The answer", "content": { "application/json": { "schema": { "$ref": "#/components/schemas/ChatResponse" } } } },
+          "400": { "description": "Missing content, invalid body or unknown agent" },
+          "422": { "description": "The answer did not match response_schema", "content": { "application/json": { "schema": { "type": "object", "properties": { "error": { "type": "string" }, "problems": { "type": "array", "items": { "type": "string" } }, "content": { "type": "string" } } } } } }
+        }
+      }
+    },
+    "/api/v1/agents": {
+      "get": {
+        "tags": ["Chat"],
+        "summary": "List the agents a chat can talk to",
+        "description": "The default agent followed by the named agents in agents.list.",
+        "responses": {
+          "200": { "description": "Agent list", "content": { "application/json": { "schema": { "type": "object", "properties": { "agents": { "type": "array", "items": { "$ref": "#/components/schemas/Agent" } } } } } } }
+        }
+      }
+    },
     "/api/v1/chat/sessions": {
       "get": {
         "tags": ["Chat"],
@@ -398,6 +559,50 @@ const openAPISpec = `{
           "200": { "description": "Session messages", "content": { "application/json": { "schema": { "type": "object", "properties": { "messages": { "type": "array", "items": { "$ref": "#/components/schemas/ChatMessage" } } } } } } },
           "404": { "description": "Session not found" }
         }
+      },
+      "patch": {
+        "tags": ["Chat"],
+        "summary": "Rename, archive or switch the agent of a chat session",
+        "parameters": [{ "name": "id", "in": "path", "required": true, "schema": { "type": "string" } }],
+        "requestBody": {
+          "required": true,
+          "content": { "application/json": { "schema": { "type": "object", "properties": { "title": { "type": "string" }, "archived": { "type": "boolean" }, "agent": { "type": "string", "description": "Named agent; empty for the default agent" } } } } }
+        },
+        "responses": {
+          "200": { "description": "Updated session", "content": { "application/json": { "schema": { "$ref": "#/components/schemas/ChatSession" } } } },
+          "400": { "description": "Nothing to update or unknown agent" }
+        }
+      }
+    },
+    "/api/v1/chat/sessions/{id}/generation": {
+      "get": {
+        "tags": ["Chat"],
+        "summary": "Get a session's generation parameter overrides",
+        "parameters": [{ "name": "id", "in": "path", "required": true, "schema": { "type": "string" } }],
+        "responses": {
+          "200": { "description": "Session overrides and effective parameters", "content": { "application/json": { "schema": { "$ref": "#/components/schemas/SessionGeneration" } } } }
+        }
+      },
+      "put": {
+        "tags": ["Chat"],
+        "summary": "Replace a session's generation parameter overrides",
+        "parameters": [{ "name": "id", "in": "path", "required": true, "schema": { "type": "string" } }],
+        "requestBody": {
+          "required": true,
+          "content": { "application/json": { "schema": { "$ref": "#/components/schemas/GenerationParams" } } }
+        },
+        "responses": {
+          "200": { "description": "Updated overrides", "content": { "application/json": { "schema": { "$ref": "#/components/schemas/SessionGeneration" } } } },
+          "400": { "description": "Invalid parameters" }
+        }
+      },
+      "delete": {
+        "tags": ["Chat"],
+        "summary": "Clear a session's generation parameter overrides",
+        "parameters": [{ "name": "id", "in": "path", "required": true, "schema": { "type": "string" } }],
+        "responses": {
+          "200": { "description": "Overrides cleared", "content": { "application/json": { "schema": { "$ref": "#/components/schemas/SessionGeneration" } } } }
+        }
       }
     },
     "/api/v1/chat/search": {
@@ -555,6 +760,123 @@ const openAPISpec = `{
         }
       }
     },
+    "/api/v1/subagents": {
+      "get": {
+        "tags": ["Subagents"],
+        "summary": "List recent subagent runs",
+        "responses": {
+          "200": { "description": "Subagent runs, newest first", "content": { "application/json": { "schema": { "type": "object", "properties": { "subagents": { "type": "array", "items": { "$ref": "#/components/schemas/SubagentRun" } } } } } } }
+        }
+      }
+    },
+    "/api/v1/subagents/{id}": {
+      "get": {
+        "tags": ["Subagents"],
+        "summary": "Get a subagent run",
+        "parameters": [{ "name": "id", "in": "path", "required": true, "schema": { "type": "string" } }],
+        "responses": {
+          "200": { "description": "Subagent run", "content": { "application/json": { "schema": { "type": "object", "properties": { "subagent": { "$ref": "#/components/schemas/SubagentRun" } } } } } },
+          "404": { "description": "Subagent not found" }
+        }
+      }
+    },
+    "/api/v1/subagents/{id}/cancel": {
+      "post": {
+        "tags": ["Subagents"],
+        "summary": "Cancel a running subagent",
+        "parameters": [{ "name": "id", "in": "path", "required": true, "schema": { "type": "string" } }],
+        "responses": {
+          "200": { "description": "Cancellation requested" },
+          "409": { "description": "Subagent not found or not running" }
+        }
+      }
+    },
+    "/api/v1/processes": {
+      "get": {
+        "tags": ["Processes"],
+        "summary": "List background processes started by the process tool (admins see all, users their own)",
+        "responses": {
+          "200": { "description": "Processes, newest first", "content": { "application/json": { "schema": { "type": "object", "properties": { "processes": { "type": "array", "items": { "$ref": "#/components/schemas/BackgroundProcess" } } } } } } },
+          "403": { "description": "No user to scope the list to" }
+        }
+      }
+    },
+    "/api/v1/processes/{id}": {
+      "get": {
+        "tags": ["Processes"],
+        "summary": "Get a background process",
+        "parameters": [{ "name": "id", "in": "path", "required": true, "schema": { "type": "string" } }],
+        "responses": {
+          "200": { "description": "Process", "content": { "application/json": { "schema": { "type": "object", "properties": { "process": { "$ref": "#/components/schemas/BackgroundProcess" } } } } } },
+          "404": { "description": "Process not found" }
+        }
+      },
+      "delete": {
+        "tags": ["Processes"],
+        "summary": "Remove a background process, killing it if it is running",
+        "parameters": [{ "name": "id", "in": "path", "required": true, "schema": { "type": "string" } }],
+        "responses": {
+          "200": { "description": "Process removed" },
+          "404": { "description": "Process not found" }
+        }
+      }
+    },
+    "/api/v1/processes/{id}/output": {
+      "get": {
+        "tags": ["Processes"],
+        "summary": "Read a background process's output from byte offsets",
+        "parameters": [
+          { "name": "id", "in": "path", "required": true, "schema": { "type": "string" } },
+          { "name": "stdout_offset", "in": "query", "schema": { "type": "integer", "minimum": 0 } },
+          { "name": "stderr_offset", "in": "query", "schema": { "type": "integer", "minimum": 0 } },
+          { "name": "max_bytes", "in": "query", "schema": { "type": "integer", "minimum": 1 } }
+        ],
+        "responses": {
+          "200": { "description": "Output since the offsets", "content": { "application/json": { "schema": { "type": "object", "properties": { "process": { "$ref": "#/components/schemas/BackgroundProcess" }, "stdout": { "$ref": "#/components/schemas/ProcessChunk" }, "stderr": { "$ref": "#/components/schemas/ProcessChunk" } } } } } },
+          "404": { "description": "Process not found" }
+        }
+      }
+    },
+    "/api/v1/processes/{id}/kill": {
+      "post": {
+        "tags": ["Processes"],
+        "summary": "Kill a background process and the processes it started",
+        "parameters": [{ "name": "id", "in": "path", "required": true, "schema": { "type": "string" } }],
+        "responses": {
+          "200": { "description": "Process killed" },
+          "404": { "description": "Process not found" },
+          "409": { "description": "Process is not running" }
+        }
+      }
+    },
+    "/api/v1/tools/policy": {
+      "get": {
+        "tags": ["Tools"],
+        "summary": "Get the tool permission policy (admin only)",
+        "responses": {
+          "200": { "description": "Active tool policy", "content": { "application/json": { "schema": { "type": "object", "properties": { "policy": { "$ref": "#/components/schemas/ToolPolicy" }, "saved": { "type": "boolean", "description": "True when the policy was saved through the API rather than taken from the config file" } } } } } },
+          "403": { "description": "Admin role required" }
+        }
+      },
+      "put": {
+        "tags": ["Tools"],
+        "summary": "Replace the tool permission policy (admin only)",
+        "requestBody": { "required": true, "content": { "application/json": { "schema": { "$ref": "#/components/schemas/ToolPolicy" } } } },
+        "responses": {
+          "200": { "description": "Active tool policy", "content": { "application/json": { "schema": { "type": "object", "properties": { "policy": { "$ref": "#/components/schemas/ToolPolicy" }, "saved": { "type": "boolean", "description": "True when the policy was saved through the API rather than taken from the config file" } } } } } },
+          "400": { "description": "Invalid policy" },
+          "403": { "description": "Admin role required" }
+        }
+      },
+      "delete": {
+        "tags": ["Tools"],
+        "summary": "Discard the saved policy and use the config file's (admin only)",
+        "responses": {
+          "200": { "description": "Active tool policy", "content": { "application/json": { "schema": { "type": "object", "properties": { "policy": { "$ref": "#/components/schemas/ToolPolicy" }, "saved": { "type": "boolean", "description": "True when the policy was saved through the API rather than taken from the config file" } } } } } },
+          "403": { "description": "Admin role required" }
+        }
+      }
+    },
     "/api/v1/channels": {
       "get": {
         "tags": ["Channels"],
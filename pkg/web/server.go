@@ -4,6 +4,7 @@ import (
 	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -19,6 +20,7 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/sipeed/kakoclaw/pkg/agent"
+	"github.com/sipeed/kakoclaw/pkg/approval"
 	"github.com/sipeed/kakoclaw/pkg/channels"
 	"github.com/sipeed/kakoclaw/pkg/config"
 	"github.com/sipeed/kakoclaw/pkg/cron"
@@ -181,6 +183,8 @@ func (s *Server) Start(ctx context.Context) error {
 	mux.HandleFunc("/api/v1/tasks", s.handleTasks)
 	mux.HandleFunc("/api/v1/tasks/search", s.handleTaskSearch) // Search tasks
 	mux.HandleFunc("/api/v1/tasks/", s.handleTasks)
+	mux.HandleFunc("/api/v1/chat", s.handleChat)                              // Run one chat turn over REST
+	mux.HandleFunc("/api/v1/agents", s.handleAgents)                          // Named agents a chat can use
 	mux.HandleFunc("/api/v1/chat/sessions", s.handleChatSessions)             // New endpoint
 	mux.HandleFunc("/api/v1/chat/sessions/", s.handleChatSessionMessages)     // New endpoint
 	mux.HandleFunc("/api/v1/chat/search", s.handleChatSearch)                 // Search messages
@@ -212,6 +216,7 @@ func (s *Server) Start(ctx context.Context) error {
 	mux.HandleFunc("/api/v1/mcp/", s.handleMCPServerAction)                   // MCP server actions: reconnect
 	mux.HandleFunc("/api/v1/metrics", s.handleMetrics)                        // Observability metrics
 	mux.HandleFunc("/api/v1/tools", s.handleToolsList)                        // Available tools list
+	mux.HandleFunc("/api/v1/tools/policy", s.handleToolPolicy)                // Tool permission policy (admin)
 	mux.HandleFunc("/api/v1/prompts", s.handlePrompts)                        // Prompt templates: list + create
 	mux.HandleFunc("/api/v1/prompts/", s.handlePromptAction)                  // Prompt templates: update/delete
 	mux.HandleFunc("/api/v1/chat/attachments", s.handleChatAttachment)        // Chat file upload/extract
@@ -220,6 +225,12 @@ func (s *Server) Start(ctx context.Context) error {
 	mux.HandleFunc("/api/v1/backup/export", s.handleBackupExport)             // Export backup
 	mux.HandleFunc("/api/v1/backup/import", s.handleBackupImport)             // Import backup
 	mux.HandleFunc("/api/v1/backup/validate", s.handleBackupValidate)         // Validate backup
+	mux.HandleFunc("/api/v1/approvals", s.handleApprovals)                    // Tool approvals: pending + history
+	mux.HandleFunc("/api/v1/approvals/", s.handleApprovalAction)              // Tool approvals: approve/deny
+	mux.HandleFunc("/api/v1/subagents", s.handleSubagents)                    // Subagent runs
+	mux.HandleFunc("/api/v1/subagents/", s.handleSubagentAction)              // Subagent run detail / cancel
+	mux.HandleFunc("/api/v1/processes", s.handleProcesses)                    // Background processes
+	mux.HandleFunc("/api/v1/processes/", s.handleProcessAction)               // Background process detail / output / kill
 	mux.HandleFunc("/ws/chat", s.handleChatWS)
 	mux.HandleFunc("/ws/tasks", s.handleTasksWS)
 	mux.Handle("/", s.staticHandler())
@@ -418,6 +429,12 @@ func checkWebSocketOrigin(r *http.Request) bool {
 	return true
 }
 
+// isAdminRequest reports whether the request was made by an admin user.
+func (s *Server) isAdminRequest(r *http.Request) bool {
+	claims, ok := r.Context().Value(userClaimsKey).(*jwtClaims)
+	return ok && claims != nil && claims.Role == "admin"
+}
+
 // getUserIDFromClaims extracts the user_id from the request's JWT claims.
 // Returns user_id and true if found; otherwise 0 and false.
 func (s *Server) getUserIDFromClaims(r *http.Request) (int64, bool) {
@@ -767,10 +784,188 @@ func (s *Server) handleChatWS(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
-	// Mutex for thread-safe WebSocket writes (streaming callback writes from same goroutine,
-	// but we protect against concurrent request handling edge cases)
+	// Mutex for thread-safe WebSocket writes (the chat worker, parallel tool
+	// callbacks and the read loop all write to the connection)
 	var wsMu sync.Mutex
 
+	// Chat turns run one at a time on a worker goroutine so that the read loop
+	// stays free to receive approval responses while a turn is in progress.
+	type chatJob struct {
+		input        string
+		sessionID    string
+		model        string
+		excludeTools []string
+		agent        *agent.AgentLoop
+	}
+	connCtx, connCancel := context.WithCancel(r.Context())
+	jobs := make(chan chatJob, 16)
+	var worker sync.WaitGroup
+
+	// enqueue is also called from the worker when a steering message comes
+	// back unused, so it must never block or send after jobs is closed.
+	var jobsMu sync.Mutex
+	jobsClosed := false
+	enqueue := func(job chatJob) bool {
+		jobsMu.Lock()
+		defer jobsMu.Unlock()
+		if jobsClosed {
+			return false
+		}
+		select {
+		case jobs <- job:
+			return true
+		default:
+			return false
+		}
+	}
+
+	process := func(job chatJob) {
+		// Create cancelable context for this execution
+		ctx, cancel := context.WithCancel(connCtx)
+		ctx = approval.WithPrompter(ctx, &wsApprovalPrompter{conn: conn, mu: &wsMu})
+		ctx = s.withPolicyCaller(ctx, r, userID, job.sessionID)
+		ctx = agent.WithSteerListener(ctx, func(content string) {
+			wsMu.Lock()
+			defer wsMu.Unlock()
+			_ = conn.WriteJSON(map[string]interface{}{
+				"type":    "steer",
+				"status":  "applied",
+				"content": content,
+			})
+		})
+		execID := fmt.Sprintf("%s:%d", job.sessionID, time.Now().UnixNano())
+
+		// Track active execution
+		s.execMu.Lock()
+		s.activeExecs[execID] = &activeExecution{
+			SessionID: job.sessionID,
+			StartedAt: time.Now(),
+			Cancel:    cancel,
+		}
+		s.execMu.Unlock()
+
+		// Cleanup after execution completes
+		defer func(id string) {
+			s.execMu.Lock()
+			delete(s.activeExecs, id)
+			s.execMu.Unlock()
+			cancel()
+		}(execID)
+
+		// Use streaming if supported
+		if s.agentLoop.SupportsStreaming() {
+			// Send stream_start
+			wsMu.Lock()
+			_ = conn.WriteJSON(map[string]interface{}{"type": "stream_start"})
+			wsMu.Unlock()
+
+			response, err := job.agent.ProcessDirectWithModelStream(
+				ctx, userID, job.input, job.sessionID, job.model,
+				func(token string) error {
+					wsMu.Lock()
+					defer wsMu.Unlock()
+					return conn.WriteJSON(map[string]interface{}{
+						"type":    "stream",
+						"content": token,
+					})
+				},
+				func(reasoning string) error {
+					wsMu.Lock()
+					defer wsMu.Unlock()
+					return conn.WriteJSON(map[string]interface{}{
+						"type":    "reasoning",
+						"content": reasoning,
+					})
+				},
+				func(ev agent.ToolEvent) error {
+					wsMu.Lock()
+					defer wsMu.Unlock()
+					payload := map[string]interface{}{
+						"type":       "tool_call",
+						"call_id":    ev.ID,
+						"name":       ev.Name,
+						"args":       ev.Args,
+						"result":     ev.Result,
+						"status":     ev.Status,
+						"started_at": ev.StartedAt,
+					}
+					if !ev.FinishedAt.IsZero() {
+						payload["finished_at"] = ev.FinishedAt
+						payload["duration_ms"] = ev.DurationMs
+					}
+					return conn.WriteJSON(payload)
+				},
+				job.excludeTools...,
+			)
+
+			if err != nil {
+				errMsg := err.Error()
+				if ctx.Err() == context.Canceled {
+					errMsg = "Execution canceled by user"
+				}
+				wsMu.Lock()
+				_ = conn.WriteJSON(map[string]interface{}{
+					"type":    "stream_end",
+					"content": "",
+					"error":   errMsg,
+				})
+				_ = conn.WriteJSON(map[string]interface{}{"type": "ready"})
+				wsMu.Unlock()
+				return
+			}
+
+			wsMu.Lock()
+			_ = conn.WriteJSON(map[string]interface{}{
+				"type":    "stream_end",
+				"content": response,
+			})
+			_ = conn.WriteJSON(map[string]interface{}{"type": "ready"})
+			wsMu.Unlock()
+		} else {
+			// Non-streaming fallback
+			response, err := job.agent.ProcessDirectWithModel(ctx, userID, job.input, job.sessionID, job.model, job.excludeTools...)
+			if err != nil {
+				errMsg := err.Error()
+				if ctx.Err() == context.Canceled {
+					errMsg = "Execution canceled by user"
+				}
+				wsMu.Lock()
+				_ = conn.WriteJSON(map[string]interface{}{
+					"type":    "message",
+					"role":    "system",
+					"content": "error: " + errMsg,
+				})
+				_ = conn.WriteJSON(map[string]interface{}{"type": "ready"})
+				wsMu.Unlock()
+				return
+			}
+			wsMu.Lock()
+			_ = conn.WriteJSON(map[string]interface{}{
+				"type":    "message",
+				"role":    "assistant",
+				"content": response,
+			})
+			_ = conn.WriteJSON(map[string]interface{}{"type": "ready"})
+			wsMu.Unlock()
+		}
+	}
+
+	worker.Add(1)
+	go func() {
+		defer worker.Done()
+		for job := range jobs {
+			process(job)
+		}
+	}()
+	defer func() {
+		connCancel()
+		jobsMu.Lock()
+		jobsClosed = true
+		close(jobs)
+		jobsMu.Unlock()
+		worker.Wait()
+	}()
+
 	for {
 		_, raw, err := conn.ReadMessage()
 		if err != nil {
@@ -784,6 +979,9 @@ func (s *Server) handleChatWS(w http.ResponseWriter, r *http.Request) {
 			Model        string   `json:"model"`
 			WebSearch    *bool    `json:"web_search"`    // legacy: nil = default (enabled), false = exclude web_search tool
 			ExcludeTools []string `json:"exclude_tools"` // new: granular control
+			ApprovalID   string   `json:"approval_id"`   // approval_response: request being answered
+			Decision     string   `json:"decision"`      // approval_response: approve_once, approve_session or deny
+			Agent        string   `json:"agent"`         // Named agent for the session; empty keeps the session's agent
 		}
 
 		// Try to decode as JSON
@@ -793,6 +991,19 @@ func (s *Server) handleChatWS(w http.ResponseWriter, r *http.Request) {
 			req.SessionID = "web:chat" // Default session
 		}
 
+		if req.Type == "approval_response" {
+			err := errors.New("approval not found")
+			if s.canResolveApproval(r, userID, req.ApprovalID) {
+				err = s.agentLoop.Approvals().Resolve(req.ApprovalID, approval.Decision(req.Decision), fmt.Sprintf("web:%d", userID))
+			}
+			if err != nil {
+				wsMu.Lock()
+				_ = conn.WriteJSON(map[string]interface{}{"type": "error", "error": err.Error()})
+				wsMu.Unlock()
+			}
+			continue
+		}
+
 		input := strings.TrimSpace(req.Content)
 		if input == "" {
 			continue
@@ -819,110 +1030,47 @@ func (s *Server) handleChatWS(w http.ResponseWriter, r *http.Request) {
 			excludeTools = append(excludeTools, req.ExcludeTools...)
 		}
 
-		func() {
-			// Create cancelable context for this execution
-			ctx, cancel := context.WithCancel(r.Context())
-			execID := fmt.Sprintf("%s:%d", sessionID, time.Now().UnixNano())
-
-			// Track active execution
-			s.execMu.Lock()
-			s.activeExecs[execID] = &activeExecution{
-				SessionID: sessionID,
-				StartedAt: time.Now(),
-				Cancel:    cancel,
-			}
-			s.execMu.Unlock()
-
-			// Cleanup after execution completes
-			defer func(id string) {
-				s.execMu.Lock()
-				delete(s.activeExecs, id)
-				s.execMu.Unlock()
-				cancel()
-			}(execID)
-
-			// Use streaming if supported
-			if s.agentLoop.SupportsStreaming() {
-				// Send stream_start
-				wsMu.Lock()
-				_ = conn.WriteJSON(map[string]interface{}{"type": "stream_start"})
-				wsMu.Unlock()
+		if rest, ok := cutPrefixFold(input, "/steer "); ok {
+			input = strings.TrimSpace(rest)
+			req.Type = "steer"
+		}
+		target, err := s.sessionAgent(userID, sessionID, strings.TrimSpace(req.Agent))
+		if err != nil {
+			wsMu.Lock()
+			_ = conn.WriteJSON(map[string]interface{}{"type": "error", "error": err.Error()})
+			wsMu.Unlock()
+			continue
+		}
+		job := chatJob{input: input, sessionID: sessionID, model: req.Model, excludeTools: excludeTools, agent: target}
 
-				response, err := s.agentLoop.ProcessDirectWithModelStream(
-					ctx, input, sessionID, req.Model,
-					func(token string) error {
-						wsMu.Lock()
-						defer wsMu.Unlock()
-						return conn.WriteJSON(map[string]interface{}{
-							"type":    "stream",
-							"content": token,
-						})
-					},
-					func(ev agent.ToolEvent) error {
-						wsMu.Lock()
-						defer wsMu.Unlock()
-						return conn.WriteJSON(map[string]interface{}{
-							"type":   "tool_call",
-							"name":   ev.Name,
-							"args":   ev.Args,
-							"result": ev.Result,
-							"status": ev.Status,
-						})
-					},
-					excludeTools...,
-				)
-
-				if err != nil {
-					errMsg := err.Error()
-					if ctx.Err() == context.Canceled {
-						errMsg = "Execution canceled by user"
-					}
+		// Steer the session's running turn instead of queuing behind it
+		if req.Type == "steer" || target.SteerAll() {
+			requeue := func() {
+				if enqueue(job) {
 					wsMu.Lock()
-					_ = conn.WriteJSON(map[string]interface{}{
-						"type":    "stream_end",
-						"content": "",
-						"error":   errMsg,
-					})
-					_ = conn.WriteJSON(map[string]interface{}{"type": "ready"})
+					_ = conn.WriteJSON(map[string]interface{}{"type": "steer", "status": "requeued", "content": input})
 					wsMu.Unlock()
-					return
 				}
-
+			}
+			// Only the owner may steer a session; other messages run as usual.
+			if s.ownsSession(userID, sessionID) && target.Steer(sessionID, input, requeue) {
 				wsMu.Lock()
-				_ = conn.WriteJSON(map[string]interface{}{
-					"type":    "stream_end",
-					"content": response,
-				})
-				_ = conn.WriteJSON(map[string]interface{}{"type": "ready"})
+				_ = conn.WriteJSON(map[string]interface{}{"type": "steer", "status": "queued", "content": input})
 				wsMu.Unlock()
-			} else {
-				// Non-streaming fallback
-				response, err := s.agentLoop.ProcessDirectWithModel(ctx, input, sessionID, req.Model, excludeTools...)
-				if err != nil {
-					errMsg := err.Error()
-					if ctx.Err() == context.Canceled {
-						errMsg = "Execution canceled by user"
-					}
-					wsMu.Lock()
-					_ = conn.WriteJSON(map[string]interface{}{
-						"type":    "message",
-						"role":    "system",
-						"content": "error: " + errMsg,
-					})
-					_ = conn.WriteJSON(map[string]interface{}{"type": "ready"})
-					wsMu.Unlock()
-					return
-				}
+				continue
+			}
+			if req.Type == "steer" {
 				wsMu.Lock()
-				_ = conn.WriteJSON(map[string]interface{}{
-					"type":    "message",
-					"role":    "assistant",
-					"content": response,
-				})
-				_ = conn.WriteJSON(map[string]interface{}{"type": "ready"})
+				_ = conn.WriteJSON(map[string]interface{}{"type": "steer", "status": "requeued", "content": input})
 				wsMu.Unlock()
 			}
-		}()
+		}
+
+		if !enqueue(job) {
+			wsMu.Lock()
+			_ = conn.WriteJSON(map[string]interface{}{"type": "error", "error": "too many queued messages"})
+			wsMu.Unlock()
+		}
 	}
 }
 
@@ -1311,6 +1459,15 @@ func (s *Server) handleChatSessionMessages(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if sessionID, ok := strings.CutSuffix(id, "/generation"); ok {
+		if _, err := s.store.GetSessionForUser(userID, sessionID); err != nil {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		s.handleSessionGeneration(w, r, userID, sessionID)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		messages, err := s.store.GetMessagesForUser(userID, id)
@@ -1333,15 +1490,26 @@ func (s *Server) handleChatSessionMessages(w http.ResponseWriter, r *http.Reques
 		var payload struct {
 			Title    *string `json:"title"`
 			Archived *bool   `json:"archived"`
+			Agent    *string `json:"agent"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 			http.Error(w, "invalid JSON body", http.StatusBadRequest)
 			return
 		}
-		if payload.Title == nil && payload.Archived == nil {
+		if payload.Title == nil && payload.Archived == nil && payload.Agent == nil {
 			http.Error(w, "nothing to update", http.StatusBadRequest)
 			return
 		}
+		if payload.Agent != nil {
+			if *payload.Agent != "" && s.agentLoop != nil && s.agentLoop.Agent(*payload.Agent) == nil {
+				http.Error(w, "unknown agent", http.StatusBadRequest)
+				return
+			}
+			if err := s.store.SetSessionAgentForUser(userID, id, *payload.Agent); err != nil {
+				http.Error(w, "failed to update session", http.StatusInternalServerError)
+				return
+			}
+		}
 		sess, err := s.store.UpdateSessionForUser(userID, id, payload.Title, payload.Archived)
 		if err != nil {
 			http.Error(w, "failed to update session", http.StatusInternalServerError)
@@ -1355,6 +1523,49 @@ func (s *Server) handleChatSessionMessages(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+// handleSessionGeneration reads, replaces or clears a chat session's
+// generation parameter overrides.
+// GET/PUT/DELETE /api/v1/chat/sessions/{id}/generation
+func (s *Server) handleSessionGeneration(w http.ResponseWriter, r *http.Request, userID int64, sessionID string) {
+	if s.agentLoop == nil {
+		http.Error(w, "agent loop unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var params config.GenerationParams
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if err := params.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.agentLoop.SetSessionGeneration(userID, sessionID, &params); err != nil {
+			http.Error(w, "failed to update generation parameters", http.StatusInternalServerError)
+			return
+		}
+	case http.MethodDelete:
+		if err := s.agentLoop.SetSessionGeneration(userID, sessionID, nil); err != nil {
+			http.Error(w, "failed to reset generation parameters", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"session_id": sessionID,
+		"overrides":  s.agentLoop.SessionGeneration(userID, sessionID),
+		"effective":  s.agentLoop.EffectiveGeneration(userID, sessionID),
+	})
+}
+
 func (s *Server) handleChatSearch(w http.ResponseWriter, r *http.Request) {
 	if s.store == nil {
 		http.Error(w, "store unavailable", http.StatusServiceUnavailable)
@@ -1449,6 +1660,14 @@ func parseID(s string) (int64, error) {
 	return strconv.ParseInt(s, 10, 64)
 }
 
+// cutPrefixFold is strings.CutPrefix with a case-insensitive prefix.
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
 func clientIP(r *http.Request) string {
 	forwarded := strings.TrimSpace(r.Header.Get("X-Forwarded-For"))
 	if forwarded != "" {
@@ -1936,6 +2155,39 @@ func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	snapshot := observability.Global().Snapshot()
+	if s.store != nil {
+		snapshot["spend"] = s.spendSummary(s.isAdminRequest(r))
+	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(snapshot)
 }
+
+// spendSummary reports LLM spend from the usage ledger for today and this
+// month, broken down by model and channel (and by user for admins).
+func (s *Server) spendSummary(includeUsers bool) map[string]interface{} {
+	now := time.Now()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	summary := map[string]interface{}{}
+	if today, err := s.store.SumLLMUsage(storage.UsageFilter{Since: dayStart}); err == nil {
+		summary["today"] = today
+	}
+	if month, err := s.store.SumLLMUsage(storage.UsageFilter{Since: monthStart}); err == nil {
+		summary["month"] = month
+	}
+
+	groups := []string{"model", "channel"}
+	if includeUsers {
+		groups = append(groups, "user")
+	}
+	for _, group := range groups {
+		rows, err := s.store.SpendBreakdown(group, monthStart)
+		if err != nil {
+			logger.WarnCF("web", "Failed to load spend breakdown", map[string]interface{}{"group": group, "error": err.Error()})
+			continue
+		}
+		summary["by_"+group] = rows
+	}
+	return summary
+}
@@ -3,16 +3,24 @@ package web
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/sipeed/kakoclaw/pkg/agent"
+	"github.com/sipeed/kakoclaw/pkg/approval"
+	"github.com/sipeed/kakoclaw/pkg/bus"
 	"github.com/sipeed/kakoclaw/pkg/config"
+	"github.com/sipeed/kakoclaw/pkg/policy"
+	"github.com/sipeed/kakoclaw/pkg/providers"
 	"github.com/sipeed/kakoclaw/pkg/skills"
 	"github.com/sipeed/kakoclaw/pkg/storage"
+	"github.com/sipeed/kakoclaw/pkg/tools"
 )
 
 func newTestServer(t *testing.T) *Server {
@@ -40,6 +48,23 @@ func newTestServer(t *testing.T) *Server {
 	return s
 }
 
+// newTestServerWithAgent returns a test server with an agent loop working in
+// <workspace>/agent without storage of its own. mutate, if set, adjusts the
+// config before the loop is built.
+func newTestServerWithAgent(t *testing.T, mutate func(*config.Config)) *Server {
+	t.Helper()
+	s := newTestServer(t)
+	cfg := config.DefaultConfig()
+	cfg.Agents.Defaults.Workspace = filepath.Join(s.workspace, "agent")
+	cfg.Storage.Path = ""
+	if mutate != nil {
+		mutate(cfg)
+	}
+	s.agentLoop = agent.NewAgentLoop(cfg, bus.NewMessageBus(), providers.NewMockProvider())
+	t.Cleanup(s.agentLoop.Stop)
+	return s
+}
+
 func TestAuthMiddlewareBlocksUnauthorizedAPI(t *testing.T) {
 	s := newTestServer(t)
 	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNoContent) })
@@ -242,6 +267,262 @@ func TestHandleSkillGenerateRequiresAgentLoop(t *testing.T) {
 	}
 }
 
+func TestHandleChatRequiresAgentLoop(t *testing.T) {
+	s := newTestServer(t)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/chat", strings.NewReader(`{"content":"hi","response_schema":{"type":"object"}}`))
+	rr := httptest.NewRecorder()
+
+	s.handleChat(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rr.Code)
+	}
+}
+
+func TestHandleAgentsListsProfiles(t *testing.T) {
+	s := newTestServerWithAgent(t, func(cfg *config.Config) {
+		workspace := filepath.Dir(cfg.Agents.Defaults.Workspace)
+		cfg.Agents.List = []config.AgentProfile{{Name: "coder", Model: "coder-model", Workspace: filepath.Join(workspace, "coder")}}
+	})
+
+	rr := httptest.NewRecorder()
+	s.handleAgents(rr, httptest.NewRequest(http.MethodGet, "/api/v1/agents", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var body struct {
+		Agents []agent.AgentInfo `json:"agents"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Agents) != 2 || !body.Agents[0].Default || body.Agents[1].Name != "coder" || body.Agents[1].Model != "coder-model" {
+		t.Fatalf("unexpected agents: %+v", body.Agents)
+	}
+
+	// A session remembers the agent it was started with.
+	if _, err := s.sessionAgent(1, "web:agents", "coder"); err != nil {
+		t.Fatalf("sessionAgent: %v", err)
+	}
+	target, err := s.sessionAgent(1, "web:agents", "")
+	if err != nil || target.Name() != "coder" {
+		t.Fatalf("expected the stored agent, got %v, %v", target, err)
+	}
+	if _, err := s.sessionAgent(1, "web:agents", "missing"); err == nil {
+		t.Fatal("expected an error for an unknown agent")
+	}
+}
+
+func TestHandleToolPolicy(t *testing.T) {
+	s := newTestServerWithAgent(t, nil)
+
+	request := func(role, method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/tools/policy", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), userClaimsKey, &jwtClaims{Sub: "1", Role: role}))
+		rr := httptest.NewRecorder()
+		s.handleToolPolicy(rr, req)
+		return rr
+	}
+
+	if rr := request("user", http.MethodGet, ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a non-admin, got %d", rr.Code)
+	}
+	if rr := request("admin", http.MethodPut, `{"enabled":true,"rules":[{"effect":"block","tools":["exec"]}]}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid policy, got %d", rr.Code)
+	}
+
+	rr := request("admin", http.MethodPut, `{"enabled":true,"rules":[{"effect":"deny","tools":["exec"],"roles":["guest"]}]}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Policy config.ToolPolicyConfig `json:"policy"`
+		Saved  bool                    `json:"saved"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !body.Saved || !body.Policy.Enabled || body.Policy.Default != "allow" || len(body.Policy.Rules) != 1 {
+		t.Fatalf("unexpected policy: %+v", body)
+	}
+	manager := s.agentLoop.ToolPolicy()
+	if manager.Allows(policy.Caller{Role: policy.GuestRole, Channel: "telegram"}, "exec") {
+		t.Fatal("the saved policy should deny exec to guests")
+	}
+
+	if rr := request("admin", http.MethodDelete, ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 on reset, got %d", rr.Code)
+	}
+	if _, saved := manager.Policy(); saved || manager.Enabled() {
+		t.Fatal("expected reset to restore the config policy")
+	}
+}
+
+type nopPrompter struct{}
+
+func (nopPrompter) Prompt(approval.Request) error                { return nil }
+func (nopPrompter) Resolved(approval.Request, approval.Decision) {}
+
+func TestApprovalsResolvableOnlyByOwner(t *testing.T) {
+	s := newTestServerWithAgent(t, func(cfg *config.Config) {
+		cfg.Tools.Approval.Enabled = true
+	})
+
+	alice, err := s.store.CreateUser("alice", "AlicePassword123!", "user")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	bob, err := s.store.CreateUser("bob", "BobPassword123!", "user")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	approvals := s.agentLoop.Approvals()
+	decided := make(chan bool, 1)
+	go func() {
+		ok, _ := approvals.Authorize(approval.WithPrompter(context.Background(), nopPrompter{}),
+			approval.Request{Tool: "exec", UserID: alice.ID, Channel: "web"})
+		decided <- ok
+	}()
+	var id string
+	for deadline := time.Now().Add(5 * time.Second); id == ""; time.Sleep(10 * time.Millisecond) {
+		if pending := approvals.Pending(alice.ID); len(pending) > 0 {
+			id = pending[0].ID
+		} else if time.Now().After(deadline) {
+			t.Fatal("the approval request never became pending")
+		}
+	}
+
+	request := func(role string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/approvals/"+id, strings.NewReader(`{"decision":"approve_once"}`))
+		return req.WithContext(context.WithValue(req.Context(), userClaimsKey, &jwtClaims{Role: role}))
+	}
+	if s.canResolveApproval(request("user"), bob.ID, id) {
+		t.Fatal("expected bob not to be able to answer alice's approval")
+	}
+	if s.canResolveApproval(request("user"), 0, id) {
+		t.Fatal("expected an unresolved user not to be able to answer approvals")
+	}
+	if !s.canResolveApproval(request("user"), alice.ID, id) || !s.canResolveApproval(request("admin"), 0, id) {
+		t.Fatal("expected alice and admins to be able to answer the approval")
+	}
+
+	rr := httptest.NewRecorder()
+	s.handleApprovalAction(rr, request("admin"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ok := <-decided; !ok {
+		t.Fatal("expected the call to be approved")
+	}
+}
+
+func TestHandleProcesses(t *testing.T) {
+	s := newTestServerWithAgent(t, nil)
+
+	alice, err := s.store.CreateUser("alice", "AlicePassword123!", "user")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	manager := s.agentLoop.Processes()
+	ctx := tools.WithExecutionContext(context.Background(), tools.ExecutionContext{UserID: 999, Workspace: s.workspace})
+	proc, err := manager.Start(ctx, "echo hello; sleep 60", "")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if _, err := manager.Read(ctx, proc.ID, tools.ProcessScope{}, 0, 0, 0, 5*time.Second); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	request := func(user, role, method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), userClaimsKey, &jwtClaims{Sub: user, Role: role}))
+		rr := httptest.NewRecorder()
+		if path == "/api/v1/processes" {
+			s.handleProcesses(rr, req)
+		} else {
+			s.handleProcessAction(rr, req)
+		}
+		return rr
+	}
+
+	var list struct {
+		Processes []tools.ProcessInfo `json:"processes"`
+	}
+	rr := request(alice.Username, "user", http.MethodGet, "/api/v1/processes")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil || len(list.Processes) != 0 {
+		t.Fatalf("expected alice to see no processes, got %+v, %v", list, err)
+	}
+	if rr := request(alice.Username, "user", http.MethodPost, "/api/v1/processes/"+proc.ID+"/kill"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 when killing another user's process, got %d", rr.Code)
+	}
+	if rr := request("nobody", "user", http.MethodGet, "/api/v1/processes"); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for an unknown user, got %d", rr.Code)
+	}
+
+	rr = request("admin", "admin", http.MethodGet, "/api/v1/processes")
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil || len(list.Processes) != 1 || list.Processes[0].ID != proc.ID {
+		t.Fatalf("expected the admin to see the process, got %+v, %v", list, err)
+	}
+
+	rr = request("admin", "admin", http.MethodGet, "/api/v1/processes/"+proc.ID+"/output?stdout_offset=2")
+	var out tools.ProcessOutput
+	if err := json.NewDecoder(rr.Body).Decode(&out); err != nil || out.Stdout.Data != "llo\n" || out.Stdout.NextOffset != 6 {
+		t.Fatalf("unexpected output: %+v, %v", out, err)
+	}
+
+	if rr := request("admin", "admin", http.MethodPost, "/api/v1/processes/"+proc.ID+"/kill"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 on kill, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := request("admin", "admin", http.MethodPost, "/api/v1/processes/"+proc.ID+"/kill"); rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 when killing a finished process, got %d", rr.Code)
+	}
+	if rr := request("admin", "admin", http.MethodDelete, "/api/v1/processes/"+proc.ID); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 on delete, got %d", rr.Code)
+	}
+	if rr := request("admin", "admin", http.MethodGet, "/api/v1/processes/"+proc.ID); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", rr.Code)
+	}
+}
+
+func TestHandleSubagentsRequiresUser(t *testing.T) {
+	s := newTestServerWithAgent(t, nil)
+
+	alice, err := s.store.CreateUser("alice", "AlicePassword123!", "user")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	request := func(user, role, method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), userClaimsKey, &jwtClaims{Sub: user, Role: role}))
+		rr := httptest.NewRecorder()
+		if path == "/api/v1/subagents" {
+			s.handleSubagents(rr, req)
+		} else {
+			s.handleSubagentAction(rr, req)
+		}
+		return rr
+	}
+
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/api/v1/subagents"},
+		{http.MethodGet, "/api/v1/subagents/run-1"},
+		{http.MethodPost, "/api/v1/subagents/run-1/cancel"},
+	} {
+		if rr := request("nobody", "user", tc.method, tc.path); rr.Code != http.StatusForbidden {
+			t.Fatalf("expected 403 for an unknown user on %s %s, got %d", tc.method, tc.path, rr.Code)
+		}
+	}
+	if rr := request(alice.Username, "user", http.MethodGet, "/api/v1/subagents"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 for alice, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := request("admin", "admin", http.MethodGet, "/api/v1/subagents"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 for an admin, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
 func TestHandleSkillCreateWritesSkillFile(t *testing.T) {
 	s := newTestServer(t)
 	content := "---\nname: demo-skill\ndescription: Test skill\n---\n\n# Demo Skill\n\n## When to use\nUse this.\n"
@@ -409,3 +690,97 @@ func TestHandleChatSessionMessagesNoID(t *testing.T) {
 		t.Fatalf("expected 400, got %d", rr.Code)
 	}
 }
+
+func TestSessionGenerationScopedToOwner(t *testing.T) {
+	s := newTestServerWithAgent(t, nil)
+
+	alice, err := s.store.CreateUser("alice", "AlicePassword123!", "user")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := s.store.CreateUser("bob", "BobPassword123!", "user"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := s.store.SaveMessageForUser(alice.ID, "web:alice", "user", "hi"); err != nil {
+		t.Fatalf("SaveMessageForUser: %v", err)
+	}
+
+	request := func(user, method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/chat/sessions/web:alice/generation", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), userClaimsKey, &jwtClaims{Sub: user, Role: "user"}))
+		rr := httptest.NewRecorder()
+		s.handleChatSessionMessages(rr, req)
+		return rr
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		if rr := request("bob", method, `{"temperature": 0.1}`); rr.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 for %s on another user's session, got %d", method, rr.Code)
+		}
+	}
+	if rr := request("alice", http.MethodPut, `{"temperature": 0.1}`); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if p := s.agentLoop.SessionGeneration(alice.ID, "web:alice"); p == nil || p.Temperature == nil || *p.Temperature != 0.1 {
+		t.Fatalf("expected the override to be stored for alice, got %+v", p)
+	}
+	if p := s.agentLoop.SessionGeneration(0, "web:alice"); p != nil {
+		t.Fatalf("expected the default user not to see alice's override, got %+v", p)
+	}
+}
+
+func TestOwnsSession(t *testing.T) {
+	s := newTestServer(t)
+	alice, err := s.store.CreateUser("alice", "AlicePassword123!", "user")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	bob, err := s.store.CreateUser("bob", "BobPassword123!", "user")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := s.store.SaveMessageForUser(alice.ID, "web:alice", "user", "hi"); err != nil {
+		t.Fatalf("SaveMessageForUser: %v", err)
+	}
+
+	if !s.ownsSession(alice.ID, "web:alice") {
+		t.Fatal("expected alice to own her session")
+	}
+	if s.ownsSession(bob.ID, "web:alice") {
+		t.Fatal("expected bob not to own alice's session")
+	}
+}
+
+func TestHandleChatUsesCallerSession(t *testing.T) {
+	s := newTestServerWithAgent(t, func(cfg *config.Config) {
+		// Share the server's store, where the web reads chat history from.
+		cfg.Storage.Path = filepath.Join(filepath.Dir(cfg.Agents.Defaults.Workspace), "tasks.db")
+	})
+
+	alice, err := s.store.CreateUser("alice", "AlicePassword123!", "user")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	request := func(user, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/chat", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), userClaimsKey, &jwtClaims{Sub: user, Role: "user"}))
+		rr := httptest.NewRecorder()
+		s.handleChat(rr, req)
+		return rr
+	}
+
+	rr := request("alice", `{"content":"hello"}`)
+	var body struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d, %v", rr.Code, err)
+	}
+	if want := fmt.Sprintf("web:%d:chat", alice.ID); body.SessionID != want {
+		t.Fatalf("expected the default session %q, got %q", want, body.SessionID)
+	}
+	if msgs, err := s.store.GetMessagesForUser(alice.ID, body.SessionID); err != nil || len(msgs) != 2 {
+		t.Fatalf("expected the turn to be saved for alice, got %d messages, %v", len(msgs), err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/sipeed/kakoclaw/pkg/agent"
+	"github.com/sipeed/kakoclaw/pkg/config"
 	"github.com/sipeed/kakoclaw/pkg/logger"
 	"github.com/sipeed/kakoclaw/pkg/storage"
 	"github.com/sipeed/kakoclaw/pkg/tools"
@@ -34,8 +35,12 @@ type Step struct {
 
 // PromptConfig holds settings for a prompt step.
 type PromptConfig struct {
-	Message string `json:"message"`
-	Model   string `json:"model,omitempty"`
+	Message    string                   `json:"message"`
+	Model      string                   `json:"model,omitempty"`
+	Generation *config.GenerationParams `json:"generation,omitempty"` // overrides for this step only
+	// OutputSchema, if set, makes the step answer with JSON matching this
+	// JSON Schema; the validated JSON becomes the step output.
+	OutputSchema map[string]interface{} `json:"output_schema,omitempty"`
 }
 
 // ToolConfig holds settings for a tool step.
@@ -208,9 +213,22 @@ func (e *Engine) executePrompt(ctx context.Context, step Step, sessionKey string
 	}
 
 	message := interpolate(cfg.Message, prevResults)
+	if cfg.Generation != nil {
+		if err := cfg.Generation.Validate(); err != nil {
+			return "", fmt.Errorf("invalid generation parameters: %w", err)
+		}
+		ctx = agent.WithGeneration(ctx, *cfg.Generation)
+	}
 
+	if len(cfg.OutputSchema) > 0 {
+		data, err := e.agentLoop.ProcessDirectJSON(ctx, 0, message, sessionKey, cfg.Model, cfg.OutputSchema)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
 	if cfg.Model != "" {
-		return e.agentLoop.ProcessDirectWithModel(ctx, message, sessionKey, cfg.Model)
+		return e.agentLoop.ProcessDirectWithModel(ctx, 0, message, sessionKey, cfg.Model)
 	}
 	return e.agentLoop.ProcessDirect(ctx, message, sessionKey)
 }